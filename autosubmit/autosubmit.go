@@ -0,0 +1,178 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package autosubmit renders self-submitting HTML forms, which carry signed messages from the tool to the platform
+// through the user's browser, e.g., deep linking responses and auth requests. The forms are compatible with a Content
+// Security Policy that only permits scripts bearing a nonce. The page is rendered from a template that tools may
+// replace, e.g., to match their branding; see Renderer.
+package autosubmit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// nonceBytes is the number of random bytes in a nonce.
+const nonceBytes = 16
+
+// A Field is a hidden form field.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// A Page is the data of the template rendering an auto-submit page. A template must POST the Fields to the Action URL
+// and set the Nonce, when it is not empty, on each of its scripts.
+type Page struct {
+	Action string
+	Fields []Field
+	Nonce  string
+}
+
+// DefaultTemplate is a minimal page that POSTs its fields as soon as it loads. Without JavaScript, the user submits
+// the form.
+var DefaultTemplate = template.Must(template.New("autoSubmit").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Returning to the platform</title></head>
+<body>
+<form id="lti-auto-submit" method="POST" action="{{.Action}}">
+{{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{end}}<noscript><button type="submit">Continue</button></noscript>
+</form>
+<script{{if .Nonce}} nonce="{{.Nonce}}"{{end}}>document.getElementById("lti-auto-submit").submit();</script>
+</body>
+</html>
+`))
+
+// A Renderer renders auto-submit pages with its Template, which is executed with a Page. When the Template is nil,
+// DefaultTemplate is used.
+type Renderer struct {
+	Template *template.Template
+}
+
+// DefaultRenderer is the Renderer used by the package-level Write and WriteWithPolicy, and by the other packages of
+// this module for their auto-submit pages. Setting its Template replaces the page throughout.
+var DefaultRenderer = &Renderer{}
+
+// Write writes a page holding a form that POSTs the fields to the action URL as soon as the page loads. If the nonce
+// is not empty, it is set on the page's script so that a Content Security Policy using the nonce (see
+// ContentSecurityPolicy) permits it; the caller is then responsible for the policy header.
+func (r *Renderer) Write(w http.ResponseWriter, action string, fields []Field, nonce string) error {
+	if action == "" {
+		return errors.New("received empty form action")
+	}
+
+	page := r.Template
+	if page == nil {
+		page = DefaultTemplate
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return page.Execute(w, Page{
+		Action: action,
+		Fields: fields,
+		Nonce:  nonce,
+	})
+}
+
+// WriteWithPolicy is like Write, but it generates a nonce and sets a restrictive Content Security Policy header
+// permitting only the page's script and submission of the form to the action URL's origin.
+func (r *Renderer) WriteWithPolicy(w http.ResponseWriter, action string, fields []Field) error {
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	policy, err := ContentSecurityPolicy(action, nonce)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Security-Policy", policy)
+
+	return r.Write(w, action, fields, nonce)
+}
+
+// Write writes an auto-submit page with DefaultRenderer. See Renderer.Write.
+func Write(w http.ResponseWriter, action string, fields []Field, nonce string) error {
+	return DefaultRenderer.Write(w, action, fields, nonce)
+}
+
+// WriteWithPolicy writes an auto-submit page with DefaultRenderer. See Renderer.WriteWithPolicy.
+func WriteWithPolicy(w http.ResponseWriter, action string, fields []Field) error {
+	return DefaultRenderer.WriteWithPolicy(w, action, fields)
+}
+
+// URLFields splits a URL into the action URL, without its query, and the fields of its query, e.g., to POST a request
+// built as a redirect URL. The fields are sorted by name; parameters with several values yield several fields.
+func URLFields(rawURL string) (string, []Field, error) {
+	actionURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse form action: %w", err)
+	}
+	values := actionURL.Query()
+	actionURL.RawQuery = ""
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []Field
+	for _, name := range names {
+		for _, value := range values[name] {
+			fields = append(fields, Field{Name: name, Value: value})
+		}
+	}
+
+	return actionURL.String(), fields, nil
+}
+
+// Fields returns the values as form fields, sorted by name.
+func Fields(values map[string]string) []Field {
+	fields := make([]Field, 0, len(values))
+	for name, value := range values {
+		fields = append(fields, Field{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields
+}
+
+// NewNonce returns a random nonce for a Content Security Policy.
+func NewNonce() (string, error) {
+	b := make([]byte, nonceBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// ContentSecurityPolicy returns a Content Security Policy for an auto-submit page: only scripts bearing the nonce
+// run, and the form may only be submitted to the origin of the action URL. Framing is not restricted, since tools are
+// commonly displayed in an iframe of the platform.
+func ContentSecurityPolicy(action, nonce string) (string, error) {
+	actionURL, err := url.Parse(action)
+	if err != nil {
+		return "", fmt.Errorf("could not parse form action: %w", err)
+	}
+	if actionURL.Scheme == "" || actionURL.Host == "" {
+		return "", errors.New("form action is not an absolute URL")
+	}
+
+	return fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; form-action %s://%s; base-uri 'none'", nonce,
+		actionURL.Scheme, actionURL.Host), nil
+}
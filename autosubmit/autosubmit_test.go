@@ -0,0 +1,98 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package autosubmit
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := Write(recorder, "https://platform.tld/return?a=1&b=2", Fields(map[string]string{
+		"JWT":   "header.payload.signature",
+		"state": `"quoted"`,
+	}), "abc123")
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`action="https://platform.tld/return?a=1&amp;b=2"`,
+		`name="JWT" value="header.payload.signature"`,
+		`name="state" value="&#34;quoted&#34;"`,
+		`<script nonce="abc123">`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("form missing %s: %s", expected, body)
+		}
+	}
+	if recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Error("form cacheable")
+	}
+
+	recorder = httptest.NewRecorder()
+	Write(recorder, "https://platform.tld/return", nil, "")
+	if !strings.Contains(recorder.Body.String(), "<script>") {
+		t.Errorf("script without nonce not written: %s", recorder.Body.String())
+	}
+
+	if err = Write(httptest.NewRecorder(), "", nil, ""); err == nil {
+		t.Error("empty action not reported")
+	}
+}
+
+func TestWriteWithPolicy(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	err := WriteWithPolicy(recorder, "https://platform.tld/return", Fields(map[string]string{"JWT": "a.b.c"}))
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	policy := recorder.Header().Get("Content-Security-Policy")
+	if !strings.Contains(policy, "form-action https://platform.tld;") {
+		t.Errorf("got policy %s", policy)
+	}
+	start := strings.Index(policy, "'nonce-") + len("'nonce-")
+	nonce := policy[start : start+strings.Index(policy[start:], "'")]
+	if !strings.Contains(recorder.Body.String(), `<script nonce="`+nonce+`">`) {
+		t.Errorf("script nonce does not match policy %s: %s", policy, recorder.Body.String())
+	}
+
+	if err = WriteWithPolicy(httptest.NewRecorder(), "/relative", nil); err == nil {
+		t.Error("relative action not reported")
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	renderer := &Renderer{Template: template.Must(template.New("custom").Parse(
+		`<p>{{.Action}}</p>{{range .Fields}}<i>{{.Name}}={{.Value}}</i>{{end}}<script nonce="{{.Nonce}}"></script>`))}
+	recorder := httptest.NewRecorder()
+	err := renderer.WriteWithPolicy(recorder, "https://platform.tld/auth", []Field{{Name: "state", Value: "<s>"}})
+	if err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "<p>https://platform.tld/auth</p><i>state=&lt;s&gt;</i>") ||
+		recorder.Header().Get("Content-Security-Policy") == "" {
+		t.Errorf("got page %s with headers %v", body, recorder.Header())
+	}
+}
+
+func TestURLFields(t *testing.T) {
+	action, fields, err := URLFields("https://platform.tld/auth?state=s&scope=openid&prompt=none&scope=x")
+	if err != nil {
+		t.Fatalf("URL fields error: %v", err)
+	}
+	want := []Field{{"prompt", "none"}, {"scope", "openid"}, {"scope", "x"}, {"state", "s"}}
+	if action != "https://platform.tld/auth" || !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %s with fields %v", action, fields)
+	}
+}
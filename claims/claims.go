@@ -0,0 +1,87 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package claims provides the identifiers of the claims, message types and version defined by the LTI specifications.
+// Applications and custom validators can reference these identifiers instead of repeating the claim URIs. The
+// LaunchClaims type provides the common launch claims in typed form, and the ResourceLinkRequest,
+// DeepLinkingRequest and SubmissionReviewRequest types add the claims specific to each supported message type.
+//
+// The typed claims are versioned by SchemaVersion, following semantic versioning. Claims and fields are added in
+// minor versions. A field that is to be removed or changed is first marked "Deprecated:" in its documentation, with
+// its replacement, and kept for at least one further minor version; it is only removed or changed in a major version.
+// Fixes to the JSON mapping of a field, i.e., where it did not follow the IMS specifications, are made in patch
+// versions.
+package claims
+
+// LTI core claims.
+// Ref: https://www.imsglobal.org/spec/lti/v1p3/#required-message-claims
+const (
+	Version            = "https://purl.imsglobal.org/spec/lti/claim/version"
+	MessageType        = "https://purl.imsglobal.org/spec/lti/claim/message_type"
+	DeploymentID       = "https://purl.imsglobal.org/spec/lti/claim/deployment_id"
+	TargetLinkURI      = "https://purl.imsglobal.org/spec/lti/claim/target_link_uri"
+	ResourceLink       = "https://purl.imsglobal.org/spec/lti/claim/resource_link"
+	Roles              = "https://purl.imsglobal.org/spec/lti/claim/roles"
+	RoleScopeMentor    = "https://purl.imsglobal.org/spec/lti/claim/role_scope_mentor"
+	Context            = "https://purl.imsglobal.org/spec/lti/claim/context"
+	ToolPlatform       = "https://purl.imsglobal.org/spec/lti/claim/tool_platform"
+	LaunchPresentation = "https://purl.imsglobal.org/spec/lti/claim/launch_presentation"
+	LIS                = "https://purl.imsglobal.org/spec/lti/claim/lis"
+	Custom             = "https://purl.imsglobal.org/spec/lti/claim/custom"
+	ForUser            = "https://purl.imsglobal.org/spec/lti/claim/for_user"
+	LTI11              = "https://purl.imsglobal.org/spec/lti/claim/lti1p1"
+)
+
+// LTI Advantage service claims.
+const (
+	AGSEndpoint          = "https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"
+	NRPSNamesRoleService = "https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice"
+	GroupsService        = "https://purl.imsglobal.org/spec/lti-gs/claim/groupsservice"
+	CaliperEndpoint      = "https://purl.imsglobal.org/spec/lti-ces/claim/caliper-endpoint-service"
+)
+
+// Deep Linking claims.
+// Ref: https://www.imsglobal.org/spec/lti-dl/v2p0
+const (
+	DeepLinkingSettings     = "https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings"
+	DeepLinkingContentItems = "https://purl.imsglobal.org/spec/lti-dl/claim/content_items"
+	DeepLinkingData         = "https://purl.imsglobal.org/spec/lti-dl/claim/data"
+	DeepLinkingMessage      = "https://purl.imsglobal.org/spec/lti-dl/claim/msg"
+	DeepLinkingLog          = "https://purl.imsglobal.org/spec/lti-dl/claim/log"
+	DeepLinkingErrorMessage = "https://purl.imsglobal.org/spec/lti-dl/claim/errormsg"
+	DeepLinkingErrorLog     = "https://purl.imsglobal.org/spec/lti-dl/claim/errorlog"
+)
+
+// Proctoring claims.
+// Ref: https://www.imsglobal.org/spec/proctoring/v1p0
+const (
+	ProctoringAttemptNumber       = "https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number"
+	ProctoringSessionData         = "https://purl.imsglobal.org/spec/lti-ap/claim/session_data"
+	ProctoringStartAssessmentURL  = "https://purl.imsglobal.org/spec/lti-ap/claim/start_assessment_url"
+	ProctoringVerifiedUser        = "https://purl.imsglobal.org/spec/lti-ap/claim/verified_user"
+	ProctoringEndAssessmentReturn = "https://purl.imsglobal.org/spec/lti-ap/claim/end_assessment_return"
+	ProctoringAssessmentControl   = "https://purl.imsglobal.org/spec/lti-ap/claim/acs"
+)
+
+// Dynamic Registration configuration claims.
+// Ref: https://www.imsglobal.org/spec/lti-dr/v1p0
+const (
+	PlatformConfiguration = "https://purl.imsglobal.org/spec/lti-platform-configuration"
+	ToolConfiguration     = "https://purl.imsglobal.org/spec/lti-tool-configuration"
+)
+
+// LTIVersion is the value of the version claim for LTI 1.3.
+const LTIVersion = "1.3.0"
+
+// LTI message types, found in the message_type claim.
+const (
+	MessageTypeResourceLink        = "LtiResourceLinkRequest"
+	MessageTypeDeepLinking         = "LtiDeepLinkingRequest"
+	MessageTypeDeepLinkingResponse = "LtiDeepLinkingResponse"
+	MessageTypeSubmissionReview    = "LtiSubmissionReviewRequest"
+	MessageTypeStartProctoring     = "LtiStartProctoring"
+	MessageTypeStartAssessment     = "LtiStartAssessment"
+	MessageTypeEndAssessment       = "LtiEndAssessment"
+)
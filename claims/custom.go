@@ -0,0 +1,96 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// ErrCustomParameterNotFound is returned when a custom parameter is absent from the custom claim.
+var ErrCustomParameterNotFound = errors.New("custom parameter not found")
+
+// CustomClaim is the custom claim, holding the custom parameters of the link or the tool.
+type CustomClaim map[string]string
+
+// UnmarshalJSON accepts custom parameters whose values are not strings, which some platforms send, and converts them
+// to their JSON text.
+func (c *CustomClaim) UnmarshalJSON(data []byte) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("custom claim improperly formatted: %w", err)
+	}
+
+	custom := make(CustomClaim, len(values))
+	for name, value := range values {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			custom[name] = s
+			continue
+		}
+		custom[name] = string(value)
+	}
+	*c = custom
+
+	return nil
+}
+
+// substitutionVariable matches a substitution variable, e.g., $User.id or $Context.id.history.
+var substitutionVariable = regexp.MustCompile(`^\$[A-Za-z][A-Za-z0-9]*(\.[A-Za-z][A-Za-z0-9]*)+$`)
+
+// String returns the named custom parameter. The boolean reports whether it is present.
+func (c CustomClaim) String(name string) (string, bool) {
+	value, ok := c[name]
+	return value, ok
+}
+
+// Int returns the named custom parameter as an integer.
+func (c CustomClaim) Int(name string) (int, error) {
+	value, ok := c[name]
+	if !ok {
+		return 0, ErrCustomParameterNotFound
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("custom parameter %s is not an integer: %w", name, err)
+	}
+
+	return i, nil
+}
+
+// Bool returns the named custom parameter as a boolean. It accepts the values understood by strconv.ParseBool, e.g.,
+// true, false, 1 and 0.
+func (c CustomClaim) Bool(name string) (bool, error) {
+	value, ok := c[name]
+	if !ok {
+		return false, ErrCustomParameterNotFound
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("custom parameter %s is not a boolean: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// Unexpanded reports whether the named custom parameter holds a substitution variable, such as $User.id, that the
+// platform did not expand. Platforms leave a variable as is when they do not support it or when its value is not
+// available, so the parameter's value should not be used.
+func (c CustomClaim) Unexpanded(name string) bool {
+	return IsSubstitutionVariable(c[name])
+}
+
+// IsSubstitutionVariable reports whether the value is a substitution variable, such as $User.id.
+//
+// Ref: https://www.imsglobal.org/spec/lti/v1p3#customproperty
+func IsSubstitutionVariable(value string) bool {
+	return substitutionVariable.MatchString(value)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCustomClaim(t *testing.T) {
+	custom := CustomClaim{
+		"section":  "AS01",
+		"week":     "3",
+		"graded":   "true",
+		"user":     "$User.id",
+		"resource": "$ResourceLink.available.startDateTime",
+		"price":    "$5.00",
+	}
+
+	if section, ok := custom.String("section"); !ok || section != "AS01" {
+		t.Errorf("got section %s, %v", section, ok)
+	}
+	if _, ok := custom.String("missing"); ok {
+		t.Error("missing parameter reported present")
+	}
+
+	if week, err := custom.Int("week"); err != nil || week != 3 {
+		t.Errorf("got week %d, error %v", week, err)
+	}
+	if _, err := custom.Int("section"); err == nil {
+		t.Error("non-integer parameter not reported")
+	}
+	if _, err := custom.Int("missing"); !errors.Is(err, ErrCustomParameterNotFound) {
+		t.Errorf("got %v, wanted ErrCustomParameterNotFound", err)
+	}
+
+	if graded, err := custom.Bool("graded"); err != nil || !graded {
+		t.Errorf("got graded %v, error %v", graded, err)
+	}
+	if _, err := custom.Bool("week"); err == nil {
+		t.Error("non-boolean parameter not reported")
+	}
+
+	for name, expected := range map[string]bool{
+		"section":  false,
+		"user":     true,
+		"resource": true,
+		"price":    false,
+		"missing":  false,
+	} {
+		if custom.Unexpanded(name) != expected {
+			t.Errorf("got unexpanded %v for %s", !expected, name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// ErrInvalidAudience is returned when the client ID of a token cannot be determined from its aud and azp claims.
+var ErrInvalidAudience = errors.New("invalid audience")
+
+// LaunchClaims holds the claims of a launch in typed form. Optional claim objects are nil when absent from the launch;
+// identity claims are empty when the platform withholds them.
+//
+// Ref: https://www.imsglobal.org/spec/lti/v1p3#required-message-claims
+type LaunchClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        Audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Nonce           string   `json:"nonce"`
+
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	MiddleName string `json:"middle_name,omitempty"`
+	Picture    string `json:"picture,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Locale     string `json:"locale,omitempty"`
+
+	MessageType        string                   `json:"https://purl.imsglobal.org/spec/lti/claim/message_type"`
+	Version            string                   `json:"https://purl.imsglobal.org/spec/lti/claim/version"`
+	DeploymentID       string                   `json:"https://purl.imsglobal.org/spec/lti/claim/deployment_id"`
+	TargetLinkURI      string                   `json:"https://purl.imsglobal.org/spec/lti/claim/target_link_uri"`
+	ResourceLink       *ResourceLinkClaim       `json:"https://purl.imsglobal.org/spec/lti/claim/resource_link,omitempty"`
+	Roles              []string                 `json:"https://purl.imsglobal.org/spec/lti/claim/roles"`
+	RoleScopeMentor    []string                 `json:"https://purl.imsglobal.org/spec/lti/claim/role_scope_mentor,omitempty"`
+	Context            *ContextClaim            `json:"https://purl.imsglobal.org/spec/lti/claim/context,omitempty"`
+	ToolPlatform       *ToolPlatformClaim       `json:"https://purl.imsglobal.org/spec/lti/claim/tool_platform,omitempty"`
+	LaunchPresentation *LaunchPresentationClaim `json:"https://purl.imsglobal.org/spec/lti/claim/launch_presentation,omitempty"`
+	LIS                *LISClaim                `json:"https://purl.imsglobal.org/spec/lti/claim/lis,omitempty"`
+	Custom             CustomClaim              `json:"https://purl.imsglobal.org/spec/lti/claim/custom,omitempty"`
+}
+
+// ResourceLinkClaim is the resource_link claim, describing the link launched from the platform.
+type ResourceLinkClaim struct {
+	ID          string `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ContextClaim is the context claim, describing the course (or other context) of the launch.
+type ContextClaim struct {
+	ID    string   `json:"id"`
+	Label string   `json:"label,omitempty"`
+	Title string   `json:"title,omitempty"`
+	Type  []string `json:"type,omitempty"`
+}
+
+// ToolPlatformClaim is the tool_platform claim, describing the platform instance.
+type ToolPlatformClaim struct {
+	GUID              string `json:"guid"`
+	ContactEmail      string `json:"contact_email,omitempty"`
+	Description       string `json:"description,omitempty"`
+	Name              string `json:"name,omitempty"`
+	URL               string `json:"url,omitempty"`
+	ProductFamilyCode string `json:"product_family_code,omitempty"`
+	Version           string `json:"version,omitempty"`
+}
+
+// The product family codes sent by common platforms in the tool_platform claim.
+const (
+	ProductFamilyBlackboard  = "BlackboardLearn"
+	ProductFamilyBrightspace = "desire2learn"
+	ProductFamilyCanvas      = "canvas"
+	ProductFamilyMoodle      = "moodle"
+	ProductFamilySakai       = "sakai"
+	ProductFamilySchoology   = "schoology"
+)
+
+// IsProductFamily reports whether the platform belongs to the product family, e.g., ProductFamilyCanvas. Codes are
+// compared without regard to case, since platforms are not consistent in their capitalization.
+func (t ToolPlatformClaim) IsProductFamily(code string) bool {
+	return strings.EqualFold(t.ProductFamilyCode, code)
+}
+
+// LaunchPresentationClaim is the launch_presentation claim, describing how the tool is displayed.
+type LaunchPresentationClaim struct {
+	DocumentTarget string `json:"document_target,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	ReturnURL      string `json:"return_url,omitempty"`
+	Locale         string `json:"locale,omitempty"`
+}
+
+// LISClaim is the lis claim, holding the SIS identifiers of the user and the course. The outcome service fields are
+// sent by platforms that support LTI 1.1 Basic Outcomes for migrated links.
+type LISClaim struct {
+	PersonSourcedID         string `json:"person_sourcedid,omitempty"`
+	CourseOfferingSourcedID string `json:"course_offering_sourcedid,omitempty"`
+	CourseSectionSourcedID  string `json:"course_section_sourcedid,omitempty"`
+	OutcomeServiceURL       string `json:"outcome_service_url,omitempty"`
+	ResultSourcedID         string `json:"result_sourcedid,omitempty"`
+}
+
+// Audience is the aud claim. It is sent either as a single string or as an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts either form of the aud claim.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud claim improperly formatted: %w", err)
+	}
+	*a = multiple
+
+	return nil
+}
+
+// ClientID returns the client ID that the token was issued to, following the OpenID Connect rules for the aud and azp
+// claims given the authorized party (azp) of the token, which is empty when the claim is absent. The audience must not
+// be empty. When the authorized party is present, it must be one of the audience and it is the client ID; otherwise,
+// the audience must be a single client ID, since a token for several audiences does not tell which of them it was
+// issued to. An error wrapping ErrInvalidAudience is returned when these rules are not met.
+//
+// Ref: https://www.imsglobal.org/spec/security/v1p0/#authentication-response-validation
+func (a Audience) ClientID(authorizedParty string) (string, error) {
+	if len(a) == 0 {
+		return "", fmt.Errorf("%w: aud claim is empty", ErrInvalidAudience)
+	}
+
+	if authorizedParty != "" {
+		for _, audience := range a {
+			if audience == authorizedParty {
+				return authorizedParty, nil
+			}
+		}
+		return "", fmt.Errorf("%w: azp %s not found in aud claim", ErrInvalidAudience, authorizedParty)
+	}
+
+	if len(a) > 1 {
+		return "", fmt.Errorf("%w: azp claim required for %d audiences", ErrInvalidAudience, len(a))
+	}
+
+	return a[0], nil
+}
+
+// ClientID returns the client ID that the launch was issued to. See Audience.ClientID.
+func (c LaunchClaims) ClientID() (string, error) {
+	return c.Audience.ClientID(c.AuthorizedParty)
+}
+
+// TokenClientID returns the client ID that the token was issued to, by its aud and azp claims. See Audience.ClientID.
+func TokenClientID(token jwt.Token) (string, error) {
+	var authorizedParty string
+	if value, ok := token.Get("azp"); ok {
+		if authorizedParty, ok = value.(string); !ok {
+			return "", fmt.Errorf("%w: azp claim improperly formatted", ErrInvalidAudience)
+		}
+	}
+
+	return Audience(token.Audience()).ClientID(authorizedParty)
+}
+
+// ParseLaunchClaims decodes launch data, as stored by the launch, into LaunchClaims.
+func ParseLaunchClaims(launchData json.RawMessage) (LaunchClaims, error) {
+	var launchClaims LaunchClaims
+	err := json.Unmarshal(launchData, &launchClaims)
+	if err != nil {
+		return LaunchClaims{}, fmt.Errorf("could not decode launch claims: %w", err)
+	}
+
+	return launchClaims, nil
+}
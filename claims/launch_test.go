@@ -0,0 +1,96 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestParseLaunchClaims(t *testing.T) {
+	launchData := json.RawMessage(`{
+		"iss": "https://platform.tld",
+		"sub": "a",
+		"aud": "abcdef123456",
+		"given_name": "A",
+		"https://purl.imsglobal.org/spec/lti/claim/message_type": "LtiResourceLinkRequest",
+		"https://purl.imsglobal.org/spec/lti/claim/roles": ["http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"],
+		"https://purl.imsglobal.org/spec/lti/claim/context": {"id": "c", "label": "CMPT 101", "type": ["CourseOffering"]},
+		"https://purl.imsglobal.org/spec/lti/claim/launch_presentation": {"document_target": "iframe", "height": 600},
+		"https://purl.imsglobal.org/spec/lti/claim/custom": {"section": "AS01", "week": 3}
+	}`)
+
+	launchClaims, err := ParseLaunchClaims(launchData)
+	if err != nil {
+		t.Fatalf("parse launch claims error: %v", err)
+	}
+	if len(launchClaims.Audience) != 1 || launchClaims.Audience[0] != "abcdef123456" {
+		t.Errorf("got audience %v", launchClaims.Audience)
+	}
+	if launchClaims.MessageType != MessageTypeResourceLink || launchClaims.GivenName != "A" || len(launchClaims.Roles) != 1 {
+		t.Errorf("got launch claims %+v", launchClaims)
+	}
+	if launchClaims.Context == nil || launchClaims.Context.Label != "CMPT 101" {
+		t.Errorf("got context %+v", launchClaims.Context)
+	}
+	if launchClaims.LaunchPresentation.Height != 600 || launchClaims.ResourceLink != nil {
+		t.Errorf("got launch presentation %+v and resource link %+v", launchClaims.LaunchPresentation,
+			launchClaims.ResourceLink)
+	}
+	if launchClaims.Custom["section"] != "AS01" || launchClaims.Custom["week"] != "3" {
+		t.Errorf("got custom %v", launchClaims.Custom)
+	}
+
+	_, err = ParseLaunchClaims(json.RawMessage(`{"aud": 1}`))
+	if err == nil {
+		t.Error("improper audience not reported")
+	}
+}
+
+func TestAudienceClientID(t *testing.T) {
+	tests := []struct {
+		audience        Audience
+		authorizedParty string
+		want            string
+	}{
+		{Audience{"a"}, "", "a"},
+		{Audience{"a"}, "a", "a"},
+		{Audience{"a", "b"}, "b", "b"},
+		{Audience{}, "", ""},
+		{Audience{}, "a", ""},
+		{Audience{"a", "b"}, "", ""},
+		{Audience{"a"}, "b", ""},
+	}
+
+	for _, test := range tests {
+		got, err := test.audience.ClientID(test.authorizedParty)
+		if got != test.want || (test.want == "") != errors.Is(err, ErrInvalidAudience) {
+			t.Errorf("%v with azp %q: got %q, %v, wanted %q", test.audience, test.authorizedParty, got, err,
+				test.want)
+		}
+	}
+}
+
+func TestTokenClientID(t *testing.T) {
+	token := jwt.New()
+	token.Set(jwt.AudienceKey, []string{"a", "b"})
+	if _, err := TokenClientID(token); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("got %v without azp, wanted ErrInvalidAudience", err)
+	}
+
+	token.Set("azp", "b")
+	if clientID, err := TokenClientID(token); clientID != "b" || err != nil {
+		t.Errorf("got %q, %v, wanted the azp", clientID, err)
+	}
+
+	token.Set("azp", 1)
+	if _, err := TokenClientID(token); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("got %v for improper azp, wanted ErrInvalidAudience", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the semantic version of the typed claims of this package: LaunchClaims, the message types and the
+// claim types they hold. It follows the deprecation policy described in the package documentation, so that
+// applications can tell which changes to expect when upgrading.
+const SchemaVersion = "1.0.0"
+
+// ErrMessageTypeMismatch is returned when launch data is parsed as a message type other than its own.
+var ErrMessageTypeMismatch = errors.New("launch data is not of the requested message type")
+
+// ResourceLinkRequest holds the claims of a resource link launch (LtiResourceLinkRequest), the launch of a link placed
+// in the platform, e.g., in a course.
+//
+// Ref: https://www.imsglobal.org/spec/lti/v1p3#resource-link-launch-request-message
+type ResourceLinkRequest struct {
+	LaunchClaims
+	AGSEndpoint      *AGSEndpointClaim      `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint,omitempty"`
+	NamesRoleService *NamesRoleServiceClaim `json:"https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice,omitempty"`
+}
+
+// DeepLinkingRequest holds the claims of a deep linking launch (LtiDeepLinkingRequest), in which the user selects
+// content for the platform to link to.
+//
+// Ref: https://www.imsglobal.org/spec/lti-dl/v2p0#deep-linking-request-message
+type DeepLinkingRequest struct {
+	LaunchClaims
+	DeepLinkingSettings *DeepLinkingSettingsClaim `json:"https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings"`
+	AGSEndpoint         *AGSEndpointClaim         `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint,omitempty"`
+	NamesRoleService    *NamesRoleServiceClaim    `json:"https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice,omitempty"`
+}
+
+// SubmissionReviewRequest holds the claims of a submission review launch (LtiSubmissionReviewRequest), opened from
+// the platform's gradebook to review a user's submission for a line item.
+//
+// Ref: https://www.imsglobal.org/spec/lti-ags/v2p0#submission-review-message
+type SubmissionReviewRequest struct {
+	LaunchClaims
+	ForUser     *ForUserClaim     `json:"https://purl.imsglobal.org/spec/lti/claim/for_user"`
+	AGSEndpoint *AGSEndpointClaim `json:"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint"`
+}
+
+// DeepLinkingSettingsClaim is the deep_linking_settings claim, describing the content the platform accepts and where
+// the response is returned. AcceptMultiple and AcceptLineItem are nil when the platform leaves them unspecified.
+type DeepLinkingSettingsClaim struct {
+	DeepLinkReturnURL                 string   `json:"deep_link_return_url"`
+	AcceptTypes                       []string `json:"accept_types"`
+	AcceptPresentationDocumentTargets []string `json:"accept_presentation_document_targets"`
+	AcceptMediaTypes                  string   `json:"accept_media_types,omitempty"`
+	AcceptMultiple                    *bool    `json:"accept_multiple,omitempty"`
+	AcceptLineItem                    *bool    `json:"accept_lineitem,omitempty"`
+	AutoCreate                        bool     `json:"auto_create,omitempty"`
+	Title                             string   `json:"title,omitempty"`
+	Text                              string   `json:"text,omitempty"`
+	Data                              string   `json:"data,omitempty"`
+}
+
+// ForUserClaim is the for_user claim, identifying the user acted upon, e.g., the student whose submission is
+// reviewed. Only UserID is required.
+type ForUserClaim struct {
+	UserID          string   `json:"user_id"`
+	PersonSourcedID string   `json:"person_sourcedid,omitempty"`
+	GivenName       string   `json:"given_name,omitempty"`
+	FamilyName      string   `json:"family_name,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	Roles           []string `json:"roles,omitempty"`
+}
+
+// AGSEndpointClaim is the Assignment and Grade Services endpoint claim, listing the scopes granted to the tool and the
+// line item endpoints of the launch.
+type AGSEndpointClaim struct {
+	Scope     []string `json:"scope"`
+	LineItems string   `json:"lineitems,omitempty"`
+	LineItem  string   `json:"lineitem,omitempty"`
+}
+
+// NamesRoleServiceClaim is the Names and Role Provisioning Services claim, holding the context's membership endpoint.
+type NamesRoleServiceClaim struct {
+	ContextMembershipsURL string   `json:"context_memberships_url"`
+	ServiceVersions       []string `json:"service_versions"`
+}
+
+// ParseResourceLinkRequest decodes the launch data of a resource link launch. It returns an error wrapping
+// ErrMessageTypeMismatch for launch data of another message type.
+func ParseResourceLinkRequest(launchData json.RawMessage) (ResourceLinkRequest, error) {
+	var request ResourceLinkRequest
+	err := parseMessage(launchData, MessageTypeResourceLink, &request, &request.LaunchClaims)
+	if err != nil {
+		return ResourceLinkRequest{}, err
+	}
+
+	return request, nil
+}
+
+// ParseDeepLinkingRequest decodes the launch data of a deep linking launch. It returns an error wrapping
+// ErrMessageTypeMismatch for launch data of another message type.
+func ParseDeepLinkingRequest(launchData json.RawMessage) (DeepLinkingRequest, error) {
+	var request DeepLinkingRequest
+	err := parseMessage(launchData, MessageTypeDeepLinking, &request, &request.LaunchClaims)
+	if err != nil {
+		return DeepLinkingRequest{}, err
+	}
+
+	return request, nil
+}
+
+// ParseSubmissionReviewRequest decodes the launch data of a submission review launch. It returns an error wrapping
+// ErrMessageTypeMismatch for launch data of another message type.
+func ParseSubmissionReviewRequest(launchData json.RawMessage) (SubmissionReviewRequest, error) {
+	var request SubmissionReviewRequest
+	err := parseMessage(launchData, MessageTypeSubmissionReview, &request, &request.LaunchClaims)
+	if err != nil {
+		return SubmissionReviewRequest{}, err
+	}
+
+	return request, nil
+}
+
+// parseMessage decodes launch data into the message, whose embedded LaunchClaims are given, and checks its message
+// type.
+func parseMessage(launchData json.RawMessage, messageType string, message interface{},
+	launchClaims *LaunchClaims) error {
+	err := json.Unmarshal(launchData, message)
+	if err != nil {
+		return fmt.Errorf("could not decode %s claims: %w", messageType, err)
+	}
+	if launchClaims.MessageType != messageType {
+		return fmt.Errorf("%w: got %s, wanted %s", ErrMessageTypeMismatch, launchClaims.MessageType, messageType)
+	}
+
+	return nil
+}
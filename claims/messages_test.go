@@ -0,0 +1,156 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package claims
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// messageFixtures holds launch data of each supported message type, following the examples of the IMS
+// specifications.
+var messageFixtures = map[string]string{
+	MessageTypeResourceLink: `{
+		"iss": "https://platform.example.edu",
+		"sub": "a6d5c443-1f51-4783-ba1a-7686ffe3b54a",
+		"aud": ["962fa4d8-bcbf-49a0-94b2-2de05ad274af"],
+		"nonce": "fc5fdc6d-5dd6-47f4-b2c9-5d1216e9b771",
+		"https://purl.imsglobal.org/spec/lti/claim/message_type": "LtiResourceLinkRequest",
+		"https://purl.imsglobal.org/spec/lti/claim/version": "1.3.0",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "07940580-b309-415e-a37c-914d387c1150",
+		"https://purl.imsglobal.org/spec/lti/claim/target_link_uri": "https://tool.example.com/lti/48320/ruix8782rs",
+		"https://purl.imsglobal.org/spec/lti/claim/resource_link": {"id": "200d101f-2c14-434a-a0f3-57c2a42369fd"},
+		"https://purl.imsglobal.org/spec/lti/claim/roles": ["http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"],
+		"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint": {
+			"scope": ["https://purl.imsglobal.org/spec/lti-ags/scope/score"],
+			"lineitem": "https://www.myuniv.example.com/2344/lineitems/1234/lineitem"
+		},
+		"https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice": {
+			"context_memberships_url": "https://www.myuniv.example.com/2344/memberships",
+			"service_versions": ["2.0"]
+		}
+	}`,
+	MessageTypeDeepLinking: `{
+		"iss": "https://platform.example.edu",
+		"sub": "a6d5c443-1f51-4783-ba1a-7686ffe3b54a",
+		"aud": "962fa4d8-bcbf-49a0-94b2-2de05ad274af",
+		"nonce": "fc5fdc6d-5dd6-47f4-b2c9-5d1216e9b771",
+		"https://purl.imsglobal.org/spec/lti/claim/message_type": "LtiDeepLinkingRequest",
+		"https://purl.imsglobal.org/spec/lti/claim/version": "1.3.0",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "07940580-b309-415e-a37c-914d387c1150",
+		"https://purl.imsglobal.org/spec/lti/claim/roles": ["http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"],
+		"https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings": {
+			"deep_link_return_url": "https://platform.example/deep_links",
+			"accept_types": ["link", "file", "html", "ltiResourceLink", "image"],
+			"accept_media_types": "image/*,text/html",
+			"accept_presentation_document_targets": ["iframe", "window", "embed"],
+			"accept_multiple": true,
+			"auto_create": true,
+			"title": "This is the default title",
+			"text": "This is the default text",
+			"data": "csrftoken:c7fbba78-7b75-46e3-9201-11e6d5f36f53"
+		}
+	}`,
+	MessageTypeSubmissionReview: `{
+		"iss": "https://platform.example.edu",
+		"sub": "a6d5c443-1f51-4783-ba1a-7686ffe3b54a",
+		"aud": "962fa4d8-bcbf-49a0-94b2-2de05ad274af",
+		"nonce": "fc5fdc6d-5dd6-47f4-b2c9-5d1216e9b771",
+		"https://purl.imsglobal.org/spec/lti/claim/message_type": "LtiSubmissionReviewRequest",
+		"https://purl.imsglobal.org/spec/lti/claim/version": "1.3.0",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "07940580-b309-415e-a37c-914d387c1150",
+		"https://purl.imsglobal.org/spec/lti/claim/roles": ["http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"],
+		"https://purl.imsglobal.org/spec/lti/claim/for_user": {
+			"user_id": "9d2cbe35-8ea4-4f9e-a3b1-7e7a1c1c1e41",
+			"person_sourcedid": "example.edu:71ee7e42-f6d2-414a-80db-b69ac2defd4",
+			"given_name": "Jane",
+			"family_name": "Doe",
+			"roles": ["http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"]
+		},
+		"https://purl.imsglobal.org/spec/lti-ags/claim/endpoint": {
+			"scope": ["https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"],
+			"lineitems": "https://www.myuniv.example.com/2344/lineitems/",
+			"lineitem": "https://www.myuniv.example.com/2344/lineitems/1234/lineitem"
+		}
+	}`,
+}
+
+func TestParseMessages(t *testing.T) {
+	resourceLink, err := ParseResourceLinkRequest(json.RawMessage(messageFixtures[MessageTypeResourceLink]))
+	if err != nil {
+		t.Fatalf("parse resource link request error: %v", err)
+	}
+	if resourceLink.ResourceLink == nil || resourceLink.AGSEndpoint == nil || resourceLink.NamesRoleService == nil ||
+		resourceLink.NamesRoleService.ServiceVersions[0] != "2.0" || resourceLink.Audience[0] == "" {
+		t.Errorf("got resource link request %+v", resourceLink)
+	}
+
+	deepLinking, err := ParseDeepLinkingRequest(json.RawMessage(messageFixtures[MessageTypeDeepLinking]))
+	if err != nil {
+		t.Fatalf("parse deep linking request error: %v", err)
+	}
+	settings := deepLinking.DeepLinkingSettings
+	if settings == nil || len(settings.AcceptTypes) != 5 || settings.AcceptMultiple == nil ||
+		!*settings.AcceptMultiple || settings.AcceptLineItem != nil || settings.Data == "" {
+		t.Errorf("got deep linking settings %+v", settings)
+	}
+
+	submissionReview, err := ParseSubmissionReviewRequest(json.RawMessage(messageFixtures[MessageTypeSubmissionReview]))
+	if err != nil {
+		t.Fatalf("parse submission review request error: %v", err)
+	}
+	if submissionReview.ForUser == nil || submissionReview.ForUser.GivenName != "Jane" ||
+		submissionReview.AGSEndpoint == nil || submissionReview.AGSEndpoint.LineItem == "" {
+		t.Errorf("got submission review request %+v", submissionReview)
+	}
+
+	_, err = ParseDeepLinkingRequest(json.RawMessage(messageFixtures[MessageTypeResourceLink]))
+	if !errors.Is(err, ErrMessageTypeMismatch) {
+		t.Errorf("got %v, wanted ErrMessageTypeMismatch", err)
+	}
+}
+
+// Test that the message types keep every claim of the fixtures when encoded again, so that no claim of the
+// specifications' examples is dropped by the typed claims.
+func TestMessageFixturesRoundTrip(t *testing.T) {
+	parsers := map[string]func(json.RawMessage) (interface{}, error){
+		MessageTypeResourceLink: func(data json.RawMessage) (interface{}, error) {
+			return ParseResourceLinkRequest(data)
+		},
+		MessageTypeDeepLinking: func(data json.RawMessage) (interface{}, error) {
+			return ParseDeepLinkingRequest(data)
+		},
+		MessageTypeSubmissionReview: func(data json.RawMessage) (interface{}, error) {
+			return ParseSubmissionReviewRequest(data)
+		},
+	}
+
+	for messageType, fixture := range messageFixtures {
+		message, err := parsers[messageType](json.RawMessage(fixture))
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", messageType, err)
+		}
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			t.Fatalf("%s: encode error: %v", messageType, err)
+		}
+
+		var want, got map[string]interface{}
+		json.Unmarshal([]byte(fixture), &want)
+		json.Unmarshal(encoded, &got)
+		// The aud claim is always encoded as an array.
+		if aud, ok := want["aud"].(string); ok {
+			want["aud"] = []interface{}{aud}
+		}
+		for name, value := range want {
+			if !reflect.DeepEqual(got[name], value) {
+				t.Errorf("%s: got %s = %v, wanted %v", messageType, name, got[name], value)
+			}
+		}
+	}
+}
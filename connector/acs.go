@@ -0,0 +1,219 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/scope"
+)
+
+// Assessment Control Service actions.
+const (
+	ControlActionPause     = "pause"
+	ControlActionResume    = "resume"
+	ControlActionTerminate = "terminate"
+	ControlActionUpdate    = "update"
+	ControlActionFlag      = "flag"
+)
+
+// Assessment statuses reported by the platform in response to a control action.
+const (
+	ControlStatusRunning    = "running"
+	ControlStatusPaused     = "paused"
+	ControlStatusTerminated = "terminated"
+	ControlStatusComplete   = "complete"
+)
+
+// Assessment Control Service media types.
+const (
+	controlMediaType       = "application/vnd.ims.lti-ap.v1.control+json"
+	controlResultMediaType = "application/vnd.ims.lti-ap.v1.controlresult+json"
+)
+
+// AssessmentControl implements Assessment Control Service functions, used by proctoring tools to act on an assessment
+// attempt in progress. Actions lists the actions supported by the platform.
+//
+// Ref: https://www.imsglobal.org/spec/proctoring/v1p0#assessment-control-service
+type AssessmentControl struct {
+	Endpoint       *url.URL
+	Actions        []string
+	AttemptNumber  int
+	ResourceLinkID string
+	Target         *Connector
+}
+
+// A ControlRequest describes a control action. IncidentTime defaults to the current time. IncidentSeverity, from 0 to
+// 1, is meaningful for flags; ExtraTime, in seconds, is meaningful for updates.
+type ControlRequest struct {
+	Action           string
+	IncidentTime     time.Time
+	IncidentSeverity float64
+	ReasonCode       string
+	ReasonMessage    string
+	ExtraTime        int
+}
+
+// A ControlResult is the platform's response to a control action: the status of the assessment attempt and any extra
+// time granted.
+type ControlResult struct {
+	Status    string
+	ExtraTime int `json:"extra_time"`
+}
+
+// controlUser identifies the user taking the assessment.
+type controlUser struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+}
+
+// controlBody is the body of a control request.
+type controlBody struct {
+	User             controlUser       `json:"user"`
+	ResourceLink     map[string]string `json:"resource_link"`
+	AttemptNumber    int               `json:"attempt_number"`
+	Action           string            `json:"action"`
+	IncidentTime     string            `json:"incident_time"`
+	IncidentSeverity *float64          `json:"incident_severity,omitempty"`
+	ReasonCode       string            `json:"reason_code,omitempty"`
+	ReasonMessage    string            `json:"reason_msg,omitempty"`
+	ExtraTime        int               `json:"extra_time,omitempty"`
+}
+
+// UpgradeAssessmentControl provides a Connector upgraded for Assessment Control Service calls. The Connector must have
+// been created from a proctoring launch that offers the service.
+func (c *Connector) UpgradeAssessmentControl() (*AssessmentControl, error) {
+	acsRawClaim, ok := c.LaunchToken.Get(claims.ProctoringAssessmentControl)
+	if !ok {
+		return nil, ErrUnsupportedService
+	}
+	acsClaim, ok := acsRawClaim.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("assessment control information improperly formatted")
+	}
+	endpointString, ok := acsClaim["assessment_control_url"].(string)
+	if !ok {
+		return nil, errors.New("assessment control endpoint not found")
+	}
+	endpoint, err := url.Parse(endpointString)
+	if err != nil {
+		return nil, fmt.Errorf("assessment control endpoint parse error: %w", err)
+	}
+
+	control := AssessmentControl{
+		Endpoint: endpoint,
+		Target:   c,
+	}
+	if actions, ok := acsClaim["actions"].([]interface{}); ok {
+		control.Actions = convertInterfaceToStringSlice(actions)
+	}
+
+	rawAttemptNumber, _ := c.LaunchToken.Get(claims.ProctoringAttemptNumber)
+	attemptNumber, ok := rawAttemptNumber.(float64)
+	if !ok {
+		return nil, errors.New("attempt number not found")
+	}
+	control.AttemptNumber = int(attemptNumber)
+
+	rawResourceLink, _ := c.LaunchToken.Get(claims.ResourceLink)
+	resourceLink, _ := rawResourceLink.(map[string]interface{})
+	control.ResourceLinkID, ok = resourceLink["id"].(string)
+	if !ok {
+		return nil, errors.New("resource link ID not found")
+	}
+
+	return &control, nil
+}
+
+// Pause asks the platform to pause the assessment attempt.
+func (a *AssessmentControl) Pause(reasonCode, reasonMessage string) (ControlResult, error) {
+	return a.Send(ControlRequest{Action: ControlActionPause, ReasonCode: reasonCode, ReasonMessage: reasonMessage})
+}
+
+// Resume asks the platform to resume a paused assessment attempt.
+func (a *AssessmentControl) Resume() (ControlResult, error) {
+	return a.Send(ControlRequest{Action: ControlActionResume})
+}
+
+// Terminate asks the platform to end the assessment attempt.
+func (a *AssessmentControl) Terminate(reasonCode, reasonMessage string) (ControlResult, error) {
+	return a.Send(ControlRequest{Action: ControlActionTerminate, ReasonCode: reasonCode, ReasonMessage: reasonMessage})
+}
+
+// Flag reports an incident in the assessment attempt to the platform, with a severity from 0 to 1.
+func (a *AssessmentControl) Flag(severity float64, reasonCode, reasonMessage string) (ControlResult, error) {
+	return a.Send(ControlRequest{
+		Action:           ControlActionFlag,
+		IncidentSeverity: severity,
+		ReasonCode:       reasonCode,
+		ReasonMessage:    reasonMessage,
+	})
+}
+
+// Send sends the control action for the launching user's assessment attempt. The action must be one of those
+// supported by the platform.
+func (a *AssessmentControl) Send(request ControlRequest) (ControlResult, error) {
+	if !contains(request.Action, a.Actions) {
+		return ControlResult{}, fmt.Errorf("platform does not support the %s action", request.Action)
+	}
+	if request.IncidentSeverity < 0 || request.IncidentSeverity > 1 {
+		return ControlResult{}, errors.New("incident severity out of range")
+	}
+	if request.IncidentTime.IsZero() {
+		request.IncidentTime = a.Target.now()
+	}
+
+	body := controlBody{
+		User: controlUser{
+			Issuer:  a.Target.LaunchToken.Issuer(),
+			Subject: a.Target.LaunchToken.Subject(),
+		},
+		ResourceLink:  map[string]string{"id": a.ResourceLinkID},
+		AttemptNumber: a.AttemptNumber,
+		Action:        request.Action,
+		IncidentTime:  request.IncidentTime.UTC().Format(time.RFC3339),
+		ReasonCode:    request.ReasonCode,
+		ReasonMessage: request.ReasonMessage,
+		ExtraTime:     request.ExtraTime,
+	}
+	if request.Action == ControlActionFlag {
+		body.IncidentSeverity = &request.IncidentSeverity
+	}
+
+	var encoded bytes.Buffer
+	err := json.NewEncoder(&encoded).Encode(body)
+	if err != nil {
+		return ControlResult{}, fmt.Errorf("could not encode body of control request: %w", err)
+	}
+
+	_, responseBody, err := a.Target.makeServiceRequest(ServiceRequest{
+		Scopes:      []string{scope.AssessmentControl},
+		Method:      http.MethodPost,
+		URI:         a.Endpoint,
+		Body:        &encoded,
+		ContentType: controlMediaType,
+		Accept:      controlResultMediaType,
+	})
+	if err != nil {
+		return ControlResult{}, fmt.Errorf("control request make service request error: %w", err)
+	}
+	defer responseBody.Close()
+
+	var result ControlResult
+	err = json.NewDecoder(responseBody).Decode(&result)
+	if err != nil {
+		return ControlResult{}, fmt.Errorf("could not decode control response body: %w", err)
+	}
+
+	return result, nil
+}
@@ -12,17 +12,31 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/scope"
 )
 
 // AGS implements Assignment & Grades Services functions.
+//
+// Limit and LimitIgnored control the page size of results requests in the same way as for NRPS.
+//
+// When Cursors and CursorKey are set, GetPagedResults stores its progress in Cursors under CursorKey after each page,
+// and a new AGS with the same cursor key resumes from the stored next page, e.g., after a restart of the process.
 type AGS struct {
-	LineItem  *url.URL
-	LineItems *url.URL
-	Scopes    []string
-	NextPage  *url.URL
-	Target    *Connector
+	LineItem     *url.URL
+	LineItems    *url.URL
+	Scopes       []string
+	Limit        int
+	LimitIgnored bool
+	NextPage     *url.URL
+	Cursors      CursorStorer
+	CursorKey    string
+	Target       *Connector
 }
 
 // AGS activityProgress constants.
@@ -43,6 +57,45 @@ const (
 	GradeNotReady        = "NotReady"
 )
 
+// ErrMissingScope is returned by the AGS methods when the launch's endpoint claim does not grant the scope that the
+// operation needs. The error is a *MissingScopeError naming the scope.
+var ErrMissingScope = errors.New("scope not granted by the platform")
+
+// A MissingScopeError reports the scope that an AGS operation needs but that the platform did not grant. Checking the
+// scope before the request spares a token request that the platform would refuse with a less helpful error.
+type MissingScopeError struct {
+	Scope string
+}
+
+// Error names the missing scope.
+func (e *MissingScopeError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrMissingScope, e.Scope)
+}
+
+// Unwrap returns ErrMissingScope, so that errors.Is reports a missing scope as ErrMissingScope.
+func (e *MissingScopeError) Unwrap() error {
+	return ErrMissingScope
+}
+
+// HasScope reports whether the platform granted the scope in the launch's endpoint claim. The lineitem scope grants
+// read access as well, so it satisfies the lineitem.readonly scope.
+func (a *AGS) HasScope(s string) bool {
+	if contains(s, a.Scopes) {
+		return true
+	}
+
+	return s == scope.LineItemReadOnly && contains(scope.LineItem, a.Scopes)
+}
+
+// requireScope returns a *MissingScopeError if the platform did not grant the scope.
+func (a *AGS) requireScope(s string) error {
+	if !a.HasScope(s) {
+		return &MissingScopeError{Scope: s}
+	}
+
+	return nil
+}
+
 // A Score represents a grade assigned by the tool and sent to the platform.
 type Score struct {
 	Timestamp        string  `json:"timestamp"`
@@ -79,7 +132,7 @@ type LineItem struct {
 // UpgradeAGS provides a Connector upgraded for AGS calls.
 func (c *Connector) UpgradeAGS() (*AGS, error) {
 	// Check for endpoint.
-	agsRawClaims, ok := c.LaunchToken.Get("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint")
+	agsRawClaims, ok := c.LaunchToken.Get(claims.AGSEndpoint)
 	if !ok {
 		return nil, ErrUnsupportedService
 	}
@@ -132,20 +185,52 @@ func (c *Connector) UpgradeAGS() (*AGS, error) {
 	}, nil
 }
 
+// lineItemServiceURI composes the URI of a lineitem's scores or results service by appending the service name to the
+// lineitem's path. Some platforms (e.g., Moodle) append query parameters to lineitem URLs, so the service name is
+// added to the path rather than the end of the URL: a trailing slash on the path is dropped, the lineitem's query
+// parameters are kept, and the additional query values are merged into them. The lineitem itself is not modified.
+func lineItemServiceURI(lineItem *url.URL, service string, additionalQuery url.Values) (*url.URL, error) {
+	if lineItem == nil {
+		return nil, errors.New("received nil lineitem URI")
+	}
+
+	query, err := url.ParseQuery(lineItem.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lineitem query values: %w", err)
+	}
+	for key, values := range additionalQuery {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+
+	serviceURI := *lineItem
+	serviceURI.Path = strings.TrimSuffix(lineItem.Path, "/") + "/" + service
+	if lineItem.RawPath != "" {
+		serviceURI.RawPath = strings.TrimSuffix(lineItem.RawPath, "/") + "/" + service
+	}
+	serviceURI.RawQuery = query.Encode()
+	serviceURI.Fragment = ""
+
+	return &serviceURI, nil
+}
+
 // PutScore posts a grade (LTI spec uses term 'score') for the launched resource to the platform's gradebook. The
 // useLaunchUserID argument specifies if the launching user's ID is used; supply false to send the user ID embedded in
 // the score argument.
 func (a *AGS) PutScore(s Score, useLaunchUserID bool) error {
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/score"}
+	if a.LineItem == nil {
+		return ErrUnsupportedService
+	}
+	scopes := []string{scope.Score}
+	if err := a.requireScope(scope.Score); err != nil {
+		return err
+	}
 
-	// Make a copy of the lineitem and add the /scores path.
-	scoreURI, err := url.Parse(a.LineItem.String())
+	scoreURI, err := lineItemServiceURI(a.LineItem, "scores", nil)
 	if err != nil {
-		return fmt.Errorf("could not parse score URI: %w", err)
+		return fmt.Errorf("could not compose score URI: %w", err)
 	}
-	scoreURI.Path += "/scores"
-	query := a.LineItem.Query()
-	scoreURI.RawQuery = query.Encode()
 
 	if useLaunchUserID {
 		// The launch data 'sub' claim is the launching user_ID.
@@ -219,19 +304,114 @@ func (a *AGS) resultsGetter(userID string) ([]Result, error) {
 	return results, nil
 }
 
+// DefaultResultsWorkers is the number of lineitems whose results are fetched at once by GetResultsForLineItems when
+// the caller does not supply a number of workers.
+const DefaultResultsWorkers = 4
+
+// A LineItemResultsError reports the lineitems whose results could not be fetched by GetResultsForLineItems. Errors
+// is keyed by lineitem URI.
+type LineItemResultsError struct {
+	Errors map[string]error
+}
+
+// Error summarizes the failed lineitems.
+func (e *LineItemResultsError) Error() string {
+	lineItems := make([]string, 0, len(e.Errors))
+	for lineItem := range e.Errors {
+		lineItems = append(lineItems, lineItem)
+	}
+	sort.Strings(lineItems)
+
+	return fmt.Sprintf("could not get results for %d lineitem(s): %s: %v", len(lineItems), lineItems[0],
+		e.Errors[lineItems[0]])
+}
+
+// GetResultsForLineItems gets the Results of many lineitems (e.g., a whole gradebook) concurrently, using at most
+// `workers' concurrent fetches (DefaultResultsWorkers when zero or less). The results are keyed by lineitem URI. If any
+// lineitem fails, the results of the others are still returned along with a *LineItemResultsError.
+func (a *AGS) GetResultsForLineItems(lineItems []*url.URL, workers int) (map[string][]Result, error) {
+	if workers <= 0 {
+		workers = DefaultResultsWorkers
+	}
+	if workers > len(lineItems) {
+		workers = len(lineItems)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		results  = make(map[string][]Result, len(lineItems))
+		failures = map[string]error{}
+		queue    = make(chan *url.URL)
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lineItem := range queue {
+				// Each lineitem gets its own AGS so that paging state is not shared.
+				lineItemAGS := AGS{
+					LineItem:     lineItem,
+					LineItems:    a.LineItems,
+					Scopes:       a.Scopes,
+					Limit:        a.Limit,
+					LimitIgnored: a.LimitIgnored,
+					Target:       a.Target,
+				}
+				lineItemResults, err := lineItemAGS.GetResults()
+
+				mu.Lock()
+				if err != nil {
+					failures[lineItem.String()] = err
+				} else {
+					results[lineItem.String()] = lineItemResults
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, lineItem := range lineItems {
+		if lineItem == nil {
+			mu.Lock()
+			failures["<nil>"] = errors.New("received nil lineitem URI")
+			mu.Unlock()
+			continue
+		}
+		queue <- lineItem
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return results, &LineItemResultsError{Errors: failures}
+	}
+
+	return results, nil
+}
+
 // GetPagedResults fetches the platform-assigned grades for a lineitem. Note: Platforms are not required to support a
 // Results service 'limit' parameter, see: https://www.imsglobal.org/spec/lti-ags/v2p0/#container-request-filters-0
-// It checks for next page links, fetching and appending them to the output.
+// It checks for next page links, fetching and appending them to the output. If the AGS has a cursor store, the
+// progress is resumed from and saved to it; an error saving the progress is returned along with the page's results.
 func (a *AGS) GetPagedResults(limit int, userID string) ([]Result, bool, error) {
+	if a.LineItem == nil {
+		return []Result{}, false, ErrUnsupportedService
+	}
 	if limit < 0 {
 		return []Result{}, false, errors.New("invalid paging limit")
 	}
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly"}
-
-	query, err := url.ParseQuery(a.LineItem.RawQuery)
-	if err != nil {
-		return []Result{}, false, fmt.Errorf("could not parse lineitem query values: %w", err)
+	if err := a.requireScope(scope.ResultReadOnly); err != nil {
+		return []Result{}, false, err
 	}
+	if err := a.resumeCursor(userID); err != nil {
+		return []Result{}, false, err
+	}
+	limit = pageLimit(limit, a.Limit, a.LimitIgnored)
+	scopes := []string{scope.ResultReadOnly}
+
+	query := url.Values{}
 	if limit != 0 {
 		query.Add("limit", strconv.Itoa(limit))
 	}
@@ -239,13 +419,10 @@ func (a *AGS) GetPagedResults(limit int, userID string) ([]Result, bool, error)
 		query.Add("user_id", userID)
 	}
 
-	// Make a copy of the lineitem and add the /results path.
-	resultURI, err := url.Parse(a.LineItem.String())
+	resultURI, err := lineItemServiceURI(a.LineItem, "results", query)
 	if err != nil {
-		return []Result{}, false, fmt.Errorf("could not parse score URI: %w", err)
+		return []Result{}, false, fmt.Errorf("could not compose results URI: %w", err)
 	}
-	resultURI.Path += "/results"
-	resultURI.RawQuery = query.Encode()
 	s := ServiceRequest{
 		Scopes: scopes,
 		Method: http.MethodGet,
@@ -264,32 +441,37 @@ func (a *AGS) GetPagedResults(limit int, userID string) ([]Result, bool, error)
 
 	defer body.Close()
 	var results []Result
-	err = json.NewDecoder(body).Decode(&results)
+	err = a.Target.decodeResponse(body, s.URI, &results)
 	if err != nil {
 		return []Result{}, false, fmt.Errorf("could not decode get result response body: %w", err)
 	}
 
-	// Get the next page link from the response headers.
-	nextPageLink := headers.Get("link")
-	if nextPageLink == "" || !strings.Contains(nextPageLink, `rel="next"`) {
-		// If there are no further next page links, set the AGS NextPage field to nil.
-		a.NextPage = nil
-		return results, false, nil
+	// Get the next page link from the response headers. If there are no further next page links, the AGS NextPage
+	// field is set to nil.
+	a.NextPage, err = nextPageURI(headers)
+	if err != nil {
+		return []Result{}, false, err
 	}
+	hasMore := a.NextPage != nil
 
-	nextPageString := strings.Trim(nextPageLink, "<>")
-	nextPage, err := url.Parse(nextPageString)
-	if err != nil {
-		return []Result{}, false, fmt.Errorf("could not parse next page URI from response headers: %w", err)
+	a.Limit, a.LimitIgnored = detectPageLimit(limit, len(results), hasMore, a.Limit, a.LimitIgnored)
+
+	if err := a.saveCursor(userID, scopes); err != nil {
+		return results, hasMore, err
 	}
-	a.NextPage = nextPage
 
-	return results, true, nil
+	return results, hasMore, nil
 }
 
 // GetLineItem gets the currently launched AGS lineitem.
 func (a *AGS) GetLineItem() (LineItem, error) {
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"}
+	if a.LineItem == nil {
+		return LineItem{}, ErrUnsupportedService
+	}
+	scopes := []string{scope.LineItemReadOnly}
+	if err := a.requireScope(scope.LineItemReadOnly); err != nil {
+		return LineItem{}, err
+	}
 
 	s := ServiceRequest{
 		Scopes: scopes,
@@ -305,7 +487,7 @@ func (a *AGS) GetLineItem() (LineItem, error) {
 
 	defer body.Close()
 	var lineItem LineItem
-	err = json.NewDecoder(body).Decode(&lineItem)
+	err = a.Target.decodeResponse(body, s.URI, &lineItem)
 	if err != nil {
 		return LineItem{}, fmt.Errorf("could not decode get lineitem response body: %w", err)
 	}
@@ -315,7 +497,13 @@ func (a *AGS) GetLineItem() (LineItem, error) {
 
 // GetLineItems gets all the lineitems for the launched context, i.e. all columns in the course gradebook.
 func (a *AGS) GetLineItems() ([]LineItem, error) {
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"}
+	if a.LineItems == nil {
+		return []LineItem{}, ErrUnsupportedService
+	}
+	scopes := []string{scope.LineItemReadOnly}
+	if err := a.requireScope(scope.LineItemReadOnly); err != nil {
+		return []LineItem{}, err
+	}
 
 	s := ServiceRequest{
 		Scopes: scopes,
@@ -331,7 +519,7 @@ func (a *AGS) GetLineItems() ([]LineItem, error) {
 
 	defer body.Close()
 	var lineItems []LineItem
-	err = json.NewDecoder(body).Decode(&lineItems)
+	err = a.Target.decodeResponse(body, s.URI, &lineItems)
 	if err != nil {
 		return []LineItem{}, fmt.Errorf("could not decode get lineitems response body: %w", err)
 	}
@@ -342,7 +530,10 @@ func (a *AGS) GetLineItems() ([]LineItem, error) {
 // UpdateLineItem sends an encoded LineItem used by the platform to update its definition of the launched lineitem, or
 // the lineitem at the optional notLaunchedLineItemEndpoint parameter if updating the launched lineitem is not desired.
 func (a *AGS) UpdateLineItem(lineItem LineItem, notLaunchedLineItemEndpoint string) (LineItem, error) {
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"}
+	scopes := []string{scope.LineItem}
+	if err := a.requireScope(scope.LineItem); err != nil {
+		return LineItem{}, err
+	}
 
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(lineItem)
@@ -352,6 +543,9 @@ func (a *AGS) UpdateLineItem(lineItem LineItem, notLaunchedLineItemEndpoint stri
 
 	var lineItemToUpdateURI *url.URL
 	if notLaunchedLineItemEndpoint == "" {
+		if a.LineItem == nil {
+			return LineItem{}, ErrUnsupportedService
+		}
 		lineItemToUpdateURI = a.LineItem
 	} else {
 		lineItemToUpdateURI, err = url.Parse(notLaunchedLineItemEndpoint)
@@ -376,7 +570,7 @@ func (a *AGS) UpdateLineItem(lineItem LineItem, notLaunchedLineItemEndpoint stri
 
 	defer responseBody.Close()
 	var updatedLineItem LineItem
-	err = json.NewDecoder(responseBody).Decode(&updatedLineItem)
+	err = a.Target.decodeResponse(responseBody, s.URI, &updatedLineItem)
 	if err != nil {
 		return LineItem{}, fmt.Errorf("could not decode update lineitem response body: %w", err)
 	}
@@ -386,7 +580,13 @@ func (a *AGS) UpdateLineItem(lineItem LineItem, notLaunchedLineItemEndpoint stri
 
 // CreateLineItem creates a new gradebook column in the launched context's lineitems container.
 func (a *AGS) CreateLineItem(lineItem LineItem) (LineItem, error) {
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"}
+	if a.LineItems == nil {
+		return LineItem{}, ErrUnsupportedService
+	}
+	scopes := []string{scope.LineItem}
+	if err := a.requireScope(scope.LineItem); err != nil {
+		return LineItem{}, err
+	}
 
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(lineItem)
@@ -410,7 +610,7 @@ func (a *AGS) CreateLineItem(lineItem LineItem) (LineItem, error) {
 
 	defer responseBody.Close()
 	var createdLineItem LineItem
-	err = json.NewDecoder(responseBody).Decode(&createdLineItem)
+	err = a.Target.decodeResponse(responseBody, s.URI, &createdLineItem)
 	if err != nil {
 		return LineItem{}, fmt.Errorf("could not decode update lineitem response body: %w", err)
 	}
@@ -423,7 +623,10 @@ func (a *AGS) DeleteLineItem(lineItemToDeleteEndpoint string) error {
 	if lineItemToDeleteEndpoint == "" {
 		return errors.New("received empty lineitem to delete")
 	}
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"}
+	scopes := []string{scope.LineItem}
+	if err := a.requireScope(scope.LineItem); err != nil {
+		return err
+	}
 
 	lineItemToDeleteURI, err := url.Parse(lineItemToDeleteEndpoint)
 	if err != nil {
@@ -0,0 +1,135 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macewan-cs/lti/scope"
+)
+
+func TestLineItemServiceURI(t *testing.T) {
+	tests := []struct {
+		lineItem string
+		query    url.Values
+		expected string
+	}{
+		{"https://platform.tld/lineitems/1", nil, "https://platform.tld/lineitems/1/scores"},
+		{"https://platform.tld/lineitems/1/", nil, "https://platform.tld/lineitems/1/scores"},
+		{"https://platform.tld/mod/lti/services.php/2/lineitems/3/lineitem?type_id=1", nil,
+			"https://platform.tld/mod/lti/services.php/2/lineitems/3/lineitem/scores?type_id=1"},
+		{"https://platform.tld/lineitems/1?type_id=1", url.Values{"limit": {"10"}},
+			"https://platform.tld/lineitems/1/scores?limit=10&type_id=1"},
+	}
+
+	for _, test := range tests {
+		lineItem, err := url.Parse(test.lineItem)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %v", test.lineItem, err)
+		}
+
+		actual, err := lineItemServiceURI(lineItem, "scores", test.query)
+		if err != nil {
+			t.Fatalf("lineitem service URI error: %v", err)
+		}
+		if actual.String() != test.expected {
+			t.Errorf("got %s, wanted %s", actual, test.expected)
+		}
+		if lineItem.String() != test.lineItem {
+			t.Errorf("lineitem modified: got %s, wanted %s", lineItem, test.lineItem)
+		}
+	}
+
+	_, err := lineItemServiceURI(nil, "scores", nil)
+	if err == nil {
+		t.Error("error not reported for nil lineitem")
+	}
+}
+
+func TestGetResultsForLineItems(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	// The lineitem requests are tracked, and held long enough to overlap, to check the number of concurrent fetches.
+	var (
+		mu                    sync.Mutex
+		inFlight, maxInFlight int
+		handler               = platform.Config.Handler
+	)
+	tracking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/lineitems/") {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer tracking.Close()
+
+	ags := AGS{Scopes: []string{scope.ResultReadOnly}, Target: newConnectorForTesting(t, tracking.URL)}
+	var lineItems []*url.URL
+	for _, id := range []string{"1", "2", "3", "missing"} {
+		lineItem, _ := url.Parse(tracking.URL + "/lineitems/" + id)
+		lineItems = append(lineItems, lineItem)
+	}
+
+	results, err := ags.GetResultsForLineItems(lineItems, 2)
+	mu.Lock()
+	if maxInFlight != 2 {
+		t.Errorf("got at most %d concurrent fetches, wanted 2", maxInFlight)
+	}
+	mu.Unlock()
+	var resultsErr *LineItemResultsError
+	if !errors.As(err, &resultsErr) {
+		t.Fatalf("got %v, wanted *LineItemResultsError", err)
+	}
+	if _, ok := resultsErr.Errors[lineItems[3].String()]; !ok || len(resultsErr.Errors) != 1 {
+		t.Errorf("got errors %v", resultsErr.Errors)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got results for %d lineitems, wanted 3", len(results))
+	}
+	for _, lineItem := range lineItems[:3] {
+		lineItemResults := results[lineItem.String()]
+		if len(lineItemResults) != 1 || lineItemResults[0].ID != lineItem.Path+"/results" {
+			t.Errorf("got results %v for %s", lineItemResults, lineItem)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	lineItem, _ := url.Parse("https://platform.tld/lineitems/1")
+	ags := AGS{LineItem: lineItem, LineItems: lineItem, Scopes: []string{scope.LineItem}}
+
+	if !ags.HasScope(scope.LineItemReadOnly) {
+		t.Error("lineitem scope does not grant lineitem.readonly")
+	}
+
+	err := ags.PutScore(Score{UserID: "a"}, false)
+	var missing *MissingScopeError
+	if !errors.Is(err, ErrMissingScope) || !errors.As(err, &missing) || missing.Scope != scope.Score {
+		t.Errorf("got %v for put score without score scope", err)
+	}
+	if _, _, err := ags.GetPagedResults(0, ""); !errors.Is(err, ErrMissingScope) {
+		t.Errorf("got %v for results without result.readonly scope", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// DefaultResponseCacheEntries is the capacity of a MemoryResponseCache created with a non-positive capacity.
+const DefaultResponseCacheEntries = 1000
+
+// A CachedResponse is a service response that carried an ETag. Its body is replayed when the platform reports that
+// the resource is unchanged.
+type CachedResponse struct {
+	ETag   string
+	Header http.Header
+	Body   []byte
+}
+
+// A ResponseCache stores service responses for conditional requests. When a Connector has a ResponseCache, GET service
+// requests for a cached resource carry If-None-Match, and a 304 Not Modified response is answered from the cache.
+// Responses without an ETag are not cached, so platforms that do not support ETags are unaffected. Implementations
+// must be safe for concurrent use.
+type ResponseCache interface {
+	Get(key string) (CachedResponse, bool)
+	Put(key string, response CachedResponse)
+}
+
+// MemoryResponseCache is an in-memory ResponseCache that holds a fixed number of responses, discarding the least
+// recently used. The responses may hold personal information, e.g., the names, emails and user IDs of an NRPS roster.
+// They are kept until they are discarded, and they are not removed by lti.PurgeUserData and lti.PurgeContextData, which
+// purge stores only; call Clear after purging, so that the cache does not keep the purged data.
+type MemoryResponseCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// memoryCacheEntry is the value of an element of the MemoryResponseCache's order list.
+type memoryCacheEntry struct {
+	key      string
+	response CachedResponse
+}
+
+var _ ResponseCache = (*MemoryResponseCache)(nil)
+
+// NewMemoryResponseCache returns a *MemoryResponseCache holding up to capacity responses.
+func NewMemoryResponseCache(capacity int) *MemoryResponseCache {
+	if capacity <= 0 {
+		capacity = DefaultResponseCacheEntries
+	}
+
+	return &MemoryResponseCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns a copy of the cached response for the key, so that changes made to it by the caller are not seen by
+// others.
+func (m *MemoryResponseCache) Get(key string) (CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return CachedResponse{}, false
+	}
+	m.order.MoveToFront(element)
+
+	return cloneResponse(element.Value.(*memoryCacheEntry).response), true
+}
+
+// Put caches a copy of the response under the key.
+func (m *MemoryResponseCache) Put(key string, response CachedResponse) {
+	response = cloneResponse(response)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryCacheEntry).response = response
+		m.order.MoveToFront(element)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, response: response})
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Clear removes every cached response.
+func (m *MemoryResponseCache) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.order.Init()
+	m.entries = make(map[string]*list.Element)
+}
+
+// cloneResponse returns a copy of the response that shares neither its header nor its body.
+func cloneResponse(response CachedResponse) CachedResponse {
+	response.Header = response.Header.Clone()
+	response.Body = append([]byte(nil), response.Body...)
+
+	return response
+}
+
+// responseCacheKey identifies a cached response by the registration, the resource and the representation requested.
+func responseCacheKey(issuer, clientID string, s ServiceRequest) string {
+	return issuer + "\x00" + clientID + "\x00" + s.Accept + "\x00" + s.AcceptLanguage + "\x00" + s.URI.String()
+}
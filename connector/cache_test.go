@@ -0,0 +1,93 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	var fullResponses, notModified int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	mux.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"id":"m","members":[{"user_id":"a"},{"user_id":"b"}]}`)
+	})
+	platform := httptest.NewServer(mux)
+	defer platform.Close()
+
+	c := newConnectorForTesting(t, platform.URL)
+	c.ResponseCache = NewMemoryResponseCache(0)
+	endpoint, _ := url.Parse(platform.URL + "/members")
+	nrps := NRPS{Endpoint: endpoint, Target: c}
+
+	for i := 0; i < 3; i++ {
+		membership, err := nrps.GetMembership()
+		if err != nil {
+			t.Fatalf("get membership error: %v", err)
+		}
+		if len(membership.Members) != 2 {
+			t.Fatalf("got %d members, wanted 2", len(membership.Members))
+		}
+	}
+	if fullResponses != 1 || notModified != 2 {
+		t.Errorf("got %d full and %d not modified responses", fullResponses, notModified)
+	}
+}
+
+func TestMemoryResponseCacheEviction(t *testing.T) {
+	cache := NewMemoryResponseCache(2)
+	cache.Put("a", CachedResponse{ETag: "a"})
+	cache.Put("b", CachedResponse{ETag: "b"})
+	cache.Get("a")
+	cache.Put("c", CachedResponse{ETag: "c"})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used response not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if response, ok := cache.Get(key); !ok || response.ETag != key {
+			t.Errorf("got %v for %s", response, key)
+		}
+	}
+}
+
+func TestMemoryResponseCacheCopies(t *testing.T) {
+	cache := NewMemoryResponseCache(0)
+	response := CachedResponse{ETag: "a", Header: http.Header{"Link": {"next"}}, Body: []byte("roster")}
+	cache.Put("a", response)
+	response.Header.Set("Link", "changed")
+	response.Body[0] = 'R'
+
+	got, _ := cache.Get("a")
+	got.Header.Set("Link", "changed")
+	got.Body[0] = 'R'
+	if got, _ = cache.Get("a"); got.Header.Get("Link") != "next" || string(got.Body) != "roster" {
+		t.Errorf("got %v, wanted the response as put", got)
+	}
+
+	cache.Clear()
+	if _, ok := cache.Get("a"); ok {
+		t.Error("response not removed by Clear")
+	}
+	cache.Put("b", CachedResponse{ETag: "b"})
+	if _, ok := cache.Get("b"); !ok {
+		t.Error("response not cached after Clear")
+	}
+}
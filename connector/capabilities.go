@@ -0,0 +1,82 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/url"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/scope"
+)
+
+// SupportsAGS reports whether the launch offers Assignment & Grades Services. Launches that are not tied to a gradable
+// resource, e.g., from course navigation, typically do not.
+func (c *Connector) SupportsAGS() bool {
+	_, ok := c.LaunchToken.Get(claims.AGSEndpoint)
+	return ok
+}
+
+// SupportsNRPS reports whether the launch offers the Names and Role Provisioning Services.
+func (c *Connector) SupportsNRPS() bool {
+	_, ok := c.LaunchToken.Get(claims.NRPSNamesRoleService)
+	return ok
+}
+
+// UpgradeAGSLimited is like UpgradeAGS, but it never fails: it returns an AGS with whatever the launch offers, which
+// may be nothing. The AGS methods that need a missing endpoint return ErrUnsupportedService, and the Can methods
+// report in advance which operations are available, so that a tool can hide grading features instead of handling an
+// upgrade error.
+func (c *Connector) UpgradeAGSLimited() *AGS {
+	ags := AGS{Target: c}
+
+	rawAGSClaim, _ := c.LaunchToken.Get(claims.AGSEndpoint)
+	agsClaim, ok := rawAGSClaim.(map[string]interface{})
+	if !ok {
+		return &ags
+	}
+
+	ags.LineItem = parseOptionalURI(agsClaim["lineitem"])
+	ags.LineItems = parseOptionalURI(agsClaim["lineitems"])
+	if scopes, ok := agsClaim["scope"].([]interface{}); ok {
+		ags.Scopes = convertInterfaceToStringSlice(scopes)
+	}
+
+	return &ags
+}
+
+// parseOptionalURI parses a URI claim value, returning nil if it is absent or invalid.
+func parseOptionalURI(value interface{}) *url.URL {
+	uriString, ok := value.(string)
+	if !ok || uriString == "" {
+		return nil
+	}
+	uri, err := url.Parse(uriString)
+	if err != nil {
+		return nil
+	}
+
+	return uri
+}
+
+// CanPutScore reports whether scores can be posted to the launched lineitem.
+func (a *AGS) CanPutScore() bool {
+	return a.LineItem != nil && a.HasScope(scope.Score)
+}
+
+// CanGetResults reports whether the results of the launched lineitem can be read.
+func (a *AGS) CanGetResults() bool {
+	return a.LineItem != nil && a.HasScope(scope.ResultReadOnly)
+}
+
+// CanReadLineItems reports whether the lineitems of the launched context can be read.
+func (a *AGS) CanReadLineItems() bool {
+	return a.LineItems != nil && a.HasScope(scope.LineItemReadOnly)
+}
+
+// CanManageLineItems reports whether lineitems can be created, updated and deleted in the launched context.
+func (a *AGS) CanManageLineItems() bool {
+	return a.LineItems != nil && a.HasScope(scope.LineItem)
+}
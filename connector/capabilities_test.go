@@ -0,0 +1,56 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestUpgradeAGSLimited(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	if c.SupportsAGS() || c.SupportsNRPS() {
+		t.Error("services reported for launch without service claims")
+	}
+
+	ags := c.UpgradeAGSLimited()
+	if ags.CanPutScore() || ags.CanGetResults() || ags.CanReadLineItems() || ags.CanManageLineItems() {
+		t.Error("capabilities reported without AGS claim")
+	}
+	if err := ags.PutScore(Score{}, false); err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+	if _, err := ags.GetLineItems(); err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+
+	// A launch from outside a gradable resource offers the lineitems container without a lineitem.
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", map[string]interface{}{
+		"lineitems": "https://platform.tld/lineitems",
+		"scope":     []interface{}{"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"},
+	})
+	if !c.SupportsAGS() {
+		t.Error("AGS not reported")
+	}
+	if _, err := c.UpgradeAGS(); err == nil {
+		t.Error("missing lineitem not reported by UpgradeAGS")
+	}
+
+	ags = c.UpgradeAGSLimited()
+	if ags.LineItems == nil || ags.LineItem != nil {
+		t.Fatalf("got lineitem %v and lineitems %v", ags.LineItem, ags.LineItems)
+	}
+	if !ags.CanReadLineItems() || ags.CanManageLineItems() || ags.CanPutScore() || ags.CanGetResults() {
+		t.Error("wrong capabilities for read-only lineitems")
+	}
+	if _, err := ags.GetLineItem(); err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+	if _, _, err := ags.GetPagedResults(0, ""); err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+}
@@ -9,6 +9,7 @@
 package connector
 
 import (
+	"bytes"
 	"context"
 	"crypto/rsa"
 	"crypto/x509"
@@ -21,20 +22,28 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwk"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
 	"github.com/macewan-cs/lti/datastore"
 	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/keyset"
 )
 
 var (
 	// ErrUnsupportedService is returned when the connector cannot be upgraded to either NRPS
 	// or AGS because the platform does not appear to support the service.
 	ErrUnsupportedService = errors.New("platform/LMS does not support the requested service")
+
+	// ErrAssertionReused is returned when the ID (jti) of a client assertion has already been used for the platform's
+	// token endpoint. Platforms reject a reused assertion as a replay, and some lock out the client after repeated
+	// replays, so the assertion is not sent.
+	ErrAssertionReused = errors.New("client assertion ID already used")
 )
 
 const (
@@ -44,31 +53,67 @@ const (
 	ClockSkewAllowanceMinutes = 2
 )
 
+// DefaultPageLimit is the page size requested from paged services (NRPS memberships and AGS results) when the caller
+// does not supply one.
+const DefaultPageLimit = 100
+
 // Timeout value for http clients.
 var timeout time.Duration = time.Second * 15
 
-// A Connector implements the base that underpins LTI 1.3 Advantage, i.e. AGS or NRPS.
+// A Connector implements the base that underpins LTI 1.3 Advantage, i.e. AGS or NRPS. Service requests may be made
+// concurrently through a Connector; AccessToken holds the token of the most recent request.
+//
+// If TokenFailures is set, failures to acquire access tokens from the platform are reported to it. If ResponseCache is
+// set, service responses carrying ETags are cached and revalidated with conditional requests. AdditionalScopes are
+// requested along with the scopes of each service request. The IDs of the client assertions sent to request access
+// tokens are recorded in the Config's replay store, so that an assertion is never sent twice; see ErrAssertionReused.
+//
+// Service requests carry an Accept-Language header so that platform-generated strings match the user's language:
+// AcceptLanguage when it is set, and otherwise the locale of the launch (see Locale).
+//
+// OnUnknownFields, if set, is called with the members of each NRPS and AGS response that the typed structs do not
+// decode, e.g., to log the vendor extensions of platforms while debugging. Responses are then read in full before
+// they are decoded.
 type Connector struct {
-	cfg         datastore.Config
-	keyID       string
-	LaunchID    string
-	LaunchToken jwt.Token
-	SigningKey  *rsa.PrivateKey
-	AccessToken datastore.AccessToken
+	cfg              datastore.Config
+	keyID            string
+	LaunchID         string
+	LaunchToken      jwt.Token
+	SigningKey       *rsa.PrivateKey
+	AccessToken      datastore.AccessToken
+	TokenFailures    *TokenFailureMonitor
+	ResponseCache    ResponseCache
+	AdditionalScopes AdditionalScopes
+	AcceptLanguage   string
+	OnUnknownFields  func(UnknownFieldsEvent)
+
+	scopeProfiles map[string][]string
+
+	mu           sync.Mutex
+	registration *datastore.Registration
 }
 
-// A ServiceRequest structures service (AGS & NRPS) connections between tool and platform.
+// A ServiceRequest structures service (AGS & NRPS) connections between tool and platform. The optional Context
+// bounds the request; a nil Context is equivalent to context.Background(). An empty AcceptLanguage is filled in from
+// the Connector.
 type ServiceRequest struct {
-	Scopes      []string
-	Method      string
-	URI         *url.URL
-	Body        io.Reader
-	ContentType string
-	Accept      string
+	Context        context.Context
+	Scopes         []string
+	Method         string
+	URI            *url.URL
+	Body           io.Reader
+	ContentType    string
+	Accept         string
+	AcceptLanguage string
 }
 
 // New creates a *Connector. To function as expected, a valid launchID must be supplied.
 func New(cfg datastore.Config, launchID, keyID string) (*Connector, error) {
+	return newConnector(cfg, launchID, keyID, nil)
+}
+
+// newConnector creates a *Connector, taking the launch token from the cache, if any, when it holds the launch.
+func newConnector(cfg datastore.Config, launchID, keyID string, launchTokens *LaunchTokenCache) (*Connector, error) {
 	connector := Connector{
 		cfg:      cfg,
 		keyID:    keyID,
@@ -84,39 +129,148 @@ func New(cfg datastore.Config, launchID, keyID string) (*Connector, error) {
 	if connector.cfg.AccessTokens == nil {
 		connector.cfg.AccessTokens = nonpersistent.DefaultStore
 	}
+	if connector.cfg.Replays == nil {
+		connector.cfg.Replays = nonpersistent.DefaultStore
+	}
+
+	if launchTokens != nil {
+		if token, ok := launchTokens.Get(launchID); ok {
+			connector.LaunchToken = token
+			return &connector, nil
+		}
+	}
 
 	err := connector.setLaunchTokenFromLaunchData(launchID)
 	if err != nil {
 		return nil, fmt.Errorf("connector made with empty launch data using launch ID %s: %w", launchID, err)
 	}
+	if launchTokens != nil {
+		launchTokens.Put(launchID, connector.LaunchToken)
+	}
 
 	return &connector, nil
 }
 
-// ClientID returns the client ID associated with the connector.
+// NewStrict is like New, but it returns an error wrapping datastore.ErrStoreNotConfigured instead of falling back on
+// the nonpersistent default store when the Config's launch data, registrations, access tokens or replays store is nil.
+// The replays store records the IDs of the Connector's client assertions.
+func NewStrict(cfg datastore.Config, launchID, keyID string) (*Connector, error) {
+	err := cfg.RequireStores(datastore.LaunchDataStore, datastore.RegistrationsStore, datastore.AccessTokensStore,
+		datastore.ReplaysStore)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cfg, launchID, keyID)
+}
+
+// NewFromLaunchData creates a *Connector from launch data supplied by the caller, e.g., claims carried with each
+// request in a signed header, and the registration of the launch, instead of looking them up in the Config's launch
+// data and registrations stores. The launch data must have been verified, e.g., as stored by a launch, and must have
+// been issued by the registration's issuer to its client ID. The Connector's LaunchID is empty.
+func NewFromLaunchData(cfg datastore.Config, launchData json.RawMessage, registration datastore.Registration,
+	keyID string) (*Connector, error) {
+	launchToken, err := jwt.Parse(launchData)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding launch data: %w", err)
+	}
+
+	connector := Connector{
+		cfg:         cfg,
+		keyID:       keyID,
+		LaunchToken: launchToken,
+	}
+	if connector.cfg.Registrations == nil {
+		connector.cfg.Registrations = nonpersistent.DefaultStore
+	}
+	if connector.cfg.AccessTokens == nil {
+		connector.cfg.AccessTokens = nonpersistent.DefaultStore
+	}
+	if connector.cfg.Replays == nil {
+		connector.cfg.Replays = nonpersistent.DefaultStore
+	}
+
+	clientID, err := claims.TokenClientID(connector.LaunchToken)
+	if err != nil {
+		return nil, err
+	}
+	if launchToken.Issuer() != registration.Issuer || clientID != registration.ClientID {
+		return nil, fmt.Errorf("launch data issued by %s to client ID %s does not match the registration",
+			launchToken.Issuer(), clientID)
+	}
+	if connector.cfg.StrictHTTPS {
+		if err := datastore.ValidateRegistrationSecurity(registration); err != nil {
+			return nil, err
+		}
+	}
+	connector.registration = &registration
+
+	return &connector, nil
+}
+
+// ClientID returns the client ID associated with the connector, or an empty string if the launch token's aud and azp
+// claims do not identify it.
 func (c *Connector) ClientID() string {
-	return c.LaunchToken.Audience()[0]
+	clientID, _ := claims.TokenClientID(c.LaunchToken)
+	return clientID
 }
 
 // SetSigningKey takes a PEM encoded private key and sets the signing key to the corresponding RSA private key.
 func (c *Connector) SetSigningKey(pemPrivateKey string) error {
+	rsaPrivateKey, err := parsePrivateKey(pemPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	c.SigningKey = rsaPrivateKey
+
+	return nil
+}
+
+// parsePrivateKey decodes a PEM encoded RSA private key.
+func parsePrivateKey(pemPrivateKey string) (*rsa.PrivateKey, error) {
 	if len(pemPrivateKey) == 0 {
-		return errors.New("received empty signing key")
+		return nil, errors.New("received empty signing key")
 	}
 
 	pemPrivateKeyBytes := []byte(pemPrivateKey)
 	pemBlock, _ := pem.Decode(pemPrivateKeyBytes)
 	if pemBlock == nil {
-		return errors.New("failed to decode PEM key block")
+		return nil, errors.New("failed to decode PEM key block")
 	}
 	rsaPrivateKey, err := x509.ParsePKCS1PrivateKey(pemBlock.Bytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse RSA key: %w", err)
+		return nil, fmt.Errorf("failed to parse RSA key: %w", err)
 	}
 
-	c.SigningKey = rsaPrivateKey
+	return rsaPrivateKey, nil
+}
 
-	return nil
+// signingKey returns the key used to sign tool-originated JWTs for the registration. A signing key set explicitly on
+// the connector takes precedence; otherwise, the tool's key carried by the registration is used. The latter allows a
+// single process to serve several tools, each with its own key.
+func (c *Connector) signingKey(registration datastore.Registration) (jwk.Key, error) {
+	rsaPrivateKey, keyID := c.SigningKey, c.keyID
+	if rsaPrivateKey == nil {
+		if registration.PrivateKey == "" {
+			return nil, errors.New("signing key has not been set for this connector")
+		}
+
+		var err error
+		rsaPrivateKey, err = parsePrivateKey(registration.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("registration signing key: %w", err)
+		}
+		keyID = registration.KeyID
+	}
+
+	signingKey, err := jwk.New(rsaPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jwk.Key: %w", err)
+	}
+	signingKey.Set(jwk.KeyIDKey, keyID)
+
+	return signingKey, nil
 }
 
 // setTokenFromLaunchData populates the Connector's token with stored launch data that is derived from the OIDC id_token
@@ -144,29 +298,303 @@ func (c *Connector) setLaunchTokenFromLaunchData(launchID string) error {
 	return nil
 }
 
-// getRegistration uses the Connector's LaunchToken issuer to get the associated registration.
+// LaunchClaims returns the claims of the launch in typed form.
+func (c *Connector) LaunchClaims() (claims.LaunchClaims, error) {
+	launchData, err := json.Marshal(c.LaunchToken)
+	if err != nil {
+		return claims.LaunchClaims{}, fmt.Errorf("could not encode launch token: %w", err)
+	}
+
+	return claims.ParseLaunchClaims(launchData)
+}
+
+// Context returns the context claim of the launch, which describes the course (or other context) the tool was launched
+// from. It returns ErrClaimNotFound if the claim is absent, as it may be for launches outside of a course.
+func (c *Connector) Context() (claims.ContextClaim, error) {
+	var launchContext claims.ContextClaim
+	if err := c.decodeClaim(claims.Context, &launchContext); err != nil {
+		return claims.ContextClaim{}, err
+	}
+	if launchContext.ID == "" {
+		return claims.ContextClaim{}, errors.New("context ID not found")
+	}
+
+	return launchContext, nil
+}
+
+// ResourceLink returns the resource_link claim of the launch, which describes the launched link. Its ID can be set as
+// the ResourceLinkID of a lineitem to associate the lineitem with the link. It returns ErrClaimNotFound if the claim
+// is absent, e.g., in a deep linking launch.
+func (c *Connector) ResourceLink() (claims.ResourceLinkClaim, error) {
+	var resourceLink claims.ResourceLinkClaim
+	if err := c.decodeClaim(claims.ResourceLink, &resourceLink); err != nil {
+		return claims.ResourceLinkClaim{}, err
+	}
+	if resourceLink.ID == "" {
+		return claims.ResourceLinkClaim{}, errors.New("resource link ID not found")
+	}
+
+	return resourceLink, nil
+}
+
+// ToolPlatform returns the tool_platform claim of the launch, which describes the platform instance and its product,
+// e.g., to apply behavior specific to a product family. It returns ErrClaimNotFound if the claim is absent.
+func (c *Connector) ToolPlatform() (claims.ToolPlatformClaim, error) {
+	var platform claims.ToolPlatformClaim
+	if err := c.decodeClaim(claims.ToolPlatform, &platform); err != nil {
+		return claims.ToolPlatformClaim{}, err
+	}
+
+	return platform, nil
+}
+
+// LIS returns the lis claim of the launch, which holds the SIS identifiers of the user and the course. It returns
+// ErrClaimNotFound if the claim is absent.
+func (c *Connector) LIS() (claims.LISClaim, error) {
+	var lis claims.LISClaim
+	if err := c.decodeClaim(claims.LIS, &lis); err != nil {
+		return claims.LISClaim{}, err
+	}
+
+	return lis, nil
+}
+
+// Custom returns the custom claim of the launch, which holds the custom parameters of the link or the tool, with
+// typed getters. It returns ErrClaimNotFound if the claim is absent.
+func (c *Connector) Custom() (claims.CustomClaim, error) {
+	var custom claims.CustomClaim
+	if err := c.decodeClaim(claims.Custom, &custom); err != nil {
+		return nil, err
+	}
+
+	return custom, nil
+}
+
+// decodeClaim decodes the named claim of the launch into v by round-tripping it through JSON. It returns
+// ErrClaimNotFound if the claim is absent.
+func (c *Connector) decodeClaim(claim string, v interface{}) error {
+	rawClaim, ok := c.LaunchToken.Get(claim)
+	if !ok {
+		return ErrClaimNotFound
+	}
+
+	encodedClaim, err := json.Marshal(rawClaim)
+	if err != nil {
+		return fmt.Errorf("could not encode %s claim: %w", claim, err)
+	}
+	err = json.Unmarshal(encodedClaim, v)
+	if err != nil {
+		return fmt.Errorf("%s claim improperly formatted: %w", claim, err)
+	}
+
+	return nil
+}
+
+// getRegistration uses the Connector's LaunchToken issuer to get the associated registration. The registration is
+// looked up once and then remembered by the Connector; see InvalidateRegistration.
 func (c *Connector) getRegistration() (datastore.Registration, error) {
-	registration, err := c.cfg.Registrations.FindRegistrationByIssuerAndClientID(c.LaunchToken.Issuer(), c.LaunchToken.Audience()[0])
+	c.mu.Lock()
+	cached := c.registration
+	c.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	clientID, err := claims.TokenClientID(c.LaunchToken)
+	if err != nil {
+		return datastore.Registration{}, err
+	}
+	registration, err := c.cfg.Registrations.FindRegistrationByIssuerAndClientID(c.LaunchToken.Issuer(), clientID)
 	if err != nil {
 		return datastore.Registration{}, err
 	}
 
+	if c.cfg.StrictHTTPS {
+		if err := datastore.ValidateRegistrationSecurity(registration); err != nil {
+			return datastore.Registration{}, err
+		}
+	}
+
+	c.mu.Lock()
+	c.registration = &registration
+	c.mu.Unlock()
+
 	return registration, nil
 }
 
-// PlatformKey gets the Platform's public key from the Registration Keyset URI.
+// InvalidateRegistration makes the Connector forget its registration, so that the next request looks it up again.
+// This is needed only when a registration is changed during the lifetime of a Connector, e.g., after a key rotation.
+func (c *Connector) InvalidateRegistration() {
+	c.mu.Lock()
+	c.registration = nil
+	c.mu.Unlock()
+}
+
+// PlatformKey gets the Platform's public key from the Registration Keyset URI. The keyset is cached by
+// keyset.DefaultCache.
 func (c *Connector) PlatformKey() (jwk.Set, error) {
 	registration, err := c.getRegistration()
 	if err != nil {
 		return nil, err
 	}
 
-	keyset, err := jwk.Fetch(context.Background(), registration.KeysetURI.String())
+	platformKeys, err := keyset.DefaultCache.Fetch(registration.KeysetURI.String())
 	if err != nil {
 		return nil, fmt.Errorf("error fetching keyset: %w", err)
 	}
 
-	return keyset, nil
+	return platformKeys, nil
+}
+
+// pageLimit determines the page size to request. A zero limit falls back on the configured limit and then on
+// DefaultPageLimit. No limit is requested from a platform known to ignore it.
+func pageLimit(limit, configured int, ignored bool) int {
+	if ignored {
+		return 0
+	}
+	if limit == 0 {
+		limit = configured
+	}
+	if limit == 0 {
+		limit = DefaultPageLimit
+	}
+
+	return limit
+}
+
+// detectPageLimit compares the number of items received with the requested limit. A platform returning more items
+// than requested ignores the limit. A platform returning fewer items with more pages available caps the page size, and
+// that cap becomes the configured limit for subsequent requests.
+func detectPageLimit(requested, received int, hasMore bool, configured int, ignored bool) (int, bool) {
+	if requested == 0 {
+		return configured, ignored
+	}
+	if received > requested {
+		return configured, true
+	}
+	if hasMore && received > 0 && received < requested {
+		return received, ignored
+	}
+
+	return configured, ignored
+}
+
+// nextPageURI returns the URI of the next page from the Link header of a paged service response, or nil if there is
+// no next page.
+//
+// Ref: https://www.imsglobal.org/spec/lti-nrps/v2p0#limit-query-parameter
+func nextPageURI(headers http.Header) (*url.URL, error) {
+	for _, link := range headers.Values("Link") {
+		for _, linkValue := range parseLinks(link) {
+			if !contains("next", linkValue.rels) {
+				continue
+			}
+
+			nextPage, err := url.Parse(linkValue.target)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse next page URI from response headers: %w", err)
+			}
+
+			return nextPage, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// A linkValue is a target URI of a Link header and its relation types.
+type linkValue struct {
+	target string
+	rels   []string
+}
+
+// parseLinks parses the link values of a Link header. Each target URI is delimited by angle brackets, so it may contain
+// commas and semicolons. Parsing stops at the first malformed link value.
+//
+// Ref: https://www.rfc-editor.org/rfc/rfc8288#section-3
+func parseLinks(header string) []linkValue {
+	var links []linkValue
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if !strings.HasPrefix(header, "<") {
+			return links
+		}
+		end := strings.IndexByte(header, '>')
+		if end < 0 {
+			return links
+		}
+		link := linkValue{target: header[1:end]}
+		header = header[end+1:]
+
+		// Parse the link's parameters, up to the comma that ends the link value. Parameter values may be quoted.
+		for {
+			header = strings.TrimLeft(header, " \t")
+			if !strings.HasPrefix(header, ";") {
+				break
+			}
+			header = strings.TrimLeft(header[1:], " \t")
+
+			nameEnd := strings.IndexAny(header, "=;,")
+			if nameEnd < 0 {
+				nameEnd = len(header)
+			}
+			name := strings.ToLower(strings.TrimSpace(header[:nameEnd]))
+			header = header[nameEnd:]
+
+			var value string
+			if strings.HasPrefix(header, "=") {
+				value, header = parseLinkParamValue(strings.TrimLeft(header[1:], " \t"))
+			}
+			if name == "rel" && link.rels == nil {
+				link.rels = strings.Fields(strings.ToLower(value))
+			}
+		}
+		links = append(links, link)
+
+		if !strings.HasPrefix(header, ",") {
+			return links
+		}
+	}
+}
+
+// parseLinkParamValue parses a token or quoted string parameter value at the start of s and returns the value and the
+// rest of s.
+func parseLinkParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, ";,")
+		if end < 0 {
+			end = len(s)
+		}
+		return strings.TrimSpace(s[:end]), s[end:]
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+
+	return value.String(), ""
+}
+
+// contains returns whether a string exists in a []string.
+func contains(n string, s []string) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+
+	return false
 }
 
 func convertInterfaceToStringSlice(input []interface{}) []string {
@@ -183,7 +611,7 @@ func (c *Connector) checkAccessTokenStore(tokenURI, clientID string, scopes []st
 	if err != nil {
 		return datastore.AccessToken{}, fmt.Errorf("suitable access token not found: %w", err)
 	}
-	if foundToken.ExpiryTime.Before(time.Now()) {
+	if foundToken.ExpiryTime.Before(c.now()) {
 		return datastore.AccessToken{}, errors.New("access token found but has expired")
 	}
 
@@ -191,23 +619,30 @@ func (c *Connector) checkAccessTokenStore(tokenURI, clientID string, scopes []st
 }
 
 // createRequest creates a signed bearer request JWT as part of an *http.Request to be sent to the platform.
-func (c *Connector) createRequest(tokenURI, clientID string, scopes []string) (*http.Request, error) {
+func (c *Connector) createRequest(registration datastore.Registration, scopes []string) (*http.Request, error) {
+	tokenURI := registration.AuthTokenURI.String()
+	clientID := registration.ClientID
+
 	token := jwt.New()
 	token.Set(jwt.IssuerKey, clientID)
 	token.Set(jwt.SubjectKey, clientID)
 	token.Set(jwt.AudienceKey, tokenURI)
-	token.Set(jwt.IssuedAtKey, time.Now().Add(-time.Minute*ClockSkewAllowanceMinutes))
-	token.Set(jwt.ExpirationKey, time.Now().Add(time.Second*AccessTokenTimeoutSeconds))
-	token.Set(jwt.JwtIDKey, "lti-service-token"+uuid.New().String())
-
-	if c.SigningKey == nil {
-		return nil, errors.New("signing key has not been set for this connector")
+	now := c.now()
+	expiry := now.Add(time.Second * AccessTokenTimeoutSeconds)
+	token.Set(jwt.IssuedAtKey, now.Add(-time.Minute*ClockSkewAllowanceMinutes))
+	token.Set(jwt.ExpirationKey, expiry)
+	assertionID := "lti-service-token" + uuid.New().String()
+	token.Set(jwt.JwtIDKey, assertionID)
+
+	err := c.recordAssertionID(tokenURI, assertionID, expiry)
+	if err != nil {
+		return nil, err
 	}
-	signingKey, err := jwk.New(c.SigningKey)
+
+	signingKey, err := c.signingKey(registration)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create jwk.Key: %w", err)
+		return nil, err
 	}
-	signingKey.Set(jwk.KeyIDKey, c.keyID)
 
 	signedToken, err := jwt.Sign(token, jwa.RS256, signingKey)
 	if err != nil {
@@ -234,8 +669,29 @@ func (c *Connector) createRequest(tokenURI, clientID string, scopes []string) (*
 	return request, nil
 }
 
-// sendRequest sends the bearer token request to the platform and processes the response.
-func sendRequest(req *http.Request) (datastore.AccessToken, error) {
+// recordAssertionID records the ID of a client assertion for the token endpoint until the assertion expires, allowing
+// for the clock skew. It returns an error wrapping ErrAssertionReused if the ID has already been recorded.
+func (c *Connector) recordAssertionID(tokenURI, assertionID string, expiry time.Time) error {
+	err := c.cfg.Replays.StoreTokenID("client-assertion\x00"+tokenURI+"\x00"+assertionID,
+		expiry.Add(time.Minute*ClockSkewAllowanceMinutes))
+	if err != nil {
+		if errors.Is(err, datastore.ErrTokenReplayed) {
+			return fmt.Errorf("%w: %s for %s", ErrAssertionReused, assertionID, tokenURI)
+		}
+		return fmt.Errorf("could not record client assertion ID: %w", err)
+	}
+
+	return nil
+}
+
+// now returns the current time according to the configured clock.
+func (c *Connector) now() time.Time {
+	return datastore.Now(c.cfg.Clock)
+}
+
+// sendRequest sends the bearer token request to the platform and processes the response. The expiry time of the
+// token is computed from the supplied time.
+func sendRequest(req *http.Request, now time.Time) (datastore.AccessToken, error) {
 	client := &http.Client{Timeout: timeout}
 	response, err := client.Do(req)
 	if err != nil {
@@ -269,38 +725,65 @@ func sendRequest(req *http.Request) (datastore.AccessToken, error) {
 	return datastore.AccessToken{
 		TokenURI:   req.URL.String(),
 		Token:      responseToken,
-		ExpiryTime: time.Now().Add(expiry),
+		ExpiryTime: now.Add(expiry),
 	}, nil
 }
 
 // GetAccessToken gets a scoped bearer token for use by a connector.
 func (c *Connector) GetAccessToken(scopes []string) error {
+	_, err := c.accessToken(scopes)
+
+	return err
+}
+
+// accessToken gets a scoped bearer token, either from storage or from the platform, and records it as the Connector's
+// AccessToken. The token is returned so that concurrent requests each use the token for their own scopes.
+func (c *Connector) accessToken(scopes []string) (datastore.AccessToken, error) {
 	registration, err := c.getRegistration()
 	if err != nil {
-		return fmt.Errorf("get registration for access token: %w", err)
+		return datastore.AccessToken{}, fmt.Errorf("get registration for access token: %w", err)
 	}
 
-	storedToken, err := c.checkAccessTokenStore(registration.AuthTokenURI.String(), registration.ClientID, scopes)
-	if err == nil {
-		c.AccessToken = storedToken
-		return nil
-	}
+	token, err := c.checkAccessTokenStore(registration.AuthTokenURI.String(), registration.ClientID, scopes)
+	if err != nil {
+		token, err = c.requestAccessToken(registration, scopes)
+		if c.TokenFailures != nil {
+			if err != nil {
+				c.TokenFailures.recordFailure(registration.Issuer, registration.ClientID,
+					registration.AuthTokenURI.String(), scopes, c.now(), err)
+			} else {
+				c.TokenFailures.recordSuccess(registration.Issuer, registration.ClientID)
+			}
+		}
+		if err != nil {
+			return datastore.AccessToken{}, err
+		}
+		token.ClientID = registration.ClientID
+		token.Scopes = scopes
+
+		c.cfg.AccessTokens.StoreAccessToken(token)
+	}
+
+	c.mu.Lock()
+	c.AccessToken = token
+	c.mu.Unlock()
+
+	return token, nil
+}
 
-	request, err := c.createRequest(registration.AuthTokenURI.String(), registration.ClientID, scopes)
+// requestAccessToken requests a scoped bearer token from the platform.
+func (c *Connector) requestAccessToken(registration datastore.Registration, scopes []string) (datastore.AccessToken,
+	error) {
+	request, err := c.createRequest(registration, scopes)
 	if err != nil {
-		return fmt.Errorf("create request for access token: %w", err)
+		return datastore.AccessToken{}, fmt.Errorf("create request for access token: %w", err)
 	}
-	responseToken, err := sendRequest(request)
+	token, err := sendRequest(request, c.now())
 	if err != nil {
-		return fmt.Errorf("send request for access token: %w", err)
+		return datastore.AccessToken{}, fmt.Errorf("send request for access token: %w", err)
 	}
-	responseToken.ClientID = registration.ClientID
-	responseToken.Scopes = scopes
 
-	c.cfg.AccessTokens.StoreAccessToken(responseToken)
-	c.AccessToken = responseToken
-
-	return nil
+	return token, nil
 }
 
 // makeServiceRequest makes direct tool to platform requests.
@@ -315,19 +798,64 @@ func (c *Connector) makeServiceRequest(s ServiceRequest) (http.Header, io.ReadCl
 	if s.Accept == "" {
 		s.Accept = "application/json"
 	}
+	if s.AcceptLanguage == "" {
+		s.AcceptLanguage = c.AcceptLanguage
+	}
+	if s.AcceptLanguage == "" {
+		s.AcceptLanguage = c.Locale()
+	}
 
-	err := c.GetAccessToken(s.Scopes)
+	if c.cfg.StrictHTTPS {
+		if err := datastore.ValidateSecureURI(s.URI); err != nil {
+			return nil, nil, fmt.Errorf("service request: %w", err)
+		}
+	}
+
+	if c.AdditionalScopes != nil {
+		registration, err := c.getRegistration()
+		if err != nil {
+			return nil, nil, fmt.Errorf("get registration for service request: %w", err)
+		}
+		s.Scopes = mergeScopes(s.Scopes, c.AdditionalScopes.For(registration.Issuer, registration.ClientID))
+	}
+
+	accessToken, err := c.accessToken(s.Scopes)
 	if err != nil {
 		return nil, nil, fmt.Errorf("get access token for service request: %w", err)
 	}
 
-	request, err := http.NewRequest(s.Method, s.URI.String(), s.Body)
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	request, err := http.NewRequestWithContext(ctx, s.Method, s.URI.String(), s.Body)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not create http request for service request: %w", err)
 	}
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.AccessToken.Token))
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken.Token))
 	request.Header.Set("Accept", s.Accept)
 	request.Header.Set("Content-Type", s.ContentType)
+	if s.AcceptLanguage != "" {
+		request.Header.Set("Accept-Language", s.AcceptLanguage)
+	}
+
+	// Make a conditional request when a response to a GET request is cached.
+	var (
+		cacheKey string
+		cached   CachedResponse
+		isCached bool
+	)
+	if c.ResponseCache != nil && method == http.MethodGet {
+		registration, err := c.getRegistration()
+		if err != nil {
+			return nil, nil, fmt.Errorf("get registration for service request: %w", err)
+		}
+		cacheKey = responseCacheKey(registration.Issuer, registration.ClientID, s)
+		cached, isCached = c.ResponseCache.Get(cacheKey)
+		if isCached {
+			request.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
 
 	client := &http.Client{Timeout: timeout}
 	response, err := client.Do(request)
@@ -335,9 +863,27 @@ func (c *Connector) makeServiceRequest(s ServiceRequest) (http.Header, io.ReadCl
 		return nil, nil, fmt.Errorf("make service request client error: %w", err)
 	}
 
+	if response.StatusCode == http.StatusNotModified && isCached {
+		response.Body.Close()
+		return cached.Header, io.NopCloser(bytes.NewReader(cached.Body)), nil
+	}
+
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		response.Body.Close()
 		return nil, nil, fmt.Errorf("service request got response status %s", http.StatusText(response.StatusCode))
 	}
 
+	etag := response.Header.Get("ETag")
+	if cacheKey != "" && etag != "" {
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read service response: %w", err)
+		}
+		c.ResponseCache.Put(cacheKey, CachedResponse{ETag: etag, Header: response.Header, Body: body})
+
+		return response.Header, io.NopCloser(bytes.NewReader(body)), nil
+	}
+
 	return response.Header, response.Body, nil
 }
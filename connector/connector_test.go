@@ -0,0 +1,421 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestNextPageURI(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{``, ``},
+		{`<https://platform.tld/members?page=2>; rel="next"`, `https://platform.tld/members?page=2`},
+		{`<https://platform.tld/members?page=1>; rel="first", <https://platform.tld/members?page=3>; rel=next`,
+			`https://platform.tld/members?page=3`},
+		{`<https://platform.tld/members?page=1>; rel="prev"`, ``},
+		{`<https://platform.tld/members?ids=1,2&page=1>; rel="prev", <https://platform.tld/members?ids=1,2&page=3>; ` +
+			`rel=next`,
+			`https://platform.tld/members?ids=1,2&page=3`},
+		{`<https://platform.tld/members;v=2?page=2>; title="a, b; c"; rel="next last"`,
+			`https://platform.tld/members;v=2?page=2`},
+		{`<https://platform.tld/members?page=1>; title="rel=\"next\""; rel="prev"`, ``},
+		{`<https://platform.tld/members?page=2>;REL=NEXT`, `https://platform.tld/members?page=2`},
+		{`https://platform.tld/members?page=2; rel="next"`, ``},
+	}
+
+	for _, test := range tests {
+		headers := http.Header{}
+		if test.link != "" {
+			headers.Set("Link", test.link)
+		}
+
+		actual, err := nextPageURI(headers)
+		if err != nil {
+			t.Fatalf("next page URI error: %v", err)
+		}
+		if (actual == nil && test.expected != "") || (actual != nil && actual.String() != test.expected) {
+			t.Errorf("got %v, wanted %q for link %q", actual, test.expected, test.link)
+		}
+	}
+}
+
+func TestDetectPageLimit(t *testing.T) {
+	if limit := pageLimit(0, 0, false); limit != DefaultPageLimit {
+		t.Errorf("got limit %d, wanted default %d", limit, DefaultPageLimit)
+	}
+	if limit := pageLimit(0, 25, false); limit != 25 {
+		t.Errorf("got limit %d, wanted configured 25", limit)
+	}
+	if limit := pageLimit(10, 25, true); limit != 0 {
+		t.Errorf("got limit %d, wanted none for ignored limit", limit)
+	}
+
+	limit, ignored := detectPageLimit(100, 50, true, 0, false)
+	if limit != 50 || ignored {
+		t.Errorf("got (%d, %t), wanted platform maximum (50, false)", limit, ignored)
+	}
+
+	limit, ignored = detectPageLimit(100, 500, false, 0, false)
+	if limit != 0 || !ignored {
+		t.Errorf("got (%d, %t), wanted ignored limit (0, true)", limit, ignored)
+	}
+
+	limit, ignored = detectPageLimit(100, 50, false, 0, false)
+	if limit != 0 || ignored {
+		t.Errorf("got (%d, %t), wanted unchanged (0, false) for a final page", limit, ignored)
+	}
+}
+
+// countingStore counts registration lookups.
+type countingStore struct {
+	*nonpersistent.Store
+	lookups int
+}
+
+func (s *countingStore) FindRegistrationByIssuerAndClientID(issuer, clientID string) (datastore.Registration, error) {
+	s.lookups++
+	return s.Store.FindRegistrationByIssuerAndClientID(issuer, clientID)
+}
+
+func TestRegistrationMemoization(t *testing.T) {
+	store := &countingStore{Store: nonpersistent.New()}
+	uri, _ := url.Parse("https://platform.tld/token")
+	registration := datastore.Registration{
+		Issuer:        "https://platform.tld",
+		ClientID:      "abcdef123456",
+		AuthTokenURI:  uri,
+		AuthLoginURI:  uri,
+		KeysetURI:     uri,
+		TargetLinkURI: uri,
+	}
+	err := store.StoreRegistration(registration)
+	if err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+
+	launchToken := jwt.New()
+	launchToken.Set(jwt.IssuerKey, registration.Issuer)
+	launchToken.Set(jwt.AudienceKey, registration.ClientID)
+	c := &Connector{cfg: datastore.Config{Registrations: store}, LaunchToken: launchToken}
+
+	for i := 0; i < 3; i++ {
+		_, err = c.getRegistration()
+		if err != nil {
+			t.Fatalf("get registration error: %v", err)
+		}
+	}
+	if store.lookups != 1 {
+		t.Errorf("got %d lookups, wanted 1", store.lookups)
+	}
+
+	c.InvalidateRegistration()
+	_, err = c.getRegistration()
+	if err != nil {
+		t.Fatalf("get registration error: %v", err)
+	}
+	if store.lookups != 2 {
+		t.Errorf("got %d lookups after invalidation, wanted 2", store.lookups)
+	}
+}
+
+func TestRecordAssertionID(t *testing.T) {
+	store := nonpersistent.New()
+	c := &Connector{cfg: datastore.Config{Replays: store}}
+	expiry := time.Now().Add(time.Hour)
+
+	err := c.recordAssertionID("https://platform.tld/token", "id", expiry)
+	if err != nil {
+		t.Fatalf("record assertion ID error: %v", err)
+	}
+	err = c.recordAssertionID("https://platform.tld/token", "id", expiry)
+	if !errors.Is(err, ErrAssertionReused) {
+		t.Errorf("got %v, wanted ErrAssertionReused", err)
+	}
+	err = c.recordAssertionID("https://other.tld/token", "id", expiry)
+	if err != nil {
+		t.Errorf("assertion ID of another platform reported: %v", err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	c.SigningKey = privateKey
+	uri, _ := url.Parse("https://platform.tld/token")
+	_, err = c.createRequest(datastore.Registration{ClientID: "abc", AuthTokenURI: uri}, []string{"scope"})
+	if err != nil {
+		t.Fatalf("create request error: %v", err)
+	}
+	var recorded int
+	store.Replays.Range(func(key, value interface{}) bool {
+		recorded++
+		return true
+	})
+	if recorded != 3 {
+		t.Errorf("got %d recorded assertion IDs, wanted 3", recorded)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	connectorKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	registrationKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	registration := datastore.Registration{
+		ClientID: "abc",
+		KeyID:    "tool-abc",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(registrationKey),
+		})),
+	}
+
+	tests := []struct {
+		name          string
+		connectorKey  *rsa.PrivateKey
+		registration  datastore.Registration
+		expectedKeyID string
+		expectedKey   *rsa.PrivateKey
+	}{
+		{"registration key", nil, registration, "tool-abc", registrationKey},
+		{"connector key takes precedence", connectorKey, registration, "connector", connectorKey},
+		{"no key", nil, datastore.Registration{ClientID: "abc"}, "", nil},
+		{"malformed registration key", nil, datastore.Registration{ClientID: "abc", PrivateKey: "key"}, "", nil},
+	}
+	for _, test := range tests {
+		c := &Connector{SigningKey: test.connectorKey, keyID: "connector"}
+		key, err := c.signingKey(test.registration)
+		if test.expectedKey == nil {
+			if err == nil {
+				t.Errorf("%s: no error reported", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: signing key error: %v", test.name, err)
+			continue
+		}
+
+		if key.KeyID() != test.expectedKeyID {
+			t.Errorf("%s: got key ID %q, wanted %q", test.name, key.KeyID(), test.expectedKeyID)
+		}
+		var raw rsa.PrivateKey
+		if err := key.Raw(&raw); err != nil || !raw.Equal(test.expectedKey) {
+			t.Errorf("%s: signing key does not match (%v)", test.name, err)
+		}
+	}
+}
+
+func TestNewStrict(t *testing.T) {
+	store := nonpersistent.New()
+	store.StoreLaunchData("1", json.RawMessage(`{"iss": "https://platform.tld", "aud": "abc", "sub": "1"}`))
+	cfg := datastore.Config{LaunchData: store, Registrations: store, AccessTokens: store}
+
+	_, err := NewStrict(cfg, "1", "key")
+	if !errors.Is(err, datastore.ErrStoreNotConfigured) {
+		t.Errorf("got %v without replays store, wanted ErrStoreNotConfigured", err)
+	}
+
+	cfg.Replays = store
+	c, err := NewStrict(cfg, "1", "key")
+	if err != nil || c.cfg.Replays != store {
+		t.Errorf("got connector %v, error %v", c, err)
+	}
+}
+
+func TestNewFromLaunchData(t *testing.T) {
+	uri, _ := url.Parse("https://platform.tld/token")
+	registration := datastore.Registration{Issuer: "https://platform.tld", ClientID: "abc", AuthTokenURI: uri}
+	launchData := json.RawMessage(`{"iss": "https://platform.tld", "aud": "abc", "sub": "1"}`)
+
+	c, err := NewFromLaunchData(datastore.Config{}, launchData, registration, "key")
+	if err != nil {
+		t.Fatalf("new from launch data error: %v", err)
+	}
+	found, err := c.getRegistration()
+	if err != nil || found.AuthTokenURI != uri || c.ClientID() != "abc" || c.LaunchToken.Subject() != "1" {
+		t.Errorf("got registration %+v, %v for connector %+v", found, err, c)
+	}
+
+	registration.ClientID = "def"
+	if _, err := NewFromLaunchData(datastore.Config{}, launchData, registration, "key"); err == nil {
+		t.Error("launch data of another registration accepted")
+	}
+	_, err = NewFromLaunchData(datastore.Config{}, json.RawMessage(`{"iss": "https://platform.tld"}`), registration,
+		"key")
+	if !errors.Is(err, claims.ErrInvalidAudience) {
+		t.Errorf("got %v for launch data without audience", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.Context()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/context", map[string]interface{}{
+		"id":    "c",
+		"label": "CMPT 101",
+		"type":  []interface{}{"http://purl.imsglobal.org/vocab/lis/v2/course#CourseOffering"},
+	})
+	launchContext, err := c.Context()
+	if err != nil {
+		t.Fatalf("context error: %v", err)
+	}
+	if launchContext.ID != "c" || launchContext.Label != "CMPT 101" || len(launchContext.Type) != 1 {
+		t.Errorf("got context %+v", launchContext)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/context", map[string]interface{}{"label": "CMPT 101"})
+	_, err = c.Context()
+	if err == nil {
+		t.Error("missing context ID not reported")
+	}
+}
+
+func TestServices(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice", map[string]interface{}{
+		"context_memberships_url": "https://platform.tld/members",
+		"service_versions":        []interface{}{"2.0"},
+	})
+	c.LaunchToken.Set("https://vendor.tld/claim/analytics", map[string]interface{}{
+		"endpoint": "https://vendor.tld/analytics",
+		"scope":    []interface{}{"https://vendor.tld/scope/analytics"},
+	})
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/launch_presentation", map[string]interface{}{
+		"return_url": "https://platform.tld/return",
+	})
+
+	services := c.Services()
+	if len(services) != 2 {
+		t.Fatalf("got services %v", services)
+	}
+	nrps := services["https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice"]
+	if nrps.Endpoints["context_memberships_url"].Path != "/members" || len(nrps.Versions) != 1 {
+		t.Errorf("got names and roles service %+v", nrps)
+	}
+
+	vendor, err := c.Service("https://vendor.tld/claim/analytics")
+	if err != nil || vendor.Endpoints["endpoint"] == nil || len(vendor.Scopes) != 1 {
+		t.Errorf("got vendor service %+v, error %v", vendor, err)
+	}
+	_, err = c.Service("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint")
+	if err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+}
+
+func TestResourceLink(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.ResourceLink()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]interface{}{
+		"id":    "quiz",
+		"title": "Quiz 1",
+	})
+	resourceLink, err := c.ResourceLink()
+	if err != nil || resourceLink.ID != "quiz" || resourceLink.Title != "Quiz 1" {
+		t.Errorf("got resource link %+v, error %v", resourceLink, err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", "quiz")
+	_, err = c.ResourceLink()
+	if err == nil || err == ErrClaimNotFound {
+		t.Errorf("got %v, wanted improper format error", err)
+	}
+}
+
+func TestCustom(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.Custom()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/custom", map[string]interface{}{
+		"attempts": 3,
+		"user":     "$User.id",
+	})
+	custom, err := c.Custom()
+	if err != nil {
+		t.Fatalf("custom error: %v", err)
+	}
+	if attempts, err := custom.Int("attempts"); err != nil || attempts != 3 {
+		t.Errorf("got attempts %d, error %v", attempts, err)
+	}
+	if !custom.Unexpanded("user") {
+		t.Error("unexpanded substitution variable not detected")
+	}
+}
+
+func TestLIS(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.LIS()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/lis", map[string]interface{}{
+		"person_sourcedid":          "example.edu:71ee7e42",
+		"course_section_sourcedid":  "example.edu:SI182-001-F16",
+		"course_offering_sourcedid": "example.edu:SI182-F16",
+		"outcome_service_url":       "https://platform.tld/outcomes",
+	})
+	lis, err := c.LIS()
+	if err != nil || lis.PersonSourcedID != "example.edu:71ee7e42" || lis.CourseSectionSourcedID == "" ||
+		lis.OutcomeServiceURL != "https://platform.tld/outcomes" {
+		t.Errorf("got lis %+v, error %v", lis, err)
+	}
+}
+
+func TestToolPlatform(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.ToolPlatform()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/tool_platform", map[string]interface{}{
+		"guid":                "platform-guid",
+		"name":                "Example LMS",
+		"version":             "2021.1",
+		"product_family_code": "Canvas",
+	})
+	platform, err := c.ToolPlatform()
+	if err != nil || platform.GUID != "platform-guid" || platform.Version != "2021.1" {
+		t.Errorf("got tool platform %+v, error %v", platform, err)
+	}
+	if !platform.IsProductFamily(claims.ProductFamilyCanvas) || platform.IsProductFamily(claims.ProductFamilyMoodle) {
+		t.Errorf("wrong product family match for %s", platform.ProductFamilyCode)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Deep Linking content item types.
+const (
+	ContentItemTypeLTIResourceLink = "ltiResourceLink"
+	ContentItemTypeLink            = "link"
+	ContentItemTypeFile            = "file"
+	ContentItemTypeHTML            = "html"
+	ContentItemTypeImage           = "image"
+)
+
+// A ContentItem is an item returned to the platform in a Deep Linking response. Each content item kind marshals to
+// JSON with its `type' property.
+//
+// Ref: https://www.imsglobal.org/spec/lti-dl/v2p0#content-item-types
+type ContentItem interface {
+	ContentItemType() string
+}
+
+// An Icon is an image (an icon or a thumbnail) representing a content item.
+type Icon struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// A Window describes how a content item is opened in a new window or tab.
+type Window struct {
+	TargetName     string `json:"targetName,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	WindowFeatures string `json:"windowFeatures,omitempty"`
+}
+
+// An Iframe describes how a content item is embedded in an iframe. Src is used by links only.
+type Iframe struct {
+	Src    string `json:"src,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// An Embed is the HTML fragment used to embed a link.
+type Embed struct {
+	HTML string `json:"html"`
+}
+
+// A ContentLineItem is the lineitem the platform is asked to create for an LTI resource link.
+type ContentLineItem struct {
+	Label          string  `json:"label,omitempty"`
+	ScoreMaximum   float64 `json:"scoreMaximum"`
+	ResourceID     string  `json:"resourceId,omitempty"`
+	Tag            string  `json:"tag,omitempty"`
+	GradesReleased *bool   `json:"gradesReleased,omitempty"`
+}
+
+// A TimeWindow is the period during which an LTI resource link is available or accepts submissions.
+type TimeWindow struct {
+	StartDateTime *time.Time `json:"startDateTime,omitempty"`
+	EndDateTime   *time.Time `json:"endDateTime,omitempty"`
+}
+
+// An LTIResourceLinkItem is a link to an LTI resource, launched later by the platform.
+type LTIResourceLinkItem struct {
+	URL        string            `json:"url,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	Text       string            `json:"text,omitempty"`
+	Icon       *Icon             `json:"icon,omitempty"`
+	Thumbnail  *Icon             `json:"thumbnail,omitempty"`
+	Window     *Window           `json:"window,omitempty"`
+	Iframe     *Iframe           `json:"iframe,omitempty"`
+	Custom     map[string]string `json:"custom,omitempty"`
+	LineItem   *ContentLineItem  `json:"lineItem,omitempty"`
+	Available  *TimeWindow       `json:"available,omitempty"`
+	Submission *TimeWindow       `json:"submission,omitempty"`
+}
+
+// ContentItemType returns the ltiResourceLink content item type.
+func (LTIResourceLinkItem) ContentItemType() string { return ContentItemTypeLTIResourceLink }
+
+// MarshalJSON encodes the item with its type.
+func (i LTIResourceLinkItem) MarshalJSON() ([]byte, error) {
+	type item LTIResourceLinkItem
+	return marshalContentItem(i.ContentItemType(), item(i))
+}
+
+// A LinkItem is a fully qualified URL to a resource hosted on the internet.
+type LinkItem struct {
+	URL       string  `json:"url"`
+	Title     string  `json:"title,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	Icon      *Icon   `json:"icon,omitempty"`
+	Thumbnail *Icon   `json:"thumbnail,omitempty"`
+	Embed     *Embed  `json:"embed,omitempty"`
+	Window    *Window `json:"window,omitempty"`
+	Iframe    *Iframe `json:"iframe,omitempty"`
+}
+
+// ContentItemType returns the link content item type.
+func (LinkItem) ContentItemType() string { return ContentItemTypeLink }
+
+// MarshalJSON encodes the item with its type.
+func (i LinkItem) MarshalJSON() ([]byte, error) {
+	type item LinkItem
+	return marshalContentItem(i.ContentItemType(), item(i))
+}
+
+// A FileItem is a file the platform copies from the URL, which may expire.
+type FileItem struct {
+	URL       string     `json:"url"`
+	Title     string     `json:"title,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Icon      *Icon      `json:"icon,omitempty"`
+	Thumbnail *Icon      `json:"thumbnail,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ContentItemType returns the file content item type.
+func (FileItem) ContentItemType() string { return ContentItemTypeFile }
+
+// MarshalJSON encodes the item with its type.
+func (i FileItem) MarshalJSON() ([]byte, error) {
+	type item FileItem
+	return marshalContentItem(i.ContentItemType(), item(i))
+}
+
+// An HTMLItem is an HTML fragment to be embedded in the platform.
+type HTMLItem struct {
+	HTML  string `json:"html"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// ContentItemType returns the html content item type.
+func (HTMLItem) ContentItemType() string { return ContentItemTypeHTML }
+
+// MarshalJSON encodes the item with its type.
+func (i HTMLItem) MarshalJSON() ([]byte, error) {
+	type item HTMLItem
+	return marshalContentItem(i.ContentItemType(), item(i))
+}
+
+// An ImageItem is an image to be rendered by the platform.
+type ImageItem struct {
+	URL       string `json:"url"`
+	Title     string `json:"title,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Icon      *Icon  `json:"icon,omitempty"`
+	Thumbnail *Icon  `json:"thumbnail,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// ContentItemType returns the image content item type.
+func (ImageItem) ContentItemType() string { return ContentItemTypeImage }
+
+// MarshalJSON encodes the item with its type.
+func (i ImageItem) MarshalJSON() ([]byte, error) {
+	type item ImageItem
+	return marshalContentItem(i.ContentItemType(), item(i))
+}
+
+// marshalContentItem encodes the fields of a content item preceded by its type.
+func marshalContentItem(contentItemType string, fields interface{}) ([]byte, error) {
+	encodedFields, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	encodedType, err := json.Marshal(contentItemType)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := append([]byte(`{"type":`), encodedType...)
+	if len(encodedFields) > 2 {
+		encoded = append(encoded, ',')
+		encoded = append(encoded, encodedFields[1:]...)
+	} else {
+		encoded = append(encoded, '}')
+	}
+
+	return encoded, nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestContentItemMarshalJSON(t *testing.T) {
+	released := true
+	start := time.Date(2021, time.September, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		item     ContentItem
+		expected string
+	}{
+		{HTMLItem{HTML: "<p>Hi</p>"}, `{"type":"html","html":"\u003cp\u003eHi\u003c/p\u003e"}`},
+		{LinkItem{URL: "https://a.tld", Window: &Window{TargetName: "_blank"}},
+			`{"type":"link","url":"https://a.tld","window":{"targetName":"_blank"}}`},
+		{LTIResourceLinkItem{
+			Title:     "Quiz",
+			Custom:    map[string]string{"quiz_id": "7"},
+			LineItem:  &ContentLineItem{ScoreMaximum: 10, GradesReleased: &released},
+			Available: &TimeWindow{StartDateTime: &start},
+		}, `{"type":"ltiResourceLink","title":"Quiz","custom":{"quiz_id":"7"},` +
+			`"lineItem":{"scoreMaximum":10,"gradesReleased":true},"available":{"startDateTime":"2021-09-01T08:00:00Z"}}`},
+		{ImageItem{URL: "https://a.tld/a.png", Width: 100, Thumbnail: &Icon{URL: "https://a.tld/t.png"}},
+			`{"type":"image","url":"https://a.tld/a.png","thumbnail":{"url":"https://a.tld/t.png"},"width":100}`},
+		{FileItem{URL: "https://a.tld/a.pdf"}, `{"type":"file","url":"https://a.tld/a.pdf"}`},
+	}
+
+	for _, test := range tests {
+		actual, err := json.Marshal(test.item)
+		if err != nil {
+			t.Fatalf("marshal %s error: %v", test.item.ContentItemType(), err)
+		}
+		if string(actual) != test.expected {
+			t.Errorf("got %s, wanted %s", actual, test.expected)
+		}
+	}
+}
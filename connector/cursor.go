@@ -0,0 +1,129 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// ErrCursorNotFound is returned by a CursorStorer when no cursor is stored under a key.
+var ErrCursorNotFound = errors.New("paging cursor not found")
+
+// A PagingCursor records the progress of a paged results export: the next page to fetch, along with the lineitem,
+// user and scopes of the request that produced it. It is encoded as JSON for storage.
+type PagingCursor struct {
+	NextPage string   `json:"nextPage"`
+	LineItem string   `json:"lineItem"`
+	UserID   string   `json:"userId,omitempty"`
+	Scopes   []string `json:"scopes"`
+	Limit    int      `json:"limit,omitempty"`
+}
+
+// A CursorStorer stores the PagingCursors of exports in progress, so that an export outliving its process, e.g., a
+// multi-hour export of the results of a large course, can be resumed after a restart. Implementations must be safe for
+// concurrent use.
+type CursorStorer interface {
+	// StoreCursor stores the cursor under the key, replacing any cursor already stored under it.
+	StoreCursor(key string, cursor PagingCursor) error
+
+	// FindCursor retrieves the cursor stored under the key. If there is none, it returns ErrCursorNotFound.
+	FindCursor(key string) (PagingCursor, error)
+
+	// DeleteCursor removes the cursor stored under the key, if any.
+	DeleteCursor(key string) error
+}
+
+// MemoryCursorStore is an in-memory CursorStorer. It does not survive restarts, so it is mostly useful for testing and
+// as an example.
+type MemoryCursorStore struct {
+	cursors sync.Map
+}
+
+var _ CursorStorer = (*MemoryCursorStore)(nil)
+
+// StoreCursor stores the cursor under the key.
+func (m *MemoryCursorStore) StoreCursor(key string, cursor PagingCursor) error {
+	m.cursors.Store(key, cursor)
+	return nil
+}
+
+// FindCursor retrieves the cursor stored under the key.
+func (m *MemoryCursorStore) FindCursor(key string) (PagingCursor, error) {
+	cursor, ok := m.cursors.Load(key)
+	if !ok {
+		return PagingCursor{}, ErrCursorNotFound
+	}
+
+	return cursor.(PagingCursor), nil
+}
+
+// DeleteCursor removes the cursor stored under the key.
+func (m *MemoryCursorStore) DeleteCursor(key string) error {
+	m.cursors.Delete(key)
+	return nil
+}
+
+// resumeCursor sets the next page from the cursor stored under the AGS's cursor key, if any, when a paged results
+// request starts. A cursor recorded for another lineitem or user is an error, since its next page would return
+// results other than those requested.
+func (a *AGS) resumeCursor(userID string) error {
+	if a.Cursors == nil || a.CursorKey == "" || a.NextPage != nil {
+		return nil
+	}
+
+	cursor, err := a.Cursors.FindCursor(a.CursorKey)
+	if err == ErrCursorNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not find paging cursor: %w", err)
+	}
+	if cursor.LineItem != a.LineItem.String() || cursor.UserID != userID {
+		return fmt.Errorf("paging cursor %s was recorded for lineitem %s and user %q", a.CursorKey, cursor.LineItem,
+			cursor.UserID)
+	}
+
+	a.NextPage, err = url.Parse(cursor.NextPage)
+	if err != nil {
+		return fmt.Errorf("could not parse paging cursor next page: %w", err)
+	}
+	if cursor.Limit != 0 {
+		a.Limit = cursor.Limit
+	}
+
+	return nil
+}
+
+// saveCursor stores the progress of a paged results request under the AGS's cursor key, or removes the cursor once
+// there are no further pages.
+func (a *AGS) saveCursor(userID string, scopes []string) error {
+	if a.Cursors == nil || a.CursorKey == "" {
+		return nil
+	}
+
+	if a.NextPage == nil {
+		if err := a.Cursors.DeleteCursor(a.CursorKey); err != nil {
+			return fmt.Errorf("could not delete paging cursor: %w", err)
+		}
+		return nil
+	}
+
+	err := a.Cursors.StoreCursor(a.CursorKey, PagingCursor{
+		NextPage: a.NextPage.String(),
+		LineItem: a.LineItem.String(),
+		UserID:   userID,
+		Scopes:   scopes,
+		Limit:    a.Limit,
+	})
+	if err != nil {
+		return fmt.Errorf("could not store paging cursor: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/macewan-cs/lti/scope"
+)
+
+func TestGetPagedResultsCursor(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	lineItem, _ := url.Parse(platform.URL + "/lineitems/paged")
+	cursors := &MemoryCursorStore{}
+	target := newConnectorForTesting(t, platform.URL)
+	newAGS := func() *AGS {
+		return &AGS{
+			LineItem:  lineItem,
+			Scopes:    []string{scope.ResultReadOnly},
+			Cursors:   cursors,
+			CursorKey: "export",
+			Target:    target,
+		}
+	}
+
+	results, hasMore, err := newAGS().GetPagedResults(0, "")
+	if err != nil || !hasMore || len(results) != 1 || results[0].ID != "0" {
+		t.Fatalf("got results %v, more %t, error %v", results, hasMore, err)
+	}
+	if _, err := cursors.FindCursor("export"); err != nil {
+		t.Fatalf("cursor not stored: %v", err)
+	}
+
+	// A new AGS, e.g., after a restart, resumes from the stored cursor.
+	ags := newAGS()
+	for _, expected := range []string{"1", "2"} {
+		results, hasMore, err = ags.GetPagedResults(0, "")
+		if err != nil || len(results) != 1 || results[0].ID != expected {
+			t.Fatalf("got results %v, error %v, wanted result %s", results, err, expected)
+		}
+	}
+	if hasMore {
+		t.Error("more pages reported after the last page")
+	}
+	if _, err := cursors.FindCursor("export"); err != ErrCursorNotFound {
+		t.Errorf("got %v for the cursor of a finished export, wanted ErrCursorNotFound", err)
+	}
+
+	// A cursor recorded for another user is not resumed.
+	if _, _, err := newAGS().GetPagedResults(0, ""); err != nil {
+		t.Fatalf("get paged results error: %v", err)
+	}
+	if _, _, err := newAGS().GetPagedResults(0, "someone"); err == nil {
+		t.Error("cursor for another user resumed")
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+)
+
+// DeepLinkingResponseTimeoutSeconds determines the validity period in seconds of a deep linking response.
+const DeepLinkingResponseTimeoutSeconds = 600
+
+// DeepLinking implements the tool's side of Deep Linking: returning the content items selected by the user to the
+// platform. The settings are taken from the deep_linking_settings claim of the launch.
+type DeepLinking struct {
+	ReturnURL                         *url.URL
+	AcceptTypes                       []string
+	AcceptPresentationDocumentTargets []string
+	AcceptMediaTypes                  string
+	AcceptMultiple                    bool
+	AutoCreate                        bool
+	Title                             string
+	Text                              string
+	Data                              string
+	Target                            *Connector
+}
+
+// A DeepLinkingResponse holds the content items and the optional messages returned to the platform.
+type DeepLinkingResponse struct {
+	ContentItems []ContentItem
+	Message      string
+	Log          string
+	ErrorMessage string
+	ErrorLog     string
+}
+
+// UpgradeDeepLinking provides a Connector upgraded for Deep Linking responses. The Connector must have been created from
+// a deep linking launch (LtiDeepLinkingRequest).
+func (c *Connector) UpgradeDeepLinking() (*DeepLinking, error) {
+	rawSettings, ok := c.LaunchToken.Get(claims.DeepLinkingSettings)
+	if !ok {
+		return nil, ErrUnsupportedService
+	}
+	settings, ok := rawSettings.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("deep linking settings improperly formatted")
+	}
+
+	returnURLString, ok := settings["deep_link_return_url"].(string)
+	if !ok {
+		return nil, errors.New("deep link return URL not found")
+	}
+	returnURL, err := url.Parse(returnURLString)
+	if err != nil {
+		return nil, fmt.Errorf("deep link return URL parse error: %w", err)
+	}
+
+	deepLinking := DeepLinking{
+		ReturnURL: returnURL,
+		Target:    c,
+	}
+	if acceptTypes, ok := settings["accept_types"].([]interface{}); ok {
+		deepLinking.AcceptTypes = convertInterfaceToStringSlice(acceptTypes)
+	}
+	if targets, ok := settings["accept_presentation_document_targets"].([]interface{}); ok {
+		deepLinking.AcceptPresentationDocumentTargets = convertInterfaceToStringSlice(targets)
+	}
+	deepLinking.AcceptMediaTypes, _ = settings["accept_media_types"].(string)
+	deepLinking.AcceptMultiple, _ = settings["accept_multiple"].(bool)
+	deepLinking.AutoCreate, _ = settings["auto_create"].(bool)
+	deepLinking.Title, _ = settings["title"].(string)
+	deepLinking.Text, _ = settings["text"].(string)
+	deepLinking.Data, _ = settings["data"].(string)
+
+	return &deepLinking, nil
+}
+
+// ErrMultipleContentItems is returned when a response holds several content items but the platform accepts only one.
+var ErrMultipleContentItems = errors.New("platform does not accept multiple content items")
+
+// A ContentItemError describes a content item that the platform does not accept. Index is the item's position in the
+// response.
+type ContentItemError struct {
+	Index int
+	Err   error
+}
+
+// Error describes the rejected content item.
+func (e ContentItemError) Error() string {
+	return fmt.Sprintf("content item %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the reason the content item is rejected.
+func (e ContentItemError) Unwrap() error {
+	return e.Err
+}
+
+// A ContentItemsError reports every problem found in a deep linking response, so that all of them can be corrected at
+// once.
+type ContentItemsError struct {
+	Errors []error
+}
+
+// Error summarizes the problems.
+func (e *ContentItemsError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+
+	return "deep linking response not accepted by platform: " + strings.Join(messages, "; ")
+}
+
+// Is reports whether any of the problems matches the target, so that errors.Is can be used to look for, e.g.,
+// ErrMultipleContentItems.
+func (e *ContentItemsError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateResponse checks the response against the constraints of the deep linking settings: the number of content
+// items (accept_multiple), their types (accept_types) and their presentation targets
+// (accept_presentation_document_targets). Platforms typically reject a response that violates them without a useful
+// explanation. All of the problems are reported in a *ContentItemsError.
+func (d *DeepLinking) ValidateResponse(response DeepLinkingResponse) error {
+	var problems []error
+
+	if len(response.ContentItems) > 1 && !d.AcceptMultiple {
+		problems = append(problems, ErrMultipleContentItems)
+	}
+	for i, item := range response.ContentItems {
+		if len(d.AcceptTypes) > 0 && !contains(item.ContentItemType(), d.AcceptTypes) {
+			problems = append(problems, ContentItemError{i, fmt.Errorf("platform does not accept %s content items",
+				item.ContentItemType())})
+		}
+		if len(d.AcceptPresentationDocumentTargets) == 0 {
+			continue
+		}
+		for _, target := range presentationTargets(item) {
+			if !contains(target, d.AcceptPresentationDocumentTargets) {
+				problems = append(problems, ContentItemError{i, fmt.Errorf("platform does not accept %s presentation",
+					target)})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ContentItemsError{Errors: problems}
+	}
+
+	return nil
+}
+
+// presentationTargets returns the presentation document targets requested by the content item.
+func presentationTargets(item ContentItem) []string {
+	var (
+		window *Window
+		iframe *Iframe
+		embed  *Embed
+	)
+	switch i := item.(type) {
+	case LTIResourceLinkItem:
+		window, iframe = i.Window, i.Iframe
+	case LinkItem:
+		window, iframe, embed = i.Window, i.Iframe, i.Embed
+	}
+
+	var targets []string
+	if window != nil {
+		targets = append(targets, "window")
+	}
+	if iframe != nil {
+		targets = append(targets, "iframe")
+	}
+	if embed != nil {
+		targets = append(targets, "embed")
+	}
+
+	return targets
+}
+
+// CreateResponse builds and signs an LtiDeepLinkingResponse message, after checking it with ValidateResponse. The data
+// value of the deep linking settings is echoed back to the platform. The returned JWT is to be POSTed to ReturnURL as
+// the JWT form parameter.
+//
+// Ref: https://www.imsglobal.org/spec/lti-dl/v2p0#deep-linking-response-message
+func (d *DeepLinking) CreateResponse(response DeepLinkingResponse) ([]byte, error) {
+	if err := d.ValidateResponse(response); err != nil {
+		return nil, err
+	}
+
+	contentItems := response.ContentItems
+	if contentItems == nil {
+		contentItems = []ContentItem{}
+	}
+
+	token := jwt.New()
+	token.Set(claims.DeepLinkingContentItems, contentItems)
+	if d.Data != "" {
+		token.Set(claims.DeepLinkingData, d.Data)
+	}
+	if response.Message != "" {
+		token.Set(claims.DeepLinkingMessage, response.Message)
+	}
+	if response.Log != "" {
+		token.Set(claims.DeepLinkingLog, response.Log)
+	}
+	if response.ErrorMessage != "" {
+		token.Set(claims.DeepLinkingErrorMessage, response.ErrorMessage)
+	}
+	if response.ErrorLog != "" {
+		token.Set(claims.DeepLinkingErrorLog, response.ErrorLog)
+	}
+
+	signedToken, err := d.Target.signMessage(token, claims.MessageTypeDeepLinkingResponse,
+		time.Second*DeepLinkingResponseTimeoutSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign deep linking response: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+// WriteResponseForm writes a self-submitting HTML form that POSTs a signed deep linking response (see CreateResponse)
+// to the platform's deep link return URL. This completes the browser redirect leg of the deep linking flow.
+func (d *DeepLinking) WriteResponseForm(w http.ResponseWriter, signedResponse []byte) error {
+	if d.ReturnURL == nil {
+		return errors.New("deep link return URL not set")
+	}
+	if len(signedResponse) == 0 {
+		return errors.New("received empty deep linking response")
+	}
+
+	err := writeAutoSubmitForm(w, d.ReturnURL.String(), signedResponse)
+	if err != nil {
+		return fmt.Errorf("could not write deep linking response form: %w", err)
+	}
+
+	return nil
+}
+
+// CreateErrorResponse builds and signs a deep linking response that returns no content items, for when the content
+// selection fails. The platform shows errorMessage to the user and may log errorLog, so that the tool fails back into
+// the platform's UI rather than leaving the user on an error page. errorLog may be empty.
+//
+// Ref: https://www.imsglobal.org/spec/lti-dl/v2p0#deep-linking-response-message
+func (d *DeepLinking) CreateErrorResponse(errorMessage, errorLog string) ([]byte, error) {
+	if errorMessage == "" {
+		return nil, errors.New("deep linking error response requires an error message")
+	}
+
+	return d.CreateResponse(DeepLinkingResponse{ErrorMessage: errorMessage, ErrorLog: errorLog})
+}
+
+// WriteErrorResponse creates a deep linking error response (see CreateErrorResponse) and writes the self-submitting
+// form returning it to the platform.
+func (d *DeepLinking) WriteErrorResponse(w http.ResponseWriter, errorMessage, errorLog string) error {
+	signedResponse, err := d.CreateErrorResponse(errorMessage, errorLog)
+	if err != nil {
+		return err
+	}
+
+	return d.WriteResponseForm(w, signedResponse)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestDeepLinkingCreateResponse(t *testing.T) {
+	store := nonpersistent.New()
+	uri, _ := url.Parse("https://platform.tld/token")
+	err := store.StoreRegistration(datastore.Registration{
+		Issuer:        "https://platform.tld",
+		ClientID:      "abcdef123456",
+		AuthTokenURI:  uri,
+		AuthLoginURI:  uri,
+		KeysetURI:     uri,
+		TargetLinkURI: uri,
+	})
+	if err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+
+	launchToken := jwt.New()
+	launchToken.Set(jwt.IssuerKey, "https://platform.tld")
+	launchToken.Set(jwt.AudienceKey, "abcdef123456")
+	launchToken.Set("https://purl.imsglobal.org/spec/lti/claim/deployment_id", "1")
+	launchToken.Set("https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings", map[string]interface{}{
+		"deep_link_return_url":                 "https://platform.tld/deeplinking",
+		"accept_types":                         []interface{}{"ltiResourceLink"},
+		"accept_presentation_document_targets": []interface{}{"iframe"},
+		"data":                                 "opaque",
+	})
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	c := &Connector{
+		cfg:         datastore.Config{Registrations: store},
+		LaunchToken: launchToken,
+		SigningKey:  privateKey,
+	}
+
+	deepLinking, err := c.UpgradeDeepLinking()
+	if err != nil {
+		t.Fatalf("upgrade deep linking error: %v", err)
+	}
+	if deepLinking.ReturnURL.String() != "https://platform.tld/deeplinking" {
+		t.Errorf("got return URL %s", deepLinking.ReturnURL)
+	}
+
+	items := []ContentItem{LTIResourceLinkItem{Title: "Quiz"}, LTIResourceLinkItem{Title: "Survey"}}
+	_, err = deepLinking.CreateResponse(DeepLinkingResponse{ContentItems: items})
+	if err == nil {
+		t.Error("multiple content items not reported")
+	}
+
+	_, err = deepLinking.CreateResponse(DeepLinkingResponse{ContentItems: []ContentItem{LinkItem{URL: "https://a.tld"}}})
+	if err == nil {
+		t.Error("unaccepted content item type not reported")
+	}
+
+	signed, err := deepLinking.CreateResponse(DeepLinkingResponse{ContentItems: items[:1], Message: "done"})
+	if err != nil {
+		t.Fatalf("create response error: %v", err)
+	}
+	response, err := jwt.Parse(signed, jwt.WithVerify(jwa.RS256, &privateKey.PublicKey))
+	if err != nil {
+		t.Fatalf("cannot verify response: %v", err)
+	}
+
+	if response.Issuer() != "abcdef123456" || response.Audience()[0] != "https://platform.tld" {
+		t.Errorf("got iss %s and aud %v", response.Issuer(), response.Audience())
+	}
+	expected := map[string]interface{}{
+		"https://purl.imsglobal.org/spec/lti/claim/message_type": "LtiDeepLinkingResponse",
+		"https://purl.imsglobal.org/spec/lti-dl/claim/data":      "opaque",
+		"https://purl.imsglobal.org/spec/lti-dl/claim/msg":       "done",
+	}
+	for claim, value := range expected {
+		actual, _ := response.Get(claim)
+		if actual != value {
+			t.Errorf("got %s %v, wanted %v", claim, actual, value)
+		}
+	}
+
+	signed, err = deepLinking.CreateErrorResponse("Nothing selected", "selection timed out")
+	if err != nil {
+		t.Fatalf("create error response error: %v", err)
+	}
+	response, err = jwt.Parse(signed, jwt.WithVerify(jwa.RS256, &privateKey.PublicKey))
+	if err != nil {
+		t.Fatalf("cannot verify error response: %v", err)
+	}
+	expected = map[string]interface{}{
+		"https://purl.imsglobal.org/spec/lti-dl/claim/errormsg": "Nothing selected",
+		"https://purl.imsglobal.org/spec/lti-dl/claim/errorlog": "selection timed out",
+	}
+	for claim, value := range expected {
+		actual, _ := response.Get(claim)
+		if actual != value {
+			t.Errorf("got %s %v, wanted %v", claim, actual, value)
+		}
+	}
+	if contentItems, _ := response.Get(claims.DeepLinkingContentItems); len(contentItems.([]interface{})) != 0 {
+		t.Errorf("got content items %v in error response", contentItems)
+	}
+	if _, err := deepLinking.CreateErrorResponse("", "log only"); err == nil {
+		t.Error("missing error message not reported")
+	}
+
+	c.LaunchToken = jwt.New()
+	_, err = c.UpgradeDeepLinking()
+	if err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+}
+
+func TestDeepLinkingWriteResponseForm(t *testing.T) {
+	returnURL, _ := url.Parse("https://platform.tld/deeplinking?a=1&b=2")
+	deepLinking := DeepLinking{ReturnURL: returnURL}
+
+	recorder := httptest.NewRecorder()
+	err := deepLinking.WriteResponseForm(recorder, []byte("header.payload.signature"))
+	if err != nil {
+		t.Fatalf("write response form error: %v", err)
+	}
+
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`action="https://platform.tld/deeplinking?a=1&amp;b=2"`,
+		`name="JWT" value="header.payload.signature"`,
+		`.submit()`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("response form missing %s: %s", expected, body)
+		}
+	}
+
+	err = deepLinking.WriteResponseForm(httptest.NewRecorder(), nil)
+	if err == nil {
+		t.Error("empty response not reported")
+	}
+}
+
+func TestDeepLinkingValidateResponse(t *testing.T) {
+	deepLinking := DeepLinking{
+		AcceptTypes:                       []string{"ltiResourceLink", "link"},
+		AcceptPresentationDocumentTargets: []string{"iframe"},
+	}
+
+	err := deepLinking.ValidateResponse(DeepLinkingResponse{ContentItems: []ContentItem{
+		LTIResourceLinkItem{Title: "Quiz", Iframe: &Iframe{}},
+	}})
+	if err != nil {
+		t.Errorf("got error %v for accepted response", err)
+	}
+
+	err = deepLinking.ValidateResponse(DeepLinkingResponse{ContentItems: []ContentItem{
+		LTIResourceLinkItem{Title: "Quiz", Window: &Window{}},
+		LinkItem{URL: "https://a.tld", Embed: &Embed{}},
+		HTMLItem{HTML: "<p>Hi</p>"},
+	}})
+	var itemsErr *ContentItemsError
+	if !errors.As(err, &itemsErr) {
+		t.Fatalf("got error %v, wanted ContentItemsError", err)
+	}
+	if !errors.Is(err, ErrMultipleContentItems) {
+		t.Error("multiple content items not reported")
+	}
+	if len(itemsErr.Errors) != 4 {
+		t.Fatalf("got %d problems, wanted 4: %v", len(itemsErr.Errors), err)
+	}
+	for i, expected := range []int{0, 1, 2} {
+		var itemErr ContentItemError
+		if !errors.As(itemsErr.Errors[i+1], &itemErr) || itemErr.Index != expected {
+			t.Errorf("got problem %v, wanted one for content item %d", itemsErr.Errors[i+1], expected)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/scope"
+)
+
+// ErrUnknownScopeProfile is returned when a scope profile is not configured.
+var ErrUnknownScopeProfile = errors.New("unknown scope profile")
+
+// Names of the default scope profiles.
+const (
+	ScopeProfileGradesReadWrite = "grades-readwrite"
+	ScopeProfileGradesReadOnly  = "grades-readonly"
+	ScopeProfileRosterReadOnly  = "roster-readonly"
+	ScopeProfileGroupsReadOnly  = "groups-readonly"
+)
+
+// DefaultScopeProfiles are the scope profiles used by Connectors whose factory configures none.
+var DefaultScopeProfiles = map[string][]string{
+	ScopeProfileGradesReadWrite: {scope.LineItem, scope.ResultReadOnly, scope.Score},
+	ScopeProfileGradesReadOnly:  {scope.LineItemReadOnly, scope.ResultReadOnly},
+	ScopeProfileRosterReadOnly:  {scope.ContextMembershipReadOnly},
+	ScopeProfileGroupsReadOnly:  {scope.ContextGroupReadOnly},
+}
+
+// A Factory creates Connectors that share a configuration: the datastores, the signing key, the scope profiles, the
+// token failure monitor, the response cache, the additional scopes, the accept language and the unknown fields hook. A
+// tool typically configures one Factory at startup and creates a Connector from it for each launch.
+//
+// LaunchTokens, if set, caches the launch tokens of the Connectors created, so that creating a Connector for a recent
+// launch neither looks up nor parses its launch data again.
+//
+// ScopeProfiles names the sets of scopes that feature code requests tokens for, e.g., "grades-readwrite", so that the
+// scopes used by the tool are managed in one place. When it is nil, DefaultScopeProfiles is used.
+type Factory struct {
+	Config           datastore.Config
+	KeyID            string
+	SigningKey       *rsa.PrivateKey
+	ScopeProfiles    map[string][]string
+	TokenFailures    *TokenFailureMonitor
+	ResponseCache    ResponseCache
+	AdditionalScopes AdditionalScopes
+	AcceptLanguage   string
+	LaunchTokens     *LaunchTokenCache
+	OnUnknownFields  func(UnknownFieldsEvent)
+}
+
+// NewFactory creates a *Factory for the datastore configuration and the tool's key ID.
+func NewFactory(cfg datastore.Config, keyID string) *Factory {
+	return &Factory{
+		Config: cfg,
+		KeyID:  keyID,
+	}
+}
+
+// New creates a *Connector for the launch, configured by the factory.
+func (f *Factory) New(launchID string) (*Connector, error) {
+	c, err := newConnector(f.Config, launchID, f.KeyID, f.LaunchTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	f.configure(c)
+
+	return c, nil
+}
+
+// NewFromLaunchData creates a *Connector for the launch data and registration supplied by the caller, configured by
+// the factory. See NewFromLaunchData.
+func (f *Factory) NewFromLaunchData(launchData json.RawMessage, registration datastore.Registration) (*Connector,
+	error) {
+	c, err := NewFromLaunchData(f.Config, launchData, registration, f.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.configure(c)
+
+	return c, nil
+}
+
+// configure applies the factory's configuration to the Connector.
+func (f *Factory) configure(c *Connector) {
+	c.SigningKey = f.SigningKey
+	c.TokenFailures = f.TokenFailures
+	c.ResponseCache = f.ResponseCache
+	c.AdditionalScopes = f.AdditionalScopes
+	c.AcceptLanguage = f.AcceptLanguage
+	c.OnUnknownFields = f.OnUnknownFields
+	c.scopeProfiles = f.ScopeProfiles
+}
+
+// ScopeProfile returns the scopes of the named profile.
+func (c *Connector) ScopeProfile(name string) ([]string, error) {
+	profiles := c.scopeProfiles
+	if profiles == nil {
+		profiles = DefaultScopeProfiles
+	}
+
+	scopes, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownScopeProfile, name)
+	}
+
+	return scopes, nil
+}
+
+// GetAccessTokenForProfile gets a bearer token for the scopes of the named profile.
+func (c *Connector) GetAccessTokenForProfile(name string) error {
+	scopes, err := c.ScopeProfile(name)
+	if err != nil {
+		return err
+	}
+
+	return c.GetAccessToken(scopes)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestFactoryScopeProfiles(t *testing.T) {
+	store := nonpersistent.New()
+	err := store.StoreLaunchData("launch", json.RawMessage(`{"iss":"https://platform.tld","aud":"abcdef123456"}`))
+	if err != nil {
+		t.Fatalf("store launch data error: %v", err)
+	}
+
+	factory := NewFactory(datastore.Config{LaunchData: store, Registrations: store, AccessTokens: store}, "key")
+	monitor := &TokenFailureMonitor{}
+	factory.TokenFailures = monitor
+
+	c, err := factory.New("launch")
+	if err != nil {
+		t.Fatalf("new connector error: %v", err)
+	}
+	if c.TokenFailures != monitor || c.ClientID() != "abcdef123456" {
+		t.Errorf("connector not configured by factory")
+	}
+	scopes, err := c.ScopeProfile(ScopeProfileRosterReadOnly)
+	if err != nil || len(scopes) != 1 {
+		t.Errorf("got default roster scopes %v, error %v", scopes, err)
+	}
+
+	factory.ScopeProfiles = map[string][]string{"grades": {"https://purl.imsglobal.org/spec/lti-ags/scope/score"}}
+	c, err = factory.New("launch")
+	if err != nil {
+		t.Fatalf("new connector error: %v", err)
+	}
+	scopes, err = c.ScopeProfile("grades")
+	if err != nil || len(scopes) != 1 {
+		t.Errorf("got configured scopes %v, error %v", scopes, err)
+	}
+	err = c.GetAccessTokenForProfile(ScopeProfileRosterReadOnly)
+	if !errors.Is(err, ErrUnknownScopeProfile) {
+		t.Errorf("got %v, wanted ErrUnknownScopeProfile", err)
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/scope"
+)
+
+// Course Groups Service media types.
+const (
+	groupContainerMediaType    = "application/vnd.ims.lti-gs.v1.contextgroupcontainer+json"
+	groupSetContainerMediaType = "application/vnd.ims.lti-gs.v1.contextgroupsetcontainer+json"
+)
+
+// Groups implements Course Groups Service functions.
+//
+// Limit, LimitIgnored and the next page fields behave as they do for NRPS. The group and group set listings are paged
+// independently. GroupSetsEndpoint is nil when the platform does not offer group sets.
+type Groups struct {
+	GroupsEndpoint    *url.URL
+	GroupSetsEndpoint *url.URL
+	Limit             int
+	LimitIgnored      bool
+	NextGroupsPage    *url.URL
+	NextGroupSetsPage *url.URL
+	Target            *Connector
+}
+
+// A Group represents a group of users within the launched course. SetIDs identifies the group sets that contain it.
+type Group struct {
+	ID     string
+	Name   string
+	Tag    string
+	SetIDs []string `json:"set_ids"`
+}
+
+// A GroupSet represents a collection of groups within the launched course, e.g., the project teams of an assignment.
+type GroupSet struct {
+	ID   string
+	Name string
+}
+
+// groupContainer is the body of a groups response.
+type groupContainer struct {
+	ID     string
+	Groups []Group
+}
+
+// groupSetContainer is the body of a group sets response.
+type groupSetContainer struct {
+	ID   string
+	Sets []GroupSet
+}
+
+// UpgradeGroups provides a Connector upgraded for Course Groups Service calls.
+func (c *Connector) UpgradeGroups() (*Groups, error) {
+	groupsRawClaim, ok := c.LaunchToken.Get(claims.GroupsService)
+	if !ok {
+		return nil, ErrUnsupportedService
+	}
+	groupsClaim, ok := groupsRawClaim.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("groups service information improperly formatted")
+	}
+
+	groupsString, ok := groupsClaim["context_groups_url"].(string)
+	if !ok {
+		return nil, errors.New("groups endpoint not found")
+	}
+	groupsEndpoint, err := url.Parse(groupsString)
+	if err != nil {
+		return nil, fmt.Errorf("groups endpoint parse error: %w", err)
+	}
+
+	groups := &Groups{
+		GroupsEndpoint: groupsEndpoint,
+		Target:         c,
+	}
+
+	// The group sets endpoint is optional.
+	groupSetsString, ok := groupsClaim["context_group_sets_url"].(string)
+	if ok {
+		groups.GroupSetsEndpoint, err = url.Parse(groupSetsString)
+		if err != nil {
+			return nil, fmt.Errorf("group sets endpoint parse error: %w", err)
+		}
+	}
+
+	return groups, nil
+}
+
+// GetGroups gets all of the groups in the launched course.
+func (g *Groups) GetGroups() ([]Group, error) {
+	return g.getGroups("")
+}
+
+// GetUserGroups gets the groups in the launched course that the user belongs to.
+func (g *Groups) GetUserGroups(userID string) ([]Group, error) {
+	if userID == "" {
+		return nil, errors.New("received empty user ID")
+	}
+
+	return g.getGroups(userID)
+}
+
+// GetGroupsInSet gets the groups in the launched course that belong to the group set.
+func (g *Groups) GetGroupsInSet(setID string) ([]Group, error) {
+	if setID == "" {
+		return nil, errors.New("received empty group set ID")
+	}
+
+	groups, err := g.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterGroups(groups, setID), nil
+}
+
+// FilterGroups returns the groups that belong to the group set. An empty set ID matches every group.
+func FilterGroups(groups []Group, setID string) []Group {
+	var filtered []Group
+	for _, group := range groups {
+		if setID != "" && !contains(setID, group.SetIDs) {
+			continue
+		}
+		filtered = append(filtered, group)
+	}
+
+	return filtered
+}
+
+// A Roster is a group together with its members.
+type Roster struct {
+	Group   Group
+	Members []Member
+}
+
+// GetRosters gets the groups of the launched course, optionally limited to a group set, along with their members as
+// reported by the Names and Roles service. This builds, e.g., the team rosters of a group assignment in one call.
+func (g *Groups) GetRosters(n *NRPS, setID string) ([]Roster, error) {
+	groups, err := g.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	membership, err := n.GetMembership()
+	if err != nil {
+		return nil, err
+	}
+
+	return JoinMembers(FilterGroups(groups, setID), membership.Members), nil
+}
+
+// JoinMembers matches members to the groups by their group enrollments, returning a roster for each group in the
+// order given. A member enrolled in several of the groups appears in each of their rosters.
+func JoinMembers(groups []Group, members []Member) []Roster {
+	rosters := make([]Roster, len(groups))
+	index := make(map[string]int, len(groups))
+	for i, group := range groups {
+		rosters[i].Group = group
+		index[group.ID] = i
+	}
+
+	for _, member := range members {
+		for _, enrollment := range member.GroupEnrollments {
+			i, ok := index[enrollment.GroupID]
+			if !ok {
+				continue
+			}
+			rosters[i].Members = append(rosters[i].Members, member)
+		}
+	}
+
+	return rosters
+}
+
+// GetPagedGroups gets paged groups for the launched course.
+func (g *Groups) GetPagedGroups(limit int) ([]Group, bool, error) {
+	return g.getPagedGroups(limit, "")
+}
+
+// GetGroupSets gets all of the group sets in the launched course.
+func (g *Groups) GetGroupSets() ([]GroupSet, error) {
+	var (
+		sets     []GroupSet
+		moreSets []GroupSet
+		err      error
+	)
+
+	for hasMore := true; hasMore; {
+		moreSets, hasMore, err = g.GetPagedGroupSets(0)
+		if err != nil {
+			return nil, fmt.Errorf("get paged group sets error: %w", err)
+		}
+		sets = append(sets, moreSets...)
+	}
+
+	return sets, nil
+}
+
+// GetPagedGroupSets gets paged group sets for the launched course.
+func (g *Groups) GetPagedGroupSets(limit int) ([]GroupSet, bool, error) {
+	if g.GroupSetsEndpoint == nil {
+		return nil, false, ErrUnsupportedService
+	}
+
+	var container groupSetContainer
+	hasMore, err := g.getPage(g.GroupSetsEndpoint, &g.NextGroupSetsPage, limit, nil, groupSetContainerMediaType,
+		&container)
+	if err != nil {
+		return nil, false, err
+	}
+	g.Limit, g.LimitIgnored = detectPageLimit(pageLimit(limit, g.Limit, g.LimitIgnored), len(container.Sets), hasMore,
+		g.Limit, g.LimitIgnored)
+
+	return container.Sets, hasMore, nil
+}
+
+// getGroups gets all of the groups, optionally limited to those of a user.
+func (g *Groups) getGroups(userID string) ([]Group, error) {
+	var (
+		groups     []Group
+		moreGroups []Group
+		err        error
+	)
+
+	for hasMore := true; hasMore; {
+		moreGroups, hasMore, err = g.getPagedGroups(0, userID)
+		if err != nil {
+			return nil, fmt.Errorf("get paged groups error: %w", err)
+		}
+		groups = append(groups, moreGroups...)
+	}
+
+	return groups, nil
+}
+
+// getPagedGroups gets paged groups, optionally limited to those of a user.
+func (g *Groups) getPagedGroups(limit int, userID string) ([]Group, bool, error) {
+	query := url.Values{}
+	if userID != "" {
+		query.Set("user_id", userID)
+	}
+
+	var container groupContainer
+	hasMore, err := g.getPage(g.GroupsEndpoint, &g.NextGroupsPage, limit, query, groupContainerMediaType, &container)
+	if err != nil {
+		return nil, false, err
+	}
+	g.Limit, g.LimitIgnored = detectPageLimit(pageLimit(limit, g.Limit, g.LimitIgnored), len(container.Groups),
+		hasMore, g.Limit, g.LimitIgnored)
+
+	return container.Groups, hasMore, nil
+}
+
+// getPage requests a page from the endpoint, or from the next page when one was set by a previous response, and
+// decodes the response body into v. The next page is updated from the response headers.
+func (g *Groups) getPage(endpoint *url.URL, nextPage **url.URL, limit int, additionalQuery url.Values, accept string,
+	v interface{}) (bool, error) {
+	if limit < 0 {
+		return false, errors.New("invalid paging limit")
+	}
+	limit = pageLimit(limit, g.Limit, g.LimitIgnored)
+
+	query, err := url.ParseQuery(endpoint.RawQuery)
+	if err != nil {
+		return false, fmt.Errorf("could not parse groups query values: %w", err)
+	}
+	for key, values := range additionalQuery {
+		query[key] = append(query[key], values...)
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	pagedURI, err := url.Parse(endpoint.String())
+	if err != nil {
+		return false, fmt.Errorf("could not parse groups endpoint: %w", err)
+	}
+	pagedURI.RawQuery = query.Encode()
+	s := ServiceRequest{
+		Scopes: []string{scope.ContextGroupReadOnly},
+		Method: http.MethodGet,
+		URI:    pagedURI,
+		Accept: accept,
+	}
+	if *nextPage != nil {
+		s.URI = *nextPage
+	}
+
+	headers, body, err := g.Target.makeServiceRequest(s)
+	if err != nil {
+		return false, fmt.Errorf("get groups make service request error: %w", err)
+	}
+	defer body.Close()
+
+	err = json.NewDecoder(body).Decode(v)
+	if err != nil {
+		return false, fmt.Errorf("could not decode groups response body: %w", err)
+	}
+
+	*nextPage, err = nextPageURI(headers)
+	if err != nil {
+		return false, err
+	}
+
+	return *nextPage != nil, nil
+}
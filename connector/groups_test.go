@@ -0,0 +1,80 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/macewan-cs/lti/claims"
+)
+
+func TestGroups(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	c := newConnectorForTesting(t, platform.URL)
+	_, err := c.UpgradeGroups()
+	if err != ErrUnsupportedService {
+		t.Fatalf("got %v, wanted ErrUnsupportedService", err)
+	}
+
+	c.LaunchToken.Set(claims.GroupsService, map[string]interface{}{
+		"context_groups_url":     platform.URL + "/groups",
+		"context_group_sets_url": platform.URL + "/groupsets",
+	})
+	groups, err := c.UpgradeGroups()
+	if err != nil {
+		t.Fatalf("upgrade groups error: %v", err)
+	}
+
+	all, err := groups.GetGroups()
+	if err != nil {
+		t.Fatalf("get groups error: %v", err)
+	}
+	if len(all) != 2 || all[1].Tag != "lab" || len(all[0].SetIDs) != 1 {
+		t.Errorf("got groups %v", all)
+	}
+
+	userGroups, err := groups.GetUserGroups("a")
+	if err != nil || len(userGroups) != 1 {
+		t.Errorf("got user groups %v, error %v", userGroups, err)
+	}
+
+	sets, err := groups.GetGroupSets()
+	if err != nil || len(sets) != 1 || sets[0].Name != "Teams" {
+		t.Errorf("got group sets %v, error %v", sets, err)
+	}
+
+	groups.GroupSetsEndpoint = nil
+	_, err = groups.GetGroupSets()
+	if err == nil {
+		t.Error("missing group sets endpoint not reported")
+	}
+}
+
+func TestJoinMembers(t *testing.T) {
+	groups := []Group{
+		{ID: "1", SetIDs: []string{"s"}},
+		{ID: "2", SetIDs: []string{"s", "t"}},
+		{ID: "3", SetIDs: []string{"t"}},
+	}
+	members := []Member{
+		{UserID: "a", GroupEnrollments: []GroupEnrollment{{"1"}, {"3"}}},
+		{UserID: "b", GroupEnrollments: []GroupEnrollment{{"2"}}},
+		{UserID: "c"},
+	}
+
+	rosters := JoinMembers(FilterGroups(groups, "s"), members)
+	if len(rosters) != 2 {
+		t.Fatalf("got %d rosters, wanted 2", len(rosters))
+	}
+	if rosters[0].Group.ID != "1" || len(rosters[0].Members) != 1 || rosters[0].Members[0].UserID != "a" {
+		t.Errorf("got first roster %v", rosters[0])
+	}
+	if rosters[1].Group.ID != "2" || len(rosters[1].Members) != 1 || rosters[1].Members[0].UserID != "b" {
+		t.Errorf("got second roster %v", rosters[1])
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The outcomes of importing a lineitem.
+const (
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportFailed    = "failed"
+)
+
+// A LineItemImportResult reports the outcome of importing one lineitem. Row is the lineitem's position in the
+// imported specs. LineItem is the created lineitem, or the existing one for a duplicate; it is the spec when the
+// import failed.
+type LineItemImportResult struct {
+	Row      int
+	Status   string
+	LineItem LineItem
+	Err      error
+}
+
+// ImportLineItems creates lineitems from the specs (e.g., the columns of an external gradebook), using at most
+// `workers' concurrent requests (DefaultResultsWorkers when zero or less). A spec is not created when a lineitem with
+// the same resource ID and tag already exists in the launched context or appears earlier in the specs; specs with
+// neither a resource ID nor a tag are always created.
+//
+// A result is returned for each spec, in the order of the specs. The error is set only when the existing lineitems
+// cannot be retrieved, in which case nothing is created.
+func (a *AGS) ImportLineItems(specs []LineItem, workers int) ([]LineItemImportResult, error) {
+	existing, err := a.GetLineItems()
+	if err != nil {
+		return nil, fmt.Errorf("could not get existing lineitems: %w", err)
+	}
+	seen := make(map[string]LineItem, len(existing))
+	for _, lineItem := range existing {
+		if key, ok := lineItemImportKey(lineItem); ok {
+			seen[key] = lineItem
+		}
+	}
+
+	results := make([]LineItemImportResult, len(specs))
+	var rows []int
+	for i, spec := range specs {
+		results[i] = LineItemImportResult{Row: i, LineItem: spec}
+		key, ok := lineItemImportKey(spec)
+		if !ok {
+			rows = append(rows, i)
+			continue
+		}
+		if duplicate, ok := seen[key]; ok {
+			results[i].Status = ImportDuplicate
+			results[i].LineItem = duplicate
+			continue
+		}
+		seen[key] = spec
+		rows = append(rows, i)
+	}
+
+	if workers <= 0 {
+		workers = DefaultResultsWorkers
+	}
+	if workers > len(rows) {
+		workers = len(rows)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		queue = make(chan int)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each row's result is written by a single worker, so no locking is needed.
+			for row := range queue {
+				created, err := a.CreateLineItem(specs[row])
+				if err != nil {
+					results[row].Status = ImportFailed
+					results[row].Err = err
+					continue
+				}
+				results[row].Status = ImportCreated
+				results[row].LineItem = created
+			}
+		}()
+	}
+	for _, row := range rows {
+		queue <- row
+	}
+	close(queue)
+	wg.Wait()
+
+	return results, nil
+}
+
+// lineItemImportKey returns the key identifying duplicates of the lineitem. The boolean is false when the lineitem
+// has neither a resource ID nor a tag.
+func lineItemImportKey(lineItem LineItem) (string, bool) {
+	if lineItem.ResourceID == "" && lineItem.Tag == "" {
+		return "", false
+	}
+
+	return lineItem.ResourceID + "\x00" + lineItem.Tag, true
+}
+
+// ParseLineItemsCSV reads lineitem specs for ImportLineItems from CSV. The first record is a header naming the
+// columns, using the lineitem's JSON names: label, scoreMaximum, tag, resourceId, resourceLinkId, startDateTime and
+// endDateTime. The label and scoreMaximum columns are required.
+func ParseLineItemsCSV(r io.Reader) ([]LineItem, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("lineitems CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read lineitems CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "label", "scoreMaximum", "tag", "resourceId", "resourceLinkId", "startDateTime", "endDateTime":
+		default:
+			return nil, fmt.Errorf("unknown lineitems CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"label", "scoreMaximum"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("lineitems CSV missing %s column", required)
+		}
+	}
+
+	var lineItems []LineItem
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read lineitems CSV: %w", err)
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		scoreMaximum, err := strconv.ParseFloat(field("scoreMaximum"), 64)
+		if err != nil || scoreMaximum <= 0 {
+			return nil, fmt.Errorf("lineitems CSV line %d: invalid scoreMaximum %q", line, field("scoreMaximum"))
+		}
+		lineItem := LineItem{
+			Label:          field("label"),
+			ScoreMaximum:   scoreMaximum,
+			Tag:            field("tag"),
+			ResourceID:     field("resourceId"),
+			ResourceLinkID: field("resourceLinkId"),
+			StartDateTime:  field("startDateTime"),
+			EndDateTime:    field("endDateTime"),
+		}
+		if lineItem.Label == "" {
+			return nil, fmt.Errorf("lineitems CSV line %d: empty label", line)
+		}
+		lineItems = append(lineItems, lineItem)
+	}
+
+	return lineItems, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/macewan-cs/lti/scope"
+)
+
+func TestImportLineItems(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	lineItems, _ := url.Parse(platform.URL + "/lineitems")
+	ags := AGS{LineItems: lineItems, Scopes: []string{scope.LineItem}, Target: newConnectorForTesting(t, platform.URL)}
+
+	specs, err := ParseLineItemsCSV(strings.NewReader(`label,scoreMaximum,resourceId,tag
+Quiz,10,quiz,grade
+Essay,20,essay,grade
+Essay again,20,essay,grade
+fail,5,,
+Lab,15,,
+`))
+	if err != nil {
+		t.Fatalf("parse CSV error: %v", err)
+	}
+	if len(specs) != 5 || specs[1].Label != "Essay" || specs[1].ScoreMaximum != 20 || specs[1].ResourceID != "essay" {
+		t.Fatalf("got specs %+v", specs)
+	}
+
+	results, err := ags.ImportLineItems(specs, 2)
+	if err != nil {
+		t.Fatalf("import lineitems error: %v", err)
+	}
+	expected := []string{ImportDuplicate, ImportCreated, ImportDuplicate, ImportFailed, ImportCreated}
+	if len(results) != len(expected) {
+		t.Fatalf("got %d results, wanted %d", len(results), len(expected))
+	}
+	for i, status := range expected {
+		if results[i].Row != i || results[i].Status != status {
+			t.Errorf("got row %d status %s, error %v, wanted %s", results[i].Row, results[i].Status, results[i].Err,
+				status)
+		}
+	}
+	if results[0].LineItem.ID != platform.URL+"/lineitems/1" {
+		t.Errorf("got duplicate lineitem %+v", results[0].LineItem)
+	}
+	if results[4].LineItem.ID != platform.URL+"/lineitems/Lab" {
+		t.Errorf("got created lineitem %+v", results[4].LineItem)
+	}
+	if results[3].Err == nil {
+		t.Error("failed creation has no error")
+	}
+}
+
+func TestParseLineItemsCSV(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"label\nQuiz\n",
+		"label,scoreMaximum,points\nQuiz,10,1\n",
+		"label,scoreMaximum\nQuiz,ten\n",
+		"label,scoreMaximum\n,10\n",
+		"label,scoreMaximum\nQuiz\n",
+	} {
+		if _, err := ParseLineItemsCSV(strings.NewReader(input)); err == nil {
+			t.Errorf("no error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/autosubmit"
+	"github.com/macewan-cs/lti/claims"
+)
+
+// CreateMessage builds and signs a message of the message type, sent from the tool to the platform through the
+// user's browser, for message types without a dedicated helper. The message claims are set first; the issuer,
+// audience, timestamps, nonce, deployment ID, message type and version claims are then set from the launch. The
+// message is valid for the validity period.
+func (c *Connector) CreateMessage(messageType string, messageClaims map[string]interface{},
+	validity time.Duration) ([]byte, error) {
+	if messageType == "" {
+		return nil, errors.New("received empty message type")
+	}
+
+	token := jwt.New()
+	for name, value := range messageClaims {
+		if err := token.Set(name, value); err != nil {
+			return nil, fmt.Errorf("could not set claim %s: %w", name, err)
+		}
+	}
+
+	signedToken, err := c.signMessage(token, messageType, validity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s message: %w", messageType, err)
+	}
+
+	return signedToken, nil
+}
+
+// WriteMessageForm writes a self-submitting HTML form that POSTs a signed message to the action URL as the JWT form
+// parameter. If the nonce is not empty, it is set on the form's script for a Content Security Policy using the nonce
+// (see autosubmit.ContentSecurityPolicy).
+func WriteMessageForm(w http.ResponseWriter, action string, signedMessage []byte, nonce string) error {
+	if len(signedMessage) == 0 {
+		return errors.New("received empty message")
+	}
+
+	return autosubmit.Write(w, action, []autosubmit.Field{{Name: "JWT", Value: string(signedMessage)}}, nonce)
+}
+
+// writeAutoSubmitForm writes a self-submitting HTML form that POSTs the signed message to the action URL as the JWT
+// form parameter.
+func writeAutoSubmitForm(w http.ResponseWriter, action string, signedMessage []byte) error {
+	return WriteMessageForm(w, action, signedMessage, "")
+}
+
+// signMessage completes and signs a message JWT sent from the tool to the platform on behalf of the launch. The
+// issuer, audience, timestamps, nonce, deployment ID, message type and version claims are set; the message-specific
+// claims must already be set on the token.
+func (c *Connector) signMessage(token jwt.Token, messageType string, validity time.Duration) ([]byte, error) {
+	registration, err := c.getRegistration()
+	if err != nil {
+		return nil, fmt.Errorf("get registration for message: %w", err)
+	}
+
+	deploymentID, ok := c.LaunchToken.Get(claims.DeploymentID)
+	if !ok {
+		return nil, errors.New("deployment ID not found in launch")
+	}
+
+	token.Set(jwt.IssuerKey, registration.ClientID)
+	token.Set(jwt.AudienceKey, c.LaunchToken.Issuer())
+	now := c.now()
+	token.Set(jwt.IssuedAtKey, now)
+	token.Set(jwt.ExpirationKey, now.Add(validity))
+	token.Set("nonce", uuid.New().String())
+	token.Set(claims.DeploymentID, deploymentID)
+	token.Set(claims.MessageType, messageType)
+	token.Set(claims.Version, claims.LTIVersion)
+
+	signingKey, err := c.signingKey(registration)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.Sign(token, jwa.RS256, signingKey)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestCreateMessage(t *testing.T) {
+	c := newConnectorForTesting(t, "https://platform.tld")
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/deployment_id", "1")
+
+	_, err := c.CreateMessage("", nil, time.Minute)
+	if err == nil {
+		t.Error("empty message type not reported")
+	}
+
+	signed, err := c.CreateMessage("LtiCustomResponse", map[string]interface{}{
+		"https://tool.tld/claim/result": "done",
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("create message error: %v", err)
+	}
+	message, err := jwt.Parse(signed, jwt.WithVerify(jwa.RS256, &c.SigningKey.PublicKey))
+	if err != nil {
+		t.Fatalf("cannot verify message: %v", err)
+	}
+	for claim, expected := range map[string]interface{}{
+		"https://purl.imsglobal.org/spec/lti/claim/message_type":  "LtiCustomResponse",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "1",
+		"https://tool.tld/claim/result":                           "done",
+	} {
+		if actual, _ := message.Get(claim); actual != expected {
+			t.Errorf("got %s %v, wanted %v", claim, actual, expected)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	err = WriteMessageForm(recorder, "https://platform.tld/return", signed, "n0nce")
+	if err != nil {
+		t.Fatalf("write message form error: %v", err)
+	}
+	if !strings.Contains(recorder.Body.String(), `<script nonce="n0nce">`) {
+		t.Errorf("form script missing nonce: %s", recorder.Body.String())
+	}
+}
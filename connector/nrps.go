@@ -6,21 +6,29 @@
 package connector
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/roles"
+	"github.com/macewan-cs/lti/scope"
 )
 
 // NRPS implements Names & Roles Provisioning Services functions.
+//
+// Limit is the page size requested when a zero limit is supplied; if it is also zero, DefaultPageLimit is used. When a
+// platform returns smaller pages than requested, Limit is lowered to the platform's apparent maximum. When a platform
+// returns larger pages than requested, LimitIgnored is set and further requests omit the limit.
 type NRPS struct {
-	Endpoint *url.URL
-	Limit    int
-	NextPage *url.URL
-	Target   *Connector
+	Endpoint     *url.URL
+	Limit        int
+	LimitIgnored bool
+	NextPage     *url.URL
+	Target       *Connector
 }
 
 // A Membership represents a course membership with a brief class description.
@@ -49,12 +57,19 @@ type Member struct {
 	UserID             string `json:"user_id"`
 	LisPersonSourceDid string `json:"lis_person_sourcedid"`
 	Roles              []string
+	GroupEnrollments   []GroupEnrollment `json:"group_enrollments,omitempty"`
+}
+
+// A GroupEnrollment identifies a course group that a member belongs to. Platforms offering the Course Groups Service
+// include these in membership responses.
+type GroupEnrollment struct {
+	GroupID string `json:"group_id"`
 }
 
 // UpgradeNRPS provides a Connector upgraded for NRPS calls.
 func (c *Connector) UpgradeNRPS() (*NRPS, error) {
 	// Check for endpoint.
-	nrpsRawClaim, ok := c.LaunchToken.Get("https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice")
+	nrpsRawClaim, ok := c.LaunchToken.Get(claims.NRPSNamesRoleService)
 	if !ok {
 		return nil, ErrUnsupportedService
 	}
@@ -77,9 +92,81 @@ func (c *Connector) UpgradeNRPS() (*NRPS, error) {
 	}, nil
 }
 
+// NRPS membership status values. A member without a status is active.
+const (
+	StatusActive   = "Active"
+	StatusInactive = "Inactive"
+	StatusDeleted  = "Deleted"
+)
+
 // GetMembership gets the launched course (referred to as a Context in LTI) membership from the platform. Using
 // GetPagedMemberships as a helper, it checks for next page links, fetching and appending them to the output.
 func (n *NRPS) GetMembership() (Membership, error) {
+	return n.getMembership("")
+}
+
+// GetMembersByRole gets the members of the launched course that hold the role, optionally limited to active members.
+// The role may be a full role URI or the short form of a context role, e.g., "Instructor". The platform is asked to
+// filter by role, and the members are filtered again locally for platforms that ignore the request.
+func (n *NRPS) GetMembersByRole(role string, activeOnly bool) ([]Member, error) {
+	if role == "" {
+		return nil, errors.New("received empty role")
+	}
+
+	membership, err := n.getMembership(roles.Normalize(role))
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterMembers(membership.Members, role, activeOnly), nil
+}
+
+// GetInstructors gets the instructors of the launched course.
+func (n *NRPS) GetInstructors() ([]Member, error) {
+	return n.GetMembersByRole(roles.Instructor, false)
+}
+
+// GetLearners gets the learners of the launched course.
+func (n *NRPS) GetLearners() ([]Member, error) {
+	return n.GetMembersByRole(roles.Learner, false)
+}
+
+// GetActiveLearners gets the learners of the launched course whose membership is active.
+func (n *NRPS) GetActiveLearners() ([]Member, error) {
+	return n.GetMembersByRole(roles.Learner, true)
+}
+
+// FilterMembers returns the members that hold the role, optionally limited to active members. An empty role matches
+// every member.
+func FilterMembers(members []Member, role string, activeOnly bool) []Member {
+	role = roles.Normalize(role)
+
+	var filtered []Member
+	for _, member := range members {
+		if activeOnly && !member.IsActive() {
+			continue
+		}
+		if role != "" && !member.HasRole(role) {
+			continue
+		}
+		filtered = append(filtered, member)
+	}
+
+	return filtered
+}
+
+// IsActive reports whether the member's status is active. Platforms may omit the status of active members.
+func (m Member) IsActive() bool {
+	return m.Status == "" || m.Status == StatusActive
+}
+
+// HasRole reports whether the member holds the role, comparing short and full forms of context roles.
+func (m Member) HasRole(role string) bool {
+	return roles.HasRole(m.Roles, role)
+}
+
+// getMembership gets the full membership, optionally asking the platform to filter by role.
+func (n *NRPS) getMembership(role string) (Membership, error) {
 	var (
 		limit          int
 		hasMore        bool
@@ -88,13 +175,13 @@ func (n *NRPS) GetMembership() (Membership, error) {
 		err            error
 	)
 
-	membership, hasMore, err = n.GetPagedMembership(limit)
+	membership, hasMore, err = n.getPagedMembership(limit, role)
 	if err != nil {
 		return Membership{}, fmt.Errorf("get paged membership error: %w", err)
 	}
 
 	for hasMore {
-		moreMembership, hasMore, err = n.GetPagedMembership(limit)
+		moreMembership, hasMore, err = n.getPagedMembership(limit, role)
 		if err != nil {
 			return Membership{}, fmt.Errorf("get more membership error: %w", err)
 		}
@@ -104,12 +191,92 @@ func (n *NRPS) GetMembership() (Membership, error) {
 	return membership, nil
 }
 
+// MembershipProgress reports the progress of a membership fetch: the number of pages fetched and the number of members
+// received so far.
+type MembershipProgress struct {
+	Pages   int
+	Members int
+}
+
+// An IncompleteMembershipError is returned with the partial membership when a membership fetch is interrupted by its
+// context, e.g., when its deadline is exceeded. Err is the context's error.
+type IncompleteMembershipError struct {
+	Progress MembershipProgress
+	Err      error
+}
+
+// Error describes the interrupted fetch.
+func (e *IncompleteMembershipError) Error() string {
+	return fmt.Sprintf("membership incomplete after %d pages (%d members): %v", e.Progress.Pages, e.Progress.Members,
+		e.Err)
+}
+
+// Unwrap returns the context's error.
+func (e *IncompleteMembershipError) Unwrap() error {
+	return e.Err
+}
+
+// GetMembershipContext gets the launched course membership like GetMembership, but it stops when the context is done
+// and it reports its progress after each page when progress is non-nil. For very large courses, this allows a caller
+// to bound the fetch and to provide feedback.
+//
+// If the context is done before the last page, the members received so far are returned along with an
+// *IncompleteMembershipError. NextPage is left at the first page not received, so a later call resumes the fetch.
+func (n *NRPS) GetMembershipContext(ctx context.Context, progress func(MembershipProgress)) (Membership, error) {
+	var (
+		membership Membership
+		current    MembershipProgress
+	)
+
+	for hasMore := true; hasMore; {
+		if err := ctx.Err(); err != nil {
+			return membership, &IncompleteMembershipError{Progress: current, Err: err}
+		}
+
+		var (
+			page Membership
+			err  error
+		)
+		page, hasMore, err = n.getPagedMembershipContext(ctx, 0, "")
+		if err != nil {
+			if ctx.Err() != nil {
+				return membership, &IncompleteMembershipError{Progress: current, Err: ctx.Err()}
+			}
+
+			return Membership{}, fmt.Errorf("get paged membership error: %w", err)
+		}
+
+		members := membership.Members
+		membership = page
+		membership.Members = append(members, page.Members...)
+
+		current.Pages++
+		current.Members = len(membership.Members)
+		if progress != nil {
+			progress(current)
+		}
+	}
+
+	return membership, nil
+}
+
 // GetPagedMembership gets paged Memberships for the launched course.
 func (n *NRPS) GetPagedMembership(limit int) (Membership, bool, error) {
+	return n.getPagedMembership(limit, "")
+}
+
+// getPagedMembership gets paged Memberships, optionally asking the platform to filter by role.
+func (n *NRPS) getPagedMembership(limit int, role string) (Membership, bool, error) {
+	return n.getPagedMembershipContext(context.Background(), limit, role)
+}
+
+// getPagedMembershipContext gets paged Memberships within the context.
+func (n *NRPS) getPagedMembershipContext(ctx context.Context, limit int, role string) (Membership, bool, error) {
 	if limit < 0 {
 		return Membership{}, false, errors.New("invalid paging limit")
 	}
-	scopes := []string{"https://purl.imsglobal.org/spec/lti-nrps/scope/contextmembership.readonly"}
+	limit = pageLimit(limit, n.Limit, n.LimitIgnored)
+	scopes := []string{scope.ContextMembershipReadOnly}
 
 	query, err := url.ParseQuery(n.Endpoint.RawQuery)
 	if err != nil {
@@ -118,6 +285,9 @@ func (n *NRPS) GetPagedMembership(limit int) (Membership, bool, error) {
 	if limit != 0 {
 		query.Add("limit", strconv.Itoa(limit))
 	}
+	if role != "" {
+		query.Add("role", role)
+	}
 
 	// Set the initial limit query parameter.
 	pagedURI, err := url.Parse(n.Endpoint.String())
@@ -126,10 +296,11 @@ func (n *NRPS) GetPagedMembership(limit int) (Membership, bool, error) {
 	}
 	pagedURI.RawQuery = query.Encode()
 	s := ServiceRequest{
-		Scopes: scopes,
-		Method: http.MethodGet,
-		URI:    pagedURI,
-		Accept: "application/vnd.ims.lti-nrps.v2.membershipcontainer+json",
+		Context: ctx,
+		Scopes:  scopes,
+		Method:  http.MethodGet,
+		URI:     pagedURI,
+		Accept:  "application/vnd.ims.lti-nrps.v2.membershipcontainer+json",
 	}
 
 	// If there was a next page set from a previous response, use it.
@@ -143,86 +314,95 @@ func (n *NRPS) GetPagedMembership(limit int) (Membership, bool, error) {
 
 	defer body.Close()
 	var membership Membership
-	err = json.NewDecoder(body).Decode(&membership)
+	err = n.Target.decodeResponse(body, s.URI, &membership)
 	if err != nil {
 		return Membership{}, false, fmt.Errorf("could not decode get paged membership response body: %w", err)
 	}
 
-	// Get the next page link from the response headers.
-	nextPageLink := headers.Get("link")
-	if nextPageLink == "" || !strings.Contains(nextPageLink, `rel="next"`) {
-		// If there are no further next page links, set the NRPS NextPage field to nil.
-		n.NextPage = nil
-		return membership, false, nil
-	}
-
-	nextPageString := strings.Trim(nextPageLink, "<>")
-	nextPage, err := url.Parse(nextPageString)
+	// Get the next page link from the response headers. If there are no further next page links, the NRPS NextPage
+	// field is set to nil.
+	n.NextPage, err = nextPageURI(headers)
 	if err != nil {
-		return Membership{}, false, fmt.Errorf("could not parse next page URI from response headers: %w", err)
+		return Membership{}, false, err
 	}
-	n.NextPage = nextPage
+	hasMore := n.NextPage != nil
+
+	n.Limit, n.LimitIgnored = detectPageLimit(limit, len(membership.Members), hasMore, n.Limit, n.LimitIgnored)
 
-	return membership, true, nil
+	return membership, hasMore, nil
+}
+
+// MissingClaims is a set of flags identifying the identity claims absent from a launch. Platforms can be configured to
+// withhold personal information, so any of these claims may be missing.
+type MissingClaims uint
+
+// Flags for the identity claims reported by GetLaunchingMemberPartial.
+const (
+	MissingEmail MissingClaims = 1 << iota
+	MissingName
+	MissingGivenName
+	MissingFamilyName
+	MissingRoles
+)
+
+// Has reports whether all of the claims identified by flags are missing.
+func (m MissingClaims) Has(flags MissingClaims) bool {
+	return m&flags == flags
 }
 
 // GetLaunchingMember returns a Member struct representing the user that performed the launch. Status is not included
-// in the launch message.
+// in the launch message. The Member includes whichever identity claims the platform sent and always includes the user
+// ID, so that launches from platforms that withhold personal information, e.g., anonymous launches, do not fail; use
+// GetLaunchingMemberPartial to find out which claims were absent. An error is returned only for claims that are
+// present but improperly formatted.
 func (n *NRPS) GetLaunchingMember() (Member, error) {
-	var launchingMember Member
+	launchingMember, _, err := n.GetLaunchingMemberPartial()
 
-	rawLaunchEmail, ok := n.Target.LaunchToken.Get("email")
-	if !ok {
-		return Member{}, errors.New("launching member email not found")
-	}
-	launchEmail, ok := rawLaunchEmail.(string)
-	if !ok {
-		return Member{}, errors.New("could not assert launching member email")
-	}
-	launchingMember.Email = launchEmail
-
-	rawFamilyName, ok := n.Target.LaunchToken.Get("family_name")
-	if !ok {
-		return Member{}, errors.New("launching member family name not found")
-	}
-	familyName, ok := rawFamilyName.(string)
-	if !ok {
-		return Member{}, errors.New("could not assert launching member family name")
-	}
-	launchingMember.FamilyName = familyName
+	return launchingMember, err
+}
 
-	rawGivenName, ok := n.Target.LaunchToken.Get("given_name")
-	if !ok {
-		return Member{}, errors.New("launching member family name not found")
-	}
-	givenName, ok := rawGivenName.(string)
-	if !ok {
-		return Member{}, errors.New("could not assert launching member family name")
-	}
-	launchingMember.GivenName = givenName
+// GetLaunchingMemberPartial returns the Member returned by GetLaunchingMember along with the MissingClaims identifying
+// the identity claims that were absent from the launch.
+func (n *NRPS) GetLaunchingMemberPartial() (Member, MissingClaims, error) {
+	var (
+		launchingMember Member
+		missing         MissingClaims
+	)
 
-	rawName, ok := n.Target.LaunchToken.Get("name")
-	if !ok {
-		return Member{}, errors.New("launching member name not found")
+	stringClaims := []struct {
+		name  string
+		flag  MissingClaims
+		field *string
+	}{
+		{"email", MissingEmail, &launchingMember.Email},
+		{"family_name", MissingFamilyName, &launchingMember.FamilyName},
+		{"given_name", MissingGivenName, &launchingMember.GivenName},
+		{"name", MissingName, &launchingMember.Name},
 	}
-	name, ok := rawName.(string)
-	if !ok {
-		return Member{}, errors.New("could not assert launching member name")
+	for _, claim := range stringClaims {
+		rawClaim, ok := n.Target.LaunchToken.Get(claim.name)
+		if !ok {
+			missing |= claim.flag
+			continue
+		}
+		*claim.field, ok = rawClaim.(string)
+		if !ok {
+			return Member{}, 0, fmt.Errorf("could not assert launching member %s", claim.name)
+		}
 	}
-	launchingMember.Name = name
 
-	rawRoles, ok := n.Target.LaunchToken.Get("https://purl.imsglobal.org/spec/lti/claim/roles")
-	if !ok {
-		return Member{}, errors.New("launching member roles not found")
-	}
-	rolesInterfaces, ok := rawRoles.([]interface{})
-	if !ok {
-		return Member{}, errors.New("could not assert launching member roles")
+	rawRoles, ok := n.Target.LaunchToken.Get(claims.Roles)
+	if ok {
+		rolesInterfaces, ok := rawRoles.([]interface{})
+		if !ok {
+			return Member{}, 0, errors.New("could not assert launching member roles")
+		}
+		launchingMember.Roles = convertInterfaceToStringSlice(rolesInterfaces)
+	} else {
+		missing |= MissingRoles
 	}
-	launchingRoles := convertInterfaceToStringSlice(rolesInterfaces)
-	launchingMember.Roles = launchingRoles
 
 	launchingMember.UserID = n.Target.LaunchToken.Subject()
 
-	return launchingMember, nil
+	return launchingMember, missing, nil
 }
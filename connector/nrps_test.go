@@ -0,0 +1,356 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/roles"
+)
+
+// newPlatformForTesting starts a platform issuing access tokens at /token, serving three pages of two members at
+// /members, serving one result for each lineitem under /lineitems/ except for /lineitems/missing and three pages of one
+// result for /lineitems/paged, and serving two pages of groups at /groups, one page of group sets at /groupsets, and
+// accepting assessment control actions at /control. The lineitems container at /lineitems holds one lineitem and
+// creates any posted lineitem not labelled "fail".
+func newPlatformForTesting(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	mux.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 2 {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/members?page=%d>; rel="next"`, r.Host, page+1))
+		}
+		fmt.Fprintf(w, `{"id":"m","members":[{"user_id":"%d-a"},{"user_id":"%d-b"}]}`, page, page)
+	})
+	mux.HandleFunc("/groups", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != groupContainerMediaType {
+			http.Error(w, "not acceptable", http.StatusNotAcceptable)
+			return
+		}
+		if r.URL.Query().Get("user_id") != "" {
+			fmt.Fprint(w, `{"id":"g","groups":[{"id":"1","name":"One","set_ids":["s"]}]}`)
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/groups?page=1>; rel="next"`, r.Host))
+			fmt.Fprint(w, `{"id":"g","groups":[{"id":"1","name":"One","set_ids":["s"]}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"g","groups":[{"id":"2","name":"Two","tag":"lab","set_ids":["s"]}]}`)
+	})
+	mux.HandleFunc("/groupsets", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != groupSetContainerMediaType {
+			http.Error(w, "not acceptable", http.StatusNotAcceptable)
+			return
+		}
+		fmt.Fprint(w, `{"id":"s","sets":[{"id":"s","name":"Teams"}]}`)
+	})
+	mux.HandleFunc("/control", func(w http.ResponseWriter, r *http.Request) {
+		var control struct {
+			Action        string
+			AttemptNumber int `json:"attempt_number"`
+		}
+		if r.Header.Get("Content-Type") != controlMediaType || json.NewDecoder(r.Body).Decode(&control) != nil ||
+			control.AttemptNumber == 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		status := map[string]string{"pause": "paused", "terminate": "terminated"}[control.Action]
+		if status == "" {
+			status = "running"
+		}
+		fmt.Fprintf(w, `{"status":"%s"}`, status)
+	})
+
+	mux.HandleFunc("/lineitems", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprintf(w, `[{"id":"http://%s/lineitems/1","label":"Quiz","resourceId":"quiz","tag":"grade"}]`, r.Host)
+			return
+		}
+		var lineItem LineItem
+		if json.NewDecoder(r.Body).Decode(&lineItem) != nil || lineItem.Label == "fail" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		lineItem.ID = fmt.Sprintf("http://%s/lineitems/%s", r.Host, lineItem.Label)
+		json.NewEncoder(w).Encode(lineItem)
+	})
+	mux.HandleFunc("/lineitems/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/lineitems/missing/") {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/lineitems/paged/") {
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 2 {
+				w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+			}
+			fmt.Fprintf(w, `[{"id":"%d","userId":"%d","resultScore":1}]`, page, page)
+			return
+		}
+		fmt.Fprintf(w, `[{"id":"%s","userId":"a","resultScore":1}]`, r.URL.Path)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+// newConnectorForTesting creates a Connector for a launch from the platform.
+func newConnectorForTesting(t *testing.T, platformURL string) *Connector {
+	store := nonpersistent.New()
+	tokenURI, _ := url.Parse(platformURL + "/token")
+	err := store.StoreRegistration(datastore.Registration{
+		Issuer:        platformURL,
+		ClientID:      "abcdef123456",
+		AuthTokenURI:  tokenURI,
+		AuthLoginURI:  tokenURI,
+		KeysetURI:     tokenURI,
+		TargetLinkURI: tokenURI,
+	})
+	if err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+
+	launchToken := jwt.New()
+	launchToken.Set(jwt.IssuerKey, platformURL)
+	launchToken.Set(jwt.AudienceKey, "abcdef123456")
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	return &Connector{
+		cfg:         datastore.Config{Registrations: store, AccessTokens: store, Replays: store},
+		LaunchToken: launchToken,
+		SigningKey:  privateKey,
+	}
+}
+
+func TestGetMembershipContext(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	endpoint, _ := url.Parse(platform.URL + "/members")
+	nrps := NRPS{Endpoint: endpoint, Target: newConnectorForTesting(t, platform.URL)}
+
+	var reported []MembershipProgress
+	membership, err := nrps.GetMembershipContext(context.Background(), func(p MembershipProgress) {
+		reported = append(reported, p)
+	})
+	if err != nil {
+		t.Fatalf("get membership error: %v", err)
+	}
+	if len(membership.Members) != 6 || len(reported) != 3 || reported[2] != (MembershipProgress{3, 6}) {
+		t.Errorf("got %d members with progress %v", len(membership.Members), reported)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	membership, err = nrps.GetMembershipContext(ctx, func(p MembershipProgress) {
+		if p.Pages == 2 {
+			cancel()
+		}
+	})
+	var incomplete *IncompleteMembershipError
+	if !errors.As(err, &incomplete) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, wanted *IncompleteMembershipError", err)
+	}
+	if len(membership.Members) != 4 || incomplete.Progress != (MembershipProgress{2, 4}) {
+		t.Errorf("got %d members with progress %v", len(membership.Members), incomplete.Progress)
+	}
+	if nrps.NextPage == nil || nrps.NextPage.Query().Get("page") != "2" {
+		t.Errorf("got next page %v, wanted page 2", nrps.NextPage)
+	}
+}
+
+func TestGetLaunchingMember(t *testing.T) {
+	launchToken := jwt.New()
+	launchToken.Set(jwt.SubjectKey, "user")
+	launchToken.Set("email", "a@b.c")
+	launchToken.Set("name", "A B")
+	launchToken.Set("given_name", "A")
+	launchToken.Set("family_name", "B")
+	launchToken.Set(claims.Roles, []interface{}{"http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"})
+	nrps := NRPS{Target: &Connector{LaunchToken: launchToken}}
+
+	member, err := nrps.GetLaunchingMember()
+	if err != nil {
+		t.Fatalf("get launching member error: %v", err)
+	}
+	if member.UserID != "user" || member.Email != "a@b.c" || member.Name != "A B" || len(member.Roles) != 1 {
+		t.Errorf("got member %+v", member)
+	}
+	member, missing, err := nrps.GetLaunchingMemberPartial()
+	if err != nil || missing != 0 || member.GivenName != "A" || member.FamilyName != "B" {
+		t.Errorf("got member %+v missing %b (%v), wanted the complete member", member, missing, err)
+	}
+
+	anonymousToken := jwt.New()
+	anonymousToken.Set(jwt.SubjectKey, "anonymous")
+	anonymousToken.Set("name", "A B")
+	nrps = NRPS{Target: &Connector{LaunchToken: anonymousToken}}
+
+	member, err = nrps.GetLaunchingMember()
+	if err != nil {
+		t.Fatalf("get anonymous launching member error: %v", err)
+	}
+	if member.UserID != "anonymous" || member.Name != "A B" || member.Email != "" {
+		t.Errorf("got member %+v", member)
+	}
+	member, missing, err = nrps.GetLaunchingMemberPartial()
+	if err != nil {
+		t.Fatalf("get partial launching member error: %v", err)
+	}
+	if member.UserID != "anonymous" || member.Name != "A B" || member.Email != "" {
+		t.Errorf("got member %+v", member)
+	}
+	expected := MissingEmail | MissingGivenName | MissingFamilyName | MissingRoles
+	if missing != expected || !missing.Has(MissingEmail|MissingRoles) || missing.Has(MissingName) {
+		t.Errorf("got missing %b, wanted %b", missing, expected)
+	}
+
+	anonymousToken.Set("email", 1)
+	if _, err = nrps.GetLaunchingMember(); err == nil {
+		t.Error("error not reported for malformed email claim")
+	}
+	if _, _, err = nrps.GetLaunchingMemberPartial(); err == nil {
+		t.Error("error not reported for malformed email claim")
+	}
+}
+
+func TestFilterMembers(t *testing.T) {
+	members := []Member{
+		{UserID: "instructor", Roles: []string{roles.Instructor}},
+		{UserID: "short-instructor", Roles: []string{"Instructor"}},
+		{UserID: "learner", Status: StatusActive, Roles: []string{roles.Learner}},
+		{UserID: "no-status-learner", Roles: []string{"Learner"}},
+		{UserID: "inactive-learner", Status: StatusInactive, Roles: []string{"Learner"}},
+		{UserID: "deleted-learner", Status: StatusDeleted, Roles: []string{"Learner", "Mentor"}},
+		{UserID: "assistant", Roles: []string{roles.TeachingAssistant}},
+	}
+
+	tests := []struct {
+		role       string
+		activeOnly bool
+		expected   []string
+	}{
+		{"", false, []string{"instructor", "short-instructor", "learner", "no-status-learner", "inactive-learner",
+			"deleted-learner", "assistant"}},
+		{"", true, []string{"instructor", "short-instructor", "learner", "no-status-learner", "assistant"}},
+		{"Instructor", false, []string{"instructor", "short-instructor"}},
+		{roles.Instructor, false, []string{"instructor", "short-instructor"}},
+		{"Learner", false, []string{"learner", "no-status-learner", "inactive-learner", "deleted-learner"}},
+		{roles.Learner, true, []string{"learner", "no-status-learner"}},
+		{"Mentor", true, nil},
+		{roles.TeachingAssistant, false, []string{"assistant"}},
+		{roles.InstitutionInstructor, false, nil},
+	}
+	for _, test := range tests {
+		var actual []string
+		for _, member := range FilterMembers(members, test.role, test.activeOnly) {
+			actual = append(actual, member.UserID)
+		}
+		if strings.Join(actual, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("role %q active only %v: got %v, wanted %v", test.role, test.activeOnly, actual, test.expected)
+		}
+	}
+}
+
+func TestMemberIsActive(t *testing.T) {
+	tests := []struct {
+		status   string
+		expected bool
+	}{
+		{"", true},
+		{StatusActive, true},
+		{StatusInactive, false},
+		{StatusDeleted, false},
+	}
+	for _, test := range tests {
+		if actual := (Member{Status: test.status}).IsActive(); actual != test.expected {
+			t.Errorf("status %q: got %v, wanted %v", test.status, actual, test.expected)
+		}
+	}
+}
+
+func TestGetMembersByRole(t *testing.T) {
+	var requestedRoles []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	// The platform ignores the role parameter, so the members are filtered locally.
+	mux.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
+		requestedRoles = append(requestedRoles, r.URL.Query().Get("role"))
+		fmt.Fprint(w, `{"id":"m","members":[`+
+			`{"user_id":"i","roles":["http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"]},`+
+			`{"user_id":"a","status":"Active","roles":["Learner"]},`+
+			`{"user_id":"b","status":"Inactive",`+
+			`"roles":["http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"]}]}`)
+	})
+	platform := httptest.NewServer(mux)
+	defer platform.Close()
+
+	endpoint, _ := url.Parse(platform.URL + "/members")
+	nrps := NRPS{Endpoint: endpoint, Target: newConnectorForTesting(t, platform.URL)}
+
+	tests := []struct {
+		name     string
+		get      func() ([]Member, error)
+		expected string
+	}{
+		{"instructors", nrps.GetInstructors, "i"},
+		{"learners", nrps.GetLearners, "a,b"},
+		{"active learners", nrps.GetActiveLearners, "a"},
+		{"short role", func() ([]Member, error) { return nrps.GetMembersByRole("Learner", true) }, "a"},
+	}
+	for _, test := range tests {
+		members, err := test.get()
+		if err != nil {
+			t.Fatalf("%s: get members error: %v", test.name, err)
+		}
+		var actual []string
+		for _, member := range members {
+			actual = append(actual, member.UserID)
+		}
+		if strings.Join(actual, ",") != test.expected {
+			t.Errorf("%s: got %v, wanted %s", test.name, actual, test.expected)
+		}
+	}
+
+	expectedRoles := []string{
+		roles.Instructor,
+		roles.Learner,
+		roles.Learner,
+		roles.Learner,
+	}
+	if strings.Join(requestedRoles, " ") != strings.Join(expectedRoles, " ") {
+		t.Errorf("got requested roles %v, wanted the full role URIs", requestedRoles)
+	}
+
+	_, err := nrps.GetMembersByRole("", false)
+	if err == nil {
+		t.Error("error not reported for empty role")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/macewan-cs/lti/claims"
+)
+
+// A ReturnMessage holds the messages passed to the platform when returning the user to it. Message and ErrorMessage
+// are shown to the user; Log and ErrorLog are only logged by the platform. Empty messages are not sent.
+//
+// Ref: https://www.imsglobal.org/spec/lti/v1p3#launch-presentation-claim
+type ReturnMessage struct {
+	Message      string
+	Log          string
+	ErrorMessage string
+	ErrorLog     string
+}
+
+// LaunchPresentation returns the launch_presentation claim of the launch, which describes how the tool is displayed
+// (document_target, height and width), where to return the user (return_url) and the user's locale. It returns
+// ErrClaimNotFound if the claim is absent.
+func (c *Connector) LaunchPresentation() (claims.LaunchPresentationClaim, error) {
+	var presentation claims.LaunchPresentationClaim
+	if err := c.decodeClaim(claims.LaunchPresentation, &presentation); err != nil {
+		return claims.LaunchPresentationClaim{}, err
+	}
+
+	return presentation, nil
+}
+
+// Locale returns the user's locale as given in the launch_presentation claim, e.g., "en-CA", or an empty string if
+// the launch does not give it.
+func (c *Connector) Locale() string {
+	presentation, err := c.LaunchPresentation()
+	if err != nil {
+		return ""
+	}
+
+	return presentation.Locale
+}
+
+// ReturnURL returns the launch's return URL with the messages added as the lti_msg, lti_log, lti_errormsg and
+// lti_errorlog query parameters.
+func (c *Connector) ReturnURL(message ReturnMessage) (*url.URL, error) {
+	presentation, err := c.LaunchPresentation()
+	if err != nil {
+		return nil, err
+	}
+	if presentation.ReturnURL == "" {
+		return nil, errors.New("return URL not found")
+	}
+
+	returnURL, err := url.Parse(presentation.ReturnURL)
+	if err != nil {
+		return nil, fmt.Errorf("return URL parse error: %w", err)
+	}
+
+	query := returnURL.Query()
+	for name, value := range map[string]string{
+		"lti_msg":      message.Message,
+		"lti_log":      message.Log,
+		"lti_errormsg": message.ErrorMessage,
+		"lti_errorlog": message.ErrorLog,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	returnURL.RawQuery = query.Encode()
+
+	return returnURL, nil
+}
+
+// ReturnToPlatform redirects the user to the launch's return URL with the messages, e.g., to report an error in the
+// platform's UI.
+func (c *Connector) ReturnToPlatform(w http.ResponseWriter, r *http.Request, message ReturnMessage) error {
+	returnURL, err := c.ReturnURL(message)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, returnURL.String(), http.StatusFound)
+
+	return nil
+}
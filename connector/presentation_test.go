@@ -0,0 +1,99 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestReturnToPlatform(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	err := c.ReturnToPlatform(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), ReturnMessage{})
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/launch_presentation", map[string]interface{}{
+		"document_target": "iframe",
+		"return_url":      "https://platform.tld/return?course=1",
+		"locale":          "en-CA",
+	})
+	presentation, err := c.LaunchPresentation()
+	if err != nil || presentation.DocumentTarget != "iframe" || presentation.Locale != "en-CA" {
+		t.Errorf("got launch presentation %+v, error %v", presentation, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	err = c.ReturnToPlatform(recorder, httptest.NewRequest(http.MethodGet, "/", nil), ReturnMessage{
+		ErrorMessage: "Quiz unavailable",
+		ErrorLog:     "quiz 3 not found",
+	})
+	if err != nil {
+		t.Fatalf("return to platform error: %v", err)
+	}
+	expected := "https://platform.tld/return?course=1&lti_errorlog=quiz+3+not+found&lti_errormsg=Quiz+unavailable"
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != expected {
+		t.Errorf("got status %d, location %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/launch_presentation", map[string]interface{}{})
+	if _, err = c.ReturnURL(ReturnMessage{}); err == nil {
+		t.Error("missing return URL not reported")
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	var acceptLanguage string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	mux.HandleFunc("/service", func(w http.ResponseWriter, r *http.Request) {
+		acceptLanguage = r.Header.Get("Accept-Language")
+	})
+	platform := httptest.NewServer(mux)
+	defer platform.Close()
+
+	c := newConnectorForTesting(t, platform.URL)
+	serviceURI, _ := url.Parse(platform.URL + "/service")
+	request := func() {
+		t.Helper()
+		_, body, err := c.makeServiceRequest(ServiceRequest{Scopes: []string{"s"}, Method: http.MethodGet,
+			URI: serviceURI})
+		if err != nil {
+			t.Fatalf("service request error: %v", err)
+		}
+		body.Close()
+	}
+
+	request()
+	if acceptLanguage != "" {
+		t.Errorf("got Accept-Language %s without locale", acceptLanguage)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/launch_presentation", map[string]interface{}{
+		"locale": "fr-CA",
+	})
+	if c.Locale() != "fr-CA" {
+		t.Errorf("got locale %s", c.Locale())
+	}
+	request()
+	if acceptLanguage != "fr-CA" {
+		t.Errorf("got Accept-Language %s, wanted launch locale", acceptLanguage)
+	}
+
+	c.AcceptLanguage = "en"
+	request()
+	if acceptLanguage != "en" {
+		t.Errorf("got Accept-Language %s, wanted configured language", acceptLanguage)
+	}
+}
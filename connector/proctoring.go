@@ -0,0 +1,137 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+)
+
+// StartAssessmentTimeoutSeconds determines the validity period in seconds of a start assessment message.
+const StartAssessmentTimeoutSeconds = 600
+
+// Proctoring implements the tool's side of a proctored assessment: once the tool has prepared the proctoring
+// environment, it returns the user to the platform with a start assessment message. The values are taken from the
+// start proctoring launch (LtiStartProctoring).
+//
+// EndAssessmentReturn is sent in the start assessment message; when set, the platform returns the user to the tool
+// with an end assessment message (LtiEndAssessment) after the assessment.
+//
+// Ref: https://www.imsglobal.org/spec/proctoring/v1p0
+type Proctoring struct {
+	StartAssessmentURL  *url.URL
+	SessionData         string
+	AttemptNumber       int
+	ResourceLinkID      string
+	EndAssessmentReturn bool
+	Target              *Connector
+}
+
+// A VerifiedUser holds the identity of the user as verified by the proctoring tool. It is returned to the platform
+// in the start assessment message.
+type VerifiedUser struct {
+	GivenName  string `json:"given_name,omitempty"`
+	MiddleName string `json:"middle_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Picture    string `json:"picture,omitempty"`
+	Locale     string `json:"locale,omitempty"`
+}
+
+// UpgradeProctoring provides a Connector upgraded for proctoring. The Connector must have been created from a start
+// proctoring launch.
+func (c *Connector) UpgradeProctoring() (*Proctoring, error) {
+	rawStartAssessmentURL, ok := c.LaunchToken.Get(claims.ProctoringStartAssessmentURL)
+	if !ok {
+		return nil, ErrUnsupportedService
+	}
+	startAssessmentURLString, ok := rawStartAssessmentURL.(string)
+	if !ok {
+		return nil, errors.New("start assessment URL improperly formatted")
+	}
+	startAssessmentURL, err := url.Parse(startAssessmentURLString)
+	if err != nil {
+		return nil, fmt.Errorf("start assessment URL parse error: %w", err)
+	}
+
+	proctoring := Proctoring{
+		StartAssessmentURL: startAssessmentURL,
+		Target:             c,
+	}
+
+	rawSessionData, _ := c.LaunchToken.Get(claims.ProctoringSessionData)
+	proctoring.SessionData, ok = rawSessionData.(string)
+	if !ok {
+		return nil, errors.New("proctoring session data not found")
+	}
+
+	rawAttemptNumber, _ := c.LaunchToken.Get(claims.ProctoringAttemptNumber)
+	attemptNumber, ok := rawAttemptNumber.(float64)
+	if !ok {
+		return nil, errors.New("attempt number not found")
+	}
+	proctoring.AttemptNumber = int(attemptNumber)
+
+	rawResourceLink, _ := c.LaunchToken.Get(claims.ResourceLink)
+	resourceLink, _ := rawResourceLink.(map[string]interface{})
+	proctoring.ResourceLinkID, ok = resourceLink["id"].(string)
+	if !ok {
+		return nil, errors.New("resource link ID not found")
+	}
+
+	return &proctoring, nil
+}
+
+// CreateStartAssessment builds and signs an LtiStartAssessment message, echoing the session data and attempt number
+// of the launch. The verified user is optional. The returned JWT is to be POSTed to StartAssessmentURL as the JWT form
+// parameter.
+//
+// Ref: https://www.imsglobal.org/spec/proctoring/v1p0#start-assessment-message
+func (p *Proctoring) CreateStartAssessment(verifiedUser *VerifiedUser) ([]byte, error) {
+	token := jwt.New()
+	token.Set(claims.ResourceLink, map[string]interface{}{"id": p.ResourceLinkID})
+	token.Set(claims.ProctoringSessionData, p.SessionData)
+	token.Set(claims.ProctoringAttemptNumber, p.AttemptNumber)
+	if verifiedUser != nil {
+		token.Set(claims.ProctoringVerifiedUser, verifiedUser)
+	}
+	if p.EndAssessmentReturn {
+		token.Set(claims.ProctoringEndAssessmentReturn, true)
+	}
+
+	signedToken, err := p.Target.signMessage(token, claims.MessageTypeStartAssessment,
+		time.Second*StartAssessmentTimeoutSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign start assessment message: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+// WriteStartAssessmentForm writes a self-submitting HTML form that POSTs a signed start assessment message (see
+// CreateStartAssessment) to the platform's start assessment URL.
+func (p *Proctoring) WriteStartAssessmentForm(w http.ResponseWriter, signedMessage []byte) error {
+	if p.StartAssessmentURL == nil {
+		return errors.New("start assessment URL not set")
+	}
+	if len(signedMessage) == 0 {
+		return errors.New("received empty start assessment message")
+	}
+
+	err := writeAutoSubmitForm(w, p.StartAssessmentURL.String(), signedMessage)
+	if err != nil {
+		return fmt.Errorf("could not write start assessment form: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestProctoringStartAssessment(t *testing.T) {
+	c := newConnectorForTesting(t, "https://platform.tld")
+	_, err := c.UpgradeProctoring()
+	if err != ErrUnsupportedService {
+		t.Fatalf("got %v, wanted ErrUnsupportedService", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/deployment_id", "1")
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]interface{}{"id": "quiz"})
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ap/claim/start_assessment_url", "https://platform.tld/start")
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ap/claim/session_data", "opaque")
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number", float64(2))
+
+	proctoring, err := c.UpgradeProctoring()
+	if err != nil {
+		t.Fatalf("upgrade proctoring error: %v", err)
+	}
+	if proctoring.AttemptNumber != 2 || proctoring.ResourceLinkID != "quiz" || proctoring.SessionData != "opaque" {
+		t.Errorf("got proctoring %+v", proctoring)
+	}
+
+	proctoring.EndAssessmentReturn = true
+	signed, err := proctoring.CreateStartAssessment(&VerifiedUser{Name: "A B"})
+	if err != nil {
+		t.Fatalf("create start assessment error: %v", err)
+	}
+	message, err := jwt.Parse(signed, jwt.WithVerify(jwa.RS256, &c.SigningKey.PublicKey))
+	if err != nil {
+		t.Fatalf("cannot verify start assessment: %v", err)
+	}
+	expected := map[string]interface{}{
+		"https://purl.imsglobal.org/spec/lti/claim/message_type":             "LtiStartAssessment",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id":            "1",
+		"https://purl.imsglobal.org/spec/lti-ap/claim/session_data":          "opaque",
+		"https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number":        float64(2),
+		"https://purl.imsglobal.org/spec/lti-ap/claim/end_assessment_return": true,
+	}
+	for claim, value := range expected {
+		actual, _ := message.Get(claim)
+		if actual != value {
+			t.Errorf("got %s %v, wanted %v", claim, actual, value)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	err = proctoring.WriteStartAssessmentForm(recorder, signed)
+	if err != nil {
+		t.Fatalf("write start assessment form error: %v", err)
+	}
+	if !strings.Contains(recorder.Body.String(), `action="https://platform.tld/start"`) {
+		t.Errorf("got form %s", recorder.Body)
+	}
+}
+
+func TestAssessmentControl(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	c := newConnectorForTesting(t, platform.URL)
+	_, err := c.UpgradeAssessmentControl()
+	if err != ErrUnsupportedService {
+		t.Fatalf("got %v, wanted ErrUnsupportedService", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]interface{}{"id": "quiz"})
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number", float64(1))
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ap/claim/acs", map[string]interface{}{
+		"assessment_control_url": platform.URL + "/control",
+		"actions":                []interface{}{"pause", "resume", "flag"},
+	})
+	control, err := c.UpgradeAssessmentControl()
+	if err != nil {
+		t.Fatalf("upgrade assessment control error: %v", err)
+	}
+
+	result, err := control.Pause("", "")
+	if err != nil || result.Status != ControlStatusPaused {
+		t.Errorf("got result %v, error %v", result, err)
+	}
+	result, err = control.Flag(0.5, "tab", "left the assessment tab")
+	if err != nil || result.Status != ControlStatusRunning {
+		t.Errorf("got result %v, error %v", result, err)
+	}
+	_, err = control.Flag(2, "", "")
+	if err == nil {
+		t.Error("out of range severity not reported")
+	}
+	_, err = control.Terminate("", "")
+	if err == nil {
+		t.Error("unsupported action not reported")
+	}
+}
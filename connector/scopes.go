@@ -0,0 +1,50 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+// registrationKey identifies a registration.
+type registrationKey struct {
+	issuer   string
+	clientID string
+}
+
+// AdditionalScopes holds extra scopes to request, per registration, with every service request. Some platforms gate
+// vendor extensions behind their own scopes, e.g., "https://canvas.instructure.com/lti/public_url_for_scores"; listing
+// them here merges them into the scopes of PutScore, the lineitem operations and the other service requests made for
+// that registration. Create it with make or NewAdditionalScopes.
+type AdditionalScopes map[registrationKey][]string
+
+// NewAdditionalScopes returns empty AdditionalScopes.
+func NewAdditionalScopes() AdditionalScopes {
+	return make(AdditionalScopes)
+}
+
+// Add adds scopes for the registration identified by the issuer and client ID.
+func (a AdditionalScopes) Add(issuer, clientID string, scopes ...string) {
+	key := registrationKey{issuer, clientID}
+	a[key] = mergeScopes(a[key], scopes)
+}
+
+// For returns the additional scopes of the registration identified by the issuer and client ID.
+func (a AdditionalScopes) For(issuer, clientID string) []string {
+	return a[registrationKey{issuer, clientID}]
+}
+
+// mergeScopes returns the scopes followed by those additional scopes that are not already present.
+func mergeScopes(scopes, additional []string) []string {
+	if len(additional) == 0 {
+		return scopes
+	}
+
+	merged := append([]string{}, scopes...)
+	for _, s := range additional {
+		if !contains(s, merged) {
+			merged = append(merged, s)
+		}
+	}
+
+	return merged
+}
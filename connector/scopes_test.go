@@ -0,0 +1,48 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/macewan-cs/lti/scope"
+)
+
+func TestAdditionalScopes(t *testing.T) {
+	var requestedScopes []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		requestedScopes = strings.Fields(r.FormValue("scope"))
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	})
+	mux.HandleFunc("/lineitem/scores", func(w http.ResponseWriter, r *http.Request) {})
+	platform := httptest.NewServer(mux)
+	defer platform.Close()
+
+	vendorScope := "https://canvas.instructure.com/lti/public_url_for_scores"
+	additional := NewAdditionalScopes()
+	additional.Add(platform.URL, "abcdef123456", vendorScope)
+	additional.Add(platform.URL, "abcdef123456", vendorScope)
+	additional.Add(platform.URL, "other", "https://platform.tld/other")
+
+	c := newConnectorForTesting(t, platform.URL)
+	c.AdditionalScopes = additional
+	lineItem, _ := url.Parse(platform.URL + "/lineitem")
+	ags := AGS{LineItem: lineItem, Scopes: []string{scope.Score}, Target: c}
+
+	err := ags.PutScore(Score{UserID: "a"}, false)
+	if err != nil {
+		t.Fatalf("put score error: %v", err)
+	}
+	if len(requestedScopes) != 2 || requestedScopes[1] != vendorScope {
+		t.Errorf("got requested scopes %v", requestedScopes)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+// AGSService is the set of Assignment & Grades Services operations provided by an upgraded Connector (*AGS).
+// Applications that depend on this interface rather than on *AGS can substitute a fake (see the ltifake package) in
+// their unit tests.
+type AGSService interface {
+	PutScore(s Score, useLaunchUserID bool) error
+	GetResults() ([]Result, error)
+	GetUserResults(userID string) ([]Result, error)
+	GetPagedResults(limit int, userID string) ([]Result, bool, error)
+	GetLineItem() (LineItem, error)
+	GetLineItems() ([]LineItem, error)
+	UpdateLineItem(lineItem LineItem, notLaunchedLineItemEndpoint string) (LineItem, error)
+	CreateLineItem(lineItem LineItem) (LineItem, error)
+	DeleteLineItem(lineItemToDeleteEndpoint string) error
+}
+
+// NRPSService is the set of Names & Roles Provisioning Services operations provided by an upgraded Connector (*NRPS).
+// Applications that depend on this interface rather than on *NRPS can substitute a fake (see the ltifake package) in
+// their unit tests.
+type NRPSService interface {
+	GetMembership() (Membership, error)
+	GetPagedMembership(limit int) (Membership, bool, error)
+	GetMembersByRole(role string, activeOnly bool) ([]Member, error)
+	GetInstructors() ([]Member, error)
+	GetLearners() ([]Member, error)
+	GetActiveLearners() ([]Member, error)
+	GetLaunchingMember() (Member, error)
+	GetLaunchingMemberPartial() (Member, MissingClaims, error)
+}
+
+var (
+	_ AGSService  = (*AGS)(nil)
+	_ NRPSService = (*NRPS)(nil)
+)
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/macewan-cs/lti/claims"
+)
+
+// A Service is a service advertised in the launch. Endpoints holds the members of the service claim whose values are
+// absolute HTTP(S) URLs, e.g., "context_memberships_url"; Scopes and Versions hold the scopes and service versions
+// advertised, if any. Attributes holds every member of the claim, including those not otherwise interpreted.
+type Service struct {
+	Claim      string
+	Endpoints  map[string]*url.URL
+	Scopes     []string
+	Versions   []string
+	Attributes map[string]interface{}
+}
+
+// serviceClaims lists the service claims recognized regardless of whether they advertise scopes.
+var serviceClaims = []string{
+	claims.AGSEndpoint,
+	claims.NRPSNamesRoleService,
+	claims.GroupsService,
+	claims.CaliperEndpoint,
+	claims.ProctoringAssessmentControl,
+}
+
+// Services returns the services advertised in the launch, keyed by claim. Besides the services of the LTI
+// specifications, any claim holding an object with a "scope" or "scopes" list is taken to be a (vendor) service. This
+// allows services without a dedicated upgrade to be used through the Connector.
+func (c *Connector) Services() map[string]Service {
+	services := make(map[string]Service)
+
+	for claim, value := range c.LaunchToken.PrivateClaims() {
+		attributes, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		service := newService(claim, attributes)
+		if !contains(claim, serviceClaims) && len(service.Scopes) == 0 {
+			continue
+		}
+		services[claim] = service
+	}
+
+	return services
+}
+
+// Service returns the service advertised in the claim, or ErrUnsupportedService if it is not advertised.
+func (c *Connector) Service(claim string) (Service, error) {
+	service, ok := c.Services()[claim]
+	if !ok {
+		return Service{}, ErrUnsupportedService
+	}
+
+	return service, nil
+}
+
+// newService interprets the members of a service claim.
+func newService(claim string, attributes map[string]interface{}) Service {
+	service := Service{
+		Claim:      claim,
+		Endpoints:  make(map[string]*url.URL),
+		Attributes: attributes,
+	}
+
+	for name, value := range attributes {
+		switch v := value.(type) {
+		case string:
+			if !strings.HasPrefix(v, "https://") && !strings.HasPrefix(v, "http://") {
+				continue
+			}
+			endpoint, err := url.Parse(v)
+			if err != nil {
+				continue
+			}
+			service.Endpoints[name] = endpoint
+		case []interface{}:
+			switch name {
+			case "scope", "scopes":
+				service.Scopes = convertInterfaceToStringSlice(v)
+			case "service_versions":
+				service.Versions = convertInterfaceToStringSlice(v)
+			}
+		}
+	}
+
+	return service
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/macewan-cs/lti/claims"
+)
+
+// ForUser identifies the user whose submission is being reviewed, as sent in the for_user claim of a submission review
+// launch. Only UserID is required.
+//
+// Ref: https://www.imsglobal.org/spec/lti-ags/v2p0#for_user-claim
+type ForUser struct {
+	UserID          string   `json:"user_id"`
+	PersonSourcedID string   `json:"person_sourcedid,omitempty"`
+	GivenName       string   `json:"given_name,omitempty"`
+	FamilyName      string   `json:"family_name,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	Roles           []string `json:"roles,omitempty"`
+}
+
+// SubmissionReview holds the details of a submission review launch (LtiSubmissionReviewRequest): the user whose
+// submission is opened and the lineitem launched from the platform's gradebook.
+type SubmissionReview struct {
+	ForUser  ForUser
+	LineItem *url.URL
+	Target   *Connector
+}
+
+// ErrClaimNotFound is returned when a claim is absent from the launch.
+var ErrClaimNotFound = errors.New("claim not found in launch")
+
+// ForUser returns the for_user claim of the launch, which identifies the user acted upon: the student whose submission
+// is reviewed, or the user on whose behalf an instructor acts. It returns ErrClaimNotFound if the claim is absent.
+func (c *Connector) ForUser() (ForUser, error) {
+	var forUser ForUser
+	if err := c.decodeClaim(claims.ForUser, &forUser); err != nil {
+		return ForUser{}, err
+	}
+	if forUser.UserID == "" {
+		return ForUser{}, errors.New("for_user user ID not found")
+	}
+
+	return forUser, nil
+}
+
+// UpgradeSubmissionReview provides a Connector upgraded for a submission review launch.
+func (c *Connector) UpgradeSubmissionReview() (*SubmissionReview, error) {
+	forUser, err := c.ForUser()
+	if err != nil {
+		if err == ErrClaimNotFound {
+			return nil, ErrUnsupportedService
+		}
+		return nil, err
+	}
+
+	agsRawClaims, ok := c.LaunchToken.Get(claims.AGSEndpoint)
+	if !ok {
+		return nil, errors.New("assignments and grades information not found")
+	}
+	agsClaims, ok := agsRawClaims.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("assignments and grades information improperly formatted")
+	}
+	lineItemString, ok := agsClaims["lineitem"].(string)
+	if !ok {
+		return nil, errors.New("could not get lineitem URI")
+	}
+	lineItem, err := url.Parse(lineItemString)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lineitem URI: %w", err)
+	}
+
+	return &SubmissionReview{
+		ForUser:  forUser,
+		LineItem: lineItem,
+		Target:   c,
+	}, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestUpgradeSubmissionReview(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.UpgradeSubmissionReview()
+	if err != ErrUnsupportedService {
+		t.Errorf("got %v, wanted ErrUnsupportedService", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/for_user", map[string]interface{}{
+		"user_id": "student",
+		"roles":   []interface{}{"http://purl.imsglobal.org/vocab/lis/v2/membership#Learner"},
+	})
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", map[string]interface{}{
+		"lineitem": "https://platform.tld/lineitems/1",
+	})
+
+	review, err := c.UpgradeSubmissionReview()
+	if err != nil {
+		t.Fatalf("upgrade submission review error: %v", err)
+	}
+	if review.ForUser.UserID != "student" || len(review.ForUser.Roles) != 1 {
+		t.Errorf("got for_user %v", review.ForUser)
+	}
+	if review.LineItem.String() != "https://platform.tld/lineitems/1" {
+		t.Errorf("got lineitem %s", review.LineItem)
+	}
+}
+
+func TestForUser(t *testing.T) {
+	c := &Connector{LaunchToken: jwt.New()}
+	_, err := c.ForUser()
+	if err != ErrClaimNotFound {
+		t.Errorf("got %v, wanted ErrClaimNotFound", err)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/for_user", map[string]interface{}{
+		"user_id":    "student",
+		"given_name": "Ada",
+		"name":       "Ada Lovelace",
+	})
+	forUser, err := c.ForUser()
+	if err != nil {
+		t.Fatalf("for user error: %v", err)
+	}
+	expected := ForUser{UserID: "student", GivenName: "Ada", Name: "Ada Lovelace"}
+	if forUser.UserID != expected.UserID || forUser.GivenName != expected.GivenName || forUser.Name != expected.Name {
+		t.Errorf("got %v, wanted %v", forUser, expected)
+	}
+
+	c.LaunchToken.Set("https://purl.imsglobal.org/spec/lti/claim/for_user", "student")
+	_, err = c.ForUser()
+	if err == nil {
+		t.Error("improperly formatted claim not reported")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// DefaultTokenBatchParallelism is the number of access tokens acquired at once by AcquireTokens when no parallelism
+// is given.
+const DefaultTokenBatchParallelism = 4
+
+// A TokenRequest names a registration and the scopes of an access token to acquire for it.
+type TokenRequest struct {
+	Registration datastore.Registration
+	Scopes       []string
+}
+
+// TokenBatchErrors lists the failed requests of AcquireTokens, in the order of the requests. errors.Is and errors.As
+// apply to each of them.
+type TokenBatchErrors []error
+
+// Error lists the failures, separated by semicolons.
+func (e TokenBatchErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d access token requests failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Is reports whether any of the failures is target.
+func (e TokenBatchErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first failure that matches target, and if one is found, sets target to it.
+func (e TokenBatchErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AcquireTokens acquires and stores the access tokens of the requests, e.g., in a nightly job that warms up the
+// tokens of every tenant before synchronizing their grades and rosters. At most parallelism tokens are requested from
+// platforms at once; when it is zero or less, DefaultTokenBatchParallelism is used. Tokens already stored and not
+// expired are not requested again. The Factory's additional scopes are requested along with the scopes of each
+// request, as they are for service requests, so that the stored tokens are the ones later found by Connectors.
+//
+// Every request is attempted, unless the context is done. AcquireTokens returns nil or the TokenBatchErrors listing
+// each failed request, naming its registration.
+func (f *Factory) AcquireTokens(ctx context.Context, requests []TokenRequest, parallelism int) error {
+	if parallelism <= 0 {
+		parallelism = DefaultTokenBatchParallelism
+	}
+
+	cfg := f.Config
+	if cfg.AccessTokens == nil {
+		cfg.AccessTokens = nonpersistent.DefaultStore
+	}
+	if cfg.Replays == nil {
+		cfg.Replays = nonpersistent.DefaultStore
+	}
+
+	failures := make([]error, len(requests))
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		if ctx.Err() != nil {
+			failures[i] = ctx.Err()
+			continue
+		}
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			failures[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, request TokenRequest) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			failures[i] = f.acquireToken(cfg, request)
+		}(i, request)
+	}
+	wg.Wait()
+
+	var batchErrors TokenBatchErrors
+	for i, err := range failures {
+		if err != nil {
+			reg := requests[i].Registration
+			batchErrors = append(batchErrors, fmt.Errorf("registration %s (client ID %s): %w", reg.Issuer,
+				reg.ClientID, err))
+		}
+	}
+	if len(batchErrors) == 0 {
+		return nil
+	}
+
+	return batchErrors
+}
+
+// acquireToken acquires the access token of the request through a Connector bound to its registration.
+func (f *Factory) acquireToken(cfg datastore.Config, request TokenRequest) error {
+	reg := request.Registration
+	if reg.AuthTokenURI == nil {
+		return fmt.Errorf("%w: auth token URI", datastore.ErrIncompleteRegistration)
+	}
+	if cfg.StrictHTTPS {
+		if err := datastore.ValidateRegistrationSecurity(reg); err != nil {
+			return err
+		}
+	}
+
+	c := Connector{
+		cfg:           cfg,
+		keyID:         f.KeyID,
+		SigningKey:    f.SigningKey,
+		TokenFailures: f.TokenFailures,
+		registration:  &reg,
+	}
+	_, err := c.accessToken(mergeScopes(request.Scopes, f.AdditionalScopes.For(reg.Issuer, reg.ClientID)))
+
+	return err
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestAcquireTokens(t *testing.T) {
+	var requests int32
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if strings.HasPrefix(r.URL.Path, "/failing") {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	}))
+	defer platform.Close()
+
+	registration := func(clientID, path string) datastore.Registration {
+		tokenURI, _ := url.Parse(platform.URL + path)
+		return datastore.Registration{Issuer: platform.URL, ClientID: clientID, AuthTokenURI: tokenURI}
+	}
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	store := nonpersistent.New()
+	factory := NewFactory(datastore.Config{AccessTokens: store}, "key")
+	factory.SigningKey = privateKey
+	factory.AdditionalScopes = NewAdditionalScopes()
+	factory.AdditionalScopes.Add(platform.URL, "a", "extra")
+
+	batch := []TokenRequest{
+		{Registration: registration("a", "/token"), Scopes: []string{"scope"}},
+		{Registration: registration("b", "/failing/token"), Scopes: []string{"scope"}},
+		{Registration: registration("c", "/token"), Scopes: []string{"scope"}},
+	}
+	err = factory.AcquireTokens(context.Background(), batch, 2)
+	var batchErrors TokenBatchErrors
+	if !errors.As(err, &batchErrors) || len(batchErrors) != 1 || !strings.Contains(err.Error(), "client ID b") {
+		t.Fatalf("got %v, wanted the failure of client b", err)
+	}
+	if _, err := store.FindAccessToken(platform.URL+"/token", "a", []string{"scope", "extra"}); err != nil {
+		t.Errorf("token with additional scopes not stored: %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("got %d token requests, wanted 3", requests)
+	}
+
+	if err := factory.AcquireTokens(context.Background(), batch[:1], 0); err != nil {
+		t.Errorf("got %v for stored token", err)
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("stored token requested again")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = factory.AcquireTokens(ctx, batch, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v for canceled context", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// DefaultLaunchTokenCacheTTL is the time for which a LaunchTokenCache keeps a launch token when no TTL is given.
+const DefaultLaunchTokenCacheTTL = 5 * time.Minute
+
+// A LaunchTokenCache keeps the launch tokens parsed by the Connectors of a Factory in memory, for at most TTL, so that
+// a tool creating a Connector for each request does not find and parse the same launch data every time. Launch data
+// removed from the store, e.g., when it is purged, remains available to new Connectors until its token expires from
+// the cache. It is safe for concurrent use.
+type LaunchTokenCache struct {
+	TTL   time.Duration
+	Clock datastore.Clock
+
+	mu        sync.Mutex
+	tokens    map[string]cachedLaunchToken
+	nextSweep time.Time
+}
+
+type cachedLaunchToken struct {
+	token  jwt.Token
+	expiry time.Time
+}
+
+// NewLaunchTokenCache returns an empty LaunchTokenCache. A TTL of zero or less selects DefaultLaunchTokenCacheTTL.
+func NewLaunchTokenCache(ttl time.Duration) *LaunchTokenCache {
+	if ttl <= 0 {
+		ttl = DefaultLaunchTokenCacheTTL
+	}
+
+	return &LaunchTokenCache{
+		TTL:    ttl,
+		tokens: make(map[string]cachedLaunchToken),
+	}
+}
+
+// Get returns a copy of the cached token of the launch, so that changes made to it by one Connector are not seen by
+// others.
+func (c *LaunchTokenCache) Get(launchID string) (jwt.Token, bool) {
+	c.mu.Lock()
+	cached, ok := c.tokens[launchID]
+	c.mu.Unlock()
+	if !ok || !datastore.Now(c.Clock).Before(cached.expiry) {
+		return nil, false
+	}
+
+	token, err := cached.token.Clone()
+	if err != nil {
+		return nil, false
+	}
+
+	return token, true
+}
+
+// Put caches a copy of the token of the launch. Expired tokens are removed from the cache at most once per TTL.
+func (c *LaunchTokenCache) Put(launchID string, token jwt.Token) {
+	clone, err := token.Clone()
+	if err != nil {
+		return
+	}
+	now := datastore.Now(c.Clock)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]cachedLaunchToken)
+	}
+	if !now.Before(c.nextSweep) {
+		for id, cached := range c.tokens {
+			if !now.Before(cached.expiry) {
+				delete(c.tokens, id)
+			}
+		}
+		c.nextSweep = now.Add(c.TTL)
+	}
+
+	c.tokens[launchID] = cachedLaunchToken{token: clone, expiry: now.Add(c.TTL)}
+}
+
+// Invalidate removes the token of the launch from the cache.
+func (c *LaunchTokenCache) Invalidate(launchID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.tokens, launchID)
+}
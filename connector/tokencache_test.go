@@ -0,0 +1,70 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// countingLaunchData counts the lookups of a LaunchDataStorer.
+type countingLaunchData struct {
+	datastore.LaunchDataStorer
+	finds int
+}
+
+func (c *countingLaunchData) FindLaunchData(launchID string) (json.RawMessage, error) {
+	c.finds++
+	return c.LaunchDataStorer.FindLaunchData(launchID)
+}
+
+func TestLaunchTokenCache(t *testing.T) {
+	store := nonpersistent.New()
+	err := store.StoreLaunchData("launch", json.RawMessage(`{"iss":"https://platform.tld","aud":"abcdef123456"}`))
+	if err != nil {
+		t.Fatalf("store launch data error: %v", err)
+	}
+	launchData := &countingLaunchData{LaunchDataStorer: store}
+
+	now := time.Now()
+	cache := NewLaunchTokenCache(time.Minute)
+	cache.Clock = datastore.ClockFunc(func() time.Time { return now })
+	factory := NewFactory(datastore.Config{LaunchData: launchData, Registrations: store, AccessTokens: store}, "key")
+	factory.LaunchTokens = cache
+
+	first, err := factory.New("launch")
+	if err != nil {
+		t.Fatalf("new connector error: %v", err)
+	}
+	first.LaunchToken.Set("aud", "changed")
+	second, err := factory.New("launch")
+	if err != nil {
+		t.Fatalf("new connector error: %v", err)
+	}
+	if launchData.finds != 1 {
+		t.Errorf("launch data found %d times, wanted 1", launchData.finds)
+	}
+	if second.ClientID() != "abcdef123456" {
+		t.Errorf("got client ID %q, change to another connector's token was shared", second.ClientID())
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := factory.New("launch"); err != nil {
+		t.Fatalf("new connector error: %v", err)
+	}
+	if launchData.finds != 2 {
+		t.Errorf("launch data found %d times after expiry, wanted 2", launchData.finds)
+	}
+
+	cache.Invalidate("launch")
+	if _, ok := cache.Get("launch"); ok {
+		t.Errorf("invalidated token still cached")
+	}
+}
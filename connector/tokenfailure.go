@@ -0,0 +1,106 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTokenFailureThreshold is the number of consecutive access token failures reported by a TokenFailureMonitor
+// whose Threshold is zero.
+const DefaultTokenFailureThreshold = 3
+
+// A TokenFailureEvent describes repeated access token failures for a registration, e.g., because its key was removed
+// from the platform or its scopes were revoked.
+type TokenFailureEvent struct {
+	Issuer              string
+	ClientID            string
+	TokenURI            string
+	Scopes              []string
+	ConsecutiveFailures int
+	FirstFailure        time.Time
+	LastFailure         time.Time
+	Err                 error
+}
+
+// A TokenFailureMonitor counts consecutive access token failures per registration. Since service requests such as
+// grade passback usually fail quietly in the background, the monitor lets operators be alerted before users notice.
+// A monitor may be shared by any number of Connectors and is safe for concurrent use.
+//
+// OnFailure is called once when a registration's consecutive failures reach Threshold (or
+// DefaultTokenFailureThreshold); it is not called again until a token has been acquired successfully. OnRecovery, if
+// set, is then called with the event that was reported. The callbacks are made synchronously, so they should hand off
+// any slow work, such as paging an operator.
+type TokenFailureMonitor struct {
+	Threshold  int
+	OnFailure  func(TokenFailureEvent)
+	OnRecovery func(TokenFailureEvent)
+
+	mu       sync.Mutex
+	failures map[registrationKey]*tokenFailures
+}
+
+// tokenFailures holds the failures of a registration.
+type tokenFailures struct {
+	event    TokenFailureEvent
+	reported bool
+}
+
+// recordFailure counts a failure for the registration and reports it once the threshold is reached.
+func (m *TokenFailureMonitor) recordFailure(issuer, clientID, tokenURI string, scopes []string, now time.Time,
+	err error) {
+	threshold := m.Threshold
+	if threshold <= 0 {
+		threshold = DefaultTokenFailureThreshold
+	}
+
+	m.mu.Lock()
+	if m.failures == nil {
+		m.failures = make(map[registrationKey]*tokenFailures)
+	}
+	key := registrationKey{issuer, clientID}
+	failures, ok := m.failures[key]
+	if !ok {
+		failures = &tokenFailures{event: TokenFailureEvent{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			FirstFailure: now,
+		}}
+		m.failures[key] = failures
+	}
+	failures.event.TokenURI = tokenURI
+	failures.event.Scopes = scopes
+	failures.event.ConsecutiveFailures++
+	failures.event.LastFailure = now
+	failures.event.Err = err
+
+	report := !failures.reported && failures.event.ConsecutiveFailures >= threshold
+	if report {
+		failures.reported = true
+	}
+	event := failures.event
+	m.mu.Unlock()
+
+	if report && m.OnFailure != nil {
+		m.OnFailure(event)
+	}
+}
+
+// recordSuccess clears the registration's failures, reporting the recovery if the failures had been reported.
+func (m *TokenFailureMonitor) recordSuccess(issuer, clientID string) {
+	m.mu.Lock()
+	key := registrationKey{issuer, clientID}
+	failures, ok := m.failures[key]
+	if ok {
+		delete(m.failures, key)
+	}
+	m.mu.Unlock()
+
+	if ok && failures.reported && m.OnRecovery != nil {
+		m.OnRecovery(failures.event)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTokenFailureMonitor(t *testing.T) {
+	var failing int32 = 1
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+	}))
+	defer platform.Close()
+
+	var failures, recoveries []TokenFailureEvent
+	monitor := &TokenFailureMonitor{
+		Threshold: 2,
+		OnFailure: func(e TokenFailureEvent) {
+			failures = append(failures, e)
+		},
+		OnRecovery: func(e TokenFailureEvent) {
+			recoveries = append(recoveries, e)
+		},
+	}
+	c := newConnectorForTesting(t, platform.URL)
+	c.TokenFailures = monitor
+
+	for i := 0; i < 4; i++ {
+		if err := c.GetAccessToken([]string{"scope"}); err == nil {
+			t.Fatal("token failure not reported")
+		}
+	}
+	if len(failures) != 1 || failures[0].ConsecutiveFailures != 2 || failures[0].ClientID != "abcdef123456" ||
+		failures[0].Err == nil {
+		t.Fatalf("got failure events %v", failures)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	if err := c.GetAccessToken([]string{"scope"}); err != nil {
+		t.Fatalf("get access token error: %v", err)
+	}
+	if len(recoveries) != 1 || recoveries[0].ConsecutiveFailures != 4 {
+		t.Errorf("got recovery events %v", recoveries)
+	}
+}
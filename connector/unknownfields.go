@@ -0,0 +1,150 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// An UnknownFieldsEvent reports the members of a service response that the typed structs of this package do not
+// decode, e.g., vendor extensions of a platform. Fields holds their paths, in which "[]" stands for the elements of an
+// array, e.g., "members[].vendor_extension". Type is the Go type the response is decoded into, e.g.,
+// "connector.Membership".
+type UnknownFieldsEvent struct {
+	URI    string
+	Type   string
+	Fields []string
+}
+
+// unmarshalerType is the type of json.Unmarshaler, whose implementations decode their members themselves.
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeResponse decodes the JSON service response from the URI into v. If the Connector's OnUnknownFields is set,
+// the members of the response that v does not decode are reported to it.
+func (c *Connector) decodeResponse(body io.Reader, uri *url.URL, v interface{}) error {
+	if c.OnUnknownFields == nil {
+		return json.NewDecoder(body).Decode(v)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %w", err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	fields := map[string]bool{}
+	collectUnknownFields(decoded, reflect.TypeOf(v), "", fields)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	event := UnknownFieldsEvent{Type: reflect.TypeOf(v).Elem().String()}
+	if uri != nil {
+		event.URI = uri.String()
+	}
+	for field := range fields {
+		event.Fields = append(event.Fields, field)
+	}
+	sort.Strings(event.Fields)
+	c.OnUnknownFields(event)
+
+	return nil
+}
+
+// collectUnknownFields adds the paths of the members of the decoded JSON value that the type does not decode to the
+// fields. Like encoding/json, it matches the members to the struct fields without regard to case.
+func collectUnknownFields(value interface{}, t reflect.Type, path string, fields map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			known := jsonFieldTypes(t)
+			for name, member := range value {
+				fieldType, ok := known[strings.ToLower(name)]
+				if !ok {
+					fields[joinFieldPath(path, name)] = true
+					continue
+				}
+				collectUnknownFields(member, fieldType, joinFieldPath(path, name), fields)
+			}
+		case reflect.Map:
+			for name, member := range value {
+				collectUnknownFields(member, t.Elem(), joinFieldPath(path, name), fields)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, element := range value {
+				collectUnknownFields(element, t.Elem(), path+"[]", fields)
+			}
+		}
+	}
+}
+
+// jsonFieldTypes returns the types of the struct's fields decoded by encoding/json, by their lowercased JSON names.
+// The fields of embedded structs are included.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	types := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for embeddedName, embeddedType := range jsonFieldTypes(embedded) {
+					if _, ok := types[embeddedName]; !ok {
+						types[embeddedName] = embeddedType
+					}
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		types[strings.ToLower(name)] = field.Type
+	}
+
+	return types
+}
+
+// joinFieldPath appends the member's name to the path of its parent.
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package connector
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeResponseUnknownFields(t *testing.T) {
+	body := `{
+		"id": "https://platform.tld/memberships",
+		"context": {"id": "1", "label": "CMPT 101", "title": "Intro", "vendor_context": true},
+		"members": [
+			{"status": "Active", "user_id": "2", "roles": ["Learner"], "https://vendor.tld/ext": {"a": 1}},
+			{"Status": "Active", "user_id": "3", "group_enrollments": [{"group_id": "g", "role": "Member"}]}
+		]
+	}`
+	uri, _ := url.Parse("https://platform.tld/memberships")
+
+	var events []UnknownFieldsEvent
+	c := &Connector{OnUnknownFields: func(event UnknownFieldsEvent) {
+		events = append(events, event)
+	}}
+	var membership Membership
+	err := c.decodeResponse(strings.NewReader(body), uri, &membership)
+	if err != nil {
+		t.Fatalf("decode response error: %v", err)
+	}
+	if len(membership.Members) != 2 || membership.Members[1].Status != "Active" {
+		t.Errorf("got membership %+v", membership)
+	}
+
+	want := UnknownFieldsEvent{
+		URI:  "https://platform.tld/memberships",
+		Type: "connector.Membership",
+		Fields: []string{
+			"context.vendor_context",
+			"members[].group_enrollments[].role",
+			"members[].https://vendor.tld/ext",
+		},
+	}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], want) {
+		t.Errorf("got events %+v", events)
+	}
+
+	events = nil
+	var lineItems []LineItem
+	err = c.decodeResponse(strings.NewReader(`[{"id": "1", "label": "A"}]`), uri, &lineItems)
+	if err != nil || len(events) != 0 {
+		t.Errorf("got %v, events %+v for known fields", err, events)
+	}
+}
@@ -10,21 +10,113 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"strings"
 	"time"
 )
 
 // Config holds the stores required for LTI packages. New package functions will accept the zero value of this struct,
 // and in the case of the zero value, the resulting LTI process will use nonpersistent storage.
+//
+// Clock is the time source used to check expiry times; when nil, the system clock is used. Tests can supply a fixed or
+// controllable clock to simulate expiry and clock skew deterministically.
+//
+// When StrictHTTPS is set, registrations and launch claims containing plaintext (http://) endpoints are rejected, with
+// the exception of endpoints on the local host. It is off by default for compatibility but is expected to become the
+// default in a future major version.
+//
+// When StrictTokens is set, id_tokens are rejected before signature verification if they are unsigned or signed with a
+// symmetric (HS*) algorithm, if they carry a typ header other than "JWT", or if they carry a crit header. The
+// signature check would normally reject such tokens anyway; strict mode makes the rejection explicit for
+// security-sensitive deployments.
 type Config struct {
 	Registrations RegistrationStorer
 	Nonces        NonceStorer
 	LaunchData    LaunchDataStorer
 	AccessTokens  AccessTokenStorer
+	Replays       ReplayStorer
+	States        StateStorer
+	Clock         Clock
+	StrictHTTPS   bool
+	StrictTokens  bool
+}
+
+// A Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts an ordinary function to the Clock interface.
+type ClockFunc func() time.Time
+
+// Now returns the function's result.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is the Clock that reads the system time.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// Now returns the current time according to the clock, or according to the system clock when clock is nil.
+func Now(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+
+	return clock.Now()
+}
+
+// ErrStoreNotConfigured is returned by the strict constructors (e.g., launch.NewStrict) when a store that they require
+// is nil. Unlike the regular constructors, they do not fall back on the nonpersistent default store, so a
+// misconfiguration, such as a store that was never assigned, is caught at startup.
+var ErrStoreNotConfigured = errors.New("store not configured")
+
+// The names of the stores of a Config, as reported by RequireStores.
+const (
+	RegistrationsStore = "Registrations"
+	NoncesStore        = "Nonces"
+	LaunchDataStore    = "LaunchData"
+	AccessTokensStore  = "AccessTokens"
+	ReplaysStore       = "Replays"
+	StatesStore        = "States"
+)
+
+// RequireStores checks that the named stores of the Config are set. It returns an error wrapping
+// ErrStoreNotConfigured that names the first nil store.
+func (c Config) RequireStores(names ...string) error {
+	for _, name := range names {
+		var configured bool
+		switch name {
+		case RegistrationsStore:
+			configured = c.Registrations != nil
+		case NoncesStore:
+			configured = c.Nonces != nil
+		case LaunchDataStore:
+			configured = c.LaunchData != nil
+		case AccessTokensStore:
+			configured = c.AccessTokens != nil
+		case ReplaysStore:
+			configured = c.Replays != nil
+		case StatesStore:
+			configured = c.States != nil
+		default:
+			return fmt.Errorf("unknown store %s", name)
+		}
+		if !configured {
+			return fmt.Errorf("%s %w", name, ErrStoreNotConfigured)
+		}
+	}
+
+	return nil
 }
 
 // A Registration is the details of a link between a Platform and a Tool. There can be multiple deployments per
 // registration. Each Registration is uniquely identified by the ClientID.
+//
+// The KeyID and PrivateKey fields are optional. They carry the tool's own signing identity (a key ID and a PEM encoded
+// RSA private key) for this registration, which allows a single process to host several tools that each sign with
+// their own key.
 type Registration struct {
 	Issuer        string
 	ClientID      string
@@ -32,6 +124,8 @@ type Registration struct {
 	AuthLoginURI  *url.URL
 	KeysetURI     *url.URL
 	TargetLinkURI *url.URL
+	KeyID         string
+	PrivateKey    string
 }
 
 // A Deployment contains that details that identify the platform-tool integration for a message.
@@ -51,6 +145,45 @@ type AccessToken struct {
 
 var maximumDeploymentIDLength = 255
 
+// ErrIncompleteRegistration is the error returned when a registration is missing a required field.
+var ErrIncompleteRegistration = errors.New("incomplete registration")
+
+// ValidateRegistration validates that a registration is complete: the issuer and client ID must be non-empty and each
+// URI must be absolute. A key ID and private key are optional, but one may not be given without the other. The error
+// wraps ErrIncompleteRegistration and names the offending field.
+func ValidateRegistration(reg Registration) error {
+	if reg.Issuer == "" {
+		return fmt.Errorf("%w: empty Issuer", ErrIncompleteRegistration)
+	}
+	if reg.ClientID == "" {
+		return fmt.Errorf("%w: empty ClientID", ErrIncompleteRegistration)
+	}
+
+	uris := []struct {
+		field string
+		uri   *url.URL
+	}{
+		{"AuthTokenURI", reg.AuthTokenURI},
+		{"AuthLoginURI", reg.AuthLoginURI},
+		{"KeysetURI", reg.KeysetURI},
+		{"TargetLinkURI", reg.TargetLinkURI},
+	}
+	for _, u := range uris {
+		if u.uri == nil {
+			return fmt.Errorf("%w: nil %s", ErrIncompleteRegistration, u.field)
+		}
+		if !u.uri.IsAbs() || u.uri.Host == "" {
+			return fmt.Errorf("%w: %s is not an absolute URI", ErrIncompleteRegistration, u.field)
+		}
+	}
+
+	if (reg.KeyID == "") != (reg.PrivateKey == "") {
+		return fmt.Errorf("%w: KeyID and PrivateKey must be set together", ErrIncompleteRegistration)
+	}
+
+	return nil
+}
+
 // ValidateDeploymentID validates a deployment ID.
 func ValidateDeploymentID(deploymentID string) error {
 	if len(deploymentID) == 0 {
@@ -63,6 +196,52 @@ func ValidateDeploymentID(deploymentID string) error {
 	return nil
 }
 
+// ErrInsecureURI is the error returned when a plaintext (http://) endpoint is found under strict HTTPS enforcement.
+var ErrInsecureURI = errors.New("insecure URI")
+
+// ValidateSecureURI checks that an endpoint uses HTTPS. Plaintext HTTP is accepted only for the local host (localhost
+// and loopback addresses), which is typical of development environments.
+func ValidateSecureURI(uri *url.URL) error {
+	if uri == nil {
+		return errors.New("received nil URI")
+	}
+
+	switch strings.ToLower(uri.Scheme) {
+	case "https":
+		return nil
+	case "http":
+		host := uri.Hostname()
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrInsecureURI, uri.Redacted())
+}
+
+// ValidateRegistrationSecurity checks that every endpoint of a registration uses HTTPS. See ValidateSecureURI.
+func ValidateRegistrationSecurity(reg Registration) error {
+	uris := []struct {
+		field string
+		uri   *url.URL
+	}{
+		{"AuthTokenURI", reg.AuthTokenURI},
+		{"AuthLoginURI", reg.AuthLoginURI},
+		{"KeysetURI", reg.KeysetURI},
+		{"TargetLinkURI", reg.TargetLinkURI},
+	}
+	for _, u := range uris {
+		if err := ValidateSecureURI(u.uri); err != nil {
+			return fmt.Errorf("registration %s: %w", u.field, err)
+		}
+	}
+
+	return nil
+}
+
 var (
 	// ErrRegistrationNotFound is the error returned when a registration cannot be found.
 	ErrRegistrationNotFound = errors.New("registration not found")
@@ -73,7 +252,8 @@ var (
 
 // A RegistrationStorer manages the storage and retrieval of LTI registrations & deployments.
 type RegistrationStorer interface {
-	// StoreRegistration stores a registration for later retrieval.
+	// StoreRegistration stores a registration for later retrieval. Incomplete registrations (see
+	// ValidateRegistration) are rejected.
 	StoreRegistration(Registration) error
 
 	// FindRegistrationByIssuerAndClientID retrieves a previously-stored registration using the `issuer' and
@@ -96,6 +276,10 @@ var (
 	// ErrNonceTargetLinkURIMismatch is the error returned when a nonce is found but there's a mismatch in the
 	// target URI.
 	ErrNonceTargetLinkURIMismatch = errors.New("nonce found with mismatched target link uri")
+
+	// ErrNonceExpired is the error returned when a nonce is found but it was stored too long ago to be used, e.g.,
+	// because the user abandoned the login and the launch was replayed later.
+	ErrNonceExpired = errors.New("nonce has expired")
 )
 
 // A NonceStorer manages the storage and retrieval of LTI nonces.
@@ -103,11 +287,45 @@ type NonceStorer interface {
 	// StoreNonce stores a nonce for later retrieval.
 	StoreNonce(nonce string, targetLinkURI string) error
 
-	// TestAndClearNonce tests for the existance of a nonce. If the nonce is found and the target URI matches, it
-	// removes/clears the nonce and returns nil. Otherwise, it returns one of the ErrNonce errors.
+	// TestAndClearNonce tests for the existance of a nonce. If the nonce is found, has not expired and the target
+	// URI matches, it removes/clears the nonce and returns nil. Otherwise, it returns one of the ErrNonce errors.
 	TestAndClearNonce(nonce string, targetLinkURI string) error
 }
 
+// ErrTokenReplayed is the error returned when a token ID has already been stored.
+var ErrTokenReplayed = errors.New("token already used")
+
+// A ReplayStorer records the IDs of the tokens that have been used, so that a token, e.g., a launch's id_token, is
+// only accepted once.
+type ReplayStorer interface {
+	// StoreTokenID records the token ID until `expiry', after which the token is no longer accepted anyway. If the
+	// token ID has already been recorded and has not expired, it returns ErrTokenReplayed.
+	StoreTokenID(tokenID string, expiry time.Time) error
+}
+
+var (
+	// ErrStateNotFound is the error returned when a state cannot be found, e.g., because it has already been used.
+	ErrStateNotFound = errors.New("state not found")
+
+	// ErrStateExpired is the error returned when a state is found but its expiry has passed.
+	ErrStateExpired = errors.New("state has expired")
+
+	// ErrStateNonceMismatch is the error returned when a state is found but it was issued with another nonce.
+	ErrStateNonceMismatch = errors.New("state found with mismatched nonce")
+)
+
+// A StateStorer keeps the state of each login on the server, so that the launch can check the state without the
+// state cookie, e.g., for browsers that block cookies in the platform's iframe (Safari's Intelligent Tracking
+// Prevention) and embedded webviews.
+type StateStorer interface {
+	// StoreState stores the state of a login, along with the nonce issued with it, until `expiry'.
+	StoreState(state string, nonce string, expiry time.Time) error
+
+	// TestAndClearState tests for the existence of a state. If the state is found, has not expired and the nonce
+	// matches, it removes/clears the state and returns nil. Otherwise, it returns one of the ErrState errors.
+	TestAndClearState(state string, nonce string) error
+}
+
 // ErrLaunchDataNotFound is the error returned when cached launch data cannot be found.
 var ErrLaunchDataNotFound = errors.New("launch data not found")
 
@@ -121,6 +339,18 @@ type LaunchDataStorer interface {
 	FindLaunchData(launchID string) (json.RawMessage, error)
 }
 
+// A Purger removes stored data belonging to a user or to a context (i.e., a course) so that tools can honor deletion
+// requests. Any store may implement it in addition to its Storer interfaces.
+type Purger interface {
+	// PurgeUserData removes all stored data associated with the user identified by `subject', i.e., the `sub'
+	// claim of the launch. It returns the number of records removed.
+	PurgeUserData(subject string) (int, error)
+
+	// PurgeContextData removes all stored data associated with the LTI context identified by `contextID'. It
+	// returns the number of records removed.
+	PurgeContextData(contextID string) (int, error)
+}
+
 // ErrAccessTokenNotFound is the error returned when an access token cannot be found.
 var ErrAccessTokenNotFound = errors.New("access token not found")
 
@@ -0,0 +1,126 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A MigratingRegistrationStore is a RegistrationStorer for moving registrations and deployments from one store to
+// another, e.g., from registrations configured in the environment to a SQL store, while the tool stays online.
+//
+// Lookups are answered by Primary, the store being migrated to, and fall back on Secondary, the store being migrated
+// from, when Primary does not have the registration or deployment. Changes are stored in Primary, and, when
+// DualWrite is set, also in Secondary, so that the tool can still be rolled back to Secondary alone. Once every
+// registration and deployment is in Primary, the MigratingRegistrationStore is replaced by Primary.
+type MigratingRegistrationStore struct {
+	Primary   RegistrationStorer
+	Secondary RegistrationStorer
+	DualWrite bool
+}
+
+// NewMigratingRegistrationStore returns a *MigratingRegistrationStore reading from primary, then secondary.
+func NewMigratingRegistrationStore(primary, secondary RegistrationStorer) *MigratingRegistrationStore {
+	return &MigratingRegistrationStore{
+		Primary:   primary,
+		Secondary: secondary,
+	}
+}
+
+// StoreRegistration stores the registration in Primary and, with DualWrite, in Secondary. If it is stored in Primary
+// but cannot be stored in Secondary, the returned error says so.
+func (m *MigratingRegistrationStore) StoreRegistration(reg Registration) error {
+	err := m.Primary.StoreRegistration(reg)
+	if err != nil {
+		return err
+	}
+	if !m.DualWrite {
+		return nil
+	}
+
+	err = m.Secondary.StoreRegistration(reg)
+	if err != nil {
+		return fmt.Errorf("registration stored in primary but not in secondary store: %w", err)
+	}
+
+	return nil
+}
+
+// FindRegistrationByIssuerAndClientID finds the registration in Primary or, if it is not found there, in Secondary.
+func (m *MigratingRegistrationStore) FindRegistrationByIssuerAndClientID(issuer,
+	clientID string) (Registration, error) {
+	reg, err := m.Primary.FindRegistrationByIssuerAndClientID(issuer, clientID)
+	if !errors.Is(err, ErrRegistrationNotFound) {
+		return reg, err
+	}
+
+	return m.Secondary.FindRegistrationByIssuerAndClientID(issuer, clientID)
+}
+
+// StoreDeployment stores the deployment in Primary and, with DualWrite, in Secondary. If it is stored in Primary but
+// cannot be stored in Secondary, the returned error says so.
+func (m *MigratingRegistrationStore) StoreDeployment(issuer string, deployment Deployment) error {
+	err := m.Primary.StoreDeployment(issuer, deployment)
+	if err != nil {
+		return err
+	}
+	if !m.DualWrite {
+		return nil
+	}
+
+	err = m.Secondary.StoreDeployment(issuer, deployment)
+	if err != nil {
+		return fmt.Errorf("deployment stored in primary but not in secondary store: %w", err)
+	}
+
+	return nil
+}
+
+// FindDeployment finds the deployment in Primary or, if it is not found there, in Secondary.
+func (m *MigratingRegistrationStore) FindDeployment(issuer, deploymentID string) (Deployment, error) {
+	deployment, err := m.Primary.FindDeployment(issuer, deploymentID)
+	if !errors.Is(err, ErrDeploymentNotFound) {
+		return deployment, err
+	}
+
+	return m.Secondary.FindDeployment(issuer, deploymentID)
+}
+
+// ListRegistrations lists the registrations of both stores that are RegistrationListers, sorted by issuer and client
+// ID. A registration found in both stores is listed once, as found in Primary.
+func (m *MigratingRegistrationStore) ListRegistrations() ([]Registration, error) {
+	seen := map[string]bool{}
+	var registrations []Registration
+	for _, store := range []RegistrationStorer{m.Primary, m.Secondary} {
+		lister, ok := store.(RegistrationLister)
+		if !ok {
+			continue
+		}
+		listed, err := lister.ListRegistrations()
+		if err != nil {
+			return nil, err
+		}
+		for _, reg := range listed {
+			key := cacheKey(reg.Issuer, reg.ClientID)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			registrations = append(registrations, reg)
+		}
+	}
+
+	sort.Slice(registrations, func(i, j int) bool {
+		if registrations[i].Issuer != registrations[j].Issuer {
+			return registrations[i].Issuer < registrations[j].Issuer
+		}
+		return registrations[i].ClientID < registrations[j].ClientID
+	})
+
+	return registrations, nil
+}
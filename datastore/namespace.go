@@ -0,0 +1,147 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Namespace returns a copy of the Config whose nonce, launch data, access token, replay and state stores prefix every
+// key with the namespace, so that several tools can share a backend, e.g., one SQL database, without their keys
+// meeting. The namespace is typically the tool's client ID or a configured name, and it must be distinct for each
+// tool.
+//
+// Registrations are not namespaced, since they are already keyed by issuer and client ID. Nil stores are left nil, so
+// they still fall back on the nonpersistent default store. Launch IDs, as seen by the tool, are not changed; only
+// their keys in the launch data store are. Purging user or context data through a namespaced launch data store
+// purges the data of every namespace, since the purge is performed by the underlying store.
+func Namespace(cfg Config, namespace string) (Config, error) {
+	if namespace == "" {
+		return Config{}, errors.New("received empty namespace argument")
+	}
+	prefix := keyPrefix(namespace + ":")
+
+	if cfg.Nonces != nil {
+		cfg.Nonces = namespacedNonces{store: cfg.Nonces, prefix: prefix}
+	}
+	if cfg.LaunchData != nil {
+		cfg.LaunchData = namespacedLaunchData{store: cfg.LaunchData, prefix: prefix}
+	}
+	if cfg.AccessTokens != nil {
+		cfg.AccessTokens = namespacedAccessTokens{store: cfg.AccessTokens, prefix: prefix}
+	}
+	if cfg.Replays != nil {
+		cfg.Replays = namespacedReplays{store: cfg.Replays, prefix: prefix}
+	}
+	if cfg.States != nil {
+		cfg.States = namespacedStates{store: cfg.States, prefix: prefix}
+	}
+
+	return cfg, nil
+}
+
+// A keyPrefix is the prefix of the keys of a namespace.
+type keyPrefix string
+
+// key returns the key prefixed with the namespace. An empty key is returned as is, so that the underlying store
+// rejects it as it would without a namespace.
+func (p keyPrefix) key(k string) string {
+	if k == "" {
+		return ""
+	}
+
+	return string(p) + k
+}
+
+// namespacedNonces prefixes the nonces of a NonceStorer.
+type namespacedNonces struct {
+	store  NonceStorer
+	prefix keyPrefix
+}
+
+func (n namespacedNonces) StoreNonce(nonce, targetLinkURI string) error {
+	return n.store.StoreNonce(n.prefix.key(nonce), targetLinkURI)
+}
+
+func (n namespacedNonces) TestAndClearNonce(nonce, targetLinkURI string) error {
+	return n.store.TestAndClearNonce(n.prefix.key(nonce), targetLinkURI)
+}
+
+// namespacedLaunchData prefixes the launch IDs of a LaunchDataStorer.
+type namespacedLaunchData struct {
+	store  LaunchDataStorer
+	prefix keyPrefix
+}
+
+func (n namespacedLaunchData) StoreLaunchData(launchID string, launchData json.RawMessage) error {
+	return n.store.StoreLaunchData(n.prefix.key(launchID), launchData)
+}
+
+func (n namespacedLaunchData) FindLaunchData(launchID string) (json.RawMessage, error) {
+	return n.store.FindLaunchData(n.prefix.key(launchID))
+}
+
+// PurgeUserData purges the user's data with the underlying store, if it is a Purger.
+func (n namespacedLaunchData) PurgeUserData(subject string) (int, error) {
+	if purger, ok := n.store.(Purger); ok {
+		return purger.PurgeUserData(subject)
+	}
+
+	return 0, nil
+}
+
+// PurgeContextData purges the context's data with the underlying store, if it is a Purger.
+func (n namespacedLaunchData) PurgeContextData(contextID string) (int, error) {
+	if purger, ok := n.store.(Purger); ok {
+		return purger.PurgeContextData(contextID)
+	}
+
+	return 0, nil
+}
+
+// namespacedAccessTokens prefixes the token URIs, which are part of the keys, of an AccessTokenStorer.
+type namespacedAccessTokens struct {
+	store  AccessTokenStorer
+	prefix keyPrefix
+}
+
+func (n namespacedAccessTokens) StoreAccessToken(token AccessToken) error {
+	token.TokenURI = n.prefix.key(token.TokenURI)
+	return n.store.StoreAccessToken(token)
+}
+
+func (n namespacedAccessTokens) FindAccessToken(tokenURI, clientID string, scopes []string) (AccessToken, error) {
+	token, err := n.store.FindAccessToken(n.prefix.key(tokenURI), clientID, scopes)
+	token.TokenURI = strings.TrimPrefix(token.TokenURI, string(n.prefix))
+	return token, err
+}
+
+// namespacedReplays prefixes the token IDs of a ReplayStorer.
+type namespacedReplays struct {
+	store  ReplayStorer
+	prefix keyPrefix
+}
+
+func (n namespacedReplays) StoreTokenID(tokenID string, expiry time.Time) error {
+	return n.store.StoreTokenID(n.prefix.key(tokenID), expiry)
+}
+
+// namespacedStates prefixes the states of a StateStorer.
+type namespacedStates struct {
+	store  StateStorer
+	prefix keyPrefix
+}
+
+func (n namespacedStates) StoreState(state, nonce string, expiry time.Time) error {
+	return n.store.StoreState(n.prefix.key(state), nonce, expiry)
+}
+
+func (n namespacedStates) TestAndClearState(state, nonce string) error {
+	return n.store.TestAndClearState(n.prefix.key(state), nonce)
+}
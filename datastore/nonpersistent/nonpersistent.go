@@ -19,17 +19,61 @@ import (
 	"github.com/macewan-cs/lti/datastore"
 )
 
-// Store implements an in-memory datastore.
+// Store implements an in-memory datastore. Clock is the time source used to check expiry times; when nil, the system
+// clock is used.
+//
+// NonceTTL is the time for which a stored nonce can be used; when zero, DefaultNonceTTL is used. Expired nonces are
+// rejected by TestAndClearNonce. LaunchDataTTL is the time for which stored launch data is retained; when zero,
+// DefaultLaunchDataTTL is used. Expired launch data is no longer found by FindLaunchData.
+//
+// Expired entries are kept in memory until they are removed by DeleteExpired, e.g., run periodically by StartSweeper.
 type Store struct {
 	Registrations *sync.Map
 	Deployments   *sync.Map
 	Nonces        *sync.Map
 	LaunchData    *sync.Map
 	AccessTokens  *sync.Map
+	Replays       *sync.Map
+	States        *sync.Map
+	Clock         datastore.Clock
+	NonceTTL      time.Duration
+	LaunchDataTTL time.Duration
+}
+
+// DefaultNonceTTL is the time for which a stored nonce can be used when a Store's NonceTTL is zero. It allows ample
+// time for the platform to complete the login by posting the launch.
+const DefaultNonceTTL = 10 * time.Minute
+
+// DefaultLaunchDataTTL is the time for which stored launch data is retained when a Store's LaunchDataTTL is zero. It
+// should exceed the time a user is expected to spend in the tool after a launch, since the launch data is needed,
+// e.g., to post a score with AGS.
+const DefaultLaunchDataTTL = 24 * time.Hour
+
+// storedNonce is the value of an entry in the Nonces map.
+type storedNonce struct {
+	targetLinkURI string
+	expiry        time.Time
+}
+
+// storedLaunchData is the value of an entry in the LaunchData map.
+type storedLaunchData struct {
+	launchData json.RawMessage
+	expiry     time.Time
+}
+
+// storedState is the value of an entry in the States map.
+type storedState struct {
+	nonce  string
+	expiry time.Time
 }
 
 // DefaultStore provides a single default datastore as a package variable so that other LTI functions can
 // fall back on this datastore whenever the user does not explicitly specify a datastore.
+//
+// DefaultStore is shared by every Config that leaves a store unset, so tests relying on it affect one another, and
+// several tools embedded in one process share their registrations. Each tool (and each parallel test) should rather
+// be given its own store, e.g., datastore.Config{Registrations: s, Nonces: s, LaunchData: s, AccessTokens: s} with
+// s := New(). Tests that must use DefaultStore can Reset it, or Snapshot it and Restore it afterwards.
 var DefaultStore *Store = New()
 
 // New returns an empty, zeroed sync.Map for each Storer interface.
@@ -40,6 +84,8 @@ func New() *Store {
 		Nonces:        &sync.Map{},
 		LaunchData:    &sync.Map{},
 		AccessTokens:  &sync.Map{},
+		Replays:       &sync.Map{},
+		States:        &sync.Map{},
 	}
 }
 
@@ -49,6 +95,10 @@ func registrationIndex(issuer, clientID string) string {
 
 // StoreRegistration stores a Registration in-memory.
 func (s *Store) StoreRegistration(reg datastore.Registration) error {
+	if err := datastore.ValidateRegistration(reg); err != nil {
+		return fmt.Errorf("received invalid registration: %w", err)
+	}
+
 	// Store the registration both with and without the client ID: later, the registration can be retrieved with or
 	// without it. See FindRegistrationByIssuerAndClientID for further details.
 	s.Registrations.Store(reg.Issuer, reg)
@@ -97,6 +147,26 @@ func (s *Store) FindRegistrationByIssuerAndClientID(issuer, clientID string) (da
 	return registration.(datastore.Registration), nil
 }
 
+// ListRegistrations returns every stored registration, ordered by issuer and client ID.
+func (s *Store) ListRegistrations() ([]datastore.Registration, error) {
+	var registrations []datastore.Registration
+	s.Registrations.Range(func(key, value interface{}) bool {
+		// Each registration is stored under two keys; it is listed by its issuer and client ID key only.
+		reg := value.(datastore.Registration)
+		if key.(string) == registrationIndex(reg.Issuer, reg.ClientID) {
+			registrations = append(registrations, reg)
+		}
+		return true
+	})
+
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrationIndex(registrations[i].Issuer, registrations[i].ClientID) <
+			registrationIndex(registrations[j].Issuer, registrations[j].ClientID)
+	})
+
+	return registrations, nil
+}
+
 // FindDeployment looks up and returns either a Deployment by the issuer and deployment ID or the datastore error
 // ErrDeploymentNotFound.
 func (s *Store) FindDeployment(issuer, deploymentID string) (datastore.Deployment, error) {
@@ -125,12 +195,18 @@ func (s *Store) StoreNonce(nonce, targetLinkURI string) error {
 		return errors.New("received empty issuer argument")
 	}
 
-	s.Nonces.Store(nonce, targetLinkURI)
+	ttl := s.NonceTTL
+	if ttl == 0 {
+		ttl = DefaultNonceTTL
+	}
+
+	s.Nonces.Store(nonce, storedNonce{targetLinkURI: targetLinkURI, expiry: datastore.Now(s.Clock).Add(ttl)})
 	return nil
 }
 
 // TestAndClearNonce looks up a nonce, clears the entry if found, and returns whether it was found via the error
-// return. If the nonce wasn't found, it returns the datastore error ErrNonceNotFound. If it was found, it returns nil.
+// return. If the nonce wasn't found, it returns the datastore error ErrNonceNotFound, and if it has expired,
+// ErrNonceExpired. If it was found, it returns nil.
 func (s *Store) TestAndClearNonce(nonce, targetLinkURI string) error {
 	if nonce == "" {
 		return errors.New("received empty nonce argument")
@@ -139,21 +215,145 @@ func (s *Store) TestAndClearNonce(nonce, targetLinkURI string) error {
 		return errors.New("received empty target link uri argument")
 	}
 
-	checkURI, ok := s.Nonces.Load(nonce)
+	value, ok := s.Nonces.Load(nonce)
 	if !ok {
 		return datastore.ErrNonceNotFound
 	}
 
 	s.Nonces.Delete(nonce)
 
-	if checkURI != targetLinkURI {
+	stored := value.(storedNonce)
+	if stored.expiry.Before(datastore.Now(s.Clock)) {
+		return datastore.ErrNonceExpired
+	}
+	if stored.targetLinkURI != targetLinkURI {
 		return datastore.ErrNonceTargetLinkURIMismatch
 	}
 
 	return nil
 }
 
-// StoreLaunchData stores the launch data, i.e. the id_token JWT.
+// DeleteExpiredNonces removes all expired nonces, i.e., those of logins that were never completed by a launch. It
+// returns the number of nonces removed.
+func (s *Store) DeleteExpiredNonces() int {
+	now := datastore.Now(s.Clock)
+
+	var removed int
+	s.Nonces.Range(func(key, value interface{}) bool {
+		if value.(storedNonce).expiry.Before(now) {
+			s.Nonces.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed
+}
+
+// DeleteExpiredLaunchData removes all launch data retained for longer than the LaunchDataTTL. It returns the number
+// of launches removed.
+func (s *Store) DeleteExpiredLaunchData() int {
+	now := datastore.Now(s.Clock)
+
+	var removed int
+	s.LaunchData.Range(func(key, value interface{}) bool {
+		if value.(storedLaunchData).expiry.Before(now) {
+			s.LaunchData.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed
+}
+
+// DeleteExpiredAccessTokens removes all expired access tokens. It returns the number of access tokens removed.
+func (s *Store) DeleteExpiredAccessTokens() int {
+	now := datastore.Now(s.Clock)
+
+	var removed int
+	s.AccessTokens.Range(func(key, value interface{}) bool {
+		var accessToken datastore.AccessToken
+		if err := json.Unmarshal(value.([]byte), &accessToken); err != nil || accessToken.ExpiryTime.Before(now) {
+			s.AccessTokens.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed
+}
+
+// DeleteExpiredTokenIDs removes all token IDs recorded by StoreTokenID whose expiry has passed. It returns the number
+// of token IDs removed.
+func (s *Store) DeleteExpiredTokenIDs() int {
+	now := datastore.Now(s.Clock)
+
+	var removed int
+	s.Replays.Range(func(key, value interface{}) bool {
+		if value.(time.Time).Before(now) {
+			s.Replays.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed
+}
+
+// DeleteExpiredStates removes all states stored by StoreState whose expiry has passed, i.e., those of logins that
+// were never completed by a launch. It returns the number of states removed.
+func (s *Store) DeleteExpiredStates() int {
+	now := datastore.Now(s.Clock)
+
+	var removed int
+	s.States.Range(func(key, value interface{}) bool {
+		if value.(storedState).expiry.Before(now) {
+			s.States.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed
+}
+
+// DeleteExpired removes all expired nonces, launch data, access tokens, token IDs and states. It returns the number
+// of entries removed.
+func (s *Store) DeleteExpired() int {
+	return s.DeleteExpiredNonces() + s.DeleteExpiredLaunchData() + s.DeleteExpiredAccessTokens() +
+		s.DeleteExpiredTokenIDs() + s.DeleteExpiredStates()
+}
+
+// StartSweeper starts removing expired entries with DeleteExpired every interval, so that a long-running tool does
+// not accumulate them. The returned sweeper must be closed to stop the background removal.
+func (s *Store) StartSweeper(interval time.Duration) *datastore.Sweeper {
+	return datastore.StartSweeper(interval, func() error {
+		s.DeleteExpired()
+		return nil
+	}, nil)
+}
+
+// StoreTokenID records a token ID until its expiry. It returns the datastore error ErrTokenReplayed if the token ID is
+// already recorded and has not expired.
+func (s *Store) StoreTokenID(tokenID string, expiry time.Time) error {
+	if tokenID == "" {
+		return errors.New("received empty tokenID argument")
+	}
+
+	recorded, loaded := s.Replays.LoadOrStore(tokenID, expiry)
+	if loaded {
+		if !recorded.(time.Time).Before(datastore.Now(s.Clock)) {
+			return datastore.ErrTokenReplayed
+		}
+		// The earlier use has expired, so the token ID is recorded anew.
+		s.Replays.Store(tokenID, expiry)
+	}
+
+	return nil
+}
+
+// StoreLaunchData stores the launch data, i.e. the id_token JWT, for the LaunchDataTTL.
 func (s *Store) StoreLaunchData(launchID string, launchData json.RawMessage) error {
 	if launchID == "" {
 		return errors.New("received empty launchID argument")
@@ -162,25 +362,101 @@ func (s *Store) StoreLaunchData(launchID string, launchData json.RawMessage) err
 		return errors.New("received empty launchData argument")
 	}
 
-	s.LaunchData.Store(launchID, launchData)
+	ttl := s.LaunchDataTTL
+	if ttl == 0 {
+		ttl = DefaultLaunchDataTTL
+	}
+
+	s.LaunchData.Store(launchID, storedLaunchData{launchData: launchData, expiry: datastore.Now(s.Clock).Add(ttl)})
 	return nil
 }
 
-// FindLaunchData retrieves a cached launchData.
+// FindLaunchData retrieves a cached launchData. Expired launch data is reported as ErrLaunchDataNotFound.
 func (s *Store) FindLaunchData(launchID string) (json.RawMessage, error) {
 	if launchID == "" {
 		return nil, errors.New("received empty launchID argument")
 	}
 
-	launchData, ok := s.LaunchData.Load(launchID)
+	value, ok := s.LaunchData.Load(launchID)
 	if !ok {
 		return nil, datastore.ErrLaunchDataNotFound
 	}
-	return launchData.(json.RawMessage), nil
+	stored := value.(storedLaunchData)
+	if stored.expiry.Before(datastore.Now(s.Clock)) {
+		return nil, datastore.ErrLaunchDataNotFound
+	}
+	return stored.launchData, nil
+}
+
+// PurgeUserData removes the launch data of all launches performed by the user identified by `subject'.
+func (s *Store) PurgeUserData(subject string) (int, error) {
+	if subject == "" {
+		return 0, errors.New("received empty subject argument")
+	}
+
+	return s.purgeLaunchData(func(claims launchClaims) bool {
+		return claims.Subject == subject
+	})
+}
+
+// PurgeContextData removes the launch data of all launches from the context identified by `contextID'.
+func (s *Store) PurgeContextData(contextID string) (int, error) {
+	if contextID == "" {
+		return 0, errors.New("received empty contextID argument")
+	}
+
+	return s.purgeLaunchData(func(claims launchClaims) bool {
+		return claims.Context.ID == contextID
+	})
+}
+
+// launchClaims holds the launch data claims that identify the owner of the launch data.
+type launchClaims struct {
+	Subject string `json:"sub"`
+	Context struct {
+		ID string `json:"id"`
+	} `json:"https://purl.imsglobal.org/spec/lti/claim/context"`
+}
+
+// purgeLaunchData removes all launch data for which `match' returns true. Launch data that cannot be decoded is
+// skipped, so that it does not prevent the rest from being purged, and reported in the returned error.
+func (s *Store) purgeLaunchData(match func(launchClaims) bool) (int, error) {
+	var (
+		purged    int
+		undecoded []string
+		decodeErr error
+	)
+
+	s.LaunchData.Range(func(key, value interface{}) bool {
+		var claims launchClaims
+		if err := json.Unmarshal(value.(storedLaunchData).launchData, &claims); err != nil {
+			undecoded = append(undecoded, key.(string))
+			if decodeErr == nil {
+				decodeErr = err
+			}
+			return true
+		}
+		if match(claims) {
+			s.LaunchData.Delete(key)
+			purged++
+		}
+
+		return true
+	})
+
+	if len(undecoded) > 0 {
+		sort.Strings(undecoded)
+		return purged, fmt.Errorf("could not decode launch data %s: %w", strings.Join(undecoded, ", "), decodeErr)
+	}
+
+	return purged, nil
 }
 
 func accessTokenIndex(tokenURI, clientID string, scopes []string) string {
-	return tokenURI + clientID + strings.Join(scopes[:], " ")
+	sortedScopes := append([]string{}, scopes...)
+	sort.Strings(sortedScopes)
+
+	return tokenURI + clientID + strings.Join(sortedScopes, " ")
 }
 
 // StoreAccessToken stores bearer tokens for potential reuse.
@@ -202,6 +478,7 @@ func (s *Store) StoreAccessToken(token datastore.AccessToken) error {
 		return errors.New("received empty expiry time")
 	}
 
+	token.Scopes = append([]string{}, token.Scopes...)
 	sort.Strings(token.Scopes)
 
 	storeValue, err := json.Marshal(token)
@@ -240,9 +517,49 @@ func (s *Store) FindAccessToken(tokenURI, clientID string, scopes []string) (dat
 	if err != nil {
 		return datastore.AccessToken{}, fmt.Errorf("could not decode access token: %w", err)
 	}
-	if accessToken.ExpiryTime.Before(time.Now()) {
+	if accessToken.ExpiryTime.Before(datastore.Now(s.Clock)) {
 		return datastore.AccessToken{}, datastore.ErrAccessTokenExpired
 	}
 
 	return accessToken, nil
 }
+
+// StoreState stores the state of a login, along with its nonce, until its expiry.
+func (s *Store) StoreState(state, nonce string, expiry time.Time) error {
+	if state == "" {
+		return errors.New("received empty state argument")
+	}
+	if nonce == "" {
+		return errors.New("received empty nonce argument")
+	}
+
+	s.States.Store(state, storedState{nonce: nonce, expiry: expiry})
+	return nil
+}
+
+// TestAndClearState looks up a state and clears the entry if found, so that each state is used once. If the state
+// wasn't found, it returns the datastore error ErrStateNotFound, if it has expired, ErrStateExpired, and if it was
+// stored with another nonce, ErrStateNonceMismatch. Otherwise, it returns nil.
+func (s *Store) TestAndClearState(state, nonce string) error {
+	if state == "" {
+		return errors.New("received empty state argument")
+	}
+	if nonce == "" {
+		return errors.New("received empty nonce argument")
+	}
+
+	value, ok := s.States.LoadAndDelete(state)
+	if !ok {
+		return datastore.ErrStateNotFound
+	}
+
+	stored := value.(storedState)
+	if stored.expiry.Before(datastore.Now(s.Clock)) {
+		return datastore.ErrStateExpired
+	}
+	if stored.nonce != nonce {
+		return datastore.ErrStateNonceMismatch
+	}
+
+	return nil
+}
@@ -6,8 +6,11 @@
 package nonpersistent
 
 import (
+	"encoding/json"
+	"errors"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,7 +42,14 @@ func TestStoreAndFindRegistrationByIssuer(t *testing.T) {
 
 	npStore := New()
 
-	err := npStore.StoreRegistration(registration)
+	incomplete := registration
+	incomplete.KeysetURI = nil
+	err := npStore.StoreRegistration(incomplete)
+	if !errors.Is(err, datastore.ErrIncompleteRegistration) {
+		t.Errorf("incomplete registration not reported: got %v", err)
+	}
+
+	err = npStore.StoreRegistration(registration)
 	if err != nil {
 		t.Fatalf("store registration error: %v", err)
 	}
@@ -72,6 +82,11 @@ func TestStoreAndFindRegistrationByIssuer(t *testing.T) {
 	if actual != registration {
 		t.Fatal("found registration does not match stored registration")
 	}
+
+	registrations, err := npStore.ListRegistrations()
+	if err != nil || len(registrations) != 1 || registrations[0].ClientID != registration.ClientID {
+		t.Errorf("got registrations %v, error %v", registrations, err)
+	}
 }
 
 func TestStoreAndFindDeploymentByDeploymentID(t *testing.T) {
@@ -160,6 +175,99 @@ func TestStoreAndTestAndClearNonce(t *testing.T) {
 	}
 }
 
+func TestNonceExpiry(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+
+	npStore := New()
+	npStore.Clock = datastore.ClockFunc(func() time.Time { return now })
+	npStore.NonceTTL = time.Minute
+
+	for _, nonce := range []string{"used", "abandoned"} {
+		if err := npStore.StoreNonce(nonce, "https://tool.tld/launch"); err != nil {
+			t.Fatalf("store nonce error: %v", err)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	err := npStore.TestAndClearNonce("used", "https://tool.tld/launch")
+	if err != datastore.ErrNonceExpired {
+		t.Errorf("got %v for stale nonce, wanted ErrNonceExpired", err)
+	}
+
+	if removed := npStore.DeleteExpiredNonces(); removed != 1 {
+		t.Errorf("got %d removed nonces, wanted 1", removed)
+	}
+	err = npStore.TestAndClearNonce("abandoned", "https://tool.tld/launch")
+	if err != datastore.ErrNonceNotFound {
+		t.Errorf("got %v for removed nonce, wanted ErrNonceNotFound", err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+
+	npStore := New()
+	npStore.Clock = datastore.ClockFunc(func() time.Time { return now })
+	npStore.LaunchDataTTL = time.Hour
+
+	npStore.StoreNonce("nonce", "https://tool.tld/launch")
+	npStore.StoreLaunchData("old", json.RawMessage(`{}`))
+	npStore.StoreTokenID("jti", now.Add(time.Minute))
+	npStore.StoreAccessToken(datastore.AccessToken{
+		TokenURI:   "https://domain.tld/token",
+		ClientID:   "abcdef123456",
+		Scopes:     []string{"https://scope/1.readonly"},
+		Token:      "123456789abcdef",
+		ExpiryTime: now.Add(time.Minute),
+	})
+
+	now = now.Add(59 * time.Minute)
+	npStore.StoreLaunchData("recent", json.RawMessage(`{}`))
+	if _, err := npStore.FindLaunchData("old"); err != nil {
+		t.Errorf("find launch data error before expiry: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := npStore.FindLaunchData("old"); err != datastore.ErrLaunchDataNotFound {
+		t.Errorf("got %v for expired launch data, wanted ErrLaunchDataNotFound", err)
+	}
+
+	if removed := npStore.DeleteExpired(); removed != 4 {
+		t.Errorf("got %d removed entries, wanted 4", removed)
+	}
+	if _, err := npStore.FindLaunchData("recent"); err != nil {
+		t.Errorf("find launch data error for unexpired launch: %v", err)
+	}
+	for _, m := range npStore.maps() {
+		m.Range(func(key, value interface{}) bool {
+			if key != "recent" {
+				t.Errorf("expired entry %v not removed", key)
+			}
+			return true
+		})
+	}
+}
+
+func TestStartSweeper(t *testing.T) {
+	npStore := New()
+	npStore.LaunchDataTTL = time.Nanosecond
+	npStore.StoreLaunchData("launch", json.RawMessage(`{}`))
+
+	sweeper := npStore.StartSweeper(time.Millisecond)
+	defer sweeper.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := npStore.LaunchData.Load("launch"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired launch data not removed by sweeper")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestStoreAccessToken(t *testing.T) {
 	testToken := datastore.AccessToken{
 		TokenURI:   "https://domain.tld/token",
@@ -265,3 +373,273 @@ func TestFindAccessToken(t *testing.T) {
 		t.Fatal("found token does not match test token")
 	}
 }
+
+func TestPurgeUserAndContextData(t *testing.T) {
+	npStore := New()
+	npStore.StoreLaunchData("1", json.RawMessage(`{"sub":"a","https://purl.imsglobal.org/spec/lti/claim/context":{"id":"x"}}`))
+	npStore.StoreLaunchData("2", json.RawMessage(`{"sub":"b","https://purl.imsglobal.org/spec/lti/claim/context":{"id":"x"}}`))
+	npStore.StoreLaunchData("3", json.RawMessage(`{"sub":"a","https://purl.imsglobal.org/spec/lti/claim/context":{"id":"y"}}`))
+
+	_, err := npStore.PurgeUserData("")
+	if err == nil {
+		t.Error("error not reported for empty subject")
+	}
+
+	purged, err := npStore.PurgeUserData("a")
+	if err != nil {
+		t.Fatalf("purge user data error: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("got %d purged, wanted 2", purged)
+	}
+	if _, err = npStore.FindLaunchData("1"); err != datastore.ErrLaunchDataNotFound {
+		t.Error("launch data not purged for user")
+	}
+
+	purged, err = npStore.PurgeContextData("x")
+	if err != nil {
+		t.Fatalf("purge context data error: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("got %d purged, wanted 1", purged)
+	}
+	if _, err = npStore.FindLaunchData("2"); err != datastore.ErrLaunchDataNotFound {
+		t.Error("launch data not purged for context")
+	}
+
+	npStore.StoreLaunchData("4", json.RawMessage(`{"sub":"c"}`))
+	npStore.StoreLaunchData("5", json.RawMessage(`not json`))
+	npStore.StoreLaunchData("6", json.RawMessage(`{"sub":"c"}`))
+	purged, err = npStore.PurgeUserData("c")
+	if err == nil || !strings.Contains(err.Error(), "5") {
+		t.Errorf("got %v, wanted the undecodable launch data reported", err)
+	}
+	if purged != 2 {
+		t.Errorf("got %d purged around undecodable launch data, wanted 2", purged)
+	}
+	if _, err = npStore.FindLaunchData("5"); err != nil {
+		t.Errorf("undecodable launch data removed: %v", err)
+	}
+}
+
+func TestFindAccessTokenClock(t *testing.T) {
+	expiry := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	now := expiry.Add(-time.Minute)
+
+	npStore := New()
+	npStore.Clock = datastore.ClockFunc(func() time.Time { return now })
+	token := datastore.AccessToken{
+		TokenURI:   "https://platform.tld/token",
+		ClientID:   "abc",
+		Scopes:     []string{"scope"},
+		Token:      "token",
+		ExpiryTime: expiry,
+	}
+	err := npStore.StoreAccessToken(token)
+	if err != nil {
+		t.Fatalf("store access token error: %v", err)
+	}
+
+	_, err = npStore.FindAccessToken(token.TokenURI, token.ClientID, token.Scopes)
+	if err != nil {
+		t.Errorf("find access token error: %v", err)
+	}
+
+	now = expiry.Add(time.Minute)
+	_, err = npStore.FindAccessToken(token.TokenURI, token.ClientID, token.Scopes)
+	if err != datastore.ErrAccessTokenExpired {
+		t.Errorf("got %v, wanted ErrAccessTokenExpired", err)
+	}
+}
+
+func TestStoreTokenID(t *testing.T) {
+	expiry := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	now := expiry.Add(-time.Minute)
+
+	npStore := New()
+	npStore.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	if err := npStore.StoreTokenID("", expiry); err == nil {
+		t.Error("error not reported for empty token ID")
+	}
+	if err := npStore.StoreTokenID("jti", expiry); err != nil {
+		t.Fatalf("store token ID error: %v", err)
+	}
+	if err := npStore.StoreTokenID("jti", expiry); err != datastore.ErrTokenReplayed {
+		t.Errorf("got %v for replayed token ID, wanted ErrTokenReplayed", err)
+	}
+
+	now = expiry.Add(time.Minute)
+	if err := npStore.StoreTokenID("jti", now.Add(time.Hour)); err != nil {
+		t.Errorf("got %v for expired token ID", err)
+	}
+	if err := npStore.StoreTokenID("jti", now.Add(time.Hour)); err != datastore.ErrTokenReplayed {
+		t.Errorf("got %v for token ID recorded anew, wanted ErrTokenReplayed", err)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	npStore := New()
+	shared := datastore.Config{Nonces: npStore, LaunchData: npStore, AccessTokens: npStore, Replays: npStore}
+	if _, err := datastore.Namespace(shared, ""); err == nil {
+		t.Error("error not reported for empty namespace")
+	}
+	toolA, err := datastore.Namespace(shared, "a")
+	if err != nil {
+		t.Fatalf("namespace error: %v", err)
+	}
+	toolB, _ := datastore.Namespace(shared, "b")
+
+	toolA.LaunchData.StoreLaunchData("launch", json.RawMessage(`{"sub":"x"}`))
+	if _, err := toolB.LaunchData.FindLaunchData("launch"); err != datastore.ErrLaunchDataNotFound {
+		t.Errorf("got %v for launch data of another namespace, wanted ErrLaunchDataNotFound", err)
+	}
+	if _, err := toolA.LaunchData.FindLaunchData("launch"); err != nil {
+		t.Errorf("find launch data error: %v", err)
+	}
+	if err := toolA.LaunchData.StoreLaunchData("", json.RawMessage(`{}`)); err == nil {
+		t.Error("error not reported for empty launch ID")
+	}
+
+	toolA.Nonces.StoreNonce("nonce", "https://tool.tld/launch")
+	if err := toolB.Nonces.TestAndClearNonce("nonce", "https://tool.tld/launch"); err != datastore.ErrNonceNotFound {
+		t.Errorf("got %v for nonce of another namespace, wanted ErrNonceNotFound", err)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	if err := toolA.Replays.StoreTokenID("jti", expiry); err != nil {
+		t.Errorf("store token ID error: %v", err)
+	}
+	if err := toolB.Replays.StoreTokenID("jti", expiry); err != nil {
+		t.Errorf("got %v for token ID of another namespace", err)
+	}
+
+	token := datastore.AccessToken{
+		TokenURI:   "https://platform.tld/token",
+		ClientID:   "c",
+		Scopes:     []string{"s"},
+		Token:      "t",
+		ExpiryTime: expiry,
+	}
+	toolA.AccessTokens.StoreAccessToken(token)
+	found, err := toolA.AccessTokens.FindAccessToken(token.TokenURI, "c", []string{"s"})
+	if err != nil || found.TokenURI != token.TokenURI {
+		t.Errorf("got %+v, error %v", found, err)
+	}
+	if _, err := toolB.AccessTokens.FindAccessToken(token.TokenURI, "c", []string{"s"}); err == nil {
+		t.Error("found access token of another namespace")
+	}
+}
+
+func TestMigratingRegistrationStore(t *testing.T) {
+	uri, _ := url.Parse("https://domain.tld/endpoint")
+	registration := datastore.Registration{
+		Issuer:        "https://test-issuer",
+		ClientID:      "abcdef123456",
+		AuthTokenURI:  uri,
+		AuthLoginURI:  uri,
+		KeysetURI:     uri,
+		TargetLinkURI: uri,
+	}
+	primary, secondary := New(), New()
+	if err := secondary.StoreRegistration(registration); err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+	secondary.StoreDeployment(registration.Issuer, datastore.Deployment{DeploymentID: "1"})
+	migrating := datastore.NewMigratingRegistrationStore(primary, secondary)
+
+	if _, err := migrating.FindRegistrationByIssuerAndClientID(registration.Issuer, registration.ClientID); err != nil {
+		t.Errorf("registration not found in secondary store: %v", err)
+	}
+	if _, err := migrating.FindDeployment(registration.Issuer, "1"); err != nil {
+		t.Errorf("deployment not found in secondary store: %v", err)
+	}
+
+	added := registration
+	added.ClientID = "added"
+	if err := migrating.StoreRegistration(added); err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+	if _, err := secondary.FindRegistrationByIssuerAndClientID(added.Issuer, added.ClientID); err == nil {
+		t.Error("registration written to secondary store without DualWrite")
+	}
+
+	migrating.DualWrite = true
+	migrating.StoreDeployment(registration.Issuer, datastore.Deployment{DeploymentID: "2"})
+	for _, store := range []*Store{primary, secondary} {
+		if _, err := store.FindDeployment(registration.Issuer, "2"); err != nil {
+			t.Errorf("deployment not written to both stores: %v", err)
+		}
+	}
+
+	primary.StoreRegistration(registration)
+	registrations, err := migrating.ListRegistrations()
+	if err != nil || len(registrations) != 2 {
+		t.Errorf("got registrations %v, error %v, wanted 2 registrations", registrations, err)
+	}
+}
+
+func TestStoreState(t *testing.T) {
+	expiry := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	now := expiry.Add(-time.Minute)
+
+	npStore := New()
+	npStore.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	if err := npStore.StoreState("", "nonce", expiry); err == nil {
+		t.Error("error not reported for empty state")
+	}
+	npStore.StoreState("state", "nonce", expiry)
+	if err := npStore.TestAndClearState("state", "other"); err != datastore.ErrStateNonceMismatch {
+		t.Errorf("got %v for mismatched nonce, wanted ErrStateNonceMismatch", err)
+	}
+	if err := npStore.TestAndClearState("state", "nonce"); err != datastore.ErrStateNotFound {
+		t.Errorf("got %v for cleared state, wanted ErrStateNotFound", err)
+	}
+
+	npStore.StoreState("state", "nonce", expiry)
+	if err := npStore.TestAndClearState("state", "nonce"); err != nil {
+		t.Errorf("got %v for stored state", err)
+	}
+
+	npStore.StoreState("state", "nonce", expiry)
+	now = expiry.Add(time.Minute)
+	if removed := npStore.DeleteExpiredStates(); removed != 1 {
+		t.Errorf("removed %d expired states, wanted 1", removed)
+	}
+	npStore.StoreState("state", "nonce", expiry)
+	if err := npStore.TestAndClearState("state", "nonce"); err != datastore.ErrStateExpired {
+		t.Errorf("got %v for expired state, wanted ErrStateExpired", err)
+	}
+}
+
+func TestSnapshotRestoreAndReset(t *testing.T) {
+	npStore := New()
+	npStore.StoreLaunchData("kept", json.RawMessage(`{}`))
+	npStore.StoreNonce("nonce", "https://tool.tld/launch")
+
+	snapshot := npStore.Snapshot()
+	npStore.StoreLaunchData("added", json.RawMessage(`{}`))
+	npStore.TestAndClearNonce("nonce", "https://tool.tld/launch")
+
+	npStore.Restore(snapshot)
+	if _, err := npStore.FindLaunchData("added"); err == nil {
+		t.Error("launch data stored after snapshot was not removed")
+	}
+	if _, err := npStore.FindLaunchData("kept"); err != nil {
+		t.Errorf("launch data stored before snapshot not restored: %v", err)
+	}
+	if err := npStore.TestAndClearNonce("nonce", "https://tool.tld/launch"); err != nil {
+		t.Errorf("nonce not restored: %v", err)
+	}
+
+	npStore.Reset()
+	if _, err := npStore.FindLaunchData("kept"); err == nil {
+		t.Error("launch data not removed by reset")
+	}
+
+	npStore.Restore(snapshot)
+	if _, err := npStore.FindLaunchData("kept"); err != nil {
+		t.Errorf("snapshot changed by later modifications: %v", err)
+	}
+}
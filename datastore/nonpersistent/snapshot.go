@@ -0,0 +1,86 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package nonpersistent
+
+import "sync"
+
+// A Snapshot holds a copy of the contents of a Store, as taken by Snapshot. It is only meaningful to the Store's
+// Restore method.
+type Snapshot struct {
+	registrations map[interface{}]interface{}
+	deployments   map[interface{}]interface{}
+	nonces        map[interface{}]interface{}
+	launchData    map[interface{}]interface{}
+	accessTokens  map[interface{}]interface{}
+	replays       map[interface{}]interface{}
+	states        map[interface{}]interface{}
+}
+
+// Reset removes all of the contents of the store. The maps are cleared in place, so anything holding them sees the
+// store empty.
+func (s *Store) Reset() {
+	for _, m := range s.maps() {
+		clearMap(m)
+	}
+}
+
+// Snapshot copies the current contents of the store. The stored values themselves are not copied, but none of the
+// store's methods modify a value once it is stored.
+func (s *Store) Snapshot() *Snapshot {
+	return &Snapshot{
+		registrations: copyMap(s.Registrations),
+		deployments:   copyMap(s.Deployments),
+		nonces:        copyMap(s.Nonces),
+		launchData:    copyMap(s.LaunchData),
+		accessTokens:  copyMap(s.AccessTokens),
+		replays:       copyMap(s.Replays),
+		states:        copyMap(s.States),
+	}
+}
+
+// Restore replaces the contents of the store with those of the snapshot.
+func (s *Store) Restore(snapshot *Snapshot) {
+	restoreMap(s.Registrations, snapshot.registrations)
+	restoreMap(s.Deployments, snapshot.deployments)
+	restoreMap(s.Nonces, snapshot.nonces)
+	restoreMap(s.LaunchData, snapshot.launchData)
+	restoreMap(s.AccessTokens, snapshot.accessTokens)
+	restoreMap(s.Replays, snapshot.replays)
+	restoreMap(s.States, snapshot.states)
+}
+
+// maps returns the maps of the store.
+func (s *Store) maps() []*sync.Map {
+	return []*sync.Map{s.Registrations, s.Deployments, s.Nonces, s.LaunchData, s.AccessTokens, s.Replays,
+		s.States}
+}
+
+// clearMap deletes every entry of the map.
+func clearMap(m *sync.Map) {
+	m.Range(func(key, _ interface{}) bool {
+		m.Delete(key)
+		return true
+	})
+}
+
+// copyMap returns the entries of the map.
+func copyMap(m *sync.Map) map[interface{}]interface{} {
+	entries := map[interface{}]interface{}{}
+	m.Range(func(key, value interface{}) bool {
+		entries[key] = value
+		return true
+	})
+
+	return entries
+}
+
+// restoreMap replaces the entries of the map.
+func restoreMap(m *sync.Map, entries map[interface{}]interface{}) {
+	clearMap(m)
+	for key, value := range entries {
+		m.Store(key, value)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultRegistrationCacheTTL is the time for which a RegistrationCache keeps a registration or deployment when no
+// TTL is given.
+const DefaultRegistrationCacheTTL = 5 * time.Minute
+
+// A RegistrationVersioner reports a version of its stored registrations and deployments, which changes whenever any
+// of them changes. The version is opaque; it is only compared with earlier versions.
+type RegistrationVersioner interface {
+	RegistrationVersion() (string, error)
+}
+
+// A RegistrationCache is a RegistrationStorer that keeps the registrations and deployments found in an underlying
+// store in memory, for at most TTL, to spare the underlying store a lookup on every login and launch. Each caller gets
+// its own copy of a cached registration's URLs, so that changes made to them by one caller are not seen by others.
+//
+// Changes made through the cache invalidate the affected entries. In a clustered deployment, changes made on other
+// nodes are picked up when the entries expire or earlier, by one of:
+//
+// Invalidate, called, e.g., by a listener for a PostgreSQL NOTIFY sent by a trigger on the registration tables.
+//
+// StartPolling, which periodically compares the underlying store's RegistrationVersion, if it is a
+// RegistrationVersioner, and invalidates the cache when it changes.
+type RegistrationCache struct {
+	RegistrationStorer
+	TTL   time.Duration
+	Clock Clock
+
+	mu            sync.Mutex
+	registrations map[string]cachedRegistration
+	deployments   map[string]cachedDeployment
+	version       string
+}
+
+type cachedRegistration struct {
+	registration Registration
+	expiry       time.Time
+}
+
+type cachedDeployment struct {
+	deployment Deployment
+	expiry     time.Time
+}
+
+// NewRegistrationCache returns a RegistrationCache for the store. A TTL of zero or less selects
+// DefaultRegistrationCacheTTL.
+func NewRegistrationCache(store RegistrationStorer, ttl time.Duration) *RegistrationCache {
+	if ttl <= 0 {
+		ttl = DefaultRegistrationCacheTTL
+	}
+
+	return &RegistrationCache{
+		RegistrationStorer: store,
+		TTL:                ttl,
+		registrations:      map[string]cachedRegistration{},
+		deployments:        map[string]cachedDeployment{},
+	}
+}
+
+// StoreRegistration stores the registration in the underlying store and invalidates the cached registrations of its
+// issuer.
+func (c *RegistrationCache) StoreRegistration(reg Registration) error {
+	err := c.RegistrationStorer.StoreRegistration(reg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A lookup by issuer alone may have been answered with another registration of the issuer, so all of them go.
+	for key, cached := range c.registrations {
+		if cached.registration.Issuer == reg.Issuer {
+			delete(c.registrations, key)
+		}
+	}
+
+	return err
+}
+
+// FindRegistrationByIssuerAndClientID returns the cached registration, or finds it in the underlying store and caches
+// it. Registrations that are not found are not cached.
+func (c *RegistrationCache) FindRegistrationByIssuerAndClientID(issuer, clientID string) (Registration, error) {
+	key := cacheKey(issuer, clientID)
+	now := Now(c.Clock)
+
+	c.mu.Lock()
+	cached, ok := c.registrations[key]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expiry) {
+		return copyRegistration(cached.registration), nil
+	}
+
+	reg, err := c.RegistrationStorer.FindRegistrationByIssuerAndClientID(issuer, clientID)
+	if err != nil {
+		return Registration{}, err
+	}
+
+	c.mu.Lock()
+	c.registrations[key] = cachedRegistration{registration: copyRegistration(reg), expiry: now.Add(c.TTL)}
+	c.mu.Unlock()
+
+	return reg, nil
+}
+
+// StoreDeployment stores the deployment in the underlying store and invalidates its cached entry.
+func (c *RegistrationCache) StoreDeployment(issuer string, deployment Deployment) error {
+	err := c.RegistrationStorer.StoreDeployment(issuer, deployment)
+
+	c.mu.Lock()
+	delete(c.deployments, cacheKey(issuer, deployment.DeploymentID))
+	c.mu.Unlock()
+
+	return err
+}
+
+// FindDeployment returns the cached deployment, or finds it in the underlying store and caches it. Deployments that
+// are not found are not cached.
+func (c *RegistrationCache) FindDeployment(issuer, deploymentID string) (Deployment, error) {
+	key := cacheKey(issuer, deploymentID)
+	now := Now(c.Clock)
+
+	c.mu.Lock()
+	cached, ok := c.deployments[key]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expiry) {
+		return cached.deployment, nil
+	}
+
+	deployment, err := c.RegistrationStorer.FindDeployment(issuer, deploymentID)
+	if err != nil {
+		return Deployment{}, err
+	}
+
+	c.mu.Lock()
+	c.deployments[key] = cachedDeployment{deployment: deployment, expiry: now.Add(c.TTL)}
+	c.mu.Unlock()
+
+	return deployment, nil
+}
+
+// Invalidate removes every cached registration and deployment.
+func (c *RegistrationCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.registrations = map[string]cachedRegistration{}
+	c.deployments = map[string]cachedDeployment{}
+}
+
+// Refresh invalidates the cache if the underlying store's registration version has changed since the previous
+// refresh. If the underlying store is not a RegistrationVersioner, the cache is invalidated unconditionally.
+func (c *RegistrationCache) Refresh() error {
+	versioner, ok := c.RegistrationStorer.(RegistrationVersioner)
+	if !ok {
+		c.Invalidate()
+		return nil
+	}
+
+	version, err := versioner.RegistrationVersion()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	changed := version != c.version
+	c.version = version
+	c.mu.Unlock()
+	if changed {
+		c.Invalidate()
+	}
+
+	return nil
+}
+
+// StartPolling calls Refresh every interval until the returned Sweeper is closed. If onError is non-nil, it receives
+// any error returned by Refresh.
+func (c *RegistrationCache) StartPolling(interval time.Duration, onError func(error)) *Sweeper {
+	return StartSweeper(interval, c.Refresh, onError)
+}
+
+// copyRegistration returns a copy of the registration that shares none of its URLs.
+func copyRegistration(reg Registration) Registration {
+	for _, uri := range []**url.URL{&reg.AuthTokenURI, &reg.AuthLoginURI, &reg.KeysetURI, &reg.TargetLinkURI} {
+		if *uri != nil {
+			copied := **uri
+			*uri = &copied
+		}
+	}
+
+	return reg
+}
+
+// cacheKey joins the parts of a cache key.
+func cacheKey(first, second string) string {
+	return first + "\x00" + second
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingStore is a RegistrationStorer holding one registration and one deployment that counts its lookups.
+type countingStore struct {
+	registration Registration
+	lookups      int
+	version      string
+}
+
+func (s *countingStore) StoreRegistration(reg Registration) error {
+	s.registration = reg
+	return nil
+}
+
+func (s *countingStore) FindRegistrationByIssuerAndClientID(issuer, clientID string) (Registration, error) {
+	s.lookups++
+	if issuer != s.registration.Issuer {
+		return Registration{}, ErrRegistrationNotFound
+	}
+	return s.registration, nil
+}
+
+func (s *countingStore) StoreDeployment(issuer string, deployment Deployment) error {
+	return nil
+}
+
+func (s *countingStore) FindDeployment(issuer, deploymentID string) (Deployment, error) {
+	s.lookups++
+	return Deployment{DeploymentID: deploymentID}, nil
+}
+
+func (s *countingStore) RegistrationVersion() (string, error) {
+	return s.version, nil
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func TestRegistrationCache(t *testing.T) {
+	store := &countingStore{registration: Registration{Issuer: "a", ClientID: "b"}, version: "1"}
+	clock := &fixedClock{now: time.Unix(0, 0)}
+	cache := NewRegistrationCache(store, time.Minute)
+	cache.Clock = clock
+
+	find := func() {
+		t.Helper()
+		if _, err := cache.FindRegistrationByIssuerAndClientID("a", "b"); err != nil {
+			t.Fatalf("find registration error: %v", err)
+		}
+	}
+	expectLookups := func(expected int) {
+		t.Helper()
+		if store.lookups != expected {
+			t.Errorf("got %d lookups, wanted %d", store.lookups, expected)
+		}
+	}
+
+	find()
+	find()
+	expectLookups(1)
+
+	if _, err := cache.FindRegistrationByIssuerAndClientID("missing", ""); err != ErrRegistrationNotFound {
+		t.Errorf("got %v, wanted ErrRegistrationNotFound", err)
+	}
+	cache.FindRegistrationByIssuerAndClientID("missing", "")
+	expectLookups(3)
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	find()
+	expectLookups(4)
+
+	cache.StoreRegistration(Registration{Issuer: "a", ClientID: "b", KeyID: "new"})
+	reg, _ := cache.FindRegistrationByIssuerAndClientID("a", "b")
+	if reg.KeyID != "new" {
+		t.Errorf("got stale registration %+v", reg)
+	}
+	expectLookups(5)
+
+	cache.FindDeployment("a", "1")
+	cache.FindDeployment("a", "1")
+	expectLookups(6)
+
+	// The first refresh records the version; later ones invalidate only when it changes.
+	cache.Refresh()
+	find()
+	expectLookups(7)
+	cache.Refresh()
+	find()
+	expectLookups(7)
+	store.version = "2"
+	cache.Refresh()
+	find()
+	cache.FindDeployment("a", "1")
+	expectLookups(9)
+}
+
+func TestRegistrationCacheCopiesURLs(t *testing.T) {
+	authLoginURI, _ := url.Parse("https://platform.tld/auth")
+	store := &countingStore{registration: Registration{Issuer: "a", ClientID: "b", AuthLoginURI: authLoginURI}}
+	cache := NewRegistrationCache(store, time.Minute)
+	if _, err := cache.FindRegistrationByIssuerAndClientID("a", "b"); err != nil {
+		t.Fatalf("find registration error: %v", err)
+	}
+
+	// Callers changing the URLs of the registrations they get, concurrently, must not change the cached registration;
+	// run with -race to detect URLs shared between them.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reg, err := cache.FindRegistrationByIssuerAndClientID("a", "b")
+			if err != nil {
+				t.Errorf("find registration error: %v", err)
+				return
+			}
+			reg.AuthLoginURI.RawQuery = fmt.Sprintf("state=%d", i)
+		}(i)
+	}
+	wg.Wait()
+
+	reg, _ := cache.FindRegistrationByIssuerAndClientID("a", "b")
+	if reg.AuthLoginURI.String() != "https://platform.tld/auth" || reg.AuthTokenURI != nil {
+		t.Errorf("got cached registration with %v and %v", reg.AuthLoginURI, reg.AuthTokenURI)
+	}
+}
@@ -3,15 +3,20 @@
 // This source code is licensed under the MIT-style license found in
 // the LICENSE file in the root directory of this source tree.
 
-// Package sql implements a persistent SQL data store. It implements the RegistrationStorer interface.
+// Package sql implements a persistent SQL data store. It implements the RegistrationStorer, AccessTokenStorer and
+// ReplayStorer interfaces.
 package sql
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/macewan-cs/lti/datastore"
 )
@@ -24,6 +29,15 @@ type RegistrationFields struct {
 	AuthLoginURI  string
 	KeysetURI     string
 	TargetLinkURI string
+
+	// KeyID and PrivateKey are optional. When they are empty, the tool's signing identity is not stored with the
+	// registration.
+	KeyID      string
+	PrivateKey string
+
+	// UpdatedAt is optional. It names a column that the database sets to the time of each insert or update of a
+	// registration, e.g., by a default and a trigger. It is required by RegistrationVersion.
+	UpdatedAt string
 }
 
 // DeploymentFields provides the database column names for fields in the datastore.Deployment structure.
@@ -32,20 +46,45 @@ type DeploymentFields struct {
 	DeploymentID string
 }
 
-// Config represents the table and field names necessary for storing/retrieving registrations and deployments within the
-// database.
+// AccessTokenFields provides the database column names for fields in the datastore.AccessToken structure.
+type AccessTokenFields struct {
+	TokenURI   string
+	ClientID   string
+	Scopes     string
+	Token      string
+	ExpiryTime string
+}
+
+// ReplayFields provides the database column names for the token IDs recorded by StoreTokenID.
+type ReplayFields struct {
+	TokenID    string
+	ExpiryTime string
+}
+
+// Config represents the table and field names necessary for storing/retrieving registrations, deployments, access
+// tokens and used token IDs within the database.
+//
+// The replay table should have a primary key or unique constraint on its token ID column, so that concurrent uses of
+// a token across several tool instances cannot both be recorded; the use whose record violates it is reported as a
+// replay.
 type Config struct {
 	RegistrationTable  string
 	RegistrationFields RegistrationFields
 	DeploymentTable    string
 	DeploymentFields   DeploymentFields
+	AccessTokenTable   string
+	AccessTokenFields  AccessTokenFields
+	ReplayTable        string
+	ReplayFields       ReplayFields
 }
 
 type registrationIdentifiers struct {
-	table    string
-	fields   string
-	issuer   string
-	clientID string
+	table     string
+	fields    string
+	issuer    string
+	clientID  string
+	hasKey    bool
+	updatedAt string
 }
 
 type deploymentIdentifiers struct {
@@ -54,12 +93,32 @@ type deploymentIdentifiers struct {
 	deploymentID string
 }
 
+type accessTokenIdentifiers struct {
+	table      string
+	fields     string
+	tokenURI   string
+	clientID   string
+	scopes     string
+	expiryTime string
+}
+
+type replayIdentifiers struct {
+	table      string
+	tokenID    string
+	expiryTime string
+}
+
 // Store implements a persistent SQL-based datastore.
 type Store struct {
 	*sql.DB
 
+	// Clock is the time source used to check expiry times; when nil, the system clock is used.
+	Clock datastore.Clock
+
 	registration registrationIdentifiers
 	deployment   deploymentIdentifiers
+	accessToken  accessTokenIdentifiers
+	replay       replayIdentifiers
 }
 
 // NewConfig returns a new configuration struct with default table and field names for the SQL database.
@@ -79,71 +138,173 @@ func NewConfig() Config {
 			Issuer:       "issuer",
 			DeploymentID: "deployment_id",
 		},
+		AccessTokenTable: "access_token",
+		AccessTokenFields: AccessTokenFields{
+			TokenURI:   "token_uri",
+			ClientID:   "client_id",
+			Scopes:     "scopes",
+			Token:      "token",
+			ExpiryTime: "expiry_time",
+		},
+		ReplayTable: "token_replay",
+		ReplayFields: ReplayFields{
+			TokenID:    "token_id",
+			ExpiryTime: "expiry_time",
+		},
 	}
 }
 
 // New returns a Store that satisifes the datastore.RegistrationStorer and datastore.DeploymentStorer interfaces.
 func New(database *sql.DB, config Config) *Store {
+	// The strings must be joined in this order to match their use with in the SQL queries.
+	registrationFields := []string{
+		config.RegistrationFields.Issuer,
+		config.RegistrationFields.ClientID,
+		config.RegistrationFields.AuthTokenURI,
+		config.RegistrationFields.AuthLoginURI,
+		config.RegistrationFields.KeysetURI,
+		config.RegistrationFields.TargetLinkURI,
+	}
+	hasKey := config.RegistrationFields.KeyID != "" && config.RegistrationFields.PrivateKey != ""
+	if hasKey {
+		registrationFields = append(registrationFields, config.RegistrationFields.KeyID,
+			config.RegistrationFields.PrivateKey)
+	}
+
 	return &Store{
 		DB: database,
 		registration: registrationIdentifiers{
-			table: config.RegistrationTable,
-			fields: strings.Join([]string{
-				// The strings must be joined in this order to
-				// match their use with in the SQL queries.
-				config.RegistrationFields.Issuer,
-				config.RegistrationFields.ClientID,
-				config.RegistrationFields.AuthTokenURI,
-				config.RegistrationFields.AuthLoginURI,
-				config.RegistrationFields.KeysetURI,
-				config.RegistrationFields.TargetLinkURI,
-			}, ","),
-			issuer:   config.RegistrationFields.Issuer,
-			clientID: config.RegistrationFields.ClientID,
+			table:     config.RegistrationTable,
+			fields:    strings.Join(registrationFields, ","),
+			issuer:    config.RegistrationFields.Issuer,
+			clientID:  config.RegistrationFields.ClientID,
+			hasKey:    hasKey,
+			updatedAt: config.RegistrationFields.UpdatedAt,
 		},
 		deployment: deploymentIdentifiers{
 			table:        config.DeploymentTable,
 			issuer:       config.DeploymentFields.Issuer,
 			deploymentID: config.DeploymentFields.DeploymentID,
 		},
+		accessToken: accessTokenIdentifiers{
+			table: config.AccessTokenTable,
+			fields: strings.Join([]string{
+				// The strings must be joined in this order to
+				// match their use with in the SQL queries.
+				config.AccessTokenFields.TokenURI,
+				config.AccessTokenFields.ClientID,
+				config.AccessTokenFields.Scopes,
+				config.AccessTokenFields.Token,
+				config.AccessTokenFields.ExpiryTime,
+			}, ","),
+			tokenURI:   config.AccessTokenFields.TokenURI,
+			clientID:   config.AccessTokenFields.ClientID,
+			scopes:     config.AccessTokenFields.Scopes,
+			expiryTime: config.AccessTokenFields.ExpiryTime,
+		},
+		replay: replayIdentifiers{
+			table:      config.ReplayTable,
+			tokenID:    config.ReplayFields.TokenID,
+			expiryTime: config.ReplayFields.ExpiryTime,
+		},
 	}
 }
 
 // StoreRegistration stores a registration in the SQL database.
 func (s *Store) StoreRegistration(reg datastore.Registration) error {
+	if err := datastore.ValidateRegistration(reg); err != nil {
+		return fmt.Errorf("received invalid registration: %w", err)
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		return s.insertRegistration(tx, reg)
+	})
+}
+
+// StoreRegistrationWithDeployments stores a registration and its deployments in a single transaction, so that an
+// onboarding that fails part way does not leave the registration without its deployments. Nothing is stored if the
+// registration or any of the deployments is invalid or cannot be stored.
+func (s *Store) StoreRegistrationWithDeployments(reg datastore.Registration, deployments []datastore.Deployment) error {
+	if err := datastore.ValidateRegistration(reg); err != nil {
+		return fmt.Errorf("received invalid registration: %w", err)
+	}
+	for _, d := range deployments {
+		if err := datastore.ValidateDeploymentID(d.DeploymentID); err != nil {
+			return fmt.Errorf("received invalid deployment ID: %w", err)
+		}
+	}
+
+	return s.inTransaction(func(tx *sql.Tx) error {
+		if err := s.insertRegistration(tx, reg); err != nil {
+			return err
+		}
+		for _, d := range deployments {
+			if err := s.insertDeployment(tx, reg.Issuer, d); err != nil {
+				return fmt.Errorf("could not store deployment %s: %w", d.DeploymentID, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// inTransaction runs f in a transaction, which is committed if f succeeds and rolled back otherwise.
+func (s *Store) inTransaction(f func(tx *sql.Tx) error) error {
 	tx, err := s.DB.Begin()
 	if err != nil {
 		return err
 	}
 
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertRegistration inserts a registration within the transaction.
+func (s *Store) insertRegistration(tx *sql.Tx, reg datastore.Registration) error {
 	authTokenURI := reg.AuthTokenURI.String()
 	authLoginURI := reg.AuthLoginURI.String()
 	keysetURI := reg.KeysetURI.String()
 	targetLinkURI := reg.TargetLinkURI.String()
 
+	values := `$1, $2, $3, $4, $5, $6`
+	qArgs := []interface{}{reg.Issuer, reg.ClientID, authTokenURI, authLoginURI, keysetURI, targetLinkURI}
+	if s.registration.hasKey {
+		values += `, $7, $8`
+		qArgs = append(qArgs, reg.KeyID, reg.PrivateKey)
+	}
+
 	q := `INSERT INTO ` + s.registration.table + ` (` + s.registration.fields + `)
-                   VALUES ($1, $2, $3, $4, $5, $6)`
-	result, err := tx.Exec(q, reg.Issuer, reg.ClientID, authTokenURI, authLoginURI,
-		keysetURI, targetLinkURI)
+                   VALUES (` + values + `)`
+
+	return execOne(tx, q, qArgs...)
+}
+
+// insertDeployment inserts a deployment within the transaction.
+func (s *Store) insertDeployment(tx *sql.Tx, issuer string, d datastore.Deployment) error {
+	q := `INSERT INTO ` + s.deployment.table + ` (` + s.deployment.issuer + `,` + s.deployment.deploymentID + `)
+                   VALUES ($1, $2)`
+
+	return execOne(tx, q, issuer, d.DeploymentID)
+}
+
+// execOne executes a statement that must affect exactly one row.
+func execOne(tx *sql.Tx, q string, qArgs ...interface{}) error {
+	result, err := tx.Exec(q, qArgs...)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-
 	if rowsAffected != 1 {
-		tx.Rollback()
-		return err
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return err
+		return fmt.Errorf("statement affected %d rows, expected 1", rowsAffected)
 	}
 
 	return nil
@@ -169,16 +330,56 @@ func (s *Store) FindRegistrationByIssuerAndClientID(issuer, clientID string) (da
 		qArgs = append(qArgs, clientID)
 	}
 
+	reg, err := s.scanRegistration(s.DB.QueryRow(q, qArgs...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return datastore.Registration{}, datastore.ErrRegistrationNotFound
+		}
+		return datastore.Registration{}, err
+	}
+
+	return reg, nil
+}
+
+// ListRegistrations retrieves every registration from the SQL database, ordered by issuer and client ID.
+func (s *Store) ListRegistrations() ([]datastore.Registration, error) {
+	q := `SELECT ` + s.registration.fields + `
+                FROM ` + s.registration.table + `
+            ORDER BY ` + s.registration.issuer + `, ` + s.registration.clientID
+
+	rows, err := s.DB.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var registrations []datastore.Registration
+	for rows.Next() {
+		reg, err := s.scanRegistration(rows)
+		if err != nil {
+			return nil, err
+		}
+		registrations = append(registrations, reg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+// scanRegistration scans a row holding the registration fields.
+func (s *Store) scanRegistration(row interface{ Scan(...interface{}) error }) (datastore.Registration, error) {
 	var (
 		reg                                                  datastore.Registration
 		authTokenURI, authLoginURI, keysetURI, targetLinkURI string
 	)
-	err := s.DB.QueryRow(q, qArgs...).Scan(&reg.Issuer, &reg.ClientID, &authTokenURI, &authLoginURI,
-		&keysetURI, &targetLinkURI)
+	dest := []interface{}{&reg.Issuer, &reg.ClientID, &authTokenURI, &authLoginURI, &keysetURI, &targetLinkURI}
+	if s.registration.hasKey {
+		dest = append(dest, &reg.KeyID, &reg.PrivateKey)
+	}
+	err := row.Scan(dest...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return datastore.Registration{}, datastore.ErrRegistrationNotFound
-		}
 		return datastore.Registration{}, err
 	}
 
@@ -211,26 +412,153 @@ func (s *Store) StoreDeployment(issuer string, d datastore.Deployment) error {
 		return fmt.Errorf("received invalid deployment ID: %v", err)
 	}
 
-	tx, err := s.DB.Begin()
+	return s.inTransaction(func(tx *sql.Tx) error {
+		return s.insertDeployment(tx, issuer, d)
+	})
+}
+
+// FindDeployment looks up and returns either a Deployment by the issuer and deployment ID or the datastore error
+// ErrDeploymentNotFound.
+func (s *Store) FindDeployment(issuer, deploymentID string) (datastore.Deployment, error) {
+	if issuer == "" {
+		return datastore.Deployment{}, errors.New("received empty issuer argument")
+	}
+	if err := datastore.ValidateDeploymentID(deploymentID); err != nil {
+		return datastore.Deployment{}, fmt.Errorf("received invalid deployment ID: %v", err)
+	}
+
+	q := `SELECT ` + s.deployment.deploymentID + `
+                FROM ` + s.deployment.table + `
+               WHERE ` + s.deployment.issuer + ` = $1
+                 AND ` + s.deployment.deploymentID + ` = $2`
+	deployment := datastore.Deployment{}
+	err := s.DB.QueryRow(q, issuer, deploymentID).Scan(&deployment.DeploymentID)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return datastore.Deployment{}, datastore.ErrRegistrationNotFound
+		}
+		return datastore.Deployment{}, err
 	}
 
-	q := `INSERT INTO ` + s.deployment.table + ` (` + s.deployment.issuer + `,` + s.deployment.deploymentID + `)
-                   VALUES ($1, $2)`
-	result, err := tx.Exec(q, issuer, d.DeploymentID)
+	return deployment, nil
+}
+
+// RegistrationVersion returns a version of the stored registrations and deployments for a
+// datastore.RegistrationCache. It is a digest of the issuer, client ID and update time of every registration and of
+// every deployment, so it requires the UpdatedAt registration field to be configured. The digest is computed from the
+// rows rather than with aggregate functions, whose support varies between databases.
+func (s *Store) RegistrationVersion() (string, error) {
+	if s.registration.updatedAt == "" {
+		return "", errors.New("registration updated at field not configured")
+	}
+
+	q := `SELECT ` + s.registration.issuer + `, ` + s.registration.clientID + `, ` + s.registration.updatedAt + `
+                FROM ` + s.registration.table
+	registrations, err := s.versionRows(q, 3)
+	if err != nil {
+		return "", fmt.Errorf("could not query registration version: %w", err)
+	}
+	q = `SELECT ` + s.deployment.issuer + `, ` + s.deployment.deploymentID + `
+               FROM ` + s.deployment.table
+	deployments, err := s.versionRows(q, 2)
+	if err != nil {
+		return "", fmt.Errorf("could not query deployment version: %w", err)
+	}
+
+	h := sha256.New()
+	for _, rows := range [][]string{registrations, deployments} {
+		fmt.Fprintf(h, "%d\n", len(rows))
+		for _, row := range rows {
+			fmt.Fprintf(h, "%q\n", row)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// versionRows returns the rows of a query selecting columns columns, each formatted as a string, in sorted order.
+func (s *Store) versionRows(q string, columns int) ([]string, error) {
+	rows, err := s.DB.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var formatted []string
+	values := make([]interface{}, columns)
+	dest := make([]interface{}, columns)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		fields := make([]string, columns)
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			fields[i] = fmt.Sprint(value)
+		}
+		formatted = append(formatted, strings.Join(fields, "\x00"))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(formatted)
+
+	return formatted, nil
+}
+
+// joinScopes returns the scopes in a canonical (sorted, space-separated) form so that a token can be found regardless of
+// the order in which its scopes were requested.
+func joinScopes(scopes []string) string {
+	sortedScopes := append([]string{}, scopes...)
+	sort.Strings(sortedScopes)
+
+	return strings.Join(sortedScopes, " ")
+}
+
+// StoreAccessToken stores an access token in the SQL database, replacing any previously-stored token for the same
+// token URI, client ID, and scopes.
+func (s *Store) StoreAccessToken(token datastore.AccessToken) error {
+	if token.TokenURI == "" {
+		return errors.New("received empty tokenURI")
+	}
+	if token.ClientID == "" {
+		return errors.New("received empty clientID")
+	}
+	if len(token.Scopes) == 0 {
+		return errors.New("received empty scopes")
+	}
+	if token.Token == "" {
+		return errors.New("received empty accessToken")
+	}
+	if token.ExpiryTime.IsZero() {
+		return errors.New("received empty expiry time")
+	}
+	scopes := joinScopes(token.Scopes)
+
+	tx, err := s.DB.Begin()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	q := `DELETE FROM ` + s.accessToken.table + `
+               WHERE ` + s.accessToken.tokenURI + ` = $1
+                 AND ` + s.accessToken.clientID + ` = $2
+                 AND ` + s.accessToken.scopes + ` = $3`
+	_, err = tx.Exec(q, token.TokenURI, token.ClientID, scopes)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	if rowsAffected != 1 {
+	q = `INSERT INTO ` + s.accessToken.table + ` (` + s.accessToken.fields + `)
+                  VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.Exec(q, token.TokenURI, token.ClientID, scopes, token.Token, token.ExpiryTime.UTC())
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -243,28 +571,166 @@ func (s *Store) StoreDeployment(issuer string, d datastore.Deployment) error {
 	return nil
 }
 
-// FindDeployment looks up and returns either a Deployment by the issuer and deployment ID or the datastore error
-// ErrDeploymentNotFound.
-func (s *Store) FindDeployment(issuer, deploymentID string) (datastore.Deployment, error) {
-	if issuer == "" {
-		return datastore.Deployment{}, errors.New("received empty issuer argument")
+// FindAccessToken retrieves an access token from the SQL database. It returns datastore.ErrAccessTokenNotFound if no
+// token is stored and datastore.ErrAccessTokenExpired if the stored token has expired.
+func (s *Store) FindAccessToken(tokenURI, clientID string, scopes []string) (datastore.AccessToken, error) {
+	if tokenURI == "" {
+		return datastore.AccessToken{}, errors.New("received empty tokenURI")
 	}
-	if err := datastore.ValidateDeploymentID(deploymentID); err != nil {
-		return datastore.Deployment{}, fmt.Errorf("received invalid deployment ID: %v", err)
+	if clientID == "" {
+		return datastore.AccessToken{}, errors.New("received empty clientID")
+	}
+	if len(scopes) == 0 {
+		return datastore.AccessToken{}, errors.New("received empty scopes")
 	}
 
-	q := `SELECT ` + s.deployment.deploymentID + `
-                FROM ` + s.deployment.table + `
-               WHERE ` + s.deployment.issuer + ` = $1
-                 AND ` + s.deployment.deploymentID + ` = $2`
-	deployment := datastore.Deployment{}
-	err := s.DB.QueryRow(q, issuer, deploymentID).Scan(&deployment.DeploymentID)
+	q := `SELECT ` + s.accessToken.fields + `
+                FROM ` + s.accessToken.table + `
+               WHERE ` + s.accessToken.tokenURI + ` = $1
+                 AND ` + s.accessToken.clientID + ` = $2
+                 AND ` + s.accessToken.scopes + ` = $3`
+	var (
+		token        datastore.AccessToken
+		storedScopes string
+	)
+	err := s.DB.QueryRow(q, tokenURI, clientID, joinScopes(scopes)).Scan(&token.TokenURI, &token.ClientID,
+		&storedScopes, &token.Token, &token.ExpiryTime)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return datastore.Deployment{}, datastore.ErrRegistrationNotFound
+			return datastore.AccessToken{}, datastore.ErrAccessTokenNotFound
 		}
-		return datastore.Deployment{}, err
+		return datastore.AccessToken{}, err
 	}
+	token.Scopes = strings.Fields(storedScopes)
 
-	return deployment, nil
+	if token.ExpiryTime.Before(datastore.Now(s.Clock)) {
+		return datastore.AccessToken{}, datastore.ErrAccessTokenExpired
+	}
+
+	return token, nil
+}
+
+// DeleteExpiredAccessTokens removes all expired access tokens from the SQL database. It returns the number of tokens
+// removed.
+func (s *Store) DeleteExpiredAccessTokens() (int, error) {
+	q := `DELETE FROM ` + s.accessToken.table + `
+               WHERE ` + s.accessToken.expiryTime + ` < $1`
+	result, err := s.DB.Exec(q, datastore.Now(s.Clock).UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rowsAffected), nil
+}
+
+// StartAccessTokenSweeper starts removing expired access tokens from the SQL database every interval. Errors are passed
+// to onError, which may be nil. The returned sweeper must be closed to stop the background removal.
+func (s *Store) StartAccessTokenSweeper(interval time.Duration, onError func(error)) *datastore.Sweeper {
+	return datastore.StartSweeper(interval, func() error {
+		_, err := s.DeleteExpiredAccessTokens()
+		return err
+	}, onError)
+}
+
+// StoreTokenID records a used token ID in the SQL database until its expiry. It returns datastore.ErrTokenReplayed if
+// the token ID is already recorded and has not expired.
+func (s *Store) StoreTokenID(tokenID string, expiry time.Time) error {
+	if tokenID == "" {
+		return errors.New("received empty tokenID argument")
+	}
+
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		// An expired record of the token ID does not prevent its use.
+		q := `DELETE FROM ` + s.replay.table + `
+                       WHERE ` + s.replay.tokenID + ` = $1
+                         AND ` + s.replay.expiryTime + ` < $2`
+		_, err := tx.Exec(q, tokenID, datastore.Now(s.Clock).UTC())
+		if err != nil {
+			return err
+		}
+
+		q = `SELECT ` + s.replay.tokenID + `
+                       FROM ` + s.replay.table + `
+                      WHERE ` + s.replay.tokenID + ` = $1`
+		var recorded string
+		err = tx.QueryRow(q, tokenID).Scan(&recorded)
+		if err == nil {
+			return datastore.ErrTokenReplayed
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		q = `INSERT INTO ` + s.replay.table + ` (` + s.replay.tokenID + `,` + s.replay.expiryTime + `)
+                          VALUES ($1, $2)`
+		return execOne(tx, q, tokenID, expiry.UTC())
+	})
+	if err != nil && err != datastore.ErrTokenReplayed {
+		// A concurrent use of the token, e.g., on another instance, may record it between the lookup and the insert,
+		// which then violates the table's unique constraint. The token ID is then found recorded, and the use is a
+		// replay rather than a failure.
+		if recorded, lookupErr := s.tokenIDRecorded(tokenID); lookupErr == nil && recorded {
+			return datastore.ErrTokenReplayed
+		}
+		return fmt.Errorf("could not store token ID: %w", err)
+	}
+
+	return err
+}
+
+// tokenIDRecorded reports whether the token ID is recorded in the SQL database and has not expired.
+func (s *Store) tokenIDRecorded(tokenID string) (bool, error) {
+	q := `SELECT ` + s.replay.expiryTime + `
+                FROM ` + s.replay.table + `
+               WHERE ` + s.replay.tokenID + ` = $1`
+	rows, err := s.DB.Query(q, tokenID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	now := datastore.Now(s.Clock)
+	for rows.Next() {
+		var expiry time.Time
+		if err := rows.Scan(&expiry); err != nil {
+			return false, err
+		}
+		if !expiry.Before(now) {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// DeleteExpiredTokenIDs removes all expired token IDs from the SQL database. It returns the number of token IDs
+// removed.
+func (s *Store) DeleteExpiredTokenIDs() (int, error) {
+	q := `DELETE FROM ` + s.replay.table + `
+               WHERE ` + s.replay.expiryTime + ` < $1`
+	result, err := s.DB.Exec(q, datastore.Now(s.Clock).UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rowsAffected), nil
+}
+
+// StartReplaySweeper starts removing expired token IDs from the SQL database every interval. Errors are passed to
+// onError, which may be nil. The returned sweeper must be closed to stop the background removal.
+func (s *Store) StartReplaySweeper(interval time.Duration, onError func(error)) *datastore.Sweeper {
+	return datastore.StartSweeper(interval, func() error {
+		_, err := s.DeleteExpiredTokenIDs()
+		return err
+	}, onError)
 }
@@ -6,10 +6,17 @@
 package sql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"net/url"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/macewan-cs/lti/datastore"
 	_ "github.com/mlhoyt/ramsql/driver"
@@ -32,6 +39,19 @@ func TestNewConfig(t *testing.T) {
 			Issuer:       "issuer",
 			DeploymentID: "deployment_id",
 		},
+		AccessTokenTable: "access_token",
+		AccessTokenFields: AccessTokenFields{
+			TokenURI:   "token_uri",
+			ClientID:   "client_id",
+			Scopes:     "scopes",
+			Token:      "token",
+			ExpiryTime: "expiry_time",
+		},
+		ReplayTable: "token_replay",
+		ReplayFields: ReplayFields{
+			TokenID:    "token_id",
+			ExpiryTime: "expiry_time",
+		},
 	}
 
 	if !reflect.DeepEqual(actualConfig, expectedConfig) {
@@ -58,7 +78,9 @@ func TestNew(t *testing.T) {
 		len(actualStore.registration.issuer) == 0 ||
 		len(actualStore.deployment.table) == 0 ||
 		len(actualStore.deployment.issuer) == 0 ||
-		len(actualStore.deployment.deploymentID) == 0 {
+		len(actualStore.deployment.deploymentID) == 0 ||
+		len(actualStore.accessToken.table) == 0 ||
+		len(actualStore.accessToken.fields) == 0 {
 		t.Error("one or more fields were unset in the Store")
 	}
 }
@@ -164,6 +186,51 @@ func TestStoreAndFindRegistrationByIssuerAndClientID(t *testing.T) {
 	}
 }
 
+func TestListRegistrations(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestListRegistrations")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE registration (
+                           issuer text,
+                           client_id text,
+                           auth_token_uri text,
+                           auth_login_uri text,
+                           keyset_uri text,
+                           target_link_uri text,
+                           PRIMARY KEY (issuer, client_id)
+                         )`)
+
+	store := New(db, NewConfig())
+	registrations, err := store.ListRegistrations()
+	if err != nil || len(registrations) != 0 {
+		t.Fatalf("got %v, error %v for empty table", registrations, err)
+	}
+
+	registration := newRegistrationForTesting(t)
+	other := registration
+	other.ClientID = "a"
+	for _, reg := range []datastore.Registration{registration, other} {
+		if err := store.StoreRegistration(reg); err != nil {
+			t.Fatalf("cannot store registration: %v", err)
+		}
+	}
+
+	registrations, err = store.ListRegistrations()
+	if err != nil {
+		t.Fatalf("cannot list registrations: %v", err)
+	}
+	// The `ramsql' driver ignores ORDER BY, so the order of the registrations is not checked.
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].ClientID < registrations[j].ClientID
+	})
+	if !reflect.DeepEqual(registrations, []datastore.Registration{other, registration}) {
+		t.Errorf("got %#v", registrations)
+	}
+}
+
 func TestStoreDeployment(t *testing.T) {
 	db, err := sql.Open("ramsql", "TestStoreDeployment")
 	if err != nil {
@@ -190,6 +257,212 @@ func TestStoreDeployment(t *testing.T) {
 	}
 }
 
+func TestStoreRegistrationWithDeployments(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestStoreRegistrationWithDeployments")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE registration (
+                           issuer text,
+                           client_id text,
+                           auth_token_uri text,
+                           auth_login_uri text,
+                           keyset_uri text,
+                           target_link_uri text,
+                           PRIMARY KEY (issuer, client_id)
+                         )`)
+
+	mustExec(t, db, `CREATE TABLE deployment (
+                           issuer text,
+                           deployment_id text
+                         )`)
+
+	// The `ramsql' driver does not roll back transactions, so the rollback of a failed deployment is tested with a
+	// recording driver in TestStoreRegistrationWithDeploymentsRollback.
+	store := New(db, NewConfig())
+	registration := newRegistrationForTesting(t)
+
+	err = store.StoreRegistrationWithDeployments(registration, []datastore.Deployment{{DeploymentID: ""}})
+	if err == nil {
+		t.Error("invalid deployment ID not reported")
+	}
+
+	err = store.StoreRegistrationWithDeployments(registration, []datastore.Deployment{
+		{DeploymentID: "1"},
+		{DeploymentID: "2"},
+	})
+	if err != nil {
+		t.Fatalf("cannot store registration with deployments: %v", err)
+	}
+	if _, err = store.FindRegistrationByIssuerAndClientID("a", "b"); err != nil {
+		t.Errorf("cannot find registration: %v", err)
+	}
+	for _, deploymentID := range []string{"1", "2"} {
+		if _, err = store.FindDeployment("a", deploymentID); err != nil {
+			t.Errorf("cannot find deployment %s: %v", deploymentID, err)
+		}
+	}
+}
+
+// A txRecorder is a driver.Connector recording the statements executed and the transactions committed and rolled
+// back. Statements containing failOn fail.
+type txRecorder struct {
+	mu        sync.Mutex
+	failOn    string
+	executed  []string
+	commits   int
+	rollbacks int
+}
+
+func (r *txRecorder) Connect(context.Context) (driver.Conn, error) { return txRecorderConn{r}, nil }
+func (r *txRecorder) Driver() driver.Driver                        { return nil }
+
+type txRecorderConn struct{ r *txRecorder }
+
+func (c txRecorderConn) Close() error              { return nil }
+func (c txRecorderConn) Begin() (driver.Tx, error) { return txRecorderTx{c.r}, nil }
+
+func (c txRecorderConn) Prepare(query string) (driver.Stmt, error) {
+	return txRecorderStmt{c.r, query}, nil
+}
+
+type txRecorderTx struct{ r *txRecorder }
+
+func (t txRecorderTx) Commit() error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	t.r.commits++
+	return nil
+}
+
+func (t txRecorderTx) Rollback() error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	t.r.rollbacks++
+	return nil
+}
+
+type txRecorderStmt struct {
+	r     *txRecorder
+	query string
+}
+
+func (s txRecorderStmt) Close() error  { return nil }
+func (s txRecorderStmt) NumInput() int { return -1 }
+
+func (s txRecorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	if s.r.failOn != "" && strings.Contains(s.query, s.r.failOn) {
+		return nil, errors.New("statement failed")
+	}
+	s.r.executed = append(s.r.executed, s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s txRecorderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries not supported")
+}
+
+func TestStoreRegistrationWithDeploymentsRollback(t *testing.T) {
+	recorder := &txRecorder{failOn: "INSERT INTO deployment"}
+	db := sql.OpenDB(recorder)
+	defer db.Close()
+	store := New(db, NewConfig())
+	registration := newRegistrationForTesting(t)
+
+	err := store.StoreRegistrationWithDeployments(registration, []datastore.Deployment{{DeploymentID: "1"}})
+	if err == nil {
+		t.Fatal("deployment error not reported")
+	}
+	if recorder.commits != 0 || recorder.rollbacks != 1 {
+		t.Errorf("got %d commits and %d rollbacks, wanted the transaction rolled back", recorder.commits,
+			recorder.rollbacks)
+	}
+
+	recorder.failOn = ""
+	err = store.StoreRegistrationWithDeployments(registration, []datastore.Deployment{
+		{DeploymentID: "1"},
+		{DeploymentID: "2"},
+	})
+	if err != nil {
+		t.Fatalf("cannot store registration with deployments: %v", err)
+	}
+	if recorder.commits != 1 || recorder.rollbacks != 1 {
+		t.Errorf("got %d commits and %d rollbacks, wanted the transaction committed", recorder.commits,
+			recorder.rollbacks)
+	}
+	// The registration of the rolled back transaction, then the registration and its two deployments.
+	if len(recorder.executed) != 4 {
+		t.Errorf("got %d statements executed, wanted 4", len(recorder.executed))
+	}
+}
+
+func TestRegistrationVersion(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestRegistrationVersion")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE registration (
+                           issuer text,
+                           client_id text,
+                           auth_token_uri text,
+                           auth_login_uri text,
+                           keyset_uri text,
+                           target_link_uri text,
+                           updated_at bigint,
+                           PRIMARY KEY (issuer, client_id)
+                         )`)
+	mustExec(t, db, `CREATE TABLE deployment (
+                           issuer text,
+                           deployment_id text
+                         )`)
+
+	store := New(db, NewConfig())
+	if _, err = store.RegistrationVersion(); err == nil {
+		t.Error("missing updated at field not reported")
+	}
+
+	config := NewConfig()
+	config.RegistrationFields.UpdatedAt = "updated_at"
+	store = New(db, config)
+	before, err := store.RegistrationVersion()
+	if err != nil {
+		t.Fatalf("registration version error: %v", err)
+	}
+
+	err = store.StoreDeployment("a", datastore.Deployment{DeploymentID: "b"})
+	if err != nil {
+		t.Fatalf("cannot store deployment: %v", err)
+	}
+	after, err := store.RegistrationVersion()
+	if err != nil {
+		t.Fatalf("registration version error: %v", err)
+	}
+	if before == after {
+		t.Errorf("version %s unchanged after storing deployment", after)
+	}
+	if unchanged, err := store.RegistrationVersion(); err != nil || unchanged != after {
+		t.Errorf("got version %s (%v) without changes, wanted %s", unchanged, err, after)
+	}
+
+	err = store.StoreRegistration(newRegistrationForTesting(t))
+	if err != nil {
+		t.Fatalf("cannot store registration: %v", err)
+	}
+	registered, err := store.RegistrationVersion()
+	if err != nil {
+		t.Fatalf("registration version error: %v", err)
+	}
+	if registered == after {
+		t.Errorf("version %s unchanged after storing registration", registered)
+	}
+}
+
 func TestFindDeployment(t *testing.T) {
 	db, err := sql.Open("ramsql", "TestFindDeployment")
 	if err != nil {
@@ -245,3 +518,175 @@ func TestFindDeployment(t *testing.T) {
 		t.Fatalf("deployment ID not validated")
 	}
 }
+
+func TestStoreAndFindAccessToken(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestStoreAndFindAccessToken")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE access_token (
+                           token_uri text,
+                           client_id text,
+                           scopes text,
+                           token text,
+                           expiry_time timestamp
+                         )`)
+
+	store := New(db, NewConfig())
+	token := datastore.AccessToken{
+		TokenURI:   "https://domain.tld/token",
+		ClientID:   "abcdef123456",
+		Scopes:     []string{"https://scope/2.delete", "https://scope/1.readonly"},
+		Token:      "123456789abcdef",
+		ExpiryTime: time.Now().Add(-time.Hour).UTC().Round(time.Second),
+	}
+
+	_, err = store.FindAccessToken(token.TokenURI, token.ClientID, token.Scopes)
+	if err != datastore.ErrAccessTokenNotFound {
+		t.Fatalf("unexpected error for missing token: %v", err)
+	}
+
+	err = store.StoreAccessToken(token)
+	if err != nil {
+		t.Fatalf("cannot store access token: %v", err)
+	}
+	_, err = store.FindAccessToken(token.TokenURI, token.ClientID, token.Scopes)
+	if err != datastore.ErrAccessTokenExpired {
+		t.Fatalf("unexpected error for expired token: %v", err)
+	}
+
+	removed, err := store.DeleteExpiredAccessTokens()
+	if err != nil {
+		t.Fatalf("cannot delete expired access tokens: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("got %d removed tokens, wanted 1", removed)
+	}
+
+	token.ExpiryTime = time.Now().Add(time.Hour).UTC().Round(time.Second)
+	err = store.StoreAccessToken(token)
+	if err != nil {
+		t.Fatalf("cannot store access token: %v", err)
+	}
+	found, err := store.FindAccessToken(token.TokenURI, token.ClientID, []string{"https://scope/1.readonly",
+		"https://scope/2.delete"})
+	if err != nil {
+		t.Fatalf("cannot find access token: %v", err)
+	}
+	if found.Token != token.Token || !found.ExpiryTime.Equal(token.ExpiryTime) {
+		t.Fatalf("got %#v, wanted %#v", found, token)
+	}
+}
+
+func TestStoreTokenID(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestStoreTokenID")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE token_replay (
+                           token_id text,
+                           expiry_time timestamp
+                         )`)
+
+	now := time.Now().UTC().Round(time.Second)
+	store := New(db, NewConfig())
+	store.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	err = store.StoreTokenID("jti", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("cannot store token ID: %v", err)
+	}
+	err = store.StoreTokenID("jti", now.Add(time.Minute))
+	if err != datastore.ErrTokenReplayed {
+		t.Fatalf("got %v for replayed token ID, wanted ErrTokenReplayed", err)
+	}
+
+	now = now.Add(time.Hour)
+	err = store.StoreTokenID("other", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("cannot store token ID: %v", err)
+	}
+	removed, err := store.DeleteExpiredTokenIDs()
+	if err != nil {
+		t.Fatalf("cannot delete expired token IDs: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("got %d removed token IDs, wanted 1", removed)
+	}
+
+	err = store.StoreTokenID("jti", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("got %v for token ID whose earlier use expired", err)
+	}
+}
+
+// A racingConnector is a driver.Connector opening connections of the driver whose replay inserts record the token ID,
+// as a concurrent use of the token on another instance would, and then fail like a unique constraint violation.
+type racingConnector struct {
+	driver driver.Driver
+	name   string
+}
+
+func (c racingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.name)
+	if err != nil {
+		return nil, err
+	}
+	return racingConn{conn}, nil
+}
+
+func (c racingConnector) Driver() driver.Driver { return c.driver }
+
+type racingConn struct{ driver.Conn }
+
+func (c racingConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil || !strings.Contains(query, "INSERT INTO token_replay") {
+		return stmt, err
+	}
+	return racingStmt{stmt}, nil
+}
+
+type racingStmt struct{ driver.Stmt }
+
+func (s racingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.Stmt.Exec(args); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("duplicate key value violates unique constraint")
+}
+
+func TestStoreTokenIDConcurrentUse(t *testing.T) {
+	db, err := sql.Open("ramsql", "TestStoreTokenIDConcurrentUse")
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer db.Close()
+
+	mustExec(t, db, `CREATE TABLE token_replay (
+                           token_id text,
+                           expiry_time timestamp
+                         )`)
+
+	racing := sql.OpenDB(racingConnector{driver: db.Driver(), name: "TestStoreTokenIDConcurrentUse"})
+	defer racing.Close()
+	now := time.Now().UTC().Round(time.Second)
+	store := New(racing, NewConfig())
+	store.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	// The token ID is recorded by the concurrent use, so the insert's failure is reported as a replay.
+	err = store.StoreTokenID("jti", now.Add(time.Minute))
+	if err != datastore.ErrTokenReplayed {
+		t.Errorf("got %v for token ID recorded concurrently, wanted ErrTokenReplayed", err)
+	}
+
+	// An insert failing for another reason is reported as such.
+	err = store.StoreTokenID("expired", now.Add(-time.Minute))
+	if err == nil || err == datastore.ErrTokenReplayed {
+		t.Errorf("got %v for failed insert, wanted an error other than ErrTokenReplayed", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"sync"
+	"time"
+)
+
+// A Sweeper periodically runs a cleanup function in the background, e.g., to remove expired records from a store. It
+// runs until it is closed.
+type Sweeper struct {
+	done chan struct{}
+	once sync.Once
+}
+
+// StartSweeper runs sweep every interval in a new goroutine. If onError is non-nil, it receives any error returned by
+// sweep.
+func StartSweeper(interval time.Duration, sweep func() error, onError func(error)) *Sweeper {
+	s := Sweeper{
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := sweep(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return &s
+}
+
+// Close stops the sweeper. It is safe to call Close more than once.
+func (s *Sweeper) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
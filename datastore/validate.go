@@ -0,0 +1,153 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ValidationErrors is the list of problems found by Config.Validate. errors.Is and errors.As apply to each of them, so
+// that, e.g., errors.Is(err, ErrIncompleteRegistration) reports whether any registration is incomplete.
+type ValidationErrors []error
+
+// Error lists the problems, separated by semicolons.
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d configuration problems: %s", len(v), strings.Join(messages, "; "))
+}
+
+// Is reports whether any of the problems is target.
+func (v ValidationErrors) Is(target error) bool {
+	for _, err := range v {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first problem that matches target, and if one is found, sets target to it.
+func (v ValidationErrors) As(target interface{}) bool {
+	for _, err := range v {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A RegistrationLister lists every stored registration. Registration stores implementing it have their registrations
+// checked by Config.Validate.
+type RegistrationLister interface {
+	ListRegistrations() ([]Registration, error)
+}
+
+// ErrInvalidPrivateKey is the error returned when a registration's private key is not a PEM encoded RSA private key.
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
+// ValidatePrivateKey checks that a private key is a PEM encoded PKCS #1 RSA private key, the format used by the
+// connector to sign its requests and by the keyset handler to publish the public key.
+func ValidatePrivateKey(privateKey string) error {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return fmt.Errorf("%w: no PEM block found", ErrInvalidPrivateKey)
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+
+	return nil
+}
+
+// Validate checks the configuration, e.g., at startup, so that a deployment fails fast instead of discovering its
+// problems request by request. It reports stores that are set to a nil pointer, which, unlike a nil store, do not fall
+// back on the nonpersistent default store. When the registration store is a RegistrationLister, each registration is
+// also checked for completeness (see ValidateRegistration), for the format of its private key and, under
+// StrictHTTPS, for plaintext endpoints.
+//
+// Validate returns nil or the ValidationErrors listing every problem found.
+func (c Config) Validate() error {
+	var problems ValidationErrors
+
+	stores := []struct {
+		name  string
+		store interface{}
+	}{
+		{RegistrationsStore, c.Registrations},
+		{NoncesStore, c.Nonces},
+		{LaunchDataStore, c.LaunchData},
+		{AccessTokensStore, c.AccessTokens},
+		{ReplaysStore, c.Replays},
+		{StatesStore, c.States},
+	}
+	for _, s := range stores {
+		if isNilPointer(s.store) {
+			problems = append(problems, fmt.Errorf("%s %w: nil %T", s.name, ErrStoreNotConfigured, s.store))
+		}
+	}
+
+	if lister, ok := c.Registrations.(RegistrationLister); ok && !isNilPointer(lister) {
+		registrations, err := lister.ListRegistrations()
+		if err != nil {
+			problems = append(problems, fmt.Errorf("could not list registrations: %w", err))
+		}
+		for _, reg := range registrations {
+			problems = append(problems, c.validateRegistration(reg)...)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return problems
+}
+
+// validateRegistration returns the problems of a stored registration, each naming the registration.
+func (c Config) validateRegistration(reg Registration) []error {
+	var problems []error
+	report := func(err error) {
+		problems = append(problems, fmt.Errorf("registration %s (client ID %s): %w", reg.Issuer, reg.ClientID, err))
+	}
+
+	if err := ValidateRegistration(reg); err != nil {
+		report(err)
+		return problems
+	}
+	if reg.PrivateKey != "" {
+		if err := ValidatePrivateKey(reg.PrivateKey); err != nil {
+			report(err)
+		}
+	}
+	if c.StrictHTTPS {
+		if err := ValidateRegistrationSecurity(reg); err != nil {
+			report(err)
+		}
+	}
+
+	return problems
+}
+
+// isNilPointer reports whether a store is set to a nil pointer.
+func isNilPointer(store interface{}) bool {
+	if store == nil {
+		return false
+	}
+	value := reflect.ValueOf(store)
+
+	return value.Kind() == reflect.Ptr && value.IsNil()
+}
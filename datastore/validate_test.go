@@ -0,0 +1,92 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+// listingStore is a RegistrationStorer that lists its registrations.
+type listingStore struct {
+	countingStore
+	registrations []Registration
+}
+
+func (s *listingStore) ListRegistrations() ([]Registration, error) {
+	return s.registrations, nil
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Config{}).Validate(); err != nil {
+		t.Errorf("got %v for zero Config", err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}))
+
+	uri := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		return u
+	}
+	valid := Registration{
+		Issuer:        "https://platform.tld",
+		ClientID:      "a",
+		AuthTokenURI:  uri("https://platform.tld/token"),
+		AuthLoginURI:  uri("https://platform.tld/auth"),
+		KeysetURI:     uri("https://platform.tld/keyset"),
+		TargetLinkURI: uri("https://tool.tld/launch"),
+		KeyID:         "1",
+		PrivateKey:    pemKey,
+	}
+	badKey := valid
+	badKey.ClientID = "b"
+	badKey.PrivateKey = "not a key"
+	incomplete := valid
+	incomplete.ClientID = "c"
+	incomplete.KeysetURI = nil
+	plaintext := valid
+	plaintext.ClientID = "d"
+	plaintext.AuthTokenURI = uri("http://platform.tld/token")
+
+	store := &listingStore{registrations: []Registration{valid}}
+	cfg := Config{Registrations: store, StrictHTTPS: true}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("got %v for valid configuration", err)
+	}
+
+	var nilStore *listingStore
+	store.registrations = append(store.registrations, badKey, incomplete, plaintext)
+	err = Config{Registrations: nilStore}.Validate()
+	if !errors.Is(err, ErrStoreNotConfigured) {
+		t.Errorf("got %v for nil pointer store, wanted ErrStoreNotConfigured", err)
+	}
+
+	err = cfg.Validate()
+	var problems ValidationErrors
+	if !errors.As(err, &problems) || len(problems) != 3 {
+		t.Fatalf("got %v, wanted 3 problems", err)
+	}
+	for _, target := range []error{ErrInvalidPrivateKey, ErrIncompleteRegistration, ErrInsecureURI} {
+		if !errors.Is(err, target) {
+			t.Errorf("%v not reported in %v", target, err)
+		}
+	}
+}
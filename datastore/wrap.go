@@ -0,0 +1,299 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// A Call describes a call to a method of a wrapped store, as seen by its Hooks. Store is the name of the store (e.g.,
+// RegistrationsStore) and Method the name of the method (e.g., "FindRegistrationByIssuerAndClientID"). Args holds the
+// arguments of the call, in order. Result, Err, Start and Duration are set once the underlying store has returned;
+// Result holds the value returned along with the error, if any, e.g., the Registration found.
+type Call struct {
+	Store    string
+	Method   string
+	Args     []interface{}
+	Result   interface{}
+	Err      error
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Hooks are called around each call to a wrapped store, e.g., to log the calls or to record their durations as
+// metrics. Before, if set, is called before the underlying store; if it returns an error, the underlying store is not
+// called and the error is returned to the caller. After, if set, is called once the underlying store has returned. It
+// may replace call.Err, e.g., to translate a backend error, and the caller receives call.Err. Both may be called
+// concurrently, as the stores are.
+type Hooks struct {
+	Before func(call *Call) error
+	After  func(call *Call)
+}
+
+// run calls f between the hooks and returns the resulting error.
+func (h Hooks) run(store, method string, args []interface{}, f func() (interface{}, error)) error {
+	call := &Call{Store: store, Method: method, Args: args}
+	if h.Before != nil {
+		if err := h.Before(call); err != nil {
+			return err
+		}
+	}
+
+	call.Start = time.Now()
+	call.Result, call.Err = f()
+	call.Duration = time.Since(call.Start)
+	if h.After != nil {
+		h.After(call)
+	}
+
+	return call.Err
+}
+
+// Wrap returns a copy of the Config whose stores are wrapped with the hooks. Nil stores are left nil, so they still
+// fall back on the nonpersistent default store, which is not wrapped.
+func Wrap(cfg Config, hooks Hooks) Config {
+	if cfg.Registrations != nil {
+		cfg.Registrations = WrapRegistrationStorer(cfg.Registrations, hooks)
+	}
+	if cfg.Nonces != nil {
+		cfg.Nonces = WrapNonceStorer(cfg.Nonces, hooks)
+	}
+	if cfg.LaunchData != nil {
+		cfg.LaunchData = WrapLaunchDataStorer(cfg.LaunchData, hooks)
+	}
+	if cfg.AccessTokens != nil {
+		cfg.AccessTokens = WrapAccessTokenStorer(cfg.AccessTokens, hooks)
+	}
+	if cfg.Replays != nil {
+		cfg.Replays = WrapReplayStorer(cfg.Replays, hooks)
+	}
+	if cfg.States != nil {
+		cfg.States = WrapStateStorer(cfg.States, hooks)
+	}
+
+	return cfg
+}
+
+// WrapRegistrationStorer returns a RegistrationStorer calling the hooks around each call to the store. The wrapper is
+// also a RegistrationLister, so that Config.Validate still checks the registrations of a store that lists them.
+func WrapRegistrationStorer(store RegistrationStorer, hooks Hooks) RegistrationStorer {
+	return wrappedRegistrations{store: store, hooks: hooks}
+}
+
+// wrappedRegistrations calls hooks around a RegistrationStorer.
+type wrappedRegistrations struct {
+	store RegistrationStorer
+	hooks Hooks
+}
+
+func (w wrappedRegistrations) StoreRegistration(reg Registration) error {
+	return w.hooks.run(RegistrationsStore, "StoreRegistration", []interface{}{reg}, func() (interface{}, error) {
+		return nil, w.store.StoreRegistration(reg)
+	})
+}
+
+func (w wrappedRegistrations) FindRegistrationByIssuerAndClientID(issuer, clientID string) (Registration, error) {
+	var reg Registration
+	args := []interface{}{issuer, clientID}
+	err := w.hooks.run(RegistrationsStore, "FindRegistrationByIssuerAndClientID", args, func() (interface{}, error) {
+		var err error
+		reg, err = w.store.FindRegistrationByIssuerAndClientID(issuer, clientID)
+		return reg, err
+	})
+
+	return reg, err
+}
+
+func (w wrappedRegistrations) StoreDeployment(issuer string, deployment Deployment) error {
+	args := []interface{}{issuer, deployment}
+	return w.hooks.run(RegistrationsStore, "StoreDeployment", args, func() (interface{}, error) {
+		return nil, w.store.StoreDeployment(issuer, deployment)
+	})
+}
+
+func (w wrappedRegistrations) FindDeployment(issuer, deploymentID string) (Deployment, error) {
+	var deployment Deployment
+	args := []interface{}{issuer, deploymentID}
+	err := w.hooks.run(RegistrationsStore, "FindDeployment", args, func() (interface{}, error) {
+		var err error
+		deployment, err = w.store.FindDeployment(issuer, deploymentID)
+		return deployment, err
+	})
+
+	return deployment, err
+}
+
+// ListRegistrations lists the registrations of the underlying store, if it is a RegistrationLister.
+func (w wrappedRegistrations) ListRegistrations() ([]Registration, error) {
+	lister, ok := w.store.(RegistrationLister)
+	if !ok {
+		return nil, nil
+	}
+
+	var registrations []Registration
+	err := w.hooks.run(RegistrationsStore, "ListRegistrations", nil, func() (interface{}, error) {
+		var err error
+		registrations, err = lister.ListRegistrations()
+		return registrations, err
+	})
+
+	return registrations, err
+}
+
+// WrapNonceStorer returns a NonceStorer calling the hooks around each call to the store.
+func WrapNonceStorer(store NonceStorer, hooks Hooks) NonceStorer {
+	return wrappedNonces{store: store, hooks: hooks}
+}
+
+// wrappedNonces calls hooks around a NonceStorer.
+type wrappedNonces struct {
+	store NonceStorer
+	hooks Hooks
+}
+
+func (w wrappedNonces) StoreNonce(nonce, targetLinkURI string) error {
+	return w.hooks.run(NoncesStore, "StoreNonce", []interface{}{nonce, targetLinkURI}, func() (interface{}, error) {
+		return nil, w.store.StoreNonce(nonce, targetLinkURI)
+	})
+}
+
+func (w wrappedNonces) TestAndClearNonce(nonce, targetLinkURI string) error {
+	args := []interface{}{nonce, targetLinkURI}
+	return w.hooks.run(NoncesStore, "TestAndClearNonce", args, func() (interface{}, error) {
+		return nil, w.store.TestAndClearNonce(nonce, targetLinkURI)
+	})
+}
+
+// WrapLaunchDataStorer returns a LaunchDataStorer calling the hooks around each call to the store. The wrapper is
+// also a Purger, which forwards to the store if it is one.
+func WrapLaunchDataStorer(store LaunchDataStorer, hooks Hooks) LaunchDataStorer {
+	return wrappedLaunchData{store: store, hooks: hooks}
+}
+
+// wrappedLaunchData calls hooks around a LaunchDataStorer.
+type wrappedLaunchData struct {
+	store LaunchDataStorer
+	hooks Hooks
+}
+
+func (w wrappedLaunchData) StoreLaunchData(launchID string, launchData json.RawMessage) error {
+	args := []interface{}{launchID, launchData}
+	return w.hooks.run(LaunchDataStore, "StoreLaunchData", args, func() (interface{}, error) {
+		return nil, w.store.StoreLaunchData(launchID, launchData)
+	})
+}
+
+func (w wrappedLaunchData) FindLaunchData(launchID string) (json.RawMessage, error) {
+	var launchData json.RawMessage
+	err := w.hooks.run(LaunchDataStore, "FindLaunchData", []interface{}{launchID}, func() (interface{}, error) {
+		var err error
+		launchData, err = w.store.FindLaunchData(launchID)
+		return launchData, err
+	})
+
+	return launchData, err
+}
+
+// PurgeUserData purges the user's data with the underlying store, if it is a Purger.
+func (w wrappedLaunchData) PurgeUserData(subject string) (int, error) {
+	return w.purge("PurgeUserData", subject, func(p Purger) (int, error) {
+		return p.PurgeUserData(subject)
+	})
+}
+
+// PurgeContextData purges the context's data with the underlying store, if it is a Purger.
+func (w wrappedLaunchData) PurgeContextData(contextID string) (int, error) {
+	return w.purge("PurgeContextData", contextID, func(p Purger) (int, error) {
+		return p.PurgeContextData(contextID)
+	})
+}
+
+// purge runs the purge method between the hooks, if the underlying store is a Purger.
+func (w wrappedLaunchData) purge(method, arg string, f func(Purger) (int, error)) (int, error) {
+	purger, ok := w.store.(Purger)
+	if !ok {
+		return 0, nil
+	}
+
+	var removed int
+	err := w.hooks.run(LaunchDataStore, method, []interface{}{arg}, func() (interface{}, error) {
+		var err error
+		removed, err = f(purger)
+		return removed, err
+	})
+
+	return removed, err
+}
+
+// WrapAccessTokenStorer returns an AccessTokenStorer calling the hooks around each call to the store.
+func WrapAccessTokenStorer(store AccessTokenStorer, hooks Hooks) AccessTokenStorer {
+	return wrappedAccessTokens{store: store, hooks: hooks}
+}
+
+// wrappedAccessTokens calls hooks around an AccessTokenStorer.
+type wrappedAccessTokens struct {
+	store AccessTokenStorer
+	hooks Hooks
+}
+
+func (w wrappedAccessTokens) StoreAccessToken(token AccessToken) error {
+	return w.hooks.run(AccessTokensStore, "StoreAccessToken", []interface{}{token}, func() (interface{}, error) {
+		return nil, w.store.StoreAccessToken(token)
+	})
+}
+
+func (w wrappedAccessTokens) FindAccessToken(tokenURI, clientID string, scopes []string) (AccessToken, error) {
+	var token AccessToken
+	args := []interface{}{tokenURI, clientID, scopes}
+	err := w.hooks.run(AccessTokensStore, "FindAccessToken", args, func() (interface{}, error) {
+		var err error
+		token, err = w.store.FindAccessToken(tokenURI, clientID, scopes)
+		return token, err
+	})
+
+	return token, err
+}
+
+// WrapReplayStorer returns a ReplayStorer calling the hooks around each call to the store.
+func WrapReplayStorer(store ReplayStorer, hooks Hooks) ReplayStorer {
+	return wrappedReplays{store: store, hooks: hooks}
+}
+
+// wrappedReplays calls hooks around a ReplayStorer.
+type wrappedReplays struct {
+	store ReplayStorer
+	hooks Hooks
+}
+
+func (w wrappedReplays) StoreTokenID(tokenID string, expiry time.Time) error {
+	return w.hooks.run(ReplaysStore, "StoreTokenID", []interface{}{tokenID, expiry}, func() (interface{}, error) {
+		return nil, w.store.StoreTokenID(tokenID, expiry)
+	})
+}
+
+// WrapStateStorer returns a StateStorer calling the hooks around each call to the store.
+func WrapStateStorer(store StateStorer, hooks Hooks) StateStorer {
+	return wrappedStates{store: store, hooks: hooks}
+}
+
+// wrappedStates calls hooks around a StateStorer.
+type wrappedStates struct {
+	store StateStorer
+	hooks Hooks
+}
+
+func (w wrappedStates) StoreState(state, nonce string, expiry time.Time) error {
+	return w.hooks.run(StatesStore, "StoreState", []interface{}{state, nonce, expiry}, func() (interface{}, error) {
+		return nil, w.store.StoreState(state, nonce, expiry)
+	})
+}
+
+func (w wrappedStates) TestAndClearState(state, nonce string) error {
+	return w.hooks.run(StatesStore, "TestAndClearState", []interface{}{state, nonce}, func() (interface{}, error) {
+		return nil, w.store.TestAndClearState(state, nonce)
+	})
+}
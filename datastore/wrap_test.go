@@ -0,0 +1,69 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	store := &countingStore{registration: Registration{Issuer: "a", ClientID: "b"}}
+	var calls []*Call
+	hooks := Hooks{
+		After: func(call *Call) {
+			calls = append(calls, call)
+		},
+	}
+	cfg := Wrap(Config{Registrations: store}, hooks)
+	if cfg.Nonces != nil || cfg.LaunchData != nil {
+		t.Errorf("nil stores were wrapped")
+	}
+
+	reg, err := cfg.Registrations.FindRegistrationByIssuerAndClientID("a", "b")
+	if err != nil || reg.ClientID != "b" {
+		t.Fatalf("got %v, error %v", reg, err)
+	}
+	_, err = cfg.Registrations.FindRegistrationByIssuerAndClientID("x", "b")
+	if !errors.Is(err, ErrRegistrationNotFound) {
+		t.Errorf("got %v, wanted ErrRegistrationNotFound", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, wanted 2", len(calls))
+	}
+	call := calls[0]
+	if call.Store != RegistrationsStore || call.Method != "FindRegistrationByIssuerAndClientID" ||
+		len(call.Args) != 2 || call.Args[0] != "a" || call.Result.(Registration).ClientID != "b" || call.Err != nil {
+		t.Errorf("got call %+v", call)
+	}
+	if !errors.Is(calls[1].Err, ErrRegistrationNotFound) {
+		t.Errorf("got call error %v, wanted ErrRegistrationNotFound", calls[1].Err)
+	}
+
+	rejected := errors.New("rejected")
+	hooks.Before = func(call *Call) error {
+		return rejected
+	}
+	wrapped := WrapRegistrationStorer(store, hooks)
+	lookups := store.lookups
+	if _, err := wrapped.FindDeployment("a", "1"); !errors.Is(err, rejected) {
+		t.Errorf("got %v, wanted the error of Before", err)
+	}
+	if store.lookups != lookups {
+		t.Errorf("store called after Before returned an error")
+	}
+
+	translated := errors.New("translated")
+	wrapped = WrapRegistrationStorer(store, Hooks{After: func(call *Call) {
+		call.Err = translated
+	}})
+	if _, err := wrapped.FindDeployment("a", "1"); !errors.Is(err, translated) {
+		t.Errorf("got %v, wanted the error set by After", err)
+	}
+	if _, err := wrapped.(RegistrationLister).ListRegistrations(); err != nil {
+		t.Errorf("got %v listing a store that is not a RegistrationLister", err)
+	}
+}
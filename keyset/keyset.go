@@ -0,0 +1,329 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package keyset fetches and caches platform keysets, and verifies the JWTs signed with their keys.
+package keyset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// Timeout value for http clients.
+var timeout time.Duration = time.Second * 15
+
+// maximumKeysetBody limits the response body read when fetching a keyset.
+const maximumKeysetBody = 1 << 20
+
+// DefaultTTL is the time for which a Cache keeps a keyset when no TTL is given.
+const DefaultTTL = 10 * time.Minute
+
+// DefaultMinimumRefreshInterval is the shortest time between two fetches of a keyset prompted by an unknown key ID,
+// when a Cache's MinimumRefreshInterval is zero.
+const DefaultMinimumRefreshInterval = time.Minute
+
+var (
+	// ErrKeysetUnavailable is returned when a keyset cannot be retrieved.
+	ErrKeysetUnavailable = errors.New("platform keyset unavailable")
+
+	// ErrInvalidSignature is returned when a JWT's signature cannot be verified with the keyset.
+	ErrInvalidSignature = errors.New("invalid JWT signature")
+)
+
+// DefaultCache is the Cache used by the package-level Verify.
+var DefaultCache = NewCache(0)
+
+// A Cache keeps the keysets fetched from platforms in memory, for at most TTL, to spare the platform a request for
+// every JWT verified. A keyset is kept for a shorter time when the platform's Cache-Control header allows it to be
+// cached for less, but for at least MinimumRefreshInterval. JWTs are verified with the key selected by SelectKey. A
+// JWT signed with a key ID that is not in the cached keyset prompts a new fetch, so that rotated keys are picked up
+// before the cached keyset expires. Such fetches are made at most once every MinimumRefreshInterval per keyset, so
+// that JWTs with made-up key IDs cannot be used to flood the platform. Run refreshes the cached keysets in the
+// background, so that verifying JWTs does not wait for their platforms.
+//
+// HTTPClient, if set, is used to fetch keysets; otherwise a client with a 15 second timeout is used. Clock, if set,
+// is the time source used to check expiry times. The zero value is an empty Cache using DefaultTTL, like NewCache(0).
+type Cache struct {
+	TTL                    time.Duration
+	MinimumRefreshInterval time.Duration
+	HTTPClient             *http.Client
+	Clock                  datastore.Clock
+
+	mu      sync.Mutex
+	keysets map[string]cachedKeyset
+}
+
+type cachedKeyset struct {
+	keyset  jwk.Set
+	fetched time.Time
+	expires time.Time
+}
+
+// NewCache returns an empty Cache. A TTL of zero or less selects DefaultTTL.
+func NewCache(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{
+		TTL:     ttl,
+		keysets: map[string]cachedKeyset{},
+	}
+}
+
+// Fetch returns the cached keyset found at the URI, or fetches it and caches it. Keysets that cannot be fetched are
+// not cached.
+func (c *Cache) Fetch(uri string) (jwk.Set, error) {
+	now := datastore.Now(c.Clock)
+
+	c.mu.Lock()
+	cached, ok := c.keysets[uri]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.keyset, nil
+	}
+
+	return c.fetch(uri, now)
+}
+
+// Key returns the key of the keyset found at the URI that verifies a JWT signed with the key ID and algorithm, as
+// selected by SelectKey. The keyset is fetched again if the key ID is not found in the cached keyset.
+func (c *Cache) Key(keysetURI, keyID string, algorithm jwa.SignatureAlgorithm) (jwk.Key, error) {
+	keyset, err := c.Fetch(keysetURI)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != "" {
+		if _, ok := keyset.LookupKeyID(keyID); !ok {
+			keyset, err = c.refresh(keysetURI)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return SelectKey(keyset, keyID, algorithm)
+}
+
+// Run refreshes the cached keysets in the background until the context is done. Every MinimumRefreshInterval, the
+// keysets that would expire before the next refresh are fetched again. A keyset that cannot be fetched is kept until
+// it expires, and fetched again when it is next used.
+func (c *Cache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.minimumRefreshInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refreshExpiring(datastore.Now(c.Clock))
+		}
+	}
+}
+
+// Invalidate removes the keyset found at the URI from the cache.
+func (c *Cache) Invalidate(uri string) {
+	c.mu.Lock()
+	delete(c.keysets, uri)
+	c.mu.Unlock()
+}
+
+// Verify verifies the signature of the JWT with the keyset found at the URI and returns the parsed token. JWTs that
+// are unsigned or signed with a symmetric (HS*) algorithm are rejected, since they cannot be verified with a
+// platform's public keys. The token's claims, e.g., its issuer and expiry, are not validated.
+func (c *Cache) Verify(rawToken []byte, keysetURI string) (jwt.Token, error) {
+	message, err := jws.Parse(rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	signatures := message.Signatures()
+	if len(signatures) != 1 {
+		return nil, fmt.Errorf("%w: expected 1 signature, found %d", ErrInvalidSignature, len(signatures))
+	}
+	headers := signatures[0].ProtectedHeaders()
+	algorithm := headers.Algorithm()
+	if algorithm == jwa.NoSignature || strings.HasPrefix(algorithm.String(), "HS") {
+		return nil, fmt.Errorf("%w: algorithm %s not permitted", ErrInvalidSignature, algorithm)
+	}
+
+	key, err := c.Key(keysetURI, headers.KeyID(), algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(rawToken, jwt.WithVerify(algorithm, key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return token, nil
+}
+
+// SelectKey selects the key of the keyset that verifies a JWT signed with the key ID (kid) and algorithm of its
+// header. A JWT with a key ID is verified only with the key of that ID. A JWT without a key ID is accepted only when
+// the keyset holds a single key, since the keyset's other keys could otherwise be tried in its place. The key must be
+// meant for signatures and, if it names an algorithm, for the JWT's algorithm. An error wrapping ErrInvalidSignature
+// is returned when no key is selected.
+func SelectKey(keyset jwk.Set, keyID string, algorithm jwa.SignatureAlgorithm) (jwk.Key, error) {
+	var key jwk.Key
+	if keyID != "" {
+		var ok bool
+		key, ok = keyset.LookupKeyID(keyID)
+		if !ok {
+			return nil, fmt.Errorf("%w: key ID %s not found in keyset", ErrInvalidSignature, keyID)
+		}
+	} else {
+		if keyset.Len() != 1 {
+			return nil, fmt.Errorf("%w: key ID required to select one of %d keys", ErrInvalidSignature,
+				keyset.Len())
+		}
+		key, _ = keyset.Get(0)
+	}
+
+	if usage := key.KeyUsage(); usage != "" && usage != jwk.ForSignature.String() {
+		return nil, fmt.Errorf("%w: key not meant for signatures", ErrInvalidSignature)
+	}
+	if keyAlgorithm := key.Algorithm(); keyAlgorithm != "" && keyAlgorithm != algorithm.String() {
+		return nil, fmt.Errorf("%w: key meant for algorithm %s, not %s", ErrInvalidSignature, keyAlgorithm,
+			algorithm)
+	}
+
+	return key, nil
+}
+
+// Verify verifies the signature of the JWT with the keyset found at the URI, using DefaultCache.
+func Verify(rawToken []byte, keysetURI string) (jwt.Token, error) {
+	return DefaultCache.Verify(rawToken, keysetURI)
+}
+
+// refresh fetches the keyset found at the URI again, unless it was fetched within the minimum refresh interval, in
+// which case the cached keyset is returned.
+func (c *Cache) refresh(uri string) (jwk.Set, error) {
+	now := datastore.Now(c.Clock)
+
+	c.mu.Lock()
+	cached, ok := c.keysets[uri]
+	c.mu.Unlock()
+	if ok && now.Before(cached.fetched.Add(c.minimumRefreshInterval())) {
+		return cached.keyset, nil
+	}
+
+	return c.fetch(uri, now)
+}
+
+// refreshExpiring fetches the cached keysets that expire within the minimum refresh interval of now.
+func (c *Cache) refreshExpiring(now time.Time) {
+	deadline := now.Add(c.minimumRefreshInterval())
+
+	var expiring []string
+	c.mu.Lock()
+	for uri, cached := range c.keysets {
+		if cached.expires.Before(deadline) {
+			expiring = append(expiring, uri)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, uri := range expiring {
+		c.fetch(uri, now)
+	}
+}
+
+// minimumRefreshInterval returns the MinimumRefreshInterval, or DefaultMinimumRefreshInterval when it is zero.
+func (c *Cache) minimumRefreshInterval() time.Duration {
+	if c.MinimumRefreshInterval == 0 {
+		return DefaultMinimumRefreshInterval
+	}
+
+	return c.MinimumRefreshInterval
+}
+
+// ttl returns the TTL, or DefaultTTL when it is zero or less.
+func (c *Cache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultTTL
+	}
+
+	return c.TTL
+}
+
+// fetch retrieves the keyset found at the URI and caches it, for as long as the response's Cache-Control header and
+// the Cache's TTL allow.
+func (c *Cache) fetch(uri string, now time.Time) (jwk.Set, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: timeout}
+	}
+
+	response, err := client.Get(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysetUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: response status %s", ErrKeysetUnavailable, response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maximumKeysetBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysetUnavailable, err)
+	}
+	if len(body) > maximumKeysetBody {
+		return nil, fmt.Errorf("%w: keyset larger than %d bytes", ErrKeysetUnavailable, maximumKeysetBody)
+	}
+	keyset, err := jwk.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysetUnavailable, err)
+	}
+
+	lifetime := c.ttl()
+	if maxAge, ok := cacheMaxAge(response.Header); ok && maxAge < lifetime {
+		lifetime = maxAge
+	}
+	if minimum := c.minimumRefreshInterval(); lifetime < minimum {
+		lifetime = minimum
+	}
+
+	c.mu.Lock()
+	if c.keysets == nil {
+		c.keysets = map[string]cachedKeyset{}
+	}
+	c.keysets[uri] = cachedKeyset{keyset: keyset, fetched: now, expires: now.Add(lifetime)}
+	c.mu.Unlock()
+
+	return keyset, nil
+}
+
+// cacheMaxAge returns the time for which the Cache-Control header allows a response to be cached, if it limits it.
+// Responses that must not be cached, or must be revalidated, may be cached for no time.
+func cacheMaxAge(header http.Header) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package keyset
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+// newSigningKeyForTesting returns a private key with the key ID and the matching public key.
+func newSigningKeyForTesting(t *testing.T, keyID string) (jwk.Key, jwk.Key) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	signingKey, err := jwk.New(privateKey)
+	if err != nil {
+		t.Fatalf("cannot create key: %v", err)
+	}
+	publicKey, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot create key: %v", err)
+	}
+	signingKey.Set(jwk.KeyIDKey, keyID)
+	publicKey.Set(jwk.KeyIDKey, keyID)
+	publicKey.Set(jwk.AlgorithmKey, jwa.RS256)
+
+	return signingKey, publicKey
+}
+
+func TestCacheVerify(t *testing.T) {
+	firstSigningKey, firstPublicKey := newSigningKeyForTesting(t, "first")
+	secondSigningKey, secondPublicKey := newSigningKeyForTesting(t, "second")
+
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	published := jwk.NewSet()
+	published.Add(firstPublicKey)
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		json.NewEncoder(w).Encode(published)
+	}))
+	defer platform.Close()
+
+	sign := func(key interface{}, algorithm jwa.SignatureAlgorithm) []byte {
+		token := jwt.New()
+		token.Set(jwt.IssuerKey, "https://platform.tld")
+		signed, err := jwt.Sign(token, algorithm, key)
+		if err != nil {
+			t.Fatalf("cannot sign token: %v", err)
+		}
+		return signed
+	}
+
+	clock := &fixedClock{now: time.Unix(0, 0)}
+	cache := NewCache(time.Hour)
+	cache.Clock = clock
+
+	for i := 0; i < 2; i++ {
+		token, err := cache.Verify(sign(firstSigningKey, jwa.RS256), platform.URL)
+		if err != nil {
+			t.Fatalf("verify error: %v", err)
+		}
+		if token.Issuer() != "https://platform.tld" {
+			t.Errorf("got issuer %s", token.Issuer())
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches, wanted the keyset to be cached", fetches)
+	}
+
+	// A rotated key is picked up once the minimum refresh interval has passed.
+	mu.Lock()
+	published.Add(secondPublicKey)
+	mu.Unlock()
+	if _, err := cache.Verify(sign(secondSigningKey, jwa.RS256), platform.URL); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v within the minimum refresh interval, wanted ErrInvalidSignature", err)
+	}
+	clock.now = clock.now.Add(DefaultMinimumRefreshInterval)
+	if _, err := cache.Verify(sign(secondSigningKey, jwa.RS256), platform.URL); err != nil {
+		t.Errorf("verify error for rotated key: %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("got %d fetches, wanted 2", fetches)
+	}
+
+	if _, err := cache.Verify(sign([]byte("secret"), jwa.HS256), platform.URL); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v for symmetric algorithm, wanted ErrInvalidSignature", err)
+	}
+
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+	_, err := cache.Verify(sign(firstSigningKey, jwa.RS256), unavailable.URL)
+	if !errors.Is(err, ErrKeysetUnavailable) {
+		t.Errorf("got %v for unavailable keyset, wanted ErrKeysetUnavailable", err)
+	}
+}
+
+func TestSelectKey(t *testing.T) {
+	_, firstPublicKey := newSigningKeyForTesting(t, "first")
+	_, secondPublicKey := newSigningKeyForTesting(t, "second")
+	single := jwk.NewSet()
+	single.Add(firstPublicKey)
+	multiple := jwk.NewSet()
+	multiple.Add(firstPublicKey)
+	multiple.Add(secondPublicKey)
+
+	tests := []struct {
+		keyset    jwk.Set
+		keyID     string
+		algorithm jwa.SignatureAlgorithm
+		want      jwk.Key
+	}{
+		{multiple, "second", jwa.RS256, secondPublicKey},
+		{single, "", jwa.RS256, firstPublicKey},
+		{multiple, "", jwa.RS256, nil},
+		{multiple, "third", jwa.RS256, nil},
+		{single, "first", jwa.RS512, nil},
+	}
+
+	for _, test := range tests {
+		key, err := SelectKey(test.keyset, test.keyID, test.algorithm)
+		if key != test.want || (test.want == nil) != errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("key ID %q of %d keys with %s: got %v, %v", test.keyID, test.keyset.Len(), test.algorithm,
+				key, err)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	_, publicKey := newSigningKeyForTesting(t, "first")
+	published := jwk.NewSet()
+	published.Add(publicKey)
+
+	var (
+		mu           sync.Mutex
+		fetches      int
+		cacheControl string
+	)
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.Header().Set("Cache-Control", cacheControl)
+		json.NewEncoder(w).Encode(published)
+	}))
+	defer platform.Close()
+
+	clock := &fixedClock{now: time.Unix(0, 0)}
+	cache := NewCache(time.Hour)
+	cache.Clock = clock
+	fetch := func(wanted int) {
+		t.Helper()
+		if _, err := cache.Fetch(platform.URL); err != nil {
+			t.Fatalf("fetch error: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if fetches != wanted {
+			t.Errorf("got %d fetches, wanted %d", fetches, wanted)
+		}
+	}
+
+	cacheControl = "public, max-age=300"
+	fetch(1)
+	clock.now = clock.now.Add(299 * time.Second)
+	fetch(1)
+	clock.now = clock.now.Add(time.Second)
+	fetch(2)
+
+	// A keyset that must not be cached is kept for the minimum refresh interval.
+	cacheControl = "no-store"
+	clock.now = clock.now.Add(300 * time.Second)
+	fetch(3)
+	clock.now = clock.now.Add(DefaultMinimumRefreshInterval - time.Second)
+	fetch(3)
+
+	// Keysets about to expire are refreshed in the background.
+	cacheControl = ""
+	cache.refreshExpiring(clock.now)
+	fetch(4)
+	cache.refreshExpiring(clock.now)
+	fetch(4)
+
+	cache.Invalidate(platform.URL)
+	fetch(5)
+}
+
+func TestZeroCache(t *testing.T) {
+	_, publicKey := newSigningKeyForTesting(t, "first")
+	published := jwk.NewSet()
+	published.Add(publicKey)
+
+	var (
+		mu      sync.Mutex
+		fetches int
+	)
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		json.NewEncoder(w).Encode(published)
+	}))
+	defer platform.Close()
+
+	clock := &fixedClock{now: time.Unix(0, 0)}
+	cache := &Cache{HTTPClient: platform.Client(), Clock: clock}
+	for _, elapsed := range []time.Duration{0, DefaultTTL - time.Second} {
+		clock.now = time.Unix(0, 0).Add(elapsed)
+		if _, err := cache.Fetch(platform.URL); err != nil {
+			t.Fatalf("fetch error: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches, wanted the keyset to be cached for DefaultTTL", fetches)
+	}
+
+	cache = &Cache{}
+	cache.Invalidate(platform.URL)
+	cache.refreshExpiring(time.Now())
+	if _, err := cache.Fetch(platform.URL); err != nil {
+		t.Errorf("fetch error for zero Cache: %v", err)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	saved := timeout
+	timeout = 50 * time.Millisecond
+	defer func() { timeout = saved }()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	if _, err := (&Cache{}).Fetch(slow.URL); !errors.Is(err, ErrKeysetUnavailable) {
+		t.Errorf("got %v for slow platform, wanted ErrKeysetUnavailable", err)
+	}
+
+	oversized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": [], "padding": "`))
+		w.Write(bytes.Repeat([]byte("a"), maximumKeysetBody))
+		w.Write([]byte(`"}`))
+	}))
+	defer oversized.Close()
+	_, err := (&Cache{HTTPClient: oversized.Client()}).Fetch(oversized.URL)
+	if !errors.Is(err, ErrKeysetUnavailable) || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("got %v for oversized keyset, wanted ErrKeysetUnavailable", err)
+	}
+}
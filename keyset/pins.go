@@ -0,0 +1,75 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package keyset
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// ErrKeySubstituted is returned when a key ID that a platform has signed with before is bound to a different key.
+var ErrKeySubstituted = errors.New("key ID bound to a different key")
+
+// Pins remembers the key bound to each key ID that the platform of a registration has signed with, so that a keyset
+// that binds one of these key IDs to another key, e.g., because the keyset or its URI has been tampered with, is
+// detected. Rotating keys is not affected, since new keys are expected to have new key IDs. Keys without a key ID are
+// not pinned. Pins are kept in memory and are safe for concurrent use.
+type Pins struct {
+	mu   sync.Mutex
+	pins map[string]map[string][]byte
+}
+
+// NewPins returns Pins without any pinned keys.
+func NewPins() *Pins {
+	return &Pins{pins: map[string]map[string][]byte{}}
+}
+
+// Check pins the key to the key ID for the registration if the key ID is seen for the first time. Otherwise, it
+// returns an error wrapping ErrKeySubstituted if the key is not the one pinned. Keys are compared by their SHA-256
+// thumbprints.
+func (p *Pins) Check(registration datastore.Registration, keyID string, key jwk.Key) error {
+	if keyID == "" {
+		return nil
+	}
+
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("could not compute thumbprint of key %s: %w", keyID, err)
+	}
+
+	registrationKey := registration.Issuer + "\x00" + registration.ClientID
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys, ok := p.pins[registrationKey]
+	if !ok {
+		keys = map[string][]byte{}
+		p.pins[registrationKey] = keys
+	}
+	pinned, ok := keys[keyID]
+	if !ok {
+		keys[keyID] = thumbprint
+		return nil
+	}
+	if !bytes.Equal(pinned, thumbprint) {
+		return fmt.Errorf("%w: key ID %s of issuer %s", ErrKeySubstituted, keyID, registration.Issuer)
+	}
+
+	return nil
+}
+
+// Forget removes the pinned keys of the registration, e.g., after the platform has legitimately reissued a key under
+// an existing key ID.
+func (p *Pins) Forget(registration datastore.Registration) {
+	p.mu.Lock()
+	delete(p.pins, registration.Issuer+"\x00"+registration.ClientID)
+	p.mu.Unlock()
+}
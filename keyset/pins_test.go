@@ -0,0 +1,42 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package keyset
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+)
+
+func TestPins(t *testing.T) {
+	_, firstPublicKey := newSigningKeyForTesting(t, "first")
+	_, substitutedPublicKey := newSigningKeyForTesting(t, "first")
+	registration := datastore.Registration{Issuer: "https://platform.tld", ClientID: "abc"}
+	other := datastore.Registration{Issuer: "https://platform.tld", ClientID: "def"}
+	pins := NewPins()
+
+	for i := 0; i < 2; i++ {
+		if err := pins.Check(registration, "first", firstPublicKey); err != nil {
+			t.Fatalf("check error: %v", err)
+		}
+	}
+	err := pins.Check(registration, "first", substitutedPublicKey)
+	if !errors.Is(err, ErrKeySubstituted) {
+		t.Errorf("got %v, wanted ErrKeySubstituted", err)
+	}
+	if err := pins.Check(registration, "", substitutedPublicKey); err != nil {
+		t.Errorf("key without key ID reported: %v", err)
+	}
+	if err := pins.Check(other, "first", substitutedPublicKey); err != nil {
+		t.Errorf("key of other registration reported: %v", err)
+	}
+
+	pins.Forget(registration)
+	if err := pins.Check(registration, "first", substitutedPublicKey); err != nil {
+		t.Errorf("check error after forgetting pins: %v", err)
+	}
+}
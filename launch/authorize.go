@@ -0,0 +1,63 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/roles"
+)
+
+// RequireRoles returns a middleware constructor for handlers that run after a successful launch (i.e., on requests
+// whose context carries a launch ID), e.g., instructor-only pages. The middleware loads the stored launch data and
+// calls next only if the user holds any of the required roles; otherwise, it responds with 403 Forbidden. Short and
+// full forms of context roles are treated as equal (see roles.HasRole), but sub-roles, e.g., a teaching assistant,
+// must be required explicitly. If the Config's launch data store is nil, it falls back on the in-memory
+// nonpersistent.DefaultStore.
+func RequireRoles(cfg datastore.Config, requiredRoles ...string) func(next http.Handler) http.Handler {
+	if cfg.LaunchData == nil {
+		cfg.LaunchData = nonpersistent.DefaultStore
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			launchID, ok := r.Context().Value(ContextKey).(string)
+			if !ok || launchID == "" {
+				http.Error(w, "launch ID not found in request", http.StatusUnauthorized)
+				return
+			}
+
+			launchData, err := cfg.LaunchData.FindLaunchData(launchID)
+			if err != nil {
+				if errors.Is(err, datastore.ErrLaunchDataNotFound) {
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+
+				http.Error(w, fmt.Sprintf("require roles: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			launchClaims, err := claims.ParseLaunchClaims(launchData)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("require roles: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			if !roles.HasAnyRole(launchClaims.Roles, requiredRoles...) {
+				http.Error(w, "user lacks the roles required for this request", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/login"
+)
+
+// A Stage identifies the launch validation step that failed.
+type Stage string
+
+// The stages of launch validation, in the order they are performed.
+const (
+	StageRequest              Stage = "request"
+	StagePlatformError        Stage = "platform_error"
+	StageToken                Stage = "token"
+	StageAlgorithm            Stage = "algorithm"
+	StageTokenHeaders         Stage = "token_headers"
+	StageRegistration         Stage = "registration"
+	StageRegistrationSecurity Stage = "registration_security"
+	StageSignature            Stage = "signature"
+	StageTimestamps           Stage = "timestamps"
+	StageState                Stage = "state"
+	StageClientID             Stage = "client_id"
+	StageClaimSecurity        Stage = "claim_security"
+	StageReplay               Stage = "replay"
+	StageNonce                Stage = "nonce"
+	StageDeployment           Stage = "deployment"
+	StageMessageType          Stage = "message_type"
+	StageMessageClaims        Stage = "message_claims"
+	StageClaimGroups          Stage = "claim_groups"
+	StageLaunchData           Stage = "launch_data"
+)
+
+// A SupportBundle describes a failed launch for support staff. It is sanitized: it holds the names of the claims that
+// were present but none of their values, apart from the identifiers needed to find the platform's registration and
+// the platform's product information. The claims are read from the id_token without verifying its signature, so
+// they may have been forged when the failure is in or before StageSignature.
+type SupportBundle struct {
+	Time         time.Time
+	Stage        Stage
+	Error        string
+	StatusCode   int
+	Issuer       string
+	ClientID     string
+	DeploymentID string
+	MessageType  string
+	ClaimNames   []string
+	Platform     PlatformInfo
+
+	// CorrelationID is the correlation ID of the JSON error response, when the Launch's JSONErrors is set.
+	CorrelationID string
+}
+
+// PlatformInfo is the product information found in the tool_platform claim.
+type PlatformInfo struct {
+	Name              string
+	ProductFamilyCode string
+	Version           string
+}
+
+// A FailureHook receives the support bundle of each failed launch, e.g., to log it or to make it available to support
+// staff. The request is provided for its context; its form values must not be recorded, since they hold the id_token.
+type FailureHook func(r *http.Request, bundle SupportBundle)
+
+// fail responds with the error, using the ErrorHandler if it is set, or else a JSON error or plain text, and, if a
+// failure hook is set, passes the failed launch's support bundle to it.
+func (l *Launch) fail(w http.ResponseWriter, r *http.Request, stage Stage, rawToken []byte, statusCode int, err error) {
+	var correlationID string
+	if l.JSONErrors && l.ErrorHandler == nil {
+		correlationID = login.CorrelationID(r)
+	}
+
+	if l.FailureHook != nil {
+		bundle := newSupportBundle(rawToken)
+		bundle.Time = datastore.Now(l.cfg.Clock)
+		bundle.Stage = stage
+		bundle.Error = err.Error()
+		bundle.StatusCode = statusCode
+		bundle.CorrelationID = correlationID
+		l.FailureHook(r, bundle)
+	}
+	l.finishTiming(r, stage)
+
+	if l.ErrorHandler != nil {
+		l.ErrorHandler(w, r, statusCode, err)
+		return
+	}
+	if l.JSONErrors {
+		login.WriteJSONError(w, statusCode, errorCode(stage, err), correlationID, err)
+		return
+	}
+	http.Error(w, err.Error(), statusCode)
+}
+
+// newSupportBundle returns a support bundle describing the unverified claims of the raw token. Any part of the token
+// that cannot be decoded is left out.
+func newSupportBundle(rawToken []byte) SupportBundle {
+	var bundle SupportBundle
+
+	parts := bytes.Split(rawToken, []byte("."))
+	if len(parts) != 3 {
+		return bundle
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return bundle
+	}
+	var tokenClaims map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &tokenClaims); err != nil {
+		return bundle
+	}
+
+	for name := range tokenClaims {
+		bundle.ClaimNames = append(bundle.ClaimNames, name)
+	}
+	sort.Strings(bundle.ClaimNames)
+
+	json.Unmarshal(tokenClaims["iss"], &bundle.Issuer)
+	json.Unmarshal(tokenClaims[claims.DeploymentID], &bundle.DeploymentID)
+	json.Unmarshal(tokenClaims[claims.MessageType], &bundle.MessageType)
+
+	// The audience may be a single value or an array; the client ID is its first value.
+	var audience []string
+	if json.Unmarshal(tokenClaims["aud"], &audience) == nil && len(audience) > 0 {
+		bundle.ClientID = audience[0]
+	} else {
+		json.Unmarshal(tokenClaims["aud"], &bundle.ClientID)
+	}
+
+	var platform struct {
+		Name              string `json:"name"`
+		ProductFamilyCode string `json:"product_family_code"`
+		Version           string `json:"version"`
+	}
+	if json.Unmarshal(tokenClaims[claims.ToolPlatform], &platform) == nil {
+		bundle.Platform = PlatformInfo(platform)
+	}
+
+	return bundle
+}
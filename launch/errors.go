@@ -0,0 +1,153 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/keyset"
+	"github.com/macewan-cs/lti/login"
+)
+
+// The errors of launch validation. The error reported for a failed launch wraps one of them, so that a tool can use
+// errors.Is to tell the failures apart, e.g., to render an appropriate page or to count them. Failures reported by a
+// datastore wrap its errors instead, e.g., datastore.ErrNonceNotFound, datastore.ErrDeploymentNotFound and
+// datastore.ErrInsecureURI. An id_token whose aud and azp claims do not identify its client ID is reported with
+// claims.ErrInvalidAudience, and a signing key that replaces a pinned one with keyset.ErrKeySubstituted.
+var (
+	// ErrPlatformError is returned when the platform posts an OIDC error response instead of an id_token. The error
+	// wraps a *PlatformError holding the platform's error code and description.
+	ErrPlatformError = errors.New("platform could not complete the launch")
+
+	// ErrInvalidToken is returned when the id_token is missing or cannot be parsed.
+	ErrInvalidToken = errors.New("invalid id_token")
+
+	// ErrTokenTooLarge is returned when the id_token is longer than the Launch's maximum token length.
+	ErrTokenTooLarge = errors.New("id_token too large")
+
+	// ErrUnknownIssuer is returned when no registration is found for the issuer and client ID of the id_token.
+	ErrUnknownIssuer = errors.New("no registration found for issuer")
+
+	// ErrAlgorithmNotPermitted is returned when the id_token is signed with an algorithm that is not accepted.
+	ErrAlgorithmNotPermitted = errors.New("id_token signature algorithm not permitted")
+
+	// ErrTokenHeaderNotPermitted is returned in strict token mode when the id_token's headers are not permitted.
+	ErrTokenHeaderNotPermitted = errors.New("id_token header not permitted")
+
+	// ErrKeysetUnavailable is returned when the platform's keyset cannot be retrieved.
+	ErrKeysetUnavailable = errors.New("platform keyset unavailable")
+
+	// ErrInvalidSignature is returned when the id_token's signature cannot be verified with the platform's keyset.
+	ErrInvalidSignature = errors.New("invalid id_token signature")
+
+	// ErrInvalidTimestamps is returned when the id_token has expired, is not yet valid or was issued in the future.
+	ErrInvalidTimestamps = errors.New("id_token expired or not yet valid")
+
+	// ErrStateMismatch is returned when the state cookie is missing or does not match the state of the request.
+	ErrStateMismatch = errors.New("state validation failed")
+
+	// ErrStaleLaunch is returned when the launch's state or nonce has expired, typically because the launch was
+	// resumed long after the login, e.g., by reloading a page left open. The user must relaunch the tool.
+	ErrStaleLaunch = errors.New("launch has expired, relaunch the tool from the platform")
+
+	// ErrClientIDMismatch is returned when the id_token's audience does not include the registration's client ID.
+	ErrClientIDMismatch = errors.New("client ID not registered for this issuer")
+
+	// ErrMissingClaim is returned when a claim required by the launch is absent.
+	ErrMissingClaim = errors.New("required claim not found in request")
+
+	// ErrMalformedClaim is returned when a claim is present but improperly formatted.
+	ErrMalformedClaim = errors.New("claim improperly formatted")
+
+	// ErrUnsupportedVersion is returned when the launch is not for a supported LTI version.
+	ErrUnsupportedVersion = errors.New("compatible version not found in request")
+
+	// ErrInvalidMessageType is returned when the message type is not supported.
+	ErrInvalidMessageType = errors.New("supported message type not found in request")
+)
+
+// A PlatformError is an OIDC authentication error response posted by the platform to the launch, e.g., when the user
+// is not logged in to the platform. Code is the value of the "error" parameter, e.g., "login_required", and
+// Description the optional human-readable "error_description".
+//
+// Source: https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+type PlatformError struct {
+	Code        string
+	Description string
+}
+
+// Error describes the platform error in terms suitable for showing to the user.
+func (e *PlatformError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("%v (%s)", ErrPlatformError, e.Code)
+	}
+
+	return fmt.Sprintf("%v (%s): %s", ErrPlatformError, e.Code, e.Description)
+}
+
+// Unwrap returns ErrPlatformError, so that errors.Is reports a platform error as ErrPlatformError.
+func (e *PlatformError) Unwrap() error {
+	return ErrPlatformError
+}
+
+// errorCodes maps the launch errors to the codes of JSON error responses. ErrStaleLaunch precedes the errors that a
+// stale launch also wraps.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{login.ErrMethodNotAllowed, "method_not_allowed"},
+	{ErrStaleLaunch, "stale_launch"},
+	{ErrPlatformError, "platform_error"},
+	{ErrInvalidToken, "invalid_token"},
+	{ErrTokenTooLarge, "token_too_large"},
+	{ErrUnknownIssuer, "unknown_issuer"},
+	{ErrAlgorithmNotPermitted, "algorithm_not_permitted"},
+	{ErrTokenHeaderNotPermitted, "token_header_not_permitted"},
+	{ErrKeysetUnavailable, "keyset_unavailable"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{keyset.ErrKeySubstituted, "key_substituted"},
+	{ErrInvalidTimestamps, "invalid_timestamps"},
+	{ErrStateMismatch, "state_mismatch"},
+	{claims.ErrInvalidAudience, "invalid_audience"},
+	{ErrClientIDMismatch, "client_id_mismatch"},
+	{ErrMissingClaim, "missing_claim"},
+	{ErrMalformedClaim, "malformed_claim"},
+	{ErrUnsupportedVersion, "unsupported_version"},
+	{ErrInvalidMessageType, "invalid_message_type"},
+}
+
+// errorCode returns the JSON error response code of the error of a failed launch. Errors other than the launch
+// errors, e.g., those of a datastore, are identified by the stage that failed.
+func errorCode(stage Stage, err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+
+	return string(stage)
+}
+
+// staleLaunchError reports a stale launch detected by the underlying error, e.g., datastore.ErrNonceExpired. errors.Is
+// reports it as both ErrStaleLaunch and the underlying error.
+type staleLaunchError struct {
+	err error
+}
+
+func (e staleLaunchError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrStaleLaunch, e.err)
+}
+
+func (e staleLaunchError) Is(target error) bool {
+	return target == ErrStaleLaunch
+}
+
+func (e staleLaunchError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/macewan-cs/lti/claims"
+)
+
+// A ClaimFilter modifies the launch claims before they are persisted. It receives the decoded id_token payload and
+// returns the claims to store.
+type ClaimFilter func(claims map[string]interface{}) map[string]interface{}
+
+// requiredClaims lists the claims that the library relies upon after the launch, e.g., to find the registration for a
+// connector. AllowClaims always retains them.
+var requiredClaims = []string{
+	"iss",
+	"sub",
+	"aud",
+	"azp",
+	"exp",
+	"iat",
+	"nonce",
+	claims.DeploymentID,
+	claims.MessageType,
+	claims.Version,
+}
+
+// RedactClaims returns a ClaimFilter that removes the named claims, e.g., "email" or "name", from the stored launch
+// data.
+func RedactClaims(names ...string) ClaimFilter {
+	return func(launchClaims map[string]interface{}) map[string]interface{} {
+		for _, name := range names {
+			delete(launchClaims, name)
+		}
+
+		return launchClaims
+	}
+}
+
+// AllowClaims returns a ClaimFilter that stores only the named claims. The claims required by the library for
+// subsequent service requests are always kept. Service claims, such as the AGS endpoint claim, must be named
+// explicitly if the tool uses those services.
+func AllowClaims(names ...string) ClaimFilter {
+	// Copy the names, so that appending the required claims cannot write into the caller's backing array.
+	allowedNames := make([]string, 0, len(names)+len(requiredClaims))
+	allowedNames = append(append(allowedNames, names...), requiredClaims...)
+	allowed := map[string]bool{}
+	for _, name := range allowedNames {
+		allowed[name] = true
+	}
+
+	return func(launchClaims map[string]interface{}) map[string]interface{} {
+		for name := range launchClaims {
+			if !allowed[name] {
+				delete(launchClaims, name)
+			}
+		}
+
+		return launchClaims
+	}
+}
+
+// filterLaunchData applies a ClaimFilter to the launch data. A nil filter leaves the launch data unchanged.
+func filterLaunchData(launchData json.RawMessage, filter ClaimFilter) (json.RawMessage, int, error) {
+	if filter == nil {
+		return launchData, http.StatusOK, nil
+	}
+
+	var launchClaims map[string]interface{}
+	err := json.Unmarshal(launchData, &launchClaims)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("filter launch data: %w", err)
+	}
+
+	filteredLaunchData, err := json.Marshal(filter(launchClaims))
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("filter launch data: %w", err)
+	}
+
+	return json.RawMessage(filteredLaunchData), http.StatusOK, nil
+}
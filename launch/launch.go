@@ -8,39 +8,184 @@ package launch
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
 	"github.com/macewan-cs/lti/datastore"
 	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/keyset"
 	"github.com/macewan-cs/lti/login"
 )
 
 // A Launch implements an external application's role in the LTI specification's launch flow.
 type Launch struct {
-	cfg  datastore.Config
-	next http.HandlerFunc
+	cfg      datastore.Config
+	next     http.HandlerFunc
+	callback Callback
+
+	// ClaimFilter, if set, is applied to the launch claims before they are stored as launch data. The verified
+	// token itself is not modified. See RedactClaims and AllowClaims.
+	ClaimFilter ClaimFilter
+
+	// RequiredClaimGroups lists the groups of identity claims that must be present in the id_token. A launch
+	// missing any claim in these groups is rejected. Claims outside these groups are optional, so platforms
+	// configured to withhold personal information (e.g., for anonymous launches) are otherwise accepted.
+	RequiredClaimGroups []login.ClaimGroup
+
+	// FailureHook, if set, receives a sanitized SupportBundle describing each failed launch, so that support staff
+	// can diagnose launch failures without asking the platform's administrators for details.
+	FailureHook FailureHook
+
+	// ErrorHandler, if set, responds to failed launches in place of http.Error, e.g., to render a branded error page
+	// without showing the error's internal details to the user. Errors wrap the launch errors (e.g., ErrInvalidToken),
+	// so that the handler can tell them apart. The same handler may serve a Login.
+	ErrorHandler login.ErrorHandler
+
+	// JSONErrors, if set, makes failed launches respond with a login.JSONError instead of plain text, e.g., for tools
+	// whose pages in the platform's iframe are single-page applications. The ErrorHandler takes precedence. The
+	// error's correlation ID is also recorded in the SupportBundle passed to the FailureHook.
+	JSONErrors bool
+
+	// ClockSkew is the leeway allowed when checking the id_token's exp, iat and nbf times against the tool's clock,
+	// e.g., for platforms or proxies with skewed clocks. When it is zero, DefaultClockSkew is used.
+	ClockSkew time.Duration
+
+	// MaximumTokenAge, if set, rejects id_tokens issued (iat) longer ago than this, even if they have not expired,
+	// e.g., to bound how long a captured id_token remains usable on platforms issuing long-lived tokens.
+	MaximumTokenAge time.Duration
+
+	// Algorithms lists the JWS algorithms accepted for id_token signatures. When it is empty, DefaultAlgorithms is
+	// used. The "none" algorithm and symmetric (HS*) algorithms are rejected even if listed, since an id_token must
+	// be verifiable with the platform's public keys.
+	Algorithms []string
+
+	// MaximumTokenLength is the length, in bytes, of the longest id_token accepted. Longer id_tokens are rejected
+	// with ErrTokenTooLarge before they are parsed. When it is zero, DefaultMaximumTokenLength is used.
+	MaximumTokenLength int
+
+	// LaunchIDGenerator, if set, generates the launch ID under which the launch data is stored, e.g., to give the IDs
+	// of each tenant a namespace of their own. When it is nil, a random UUID is used.
+	LaunchIDGenerator LaunchIDGenerator
+
+	// LaunchIDPrefix is prepended to each generated launch ID. When it is empty, DefaultLaunchIDPrefix is used.
+	LaunchIDPrefix string
+
+	// KeyPins, if set, pins the key of each key ID that a platform signs id_tokens with, and rejects an id_token whose
+	// key ID is bound to a different key in the platform's keyset with keyset.ErrKeySubstituted.
+	KeyPins *keyset.Pins
+
+	// Keysets caches the platforms' keysets used to verify id_tokens. When it is nil, keyset.DefaultCache is used.
+	Keysets *keyset.Cache
+
+	// TimingHook, if set, receives the Timing of each launch, breaking down the time spent in each stage, retrieving
+	// the platform's keyset and calling the datastore.
+	TimingHook TimingHook
+
+	// timer records the Timing of the launch being served, in the copy of the Launch made for it when TimingHook is
+	// set.
+	timer *launchTimer
+
+	// ServerSideState, if set, checks the state of each launch against the Config's States store instead of the state
+	// cookie, for logins that keep the state on the server (see login.Login.ServerSideState). The state must have been
+	// stored with the id_token's nonce, and it can be used once. Without the cookie, the state no longer ties the
+	// launch to the browser that started the login; the nonce still ties it to the login.
+	ServerSideState bool
+
+	// StateSigner, if set, verifies the signed states issued by a login.Login with a StateSigner of the same key, and
+	// checks the id_token's nonce and target link URI against those carried by the state, instead of the state cookie
+	// and the Config's Nonces store. It takes precedence over ServerSideState.
+	StateSigner *login.StateSigner
+
+	// PlatformStorage, if set, reads the state back from the platform's storage frame, where a login.Login with
+	// PlatformStorage stored it, when the state cookie is missing and the platform names the frame in the launch (see
+	// login.StorageTargetParameter). The launch then responds with a page that reads the state through the LTI
+	// platform storage postMessage API and posts the launch again, with the state found. Since the browser posts the
+	// state read back, it is checked against the Config's States store, where the login stored it with its nonce, as
+	// with ServerSideState: it must have been stored with the id_token's nonce, and it can be used once. It does not
+	// tie the launch to the browser that started the login as the cookie does; the nonce still ties it to the login.
+	PlatformStorage bool
 }
 
+// A LaunchIDGenerator generates the ID of a launch from its verified claims. The ID must identify the launch data
+// uniquely, since launch data stored under an existing ID replaces it.
+type LaunchIDGenerator func(claims map[string]interface{}) (string, error)
+
+// DefaultLaunchIDPrefix is prepended to launch IDs when a Launch's LaunchIDPrefix is empty.
+const DefaultLaunchIDPrefix = "lti1p3-launch-"
+
+// DefaultAlgorithms lists the JWS algorithms accepted when a Launch's Algorithms is empty. The LTI Security Framework
+// requires platforms to sign with RS256.
+var DefaultAlgorithms = []string{jwa.RS256.String()}
+
+// DefaultMaximumTokenLength is the length, in bytes, of the longest id_token accepted when a Launch's
+// MaximumTokenLength is zero. It leaves ample room for the claims of a typical launch, including custom parameters.
+const DefaultMaximumTokenLength = 64 * 1024
+
+// requestBodyHeadroom is the length, in bytes, allowed in the body of a launch request beyond the maximum token length,
+// for the state and the other parameters posted with the id_token.
+const requestBodyHeadroom = 16 * 1024
+
+// DefaultClockSkew is the leeway allowed for the id_token's times when a Launch's ClockSkew is zero.
+const DefaultClockSkew = time.Minute * 2
+
 // ContextKeyType is used as the key to store the launch ID in the request context.
 type ContextKeyType string
 
 // ContextKey is the actual value used for the context key.
 const ContextKey = ContextKeyType("LaunchID")
 
+// TokenContextKey is the context key used to attach the verified id_token of a successful launch to the request
+// context.
+const TokenContextKey = ContextKeyType("Token")
+
+// The LTI message types that can be launched. The message type of a launch is found in the message_type claim.
+const (
+	MessageTypeResourceLink     = claims.MessageTypeResourceLink
+	MessageTypeDeepLinking      = claims.MessageTypeDeepLinking
+	MessageTypeSubmissionReview = claims.MessageTypeSubmissionReview
+	MessageTypeStartProctoring  = claims.MessageTypeStartProctoring
+	MessageTypeEndAssessment    = claims.MessageTypeEndAssessment
+)
+
+// supportedMessageTypes maps each supported message type to the validation of its message-specific claims.
+var supportedMessageTypes = map[string]func(jwt.Token) (int, error){
+	MessageTypeResourceLink:     validateResourceLink,
+	MessageTypeDeepLinking:      validateDeepLinkingSettings,
+	MessageTypeSubmissionReview: validateSubmissionReview,
+	MessageTypeStartProctoring:  validateStartProctoring,
+	MessageTypeEndAssessment:    validateEndAssessment,
+}
+
+// strictAlgorithms lists the signature algorithms accepted in strict mode. Only asymmetric algorithms are listed, since
+// an id_token must be verifiable with the platform's public keys.
+var strictAlgorithms = []string{
+	jwa.RS256.String(), jwa.RS384.String(), jwa.RS512.String(),
+	jwa.PS256.String(), jwa.PS384.String(), jwa.PS512.String(),
+	jwa.ES256.String(), jwa.ES384.String(), jwa.ES512.String(),
+}
+
 var (
 	maximumResourceLinkIDLength = 255
-	supportedLTIVersion         = "1.3.0"
-	launchIDPrefix              = "lti1p3-launch-"
+	supportedLTIVersion         = claims.LTIVersion
 )
 
+// defaultReplayRetention is the time for which an id_token without an exp claim is recorded as used.
+const defaultReplayRetention = time.Hour
+
 // New creates a *Launch, which implements the http.Handler interface for launching a tool.
 func New(cfg datastore.Config, next http.HandlerFunc) *Launch {
 	launch := Launch{
@@ -57,10 +202,41 @@ func New(cfg datastore.Config, next http.HandlerFunc) *Launch {
 	if launch.cfg.Nonces == nil {
 		launch.cfg.Nonces = nonpersistent.DefaultStore
 	}
+	if launch.cfg.Replays == nil {
+		launch.cfg.Replays = nonpersistent.DefaultStore
+	}
+	if launch.cfg.States == nil {
+		launch.cfg.States = nonpersistent.DefaultStore
+	}
 
 	return &launch
 }
 
+// A Callback handles a successful launch, given the launch ID and the claims of the launch data, i.e., after the
+// Launch's ClaimFilter is applied.
+type Callback func(w http.ResponseWriter, r *http.Request, launchID string, launchClaims claims.LaunchClaims)
+
+// NewWithCallback is like New, but on a successful launch it calls the callback with the launch ID and the parsed
+// claims instead of calling a handler, for applications that do not read the launch ID from the request context.
+func NewWithCallback(cfg datastore.Config, callback Callback) *Launch {
+	launch := New(cfg, nil)
+	launch.callback = callback
+
+	return launch
+}
+
+// NewStrict is like New, but it returns an error wrapping datastore.ErrStoreNotConfigured instead of falling back on
+// the nonpersistent default store when the Config's launch data, registrations, nonces or replays store is nil.
+func NewStrict(cfg datastore.Config, next http.HandlerFunc) (*Launch, error) {
+	err := cfg.RequireStores(datastore.LaunchDataStore, datastore.RegistrationsStore, datastore.NoncesStore,
+		datastore.ReplaysStore)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cfg, next), nil
+}
+
 // ServeHTTP performs validations according the OIDC launch flow modified for use by the IMS Global LTI v1p3
 // specifications. State is found in a user agent cookie and the POST body. Nonce is found embedded in the id_token and
 // in a datastore.
@@ -71,94 +247,278 @@ func (l *Launch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err           error
 		registration  datastore.Registration
 		verifiedToken jwt.Token
+		messageType   string
 		launchData    json.RawMessage
 	)
 
-	if rawToken, statusCode, err = getRawToken(r); err != nil {
-		http.Error(w, err.Error(), statusCode)
+	if l.TimingHook != nil {
+		l = l.withTimer()
+	}
+
+	l.timer.begin(StageRequest)
+	if statusCode, err = useRequestValues(w, r, l); err != nil {
+		l.fail(w, r, StageRequest, nil, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StagePlatformError)
+	if statusCode, err = validatePlatformError(r); err != nil {
+		l.fail(w, r, StagePlatformError, nil, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageToken)
+	if rawToken, statusCode, err = getRawToken(r, l); err != nil {
+		l.fail(w, r, StageToken, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageAlgorithm)
+	if statusCode, err = validateAlgorithm(rawToken, l); err != nil {
+		l.fail(w, r, StageAlgorithm, rawToken, statusCode, err)
 		return
 	}
 
+	l.timer.begin(StageTokenHeaders)
+	if statusCode, err = validateTokenHeaders(rawToken, l); err != nil {
+		l.fail(w, r, StageTokenHeaders, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageRegistration)
 	if registration, statusCode, err = validateRegistration(rawToken, l, r); err != nil {
-		http.Error(w, err.Error(), statusCode)
+		l.fail(w, r, StageRegistration, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageRegistrationSecurity)
+	if statusCode, err = validateRegistrationSecurity(registration, l); err != nil {
+		l.fail(w, r, StageRegistrationSecurity, rawToken, statusCode, err)
 		return
 	}
 
-	if verifiedToken, statusCode, err = validateSignature(rawToken, registration, r); err != nil {
-		http.Error(w, err.Error(), statusCode)
+	l.timer.begin(StageSignature)
+	if verifiedToken, statusCode, err = validateSignature(rawToken, registration, l); err != nil {
+		l.fail(w, r, StageSignature, rawToken, statusCode, err)
 		return
 	}
 
-	if statusCode, err = validateState(r); err != nil {
-		http.Error(w, err.Error(), statusCode)
+	l.timer.begin(StageTimestamps)
+	if statusCode, err = validateTimestamps(verifiedToken, l); err != nil {
+		l.fail(w, r, StageTimestamps, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageState)
+	if l.needsPlatformStorage(r) {
+		// Without the state cookie, the state is read back from the platform's storage frame by a page that posts the
+		// launch again.
+		if err = writePlatformStoragePage(w, r, registration); err != nil {
+			l.fail(w, r, StageState, rawToken, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if statusCode, err = validateState(r, verifiedToken, l); err != nil {
+		l.fail(w, r, StageState, rawToken, statusCode, err)
 		return
 	}
 
+	l.timer.begin(StageClientID)
 	if statusCode, err = validateClientID(verifiedToken, registration); err != nil {
-		http.Error(w, err.Error(), statusCode)
+		l.fail(w, r, StageClientID, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageClaimSecurity)
+	if statusCode, err = validateClaimSecurity(verifiedToken, l); err != nil {
+		l.fail(w, r, StageClaimSecurity, rawToken, statusCode, err)
 		return
 	}
 
+	l.timer.begin(StageReplay)
+	if statusCode, err = validateReplay(rawToken, verifiedToken, l); err != nil {
+		l.fail(w, r, StageReplay, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageNonce)
 	if statusCode, err = validateNonceAndTargetLinkURI(verifiedToken, l); err != nil {
-		http.Error(w, err.Error(), statusCode)
+		l.fail(w, r, StageNonce, rawToken, statusCode, err)
 		return
 	}
 
+	l.timer.begin(StageDeployment)
 	if statusCode, err = validateDeploymentID(verifiedToken, l); err != nil {
-		http.Error(w, err.Error(), statusCode)
+		l.fail(w, r, StageDeployment, rawToken, statusCode, err)
+		return
+	}
+
+	l.timer.begin(StageMessageType)
+	if messageType, statusCode, err = validateVersionAndMessageType(verifiedToken); err != nil {
+		l.fail(w, r, StageMessageType, rawToken, statusCode, err)
 		return
 	}
 
-	if statusCode, err = validateVersionAndMessageType(verifiedToken); err != nil {
-		http.Error(w, err.Error(), statusCode)
+	l.timer.begin(StageMessageClaims)
+	if statusCode, err = supportedMessageTypes[messageType](verifiedToken); err != nil {
+		l.fail(w, r, StageMessageClaims, rawToken, statusCode, err)
 		return
 	}
 
-	if statusCode, err = validateResourceLink(verifiedToken); err != nil {
-		http.Error(w, err.Error(), statusCode)
+	l.timer.begin(StageClaimGroups)
+	if statusCode, err = validateClaimGroups(verifiedToken, l.RequiredClaimGroups); err != nil {
+		l.fail(w, r, StageClaimGroups, rawToken, statusCode, err)
 		return
 	}
 
+	l.timer.begin(StageLaunchData)
 	if launchData, statusCode, err = getLaunchData(rawToken); err != nil {
-		http.Error(w, err.Error(), statusCode)
+		l.fail(w, r, StageLaunchData, rawToken, statusCode, err)
 		return
 	}
 
+	if launchData, statusCode, err = filterLaunchData(launchData, l.ClaimFilter); err != nil {
+		l.fail(w, r, StageLaunchData, rawToken, statusCode, err)
+		return
+	}
+
+	// The claims are parsed before the launch data is stored, so that a launch the callback cannot be given is not
+	// stored.
+	var launchClaims claims.LaunchClaims
+	if l.callback != nil {
+		if launchClaims, err = claims.ParseLaunchClaims(launchData); err != nil {
+			l.fail(w, r, StageLaunchData, rawToken, http.StatusBadRequest, fmt.Errorf("%w: %v", ErrMalformedClaim, err))
+			return
+		}
+	}
+
 	// Store the Launch data under a unique Launch ID for future reference.
-	launchID := launchIDPrefix + uuid.New().String()
+	launchID, statusCode, err := l.generateLaunchID(verifiedToken)
+	if err != nil {
+		l.fail(w, r, StageLaunchData, rawToken, statusCode, err)
+		return
+	}
 	l.cfg.LaunchData.StoreLaunchData(launchID, launchData)
+	l.finishTiming(r, "")
 
-	// Put the launch ID in the request context for subsequent handlers.
-	r = r.WithContext(contextWithLaunchID(r.Context(), launchID))
+	// Put the launch ID and the verified token in the request context for subsequent handlers.
+	ctx := contextWithLaunchID(r.Context(), launchID)
+	r = r.WithContext(context.WithValue(ctx, TokenContextKey, verifiedToken))
+
+	if l.callback != nil {
+		l.callback(w, r, launchID, launchClaims)
+		return
+	}
 
 	l.next(w, r)
 }
 
-// getRawToken gets the OIDC id_token.
-func getRawToken(r *http.Request) ([]byte, int, error) {
-	// Decode token and check for JWT format errors without verification. An external keyset is needed for verification.
+// useRequestValues makes the request's form hold the parameters of the launch, which platforms send either in the body
+// of a POST request (response_mode=form_post) or in the query of a GET request (response_mode=query). See
+// login.RequestValues. The body is limited to the maximum token length and some headroom before it is parsed, so that
+// an oversized request is rejected with ErrTokenTooLarge without being buffered.
+func useRequestValues(w http.ResponseWriter, r *http.Request, l *Launch) (int, error) {
+	if r.Body != nil {
+		limit := int64(l.maximumTokenLength() + requestBodyHeadroom)
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+	}
+
+	err := login.UseRequestValues(r)
+	if errors.Is(err, login.ErrMethodNotAllowed) {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		return http.StatusMethodNotAllowed, err
+	}
+	if errors.Is(err, ErrTokenTooLarge) {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// A limitedBody is a request body limited by http.MaxBytesReader. Its Read reports the error of a body exceeding the
+// limit as ErrTokenTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		err = fmt.Errorf("%w: request body exceeds %d bytes", ErrTokenTooLarge, b.limit)
+	}
+
+	return n, err
+}
+
+// maximumTokenLength returns the Launch's MaximumTokenLength, or DefaultMaximumTokenLength when it is not set.
+func (l *Launch) maximumTokenLength() int {
+	if l.MaximumTokenLength <= 0 {
+		return DefaultMaximumTokenLength
+	}
+
+	return l.MaximumTokenLength
+}
+
+// validatePlatformError checks whether the platform posted an OIDC error response, which holds "error" and
+// "error_description" parameters in place of the id_token, e.g., when the user's platform session has ended.
+func validatePlatformError(r *http.Request) (int, error) {
+	code := r.FormValue("error")
+	if code == "" {
+		return http.StatusOK, nil
+	}
+
+	return http.StatusBadRequest, &PlatformError{Code: code, Description: r.FormValue("error_description")}
+}
+
+// getRawToken gets the OIDC id_token. Oversized and malformed id_tokens are rejected before they are parsed.
+func getRawToken(r *http.Request, l *Launch) ([]byte, int, error) {
 	idToken := []byte(r.FormValue("id_token"))
+	if len(idToken) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("get raw token: %w: id_token not found in request", ErrInvalidToken)
+	}
+
+	maximumTokenLength := l.maximumTokenLength()
+	if len(idToken) > maximumTokenLength {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("get raw token: %w: %d bytes exceeds %d",
+			ErrTokenTooLarge, len(idToken), maximumTokenLength)
+	}
+
+	if _, err := splitToken(idToken); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("get raw token: %w", err)
+	}
+
+	// Decode token and check for JWT format errors without verification. An external keyset is needed for verification.
 	_, err := jwt.Parse(idToken)
 	if err != nil {
-		return nil, http.StatusBadRequest, fmt.Errorf("get raw token: %w", err)
+		return nil, http.StatusBadRequest, fmt.Errorf("get raw token: %w: %v", ErrInvalidToken, err)
 	}
 
 	return idToken, http.StatusOK, nil
 }
 
-// validateRegistration finds the registration by the issuer of the token.
+// validateRegistration finds the registration by the issuer of the token and the client ID it was issued to.
 func validateRegistration(rawToken []byte, l *Launch, r *http.Request) (datastore.Registration, int, error) {
 	token, err := jwt.Parse(rawToken)
 	if err != nil {
-		return datastore.Registration{}, http.StatusBadRequest, fmt.Errorf("validate registration: %w", err)
+		return datastore.Registration{}, http.StatusBadRequest, fmt.Errorf("validate registration: %w: %v",
+			ErrInvalidToken, err)
 	}
 
 	issuer := token.Issuer()
-	clientID := token.Audience()[0]
+	clientID, err := claims.TokenClientID(token)
+	if err != nil {
+		return datastore.Registration{}, http.StatusBadRequest, fmt.Errorf("validate registration: %w", err)
+	}
 	registration, err := l.cfg.Registrations.FindRegistrationByIssuerAndClientID(issuer, clientID)
 	if err != nil {
 		if err == datastore.ErrRegistrationNotFound {
-			return datastore.Registration{}, http.StatusBadRequest, fmt.Errorf("no registration found for iss %s", issuer)
+			return datastore.Registration{}, http.StatusBadRequest, fmt.Errorf("%w %s", ErrUnknownIssuer, issuer)
 		}
 
 		return datastore.Registration{}, http.StatusInternalServerError, fmt.Errorf("validate registration: %w", err)
@@ -167,49 +527,353 @@ func validateRegistration(rawToken []byte, l *Launch, r *http.Request) (datastor
 	return registration, http.StatusOK, nil
 }
 
-// validateSignature checks the authenticity of the token.
-func validateSignature(rawToken []byte, registration datastore.Registration, r *http.Request) (jwt.Token, int, error) {
-	// Get keyset from the Platform for verification.
-	keyset, err := jwk.Fetch(context.Background(), registration.KeysetURI.String())
+// validateRegistrationSecurity checks that the registration's endpoints use HTTPS when it is strictly enforced. Since
+// the registration is part of the tool's configuration, a failure is reported as an internal server error.
+func validateRegistrationSecurity(registration datastore.Registration, l *Launch) (int, error) {
+	if !l.cfg.StrictHTTPS {
+		return http.StatusOK, nil
+	}
+
+	if err := datastore.ValidateRegistrationSecurity(registration); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("validate registration: %w", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// endpointClaims lists the claims, and the members of those claims, holding endpoints used by the tool.
+var endpointClaims = []struct {
+	claim  string
+	member string
+}{
+	{claims.TargetLinkURI, ""},
+	{claims.AGSEndpoint, "lineitems"},
+	{claims.AGSEndpoint, "lineitem"},
+	{claims.NRPSNamesRoleService, "context_memberships_url"},
+	{claims.DeepLinkingSettings, "deep_link_return_url"},
+	{claims.ProctoringStartAssessmentURL, ""},
+}
+
+// validateClaimSecurity checks that the endpoints found in the launch claims use HTTPS when it is strictly enforced.
+func validateClaimSecurity(verifiedToken jwt.Token, l *Launch) (int, error) {
+	if !l.cfg.StrictHTTPS {
+		return http.StatusOK, nil
+	}
+
+	for _, endpoint := range endpointClaims {
+		value, ok := verifiedToken.Get(endpoint.claim)
+		if !ok {
+			continue
+		}
+		if endpoint.member != "" {
+			members, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if value, ok = members[endpoint.member]; !ok {
+				continue
+			}
+		}
+
+		rawURI, ok := value.(string)
+		if !ok {
+			continue
+		}
+		uri, err := url.Parse(rawURI)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("cannot parse %s: %w", endpoint.claim, err)
+		}
+		if err := datastore.ValidateSecureURI(uri); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("validate claim %s: %w", endpoint.claim, err)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateAlgorithm checks the id_token's signature algorithm against the launch's allowlist before the signature is
+// verified, so that the algorithms accepted do not depend on the platform's keyset.
+func validateAlgorithm(rawToken []byte, l *Launch) (int, error) {
+	message, err := jws.Parse(rawToken)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("validate algorithm: %w: %v", ErrInvalidToken, err)
+	}
+	signatures := message.Signatures()
+	if len(signatures) != 1 {
+		return http.StatusBadRequest, fmt.Errorf("validate algorithm: %w: expected exactly one signature",
+			ErrInvalidToken)
+	}
+
+	algorithm := signatures[0].ProtectedHeaders().Algorithm().String()
+	if algorithm == "" || strings.EqualFold(algorithm, jwa.NoSignature.String()) ||
+		strings.HasPrefix(strings.ToUpper(algorithm), "HS") {
+		return http.StatusBadRequest, fmt.Errorf("validate algorithm: %w: %q", ErrAlgorithmNotPermitted, algorithm)
+	}
+
+	algorithms := l.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	if !contains(algorithm, algorithms) {
+		return http.StatusBadRequest, fmt.Errorf("validate algorithm: %w: %q", ErrAlgorithmNotPermitted, algorithm)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateTokenHeaders checks the id_token's protected headers when strict token validation is configured. The
+// algorithm must be asymmetric, the typ header must be "JWT" if present, and no crit header is permitted because
+// this package understands no header extensions.
+func validateTokenHeaders(rawToken []byte, l *Launch) (int, error) {
+	if !l.cfg.StrictTokens {
+		return http.StatusOK, nil
+	}
+
+	message, err := jws.Parse(rawToken)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("validate token headers: %w: %v", ErrInvalidToken, err)
+	}
+	signatures := message.Signatures()
+	if len(signatures) != 1 {
+		return http.StatusBadRequest, fmt.Errorf("validate token headers: %w: expected exactly one signature",
+			ErrTokenHeaderNotPermitted)
+	}
+	headers := signatures[0].ProtectedHeaders()
+
+	algorithm := headers.Algorithm().String()
+	if !contains(algorithm, strictAlgorithms) {
+		return http.StatusBadRequest, fmt.Errorf("validate token headers: %w: algorithm %q", ErrTokenHeaderNotPermitted,
+			algorithm)
+	}
+
+	tokenType := headers.Type()
+	if tokenType != "" && !strings.EqualFold(tokenType, "JWT") {
+		return http.StatusBadRequest, fmt.Errorf("validate token headers: %w: type %q", ErrTokenHeaderNotPermitted,
+			tokenType)
+	}
+
+	if len(headers.Critical()) != 0 {
+		return http.StatusBadRequest, fmt.Errorf("validate token headers: %w: critical headers %v",
+			ErrTokenHeaderNotPermitted, headers.Critical())
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateSignature checks the authenticity of the token with the key of the platform's keyset selected by the
+// token's key ID (see keyset.SelectKey), and checks the key against the Launch's KeyPins, if any. The keyset is taken
+// from the Launch's Keysets cache.
+func validateSignature(rawToken []byte, registration datastore.Registration, l *Launch) (jwt.Token, int, error) {
+	message, err := jws.Parse(rawToken)
 	if err != nil {
-		// Since the KeysetURI is part of the registration, a failure to retrieve it should be reported as an
-		// internal server error.
-		return nil, http.StatusInternalServerError, fmt.Errorf("validate signature: %w", err)
+		return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w: %v", ErrInvalidToken, err)
+	}
+	signatures := message.Signatures()
+	if len(signatures) != 1 {
+		return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w: expected exactly one signature",
+			ErrInvalidToken)
+	}
+	headers := signatures[0].ProtectedHeaders()
+
+	keysets := l.Keysets
+	if keysets == nil {
+		keysets = keyset.DefaultCache
+	}
+	keysetStart := time.Now()
+	key, err := keysets.Key(registration.KeysetURI.String(), headers.KeyID(), headers.Algorithm())
+	l.timer.timeKeyset(keysetStart)
+	if err != nil {
+		if errors.Is(err, keyset.ErrKeysetUnavailable) {
+			// Since the KeysetURI is part of the registration, a failure to retrieve it should be reported as an
+			// internal server error.
+			return nil, http.StatusInternalServerError, fmt.Errorf("validate signature: %w: %v",
+				ErrKeysetUnavailable, err)
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w: %v", ErrInvalidSignature, err)
 	}
 
 	// Perform the signature check.
-	verifiedToken, err := jwt.Parse(rawToken, jwt.WithKeySet(keyset))
+	verifiedToken, err := jwt.Parse(rawToken, jwt.WithVerify(headers.Algorithm(), key))
 	if err != nil {
-		return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w", err)
+		return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w: %v", ErrInvalidSignature, err)
+	}
+
+	if l.KeyPins != nil {
+		if err := l.KeyPins.Check(registration, headers.KeyID(), key); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("validate signature: %w", err)
+		}
 	}
 
 	return verifiedToken, http.StatusOK, nil
 }
 
-// validateState checks the state cookie against the state query value returned by the Platform.
-func validateState(r *http.Request) (int, error) {
+// validateTimestamps checks the token's expiration, issued at and not before times against the configured clock,
+// allowing for the launch's clock skew between platform and tool, and checks the token's age if it is limited.
+func validateTimestamps(verifiedToken jwt.Token, l *Launch) (int, error) {
+	clock := jwt.ClockFunc(func() time.Time {
+		return datastore.Now(l.cfg.Clock)
+	})
+
+	skew := l.clockSkew()
+
+	err := jwt.Validate(verifiedToken, jwt.WithClock(clock), jwt.WithAcceptableSkew(skew))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("validate timestamps: %w: %v", ErrInvalidTimestamps, err)
+	}
+
+	if l.MaximumTokenAge > 0 {
+		issuedAt := verifiedToken.IssuedAt()
+		if issuedAt.IsZero() {
+			return http.StatusBadRequest, fmt.Errorf("validate timestamps: %w: iat not found", ErrInvalidTimestamps)
+		}
+		if clock.Now().Sub(issuedAt) > l.MaximumTokenAge+skew {
+			return http.StatusBadRequest, fmt.Errorf("validate timestamps: %w: issued more than %v ago",
+				ErrInvalidTimestamps, l.MaximumTokenAge)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateState checks the state cookie, the stored or signed state (see ServerSideState and StateSigner) or the state
+// read back from platform storage (see PlatformStorage) against the state query value returned by the Platform. A
+// state that has expired is reported as ErrStaleLaunch, whether or not the browser has already discarded the cookie.
+func validateState(r *http.Request, verifiedToken jwt.Token, l *Launch) (int, error) {
+	state := r.FormValue("state")
+	if expiry, ok := login.StateExpiry(state); ok && !datastore.Now(l.cfg.Clock).Before(expiry) {
+		return http.StatusBadRequest, staleLaunchError{fmt.Errorf("%w: state expired at %v", ErrStateMismatch,
+			expiry.UTC().Format(time.RFC3339))}
+	}
+
+	if l.StateSigner != nil {
+		return validateSignedState(state, verifiedToken, l)
+	}
+	if l.ServerSideState {
+		return validateStoredState(state, verifiedToken, l)
+	}
+
 	stateCookie, err := r.Cookie(login.StateCookieName)
 	if errors.Is(err, http.ErrNoCookie) {
 		stateCookie, err = r.Cookie(login.LegacyStateCookieName)
 	}
+	if err != nil && l.PlatformStorage {
+		if _, readBack := r.Form[login.StorageStateParameter]; readBack {
+			return validatePlatformStorageState(r, state, verifiedToken, l)
+		}
+	}
 	if err != nil {
-		return http.StatusBadRequest, fmt.Errorf("cannot get cookie from request: %w", err)
+		return http.StatusBadRequest, fmt.Errorf("%w: cannot get cookie from request: %v", ErrStateMismatch, err)
 	}
 
-	state := r.FormValue("state")
 	if stateCookie.Value != state {
-		return http.StatusBadRequest, errors.New("state validation failed")
+		return http.StatusBadRequest, ErrStateMismatch
 	}
 
 	return http.StatusOK, nil
 }
 
-// validateClientID checks that the claimed client ID (aud) is listed for the claimed issuer.
+// validatePlatformStorageState checks the state read back from the platform's storage frame by the page of
+// writePlatformStoragePage against the state returned by the platform. Both are posted by the browser, so the state is
+// then checked against the States store, where the login stored it with its nonce.
+func validatePlatformStorageState(r *http.Request, state string, verifiedToken jwt.Token, l *Launch) (int, error) {
+	storedState := r.FormValue(login.StorageStateParameter)
+	if storedState == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: state not found in platform storage", ErrStateMismatch)
+	}
+	if storedState != state {
+		return http.StatusBadRequest, ErrStateMismatch
+	}
+
+	return validateStoredState(state, verifiedToken, l)
+}
+
+// validateStoredState checks the state against the States store, which clears it, and that it was stored with the
+// nonce of the id_token.
+func validateStoredState(state string, verifiedToken jwt.Token, l *Launch) (int, error) {
+	if state == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: state not found in request", ErrStateMismatch)
+	}
+	nonce, _ := verifiedToken.Get("nonce")
+	nonceString, _ := nonce.(string)
+	if nonceString == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: nonce", ErrMissingClaim)
+	}
+
+	err := l.cfg.States.TestAndClearState(state, nonceString)
+	if err != nil {
+		if err == datastore.ErrStateExpired {
+			return http.StatusBadRequest, staleLaunchError{fmt.Errorf("%w: %v", ErrStateMismatch, err)}
+		}
+		if err == datastore.ErrStateNotFound || err == datastore.ErrStateNonceMismatch {
+			return http.StatusBadRequest, fmt.Errorf("%w: %v", ErrStateMismatch, err)
+		}
+
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateSignedState verifies the signed state and checks the nonce and target link URI that it carries against
+// those of the id_token.
+func validateSignedState(state string, verifiedToken jwt.Token, l *Launch) (int, error) {
+	signedState, err := l.StateSigner.Verify(state, datastore.Now(l.cfg.Clock))
+	if errors.Is(err, login.ErrSignedStateExpired) {
+		return http.StatusBadRequest, staleLaunchError{fmt.Errorf("%w: %v", ErrStateMismatch, err)}
+	}
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("%w: %v", ErrStateMismatch, err)
+	}
+
+	nonce, _ := verifiedToken.Get("nonce")
+	if nonce != signedState.Nonce {
+		return http.StatusBadRequest, fmt.Errorf("%w: nonce does not match the signed state", ErrStateMismatch)
+	}
+	targetLinkURI, _ := verifiedToken.Get(claims.TargetLinkURI)
+	if targetLinkURI != signedState.TargetLinkURI {
+		return http.StatusBadRequest, fmt.Errorf("%w: target link URI does not match the signed state",
+			ErrStateMismatch)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateClientID checks that the verified token was issued to the registration's client ID, by its aud and azp
+// claims.
 func validateClientID(verifiedToken jwt.Token, registration datastore.Registration) (int, error) {
-	audience := verifiedToken.Audience()
-	found := contains(registration.ClientID, audience)
-	if !found {
-		return http.StatusBadRequest, errors.New("client ID not registered for this issuer")
+	clientID, err := claims.TokenClientID(verifiedToken)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("validate client ID: %w", err)
+	}
+	if clientID != registration.ClientID {
+		return http.StatusBadRequest, ErrClientIDMismatch
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateReplay records the id_token as used, and rejects it if it has been used before. The token is identified by
+// its issuer and its jti claim or, if it has none, a hash of the token. It is recorded until it expires, allowing for
+// the clock skew, since it is rejected by the timestamp check after that.
+func validateReplay(rawToken []byte, verifiedToken jwt.Token, l *Launch) (int, error) {
+	tokenID := verifiedToken.JwtID()
+	if tokenID == "" {
+		hash := sha256.Sum256(rawToken)
+		tokenID = "sha256:" + hex.EncodeToString(hash[:])
+	}
+
+	expiry := verifiedToken.Expiration()
+	if expiry.IsZero() {
+		expiry = datastore.Now(l.cfg.Clock).Add(defaultReplayRetention)
+	}
+	expiry = expiry.Add(l.clockSkew())
+
+	err := l.cfg.Replays.StoreTokenID(verifiedToken.Issuer()+"\x00"+tokenID, expiry)
+	if err != nil {
+		if errors.Is(err, datastore.ErrTokenReplayed) {
+			return http.StatusBadRequest, fmt.Errorf("validate replay: %w", err)
+		}
+		return http.StatusInternalServerError, fmt.Errorf("validate replay: %w", err)
 	}
 
 	return http.StatusOK, nil
@@ -218,17 +882,25 @@ func validateClientID(verifiedToken jwt.Token, registration datastore.Registrati
 // validateNonceAndTargetLinkURI verifies that the TargetLinkURI provided during the initial (login) auth request and
 // the id_token matches, and in the process, it checks that the nonce also exists.
 func validateNonceAndTargetLinkURI(verifiedToken jwt.Token, l *Launch) (int, error) {
-	targetLinkURI, ok := verifiedToken.Get("https://purl.imsglobal.org/spec/lti/claim/target_link_uri")
+	if l.StateSigner != nil {
+		// The nonce is not stored; validateSignedState has checked it against the signed state.
+		return http.StatusOK, nil
+	}
+
+	targetLinkURI, ok := verifiedToken.Get(claims.TargetLinkURI)
 	if !ok {
-		return http.StatusBadRequest, errors.New("target link URI not found in request")
+		return http.StatusBadRequest, fmt.Errorf("%w: target link URI", ErrMissingClaim)
 	}
 
 	nonce, ok := verifiedToken.Get("nonce")
 	if !ok {
-		return http.StatusBadRequest, errors.New("nonce not found in request")
+		return http.StatusBadRequest, fmt.Errorf("%w: nonce", ErrMissingClaim)
 	}
 	err := l.cfg.Nonces.TestAndClearNonce(nonce.(string), targetLinkURI.(string))
 	if err != nil {
+		if err == datastore.ErrNonceExpired {
+			return http.StatusBadRequest, staleLaunchError{err}
+		}
 		if err == datastore.ErrNonceNotFound || err == datastore.ErrNonceTargetLinkURIMismatch {
 			return http.StatusBadRequest, err
 		}
@@ -241,9 +913,9 @@ func validateNonceAndTargetLinkURI(verifiedToken jwt.Token, l *Launch) (int, err
 
 // validateDeploymentID verifies that the deployment ID exists under the issuer.
 func validateDeploymentID(verifiedToken jwt.Token, l *Launch) (int, error) {
-	deploymentID, ok := verifiedToken.Get("https://purl.imsglobal.org/spec/lti/claim/deployment_id")
+	deploymentID, ok := verifiedToken.Get(claims.DeploymentID)
 	if !ok {
-		return http.StatusBadRequest, errors.New("deployment not found in request")
+		return http.StatusBadRequest, fmt.Errorf("%w: deployment ID", ErrMissingClaim)
 	}
 
 	_, err := l.cfg.Registrations.FindDeployment(verifiedToken.Issuer(), deploymentID.(string))
@@ -258,46 +930,179 @@ func validateDeploymentID(verifiedToken jwt.Token, l *Launch) (int, error) {
 	return http.StatusOK, nil
 }
 
-// validateVersionAndMessageType checks for a valid version and message type. Only 'Resource link launch request'
-// (LtiResourceLinkRequest) is currently supported.
-func validateVersionAndMessageType(verifiedToken jwt.Token) (int, error) {
-	ltiVersion, ok := verifiedToken.Get("https://purl.imsglobal.org/spec/lti/claim/version")
+// validateVersionAndMessageType checks for a valid version and message type, and returns the message type. Resource
+// link launch requests (LtiResourceLinkRequest), deep linking requests (LtiDeepLinkingRequest) and submission review
+// requests (LtiSubmissionReviewRequest) are supported.
+func validateVersionAndMessageType(verifiedToken jwt.Token) (string, int, error) {
+	ltiVersion, ok := verifiedToken.Get(claims.Version)
 	if !ok {
-		return http.StatusBadRequest, errors.New("LTI version not found in request")
+		return "", http.StatusBadRequest, fmt.Errorf("%w: LTI version", ErrMissingClaim)
 	}
 	if ltiVersion != supportedLTIVersion {
-		return http.StatusBadRequest, errors.New("compatible version not found in request")
+		return "", http.StatusBadRequest, ErrUnsupportedVersion
 	}
 
-	messageType, ok := verifiedToken.Get("https://purl.imsglobal.org/spec/lti/claim/message_type")
+	rawMessageType, ok := verifiedToken.Get(claims.MessageType)
+	if !ok {
+		return "", http.StatusBadRequest, fmt.Errorf("%w: message type", ErrMissingClaim)
+	}
+	messageType, ok := rawMessageType.(string)
 	if !ok {
-		return http.StatusBadRequest, errors.New("message type not found in request")
+		return "", http.StatusBadRequest, fmt.Errorf("%w: message type", ErrMalformedClaim)
 	}
-	if messageType.(string) != "LtiResourceLinkRequest" {
-		return http.StatusBadRequest, errors.New("supported message type not found in request")
+	if _, ok := supportedMessageTypes[messageType]; !ok {
+		return "", http.StatusBadRequest, ErrInvalidMessageType
 	}
 
-	return http.StatusOK, nil
+	return messageType, http.StatusOK, nil
 }
 
 // validateResourceLink verifies the resource link and ID.
 func validateResourceLink(verifiedToken jwt.Token) (int, error) {
-	rawResourceLink, ok := verifiedToken.Get("https://purl.imsglobal.org/spec/lti/claim/resource_link")
+	rawResourceLink, ok := verifiedToken.Get(claims.ResourceLink)
 	if !ok {
-		return http.StatusBadRequest, errors.New("resource link not found in request")
+		return http.StatusBadRequest, fmt.Errorf("%w: resource link", ErrMissingClaim)
 	}
 
 	resourceLink, ok := rawResourceLink.(map[string]interface{})
 	if !ok {
-		return http.StatusBadRequest, errors.New("resource link improperly formatted")
+		return http.StatusBadRequest, fmt.Errorf("%w: resource link", ErrMalformedClaim)
 	}
 
 	resourceLinkID, ok := resourceLink["id"]
 	if !ok {
-		return http.StatusBadRequest, errors.New("resource link ID not found")
+		return http.StatusBadRequest, fmt.Errorf("%w: resource link ID", ErrMissingClaim)
 	}
 	if len(resourceLinkID.(string)) > maximumResourceLinkIDLength {
-		return http.StatusBadRequest, fmt.Errorf("resource link ID exceeds maximum length (%d)", maximumResourceLinkIDLength)
+		return http.StatusBadRequest, fmt.Errorf("%w: resource link ID exceeds maximum length (%d)", ErrMalformedClaim,
+			maximumResourceLinkIDLength)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateDeepLinkingSettings verifies the deep linking settings of a deep linking request. The return URL and the
+// accepted types and presentation targets are required.
+// Source: https://www.imsglobal.org/spec/lti-dl/v2p0#deep-linking-settings.
+func validateDeepLinkingSettings(verifiedToken jwt.Token) (int, error) {
+	rawSettings, ok := verifiedToken.Get(claims.DeepLinkingSettings)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: deep linking settings", ErrMissingClaim)
+	}
+
+	settings, ok := rawSettings.(map[string]interface{})
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: deep linking settings", ErrMalformedClaim)
+	}
+
+	returnURL, ok := settings["deep_link_return_url"].(string)
+	if !ok || returnURL == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: deep link return URL", ErrMissingClaim)
+	}
+	for _, name := range []string{"accept_types", "accept_presentation_document_targets"} {
+		values, ok := settings[name].([]interface{})
+		if !ok || len(values) == 0 {
+			return http.StatusBadRequest, fmt.Errorf("%w: deep linking settings %s", ErrMissingClaim, name)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateSubmissionReview verifies a submission review request: in addition to the resource link, it requires the
+// user whose submission is reviewed (for_user) and the launched lineitem.
+// Source: https://www.imsglobal.org/spec/lti-ags/v2p0#submission-review-message.
+func validateSubmissionReview(verifiedToken jwt.Token) (int, error) {
+	if statusCode, err := validateResourceLink(verifiedToken); err != nil {
+		return statusCode, err
+	}
+
+	rawForUser, ok := verifiedToken.Get(claims.ForUser)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: for user", ErrMissingClaim)
+	}
+	forUser, ok := rawForUser.(map[string]interface{})
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: for user", ErrMalformedClaim)
+	}
+	if userID, ok := forUser["user_id"].(string); !ok || userID == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: for user ID", ErrMissingClaim)
+	}
+
+	rawEndpoint, ok := verifiedToken.Get(claims.AGSEndpoint)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: assignments and grades endpoint", ErrMissingClaim)
+	}
+	endpoint, ok := rawEndpoint.(map[string]interface{})
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: assignments and grades endpoint", ErrMalformedClaim)
+	}
+	if lineItem, ok := endpoint["lineitem"].(string); !ok || lineItem == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: lineitem", ErrMissingClaim)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateStartProctoring verifies a start proctoring request: in addition to the resource link, it requires the
+// attempt number, the session data to be echoed back to the platform, and the URL to which the start assessment
+// message is sent.
+// Source: https://www.imsglobal.org/spec/proctoring/v1p0#start-proctoring-message.
+func validateStartProctoring(verifiedToken jwt.Token) (int, error) {
+	if statusCode, err := validateEndAssessment(verifiedToken); err != nil {
+		return statusCode, err
+	}
+
+	rawSessionData, ok := verifiedToken.Get(claims.ProctoringSessionData)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: proctoring session data", ErrMissingClaim)
+	}
+	if sessionData, ok := rawSessionData.(string); !ok || sessionData == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: proctoring session data", ErrMalformedClaim)
+	}
+
+	startAssessmentURL, ok := verifiedToken.Get(claims.ProctoringStartAssessmentURL)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: start assessment URL", ErrMissingClaim)
+	}
+	if uri, ok := startAssessmentURL.(string); !ok || uri == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: start assessment URL", ErrMalformedClaim)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateEndAssessment verifies an end assessment request, which requires the resource link and the attempt number.
+// Source: https://www.imsglobal.org/spec/proctoring/v1p0#end-assessment-message.
+func validateEndAssessment(verifiedToken jwt.Token) (int, error) {
+	if statusCode, err := validateResourceLink(verifiedToken); err != nil {
+		return statusCode, err
+	}
+
+	rawAttemptNumber, ok := verifiedToken.Get(claims.ProctoringAttemptNumber)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("%w: attempt number", ErrMissingClaim)
+	}
+	attemptNumber, ok := rawAttemptNumber.(float64)
+	if !ok || attemptNumber < 1 || attemptNumber != float64(int(attemptNumber)) {
+		return http.StatusBadRequest, fmt.Errorf("%w: attempt number", ErrMalformedClaim)
+	}
+
+	return http.StatusOK, nil
+}
+
+// validateClaimGroups checks that all of the claims in the required claim groups are present.
+func validateClaimGroups(verifiedToken jwt.Token, groups []login.ClaimGroup) (int, error) {
+	for _, group := range groups {
+		groupClaims := group.Claims()
+		if groupClaims == nil {
+			return http.StatusInternalServerError, fmt.Errorf("unknown claim group %s", group)
+		}
+		for _, claim := range groupClaims {
+			if _, ok := verifiedToken.Get(claim); !ok {
+				return http.StatusBadRequest, fmt.Errorf("%w: %s (%s)", ErrMissingClaim, claim, group)
+			}
+		}
 	}
 
 	return http.StatusOK, nil
@@ -306,17 +1111,70 @@ func validateResourceLink(verifiedToken jwt.Token) (int, error) {
 // getLaunchData parses the id_token to get JWT payload for storage.
 func getLaunchData(rawToken []byte) (json.RawMessage, int, error) {
 	if len(rawToken) == 0 {
-		return nil, http.StatusBadRequest, errors.New("received empty raw token argument")
+		return nil, http.StatusBadRequest, fmt.Errorf("%w: received empty raw token argument", ErrInvalidToken)
 	}
-	rawTokenParts := strings.SplitN(string(rawToken), ".", 3)
-	payload, err := base64.RawURLEncoding.DecodeString(rawTokenParts[1])
+	rawTokenParts, err := splitToken(rawToken)
 	if err != nil {
 		return nil, http.StatusBadRequest, fmt.Errorf("get launch data: %w", err)
 	}
+	payload, err := base64.RawURLEncoding.DecodeString(rawTokenParts[1])
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("get launch data: %w: %v", ErrInvalidToken, err)
+	}
 
 	return json.RawMessage(payload), http.StatusOK, nil
 }
 
+// generateLaunchID returns a new launch ID, generated by the LaunchIDGenerator if set, with the launch ID prefix.
+func (l *Launch) generateLaunchID(verifiedToken jwt.Token) (string, int, error) {
+	prefix := l.LaunchIDPrefix
+	if prefix == "" {
+		prefix = DefaultLaunchIDPrefix
+	}
+
+	if l.LaunchIDGenerator == nil {
+		return prefix + uuid.New().String(), http.StatusOK, nil
+	}
+
+	tokenClaims, err := verifiedToken.AsMap(context.Background())
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("generate launch ID: could not read claims: %w", err)
+	}
+	id, err := l.LaunchIDGenerator(tokenClaims)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("generate launch ID: %w", err)
+	}
+	if id == "" {
+		return "", http.StatusInternalServerError, errors.New("generate launch ID: generator returned empty ID")
+	}
+
+	return prefix + id, http.StatusOK, nil
+}
+
+// splitToken splits a JWS in compact serialization into its header, payload and signature. It returns an error wrapping
+// ErrInvalidToken unless there are exactly three parts and the header and payload are not empty. The signature may be
+// empty, so that an unsigned token is reported as such by the algorithm check.
+func splitToken(rawToken []byte) ([]string, error) {
+	parts := strings.Split(string(rawToken), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, found %d", ErrInvalidToken, len(parts))
+	}
+	if parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: empty header or payload", ErrInvalidToken)
+	}
+
+	return parts, nil
+}
+
+// clockSkew returns the launch's clock skew, or DefaultClockSkew if it is not set.
+func (l *Launch) clockSkew() time.Duration {
+	if l.ClockSkew == 0 {
+		return DefaultClockSkew
+	}
+
+	return l.ClockSkew
+}
+
 // contains returns whether a string exists in a []string.
 func contains(n string, s []string) bool {
 	for _, v := range s {
@@ -334,3 +1192,11 @@ func contextWithLaunchID(ctx context.Context, launchID string) context.Context {
 
 	return context.WithValue(ctx, key, launchID)
 }
+
+// TokenFromContext returns the verified id_token attached to the context by a successful launch, or nil. Its claims
+// are those of the id_token, to which the Launch's ClaimFilter is not applied.
+func TokenFromContext(ctx context.Context) jwt.Token {
+	token, _ := ctx.Value(TokenContextKey).(jwt.Token)
+
+	return token
+}
@@ -4,3 +4,1063 @@
 // the LICENSE file in the root directory of this source tree.
 
 package launch
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/login"
+)
+
+func TestFilterLaunchData(t *testing.T) {
+	launchData := json.RawMessage(`{"iss":"https://platform.tld","aud":"abc","email":"a@b.c","name":"A B"}`)
+
+	actual, _, err := filterLaunchData(launchData, nil)
+	if err != nil {
+		t.Fatalf("filter launch data error: %v", err)
+	}
+	if string(actual) != string(launchData) {
+		t.Errorf("nil filter changed launch data: got %s", actual)
+	}
+
+	actual, _, err = filterLaunchData(launchData, RedactClaims("email"))
+	if err != nil {
+		t.Fatalf("filter launch data error: %v", err)
+	}
+	expected := `{"aud":"abc","iss":"https://platform.tld","name":"A B"}`
+	if string(actual) != expected {
+		t.Errorf("got %s, wanted %s", actual, expected)
+	}
+
+	actual, _, err = filterLaunchData(launchData, AllowClaims("name"))
+	if err != nil {
+		t.Fatalf("filter launch data error: %v", err)
+	}
+	expected = `{"aud":"abc","iss":"https://platform.tld","name":"A B"}`
+	if string(actual) != expected {
+		t.Errorf("got %s, wanted %s", actual, expected)
+	}
+
+	names := make([]string, 1, 1+len(requiredClaims))
+	names[0] = "name"
+	spare := names[:cap(names)]
+	AllowClaims(names...)
+	for _, name := range spare[1:] {
+		if name != "" {
+			t.Errorf("AllowClaims wrote %q into the caller's names", name)
+		}
+	}
+
+	_, _, err = filterLaunchData(json.RawMessage(`not json`), RedactClaims("email"))
+	if err == nil {
+		t.Error("error not reported for malformed launch data")
+	}
+}
+
+func TestTenantResolvers(t *testing.T) {
+	claims := map[string]interface{}{
+		"iss": "https://platform.tld",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "1",
+		"https://purl.imsglobal.org/spec/lti/claim/custom":        map[string]interface{}{"tenant": "acme"},
+	}
+
+	tenant, err := TenantFromIssuerAndDeployment()(claims)
+	if err != nil || tenant != "https:%2F%2Fplatform.tld/1" {
+		t.Errorf("got (%q, %v), wanted issuer and deployment tenant", tenant, err)
+	}
+
+	// An issuer's path cannot be extended by a deployment ID to identify another tenant.
+	first, _ := TenantFromIssuerAndDeployment()(map[string]interface{}{"iss": "https://a.edu/x",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "y"})
+	second, _ := TenantFromIssuerAndDeployment()(map[string]interface{}{"iss": "https://a.edu",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "x/y"})
+	if first == "" || first == second {
+		t.Errorf("got tenants %q and %q, wanted distinct tenants", first, second)
+	}
+
+	tenant, err = TenantFromCustomParameter("tenant")(claims)
+	if err != nil || tenant != "acme" {
+		t.Errorf("got (%q, %v), wanted custom parameter tenant", tenant, err)
+	}
+
+	_, err = TenantFromCustomParameter("missing")(claims)
+	if err == nil {
+		t.Error("error not reported for missing custom parameter")
+	}
+}
+
+func TestRequireRoles(t *testing.T) {
+	store := nonpersistent.New()
+	launchData := `{"https://purl.imsglobal.org/spec/lti/claim/roles":["Instructor"]}`
+	if err := store.StoreLaunchData("launch", json.RawMessage(launchData)); err != nil {
+		t.Fatalf("store launch data error: %v", err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name          string
+		launchID      string
+		requiredRoles []string
+		wantStatus    int
+	}{
+		{"full form", "launch", []string{"http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor"}, http.StatusOK},
+		{"any role suffices", "launch", []string{"Administrator", "Instructor"}, http.StatusOK},
+		{"missing role", "launch", []string{"Administrator"}, http.StatusForbidden},
+		{"no launch ID", "", []string{"Instructor"}, http.StatusUnauthorized},
+		{"unknown launch", "unknown", []string{"Instructor"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.launchID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), ContextKey, test.launchID))
+		}
+		w := httptest.NewRecorder()
+		RequireRoles(datastore.Config{LaunchData: store}, test.requiredRoles...)(next).ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got status %d, wanted %d", test.name, w.Code, test.wantStatus)
+		}
+	}
+}
+
+func TestValidateMessageType(t *testing.T) {
+	token := jwt.New()
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/version", "1.3.0")
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/message_type", "LtiDeepLinkingRequest")
+
+	messageType, _, err := validateVersionAndMessageType(token)
+	if err != nil {
+		t.Fatalf("validate message type error: %v", err)
+	}
+	if messageType != MessageTypeDeepLinking {
+		t.Errorf("got message type %s, wanted %s", messageType, MessageTypeDeepLinking)
+	}
+
+	_, err = supportedMessageTypes[messageType](token)
+	if !errors.Is(err, ErrMissingClaim) {
+		t.Errorf("got %v, wanted ErrMissingClaim for missing deep linking settings", err)
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings", map[string]interface{}{
+		"deep_link_return_url":                 "https://platform.tld/deeplinking",
+		"accept_types":                         []interface{}{"ltiResourceLink"},
+		"accept_presentation_document_targets": []interface{}{"iframe"},
+	})
+	_, err = supportedMessageTypes[messageType](token)
+	if err != nil {
+		t.Errorf("validate deep linking settings error: %v", err)
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/message_type", "LtiUnknownRequest")
+	_, _, err = validateVersionAndMessageType(token)
+	if !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("got %v, wanted ErrInvalidMessageType", err)
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/version", "1.1")
+	_, _, err = validateVersionAndMessageType(token)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("got %v, wanted ErrUnsupportedVersion", err)
+	}
+}
+
+func TestValidateStaleLaunch(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	store := nonpersistent.New()
+	store.Clock = datastore.ClockFunc(func() time.Time { return now })
+	l := New(datastore.Config{Nonces: store, Clock: store.Clock}, nil)
+
+	state := fmt.Sprintf("state-%d_abc", now.Unix())
+	r := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{"state": {state}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: login.StateCookieName, Value: state})
+	_, err := validateState(r, jwt.New(), l)
+	if !errors.Is(err, ErrStaleLaunch) || !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for expired state, wanted ErrStaleLaunch", err)
+	}
+
+	now = now.Add(-time.Second)
+	if _, err := validateState(r, jwt.New(), l); err != nil {
+		t.Errorf("got %v for unexpired state", err)
+	}
+
+	store.StoreNonce("nonce", "https://tool.tld/launch")
+	now = now.Add(nonpersistent.DefaultNonceTTL + time.Second)
+	token := jwt.New()
+	token.Set(claims.TargetLinkURI, "https://tool.tld/launch")
+	token.Set("nonce", "nonce")
+	_, err = validateNonceAndTargetLinkURI(token, l)
+	if !errors.Is(err, ErrStaleLaunch) || !errors.Is(err, datastore.ErrNonceExpired) {
+		t.Errorf("got %v for expired nonce, wanted ErrStaleLaunch", err)
+	}
+}
+
+func TestValidateStoredState(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	store := nonpersistent.New()
+	store.Clock = datastore.ClockFunc(func() time.Time { return now })
+	l := New(datastore.Config{States: store, Clock: store.Clock}, nil)
+	l.ServerSideState = true
+
+	state := fmt.Sprintf("state-%d_abc", now.Add(time.Minute).Unix())
+	store.StoreState(state, "nonce", now.Add(time.Minute))
+	r := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{"state": {state}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := jwt.New()
+	token.Set("nonce", "other")
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for mismatched nonce, wanted ErrStateMismatch", err)
+	}
+
+	store.StoreState(state, "nonce", now.Add(time.Minute))
+	token.Set("nonce", "nonce")
+	if _, err := validateState(r, token, l); err != nil {
+		t.Fatalf("got %v for stored state without cookie", err)
+	}
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for reused state, wanted ErrStateMismatch", err)
+	}
+}
+
+func TestValidateSignedState(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	l := New(datastore.Config{Clock: datastore.ClockFunc(func() time.Time { return now })}, nil)
+	l.StateSigner, _ = login.NewStateSigner(make([]byte, login.MinimumStateKeyLength))
+
+	state, _ := l.StateSigner.Sign("nonce", "https://tool.tld/launch", now.Add(time.Minute))
+	r := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{"state": {state}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := jwt.New()
+	token.Set("nonce", "nonce")
+	token.Set(claims.TargetLinkURI, "https://tool.tld/launch")
+	if _, err := validateState(r, token, l); err != nil {
+		t.Errorf("got %v for signed state without cookie", err)
+	}
+	if _, err := validateNonceAndTargetLinkURI(token, l); err != nil {
+		t.Errorf("got %v for the nonce carried by the signed state", err)
+	}
+
+	token.Set(claims.TargetLinkURI, "https://tool.tld/other")
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for mismatched target link URI, wanted ErrStateMismatch", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStaleLaunch) {
+		t.Errorf("got %v for expired signed state, wanted ErrStaleLaunch", err)
+	}
+}
+
+func TestPlatformStorage(t *testing.T) {
+	store := nonpersistent.New()
+	l := New(datastore.Config{States: store}, nil)
+	l.PlatformStorage = true
+	authLoginURI, _ := url.Parse("https://platform.tld/auth")
+	registration := datastore.Registration{AuthLoginURI: authLoginURI}
+
+	state := fmt.Sprintf("state-%d_abc", time.Now().Add(time.Minute).Unix())
+	values := url.Values{"id_token": {"a.b.c"}, "state": {state}, login.StorageTargetParameter: {"_parent"}}
+	newRequest := func(values url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		login.UseRequestValues(r)
+		return r
+	}
+	token := jwt.New()
+	token.Set("nonce", "nonce")
+
+	r := newRequest(values)
+	if !l.needsPlatformStorage(r) {
+		t.Fatal("platform storage not needed without state cookie")
+	}
+	w := httptest.NewRecorder()
+	if err := writePlatformStoragePage(w, r, registration); err != nil {
+		t.Fatalf("write platform storage page error: %v", err)
+	}
+	page := w.Body.String()
+	for _, expected := range []string{`action="/launch"`, `name="id_token" value="a.b.c"`, `"lti.get_data"`,
+		`origin = "https://platform.tld"`, `key: "state_` + state + `"`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("page missing %s: %s", expected, page)
+		}
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "form-action 'self'") {
+		t.Errorf("got policy %s", w.Header().Get("Content-Security-Policy"))
+	}
+
+	// A state read back from platform storage must have been stored by the login, so that a request cannot make one
+	// up.
+	values.Set(login.StorageStateParameter, state)
+	r = newRequest(values)
+	if l.needsPlatformStorage(r) {
+		t.Error("platform storage needed once the state is read back")
+	}
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for forged state, wanted ErrStateMismatch", err)
+	}
+
+	store.StoreState(state, "other", time.Now().Add(time.Minute))
+	if _, err := validateState(r, token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for state stored with another nonce, wanted ErrStateMismatch", err)
+	}
+
+	store.StoreState(state, "nonce", time.Now().Add(time.Minute))
+	values.Set(login.StorageStateParameter, "other")
+	if _, err := validateState(newRequest(values), token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for mismatched state, wanted ErrStateMismatch", err)
+	}
+	values.Set(login.StorageStateParameter, "")
+	if _, err := validateState(newRequest(values), token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for state not found in platform storage, wanted ErrStateMismatch", err)
+	}
+
+	values.Set(login.StorageStateParameter, state)
+	if _, err := validateState(newRequest(values), token, l); err != nil {
+		t.Errorf("got %v for the state read back from platform storage", err)
+	}
+	if _, err := validateState(newRequest(values), token, l); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("got %v for reused state, wanted ErrStateMismatch", err)
+	}
+}
+
+func TestValidateClaimSecurity(t *testing.T) {
+	token := jwt.New()
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/target_link_uri", "http://localhost:8080/launch")
+	token.Set("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", map[string]interface{}{
+		"lineitems": "https://platform.tld/lineitems",
+	})
+
+	l := &Launch{}
+	l.cfg.StrictHTTPS = true
+	_, err := validateClaimSecurity(token, l)
+	if err != nil {
+		t.Errorf("validate claim security error: %v", err)
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", map[string]interface{}{
+		"lineitems": "http://platform.tld/lineitems",
+	})
+	_, err = validateClaimSecurity(token, l)
+	if !errors.Is(err, datastore.ErrInsecureURI) {
+		t.Errorf("got %v, wanted ErrInsecureURI", err)
+	}
+
+	l.cfg.StrictHTTPS = false
+	_, err = validateClaimSecurity(token, l)
+	if err != nil {
+		t.Errorf("insecure URI reported without strict HTTPS: %v", err)
+	}
+}
+
+func TestValidateClientID(t *testing.T) {
+	registration := datastore.Registration{Issuer: "https://platform.tld", ClientID: "abc"}
+	tests := []struct {
+		audience        []string
+		authorizedParty string
+		want            error
+	}{
+		{[]string{"abc"}, "", nil},
+		{[]string{"abc", "def"}, "abc", nil},
+		{[]string{"abc", "def"}, "", claims.ErrInvalidAudience},
+		{[]string{"def"}, "abc", claims.ErrInvalidAudience},
+		{[]string{"def"}, "", ErrClientIDMismatch},
+		{[]string{"abc", "def"}, "def", ErrClientIDMismatch},
+	}
+
+	for _, test := range tests {
+		token := jwt.New()
+		token.Set(jwt.AudienceKey, test.audience)
+		if test.authorizedParty != "" {
+			token.Set("azp", test.authorizedParty)
+		}
+		_, err := validateClientID(token, registration)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%v with azp %q: got %v, wanted %v", test.audience, test.authorizedParty, err, test.want)
+		}
+	}
+
+	// A token without an audience is rejected before its registration is looked up.
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	rawToken := encode(`{"alg":"RS256"}`) + "." + encode(`{"iss":"https://platform.tld"}`) + "." + encode("signature")
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	_, statusCode, err := validateRegistration([]byte(rawToken), l, nil)
+	if statusCode != http.StatusBadRequest || !errors.Is(err, claims.ErrInvalidAudience) {
+		t.Errorf("got %d, %v for empty audience", statusCode, err)
+	}
+}
+
+func TestValidateSubmissionReview(t *testing.T) {
+	token := jwt.New()
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]interface{}{"id": "1"})
+	token.Set("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", map[string]interface{}{
+		"lineitem": "https://platform.tld/lineitems/1",
+	})
+
+	validate := supportedMessageTypes[MessageTypeSubmissionReview]
+	_, err := validate(token)
+	if err == nil {
+		t.Error("missing for user not reported")
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/for_user", map[string]interface{}{"user_id": "student"})
+	_, err = validate(token)
+	if err != nil {
+		t.Errorf("validate submission review error: %v", err)
+	}
+}
+
+func TestValidateTimestamps(t *testing.T) {
+	issued := time.Date(2021, time.September, 1, 8, 0, 0, 0, time.UTC)
+	token := jwt.New()
+	token.Set(jwt.IssuedAtKey, issued)
+	token.Set(jwt.ExpirationKey, issued.Add(time.Hour))
+
+	now := issued
+	l := &Launch{}
+	l.cfg.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	tests := []struct {
+		offset time.Duration
+		valid  bool
+	}{
+		{-time.Minute, true},
+		{-time.Minute * 10, false},
+		{time.Minute * 30, true},
+		{time.Hour + time.Minute, true},
+		{time.Hour + time.Minute*5, false},
+	}
+	for _, test := range tests {
+		now = issued.Add(test.offset)
+		_, err := validateTimestamps(token, l)
+		if (err == nil) != test.valid {
+			t.Errorf("at %v: got error %v, wanted valid %t", test.offset, err, test.valid)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTimestamps) {
+			t.Errorf("at %v: got %v, wanted ErrInvalidTimestamps", test.offset, err)
+		}
+	}
+}
+
+func TestValidateTimestampsConfigured(t *testing.T) {
+	issued := time.Date(2021, time.September, 1, 8, 0, 0, 0, time.UTC)
+	token := jwt.New()
+	token.Set(jwt.IssuedAtKey, issued)
+	token.Set(jwt.ExpirationKey, issued.Add(time.Hour))
+
+	now := issued.Add(-time.Minute * 10)
+	l := &Launch{ClockSkew: time.Minute * 15}
+	l.cfg.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	if _, err := validateTimestamps(token, l); err != nil {
+		t.Errorf("token rejected within configured clock skew: %v", err)
+	}
+
+	l.ClockSkew = time.Minute
+	l.MaximumTokenAge = time.Minute * 5
+	now = issued.Add(time.Minute * 5)
+	if _, err := validateTimestamps(token, l); err != nil {
+		t.Errorf("token rejected within maximum age: %v", err)
+	}
+	now = issued.Add(time.Minute * 7)
+	if _, err := validateTimestamps(token, l); !errors.Is(err, ErrInvalidTimestamps) {
+		t.Errorf("got %v for token older than maximum age, wanted ErrInvalidTimestamps", err)
+	}
+}
+
+func TestValidateAlgorithm(t *testing.T) {
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	rawToken := func(algorithm string) []byte {
+		return []byte(encode(`{"alg":"`+algorithm+`"}`) + "." + encode(`{"iss":"https://platform.tld"}`) + "." +
+			encode("signature"))
+	}
+
+	l := &Launch{}
+	for algorithm, valid := range map[string]bool{"RS256": true, "RS512": false, "none": false, "HS256": false} {
+		_, err := validateAlgorithm(rawToken(algorithm), l)
+		if (err == nil) != valid {
+			t.Errorf("algorithm %s: got error %v, wanted valid %t", algorithm, err, valid)
+		}
+		if !valid && !errors.Is(err, ErrAlgorithmNotPermitted) {
+			t.Errorf("algorithm %s: got %v, wanted ErrAlgorithmNotPermitted", algorithm, err)
+		}
+	}
+
+	l.Algorithms = []string{"RS512", "HS256", "none"}
+	for algorithm, valid := range map[string]bool{"RS256": false, "RS512": true, "none": false, "HS256": false} {
+		_, err := validateAlgorithm(rawToken(algorithm), l)
+		if (err == nil) != valid {
+			t.Errorf("configured algorithm %s: got error %v, wanted valid %t", algorithm, err, valid)
+		}
+	}
+}
+
+func TestGetRawToken(t *testing.T) {
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	validToken := encode(`{"alg":"RS256"}`) + "." + encode(`{"iss":"https://platform.tld"}`) + "." + encode("signature")
+	request := func(idToken string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "https://tool.tld/launch",
+			strings.NewReader(url.Values{"id_token": {idToken}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	l := &Launch{}
+	if _, _, err := getRawToken(request(validToken), l); err != nil {
+		t.Errorf("got error %v for valid token", err)
+	}
+
+	for _, idToken := range []string{"", "abc", "a.b", "a.b.c.d", "." + encode("{}") + ".c"} {
+		if _, statusCode, err := getRawToken(request(idToken), l); !errors.Is(err, ErrInvalidToken) ||
+			statusCode != http.StatusBadRequest {
+			t.Errorf("token %q: got status %d, error %v, wanted ErrInvalidToken", idToken, statusCode, err)
+		}
+	}
+
+	l.MaximumTokenLength = len(validToken) - 1
+	if _, statusCode, err := getRawToken(request(validToken), l); !errors.Is(err, ErrTokenTooLarge) ||
+		statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, error %v for oversized token, wanted ErrTokenTooLarge", statusCode, err)
+	}
+
+	if _, _, err := getLaunchData([]byte("a.b")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got %v for malformed launch data, wanted ErrInvalidToken", err)
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	l := &Launch{MaximumTokenLength: 1024}
+	limit := 1024 + requestBodyHeadroom
+	request := func(idToken string) (*http.Request, *countingReader) {
+		body := &countingReader{Reader: strings.NewReader(url.Values{"id_token": {idToken}}.Encode())}
+		r := httptest.NewRequest(http.MethodPost, "https://tool.tld/launch", body)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r, body
+	}
+
+	r, _ := request(strings.Repeat("a", 1024))
+	if statusCode, err := useRequestValues(httptest.NewRecorder(), r, l); err != nil {
+		t.Errorf("got status %d, error %v for body within limit", statusCode, err)
+	}
+	if got := len(r.FormValue("id_token")); got != 1024 {
+		t.Errorf("got id_token of %d bytes, wanted 1024", got)
+	}
+
+	r, body := request(strings.Repeat("a", 10*limit))
+	statusCode, err := useRequestValues(httptest.NewRecorder(), r, l)
+	if !errors.Is(err, ErrTokenTooLarge) || statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, error %v for oversized body, wanted ErrTokenTooLarge", statusCode, err)
+	}
+	if body.read > 2*limit {
+		t.Errorf("read %d bytes of oversized body, wanted at most %d", body.read, 2*limit)
+	}
+}
+
+func TestValidateTokenHeaders(t *testing.T) {
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	rawToken := func(header string) []byte {
+		return []byte(encode(header) + "." + encode(`{"iss":"https://platform.tld"}`) + "." + encode("signature"))
+	}
+
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{`{"alg":"RS256","typ":"JWT"}`, true},
+		{`{"alg":"RS256"}`, true},
+		{`{"alg":"ES384","typ":"jwt"}`, true},
+		{`{"alg":"none"}`, false},
+		{`{"alg":"HS256","typ":"JWT"}`, false},
+		{`{"alg":"RS256","typ":"at+jwt"}`, false},
+		{`{"alg":"RS256","crit":["exp"],"exp":1}`, false},
+	}
+
+	l := &Launch{}
+	_, err := validateTokenHeaders(rawToken(tests[3].header), l)
+	if err != nil {
+		t.Errorf("headers checked without strict tokens: %v", err)
+	}
+
+	l.cfg.StrictTokens = true
+	for _, test := range tests {
+		_, err := validateTokenHeaders(rawToken(test.header), l)
+		if (err == nil) != test.valid {
+			t.Errorf("header %s: got error %v, wanted valid %t", test.header, err, test.valid)
+		}
+		if !test.valid && !errors.Is(err, ErrTokenHeaderNotPermitted) && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("header %s: got %v, wanted ErrTokenHeaderNotPermitted", test.header, err)
+		}
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	store := nonpersistent.New()
+	store.Clock = datastore.ClockFunc(func() time.Time { return now })
+	l := New(datastore.Config{Registrations: store, Replays: store, Clock: store.Clock}, nil)
+
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, "https://platform.tld")
+	token.Set(jwt.ExpirationKey, now.Add(time.Minute))
+	token.Set(jwt.JwtIDKey, "jti")
+
+	if _, err := validateReplay([]byte("a.b.c"), token, l); err != nil {
+		t.Fatalf("got error %v for first use", err)
+	}
+	statusCode, err := validateReplay([]byte("a.b.c"), token, l)
+	if !errors.Is(err, datastore.ErrTokenReplayed) || statusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, error %v for replay, wanted ErrTokenReplayed", statusCode, err)
+	}
+
+	// Without a jti, the token is identified by its hash.
+	token = jwt.New()
+	token.Set(jwt.IssuerKey, "https://platform.tld")
+	if _, err := validateReplay([]byte("a.b.c"), token, l); err != nil {
+		t.Errorf("got error %v for first use without jti", err)
+	}
+	if _, err := validateReplay([]byte("a.b.d"), token, l); err != nil {
+		t.Errorf("got error %v for another token without jti", err)
+	}
+	if _, err := validateReplay([]byte("a.b.c"), token, l); !errors.Is(err, datastore.ErrTokenReplayed) {
+		t.Errorf("got %v for replay without jti, wanted ErrTokenReplayed", err)
+	}
+}
+
+func TestFailureHook(t *testing.T) {
+	encode := func(v string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(v))
+	}
+	payload := `{
+		"iss": "https://platform.tld",
+		"aud": ["abc"],
+		"exp": 4102444800,
+		"email": "a@b.c",
+		"https://purl.imsglobal.org/spec/lti/claim/deployment_id": "1",
+		"https://purl.imsglobal.org/spec/lti/claim/tool_platform": {"product_family_code": "moodle", "version": "3.11"}
+	}`
+	rawToken := encode(`{"alg":"RS256"}`) + "." + encode(payload) + "." + encode("signature")
+
+	var bundles []SupportBundle
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	l.FailureHook = func(r *http.Request, bundle SupportBundle) {
+		bundles = append(bundles, bundle)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+		"id_token": {rawToken},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest || len(bundles) != 1 {
+		t.Fatalf("got status %d with %d bundles", recorder.Code, len(bundles))
+	}
+	bundle := bundles[0]
+	if bundle.Stage != StageRegistration || bundle.Issuer != "https://platform.tld" || bundle.ClientID != "abc" ||
+		bundle.DeploymentID != "1" || bundle.Platform.ProductFamilyCode != "moodle" {
+		t.Errorf("got bundle %+v", bundle)
+	}
+	if len(bundle.ClaimNames) != 6 || bundle.ClaimNames[1] != "email" {
+		t.Errorf("got claim names %v", bundle.ClaimNames)
+	}
+	if strings.Contains(fmt.Sprintf("%+v", bundle), "a@b.c") {
+		t.Error("bundle contains claim value")
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	var handled error
+	l.ErrorHandler = func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+		handled = err
+		w.WriteHeader(statusCode)
+		fmt.Fprint(w, "launch failed")
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+		"id_token": {"not a token"},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	if !errors.Is(handled, ErrInvalidToken) {
+		t.Errorf("got handled error %v, wanted ErrInvalidToken", handled)
+	}
+	if recorder.Code != http.StatusBadRequest || recorder.Body.String() != "launch failed" {
+		t.Errorf("got status %d, body %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	l.JSONErrors = true
+	var bundles []SupportBundle
+	l.FailureHook = func(r *http.Request, bundle SupportBundle) {
+		bundles = append(bundles, bundle)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+		"id_token": {"not a token"},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set(login.CorrelationIDHeader, "request-1")
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	var body login.JSONError
+	if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got status %d, content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if body.Code != "invalid_token" || body.Message == "" || body.CorrelationID != "request-1" {
+		t.Errorf("got body %+v", body)
+	}
+	if len(bundles) != 1 || bundles[0].CorrelationID != "request-1" {
+		t.Errorf("got bundles %+v", bundles)
+	}
+
+	if code := errorCode(StageNonce, staleLaunchError{datastore.ErrNonceExpired}); code != "stale_launch" {
+		t.Errorf("got code %q for stale launch", code)
+	}
+	if code := errorCode(StageNonce, datastore.ErrNonceNotFound); code != string(StageNonce) {
+		t.Errorf("got code %q for datastore error", code)
+	}
+}
+
+func TestRequestMethods(t *testing.T) {
+	var bundles []SupportBundle
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	l.FailureHook = func(r *http.Request, bundle SupportBundle) {
+		bundles = append(bundles, bundle)
+	}
+
+	// An auth response returned by redirect carries its parameters in the query.
+	request := httptest.NewRequest(http.MethodGet, "/launch?error=login_required", nil)
+	l.ServeHTTP(httptest.NewRecorder(), request)
+	request = httptest.NewRequest(http.MethodPut, "/launch?error=login_required", nil)
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	if len(bundles) != 2 || bundles[0].Stage != StagePlatformError || bundles[1].Stage != StageRequest {
+		t.Fatalf("got bundles %+v", bundles)
+	}
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for PUT launch", recorder.Code)
+	}
+}
+
+func TestPlatformError(t *testing.T) {
+	var bundles []SupportBundle
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	l.FailureHook = func(r *http.Request, bundle SupportBundle) {
+		bundles = append(bundles, bundle)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+		"error":             {"login_required"},
+		"error_description": {"Your session has expired."},
+		"state":             {"abc"},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest || len(bundles) != 1 || bundles[0].Stage != StagePlatformError {
+		t.Fatalf("got status %d with bundles %+v", recorder.Code, bundles)
+	}
+	if !strings.Contains(recorder.Body.String(), "login_required") ||
+		!strings.Contains(recorder.Body.String(), "Your session has expired.") {
+		t.Errorf("got body %q", recorder.Body.String())
+	}
+
+	_, err := validatePlatformError(request)
+	var platformError *PlatformError
+	if !errors.Is(err, ErrPlatformError) || !errors.As(err, &platformError) || platformError.Code != "login_required" {
+		t.Errorf("got %v, wanted a *PlatformError", err)
+	}
+}
+
+func TestGenerateLaunchID(t *testing.T) {
+	token := jwt.New()
+	token.Set("iss", "https://platform.tld")
+	token.Set(jwt.JwtIDKey, "1")
+
+	l := &Launch{}
+	id, _, err := l.generateLaunchID(token)
+	if err != nil || !strings.HasPrefix(id, DefaultLaunchIDPrefix) || len(id) == len(DefaultLaunchIDPrefix) {
+		t.Errorf("got default launch ID %q, error %v", id, err)
+	}
+
+	l.LaunchIDPrefix = "tenant-a/"
+	l.LaunchIDGenerator = func(launchClaims map[string]interface{}) (string, error) {
+		return launchClaims["jti"].(string), nil
+	}
+	if id, _, err := l.generateLaunchID(token); err != nil || id != "tenant-a/1" {
+		t.Errorf("got launch ID %q, error %v", id, err)
+	}
+
+	l.LaunchIDGenerator = func(launchClaims map[string]interface{}) (string, error) {
+		return "", nil
+	}
+	if _, statusCode, err := l.generateLaunchID(token); err == nil || statusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, error %v for empty launch ID", statusCode, err)
+	}
+}
+
+func TestTokenFromContext(t *testing.T) {
+	if token := TokenFromContext(context.Background()); token != nil {
+		t.Errorf("got token %v from empty context", token)
+	}
+
+	token := jwt.New()
+	token.Set("sub", "a")
+	ctx := context.WithValue(context.Background(), TokenContextKey, token)
+	if found := TokenFromContext(ctx); found == nil || found.Subject() != "a" {
+		t.Errorf("got token %v", found)
+	}
+}
+
+// newPlatformForTesting starts a server publishing a platform's keyset. It returns the server, a store holding the
+// platform's registration, with the tool's launch URI as its TargetLinkURI, and its deployment "1", and a function
+// signing id_tokens with the platform's key.
+func newPlatformForTesting(t *testing.T) (*httptest.Server, *nonpersistent.Store, func(jwt.Token) []byte) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	publicKey, _ := jwk.New(&privateKey.PublicKey)
+	publicKey.Set(jwk.KeyIDKey, "platform")
+	publicKey.Set(jwk.AlgorithmKey, jwa.RS256)
+	published := jwk.NewSet()
+	published.Add(publicKey)
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(published)
+	}))
+
+	platformURI, _ := url.Parse(platform.URL)
+	targetLinkURI, _ := url.Parse("https://tool.tld/launch")
+	store := nonpersistent.New()
+	store.StoreRegistration(datastore.Registration{
+		Issuer:        "https://platform.tld",
+		ClientID:      "tool",
+		AuthTokenURI:  platformURI,
+		AuthLoginURI:  platformURI,
+		KeysetURI:     platformURI,
+		TargetLinkURI: targetLinkURI,
+	})
+	store.StoreDeployment("https://platform.tld", datastore.Deployment{DeploymentID: "1"})
+
+	sign := func(token jwt.Token) []byte {
+		signingKey, _ := jwk.New(privateKey)
+		signingKey.Set(jwk.KeyIDKey, "platform")
+		signed, err := jwt.Sign(token, jwa.RS256, signingKey)
+		if err != nil {
+			t.Fatalf("cannot sign token: %v", err)
+		}
+		return signed
+	}
+
+	return platform, store, sign
+}
+
+// newLaunchTokenForTesting returns the id_token of a resource link launch from the platform of newPlatformForTesting.
+func newLaunchTokenForTesting(nonce, targetLinkURI string) jwt.Token {
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, "https://platform.tld")
+	token.Set(jwt.SubjectKey, "user")
+	token.Set(jwt.AudienceKey, "tool")
+	token.Set(jwt.IssuedAtKey, time.Now())
+	token.Set(jwt.ExpirationKey, time.Now().Add(time.Minute))
+	token.Set("nonce", nonce)
+	token.Set(claims.Version, claims.LTIVersion)
+	token.Set(claims.MessageType, MessageTypeResourceLink)
+	token.Set(claims.DeploymentID, "1")
+	token.Set(claims.TargetLinkURI, targetLinkURI)
+	token.Set(claims.ResourceLink, map[string]interface{}{"id": "link"})
+
+	return token
+}
+
+func TestNewWithCallback(t *testing.T) {
+	platform, store, sign := newPlatformForTesting(t)
+	defer platform.Close()
+	store.StoreNonce("nonce", "https://tool.tld/launch")
+	signed := sign(newLaunchTokenForTesting("nonce", "https://tool.tld/launch"))
+
+	var (
+		calledWithID     string
+		calledWithClaims claims.LaunchClaims
+	)
+	cfg := datastore.Config{Registrations: store, Nonces: store, LaunchData: store, Replays: store}
+	l := NewWithCallback(cfg, func(w http.ResponseWriter, r *http.Request, launchID string,
+		launchClaims claims.LaunchClaims) {
+		calledWithID = launchID
+		calledWithClaims = launchClaims
+	})
+	var timing Timing
+	l.TimingHook = func(r *http.Request, t Timing) {
+		timing = t
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+		"id_token": {string(signed)},
+		"state":    {"state"},
+	}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(&http.Cookie{Name: login.StateCookieName, Value: "state"})
+	recorder := httptest.NewRecorder()
+	l.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || calledWithID == "" {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	if calledWithClaims.Subject != "user" || calledWithClaims.ResourceLink == nil ||
+		calledWithClaims.ResourceLink.ID != "link" {
+		t.Errorf("got claims %+v", calledWithClaims)
+	}
+	if _, err := store.FindLaunchData(calledWithID); err != nil {
+		t.Errorf("find launch data error: %v", err)
+	}
+	if len(timing.Stages) == 0 {
+		t.Fatal("timing hook not called")
+	}
+	lastStage := timing.Stages[len(timing.Stages)-1].Stage
+	if timing.FailedStage != "" || lastStage != StageLaunchData || timing.Keyset <= 0 || timing.DatastoreCalls == 0 ||
+		timing.Stage(StageSignature) < timing.Keyset {
+		t.Errorf("got timing %+v", timing)
+	}
+}
+
+func TestLoginThenLaunchAdditionalTargetLinkURI(t *testing.T) {
+	platform, store, sign := newPlatformForTesting(t)
+	defer platform.Close()
+	cfg := datastore.Config{Registrations: store, Nonces: store, LaunchData: store, Replays: store}
+	deepLinkingURI, _ := url.Parse("https://tool.tld/deeplinking")
+	signer, _ := login.NewStateSigner(make([]byte, login.MinimumStateKeyLength))
+
+	for _, stateSigner := range []*login.StateSigner{nil, signer} {
+		l := login.New(cfg)
+		l.AdditionalTargetLinkURIs = []*url.URL{deepLinkingURI}
+		l.StateSigner = stateSigner
+		loginRequest := httptest.NewRequest(http.MethodGet, "/login?"+url.Values{
+			"iss":             {"https://platform.tld"},
+			"login_hint":      {"user"},
+			"client_id":       {"tool"},
+			"target_link_uri": {deepLinkingURI.String()},
+		}.Encode(), nil)
+		redirectURI, stateCookie, err := l.RedirectURI(loginRequest)
+		if err != nil {
+			t.Fatalf("login error: %v", err)
+		}
+		authRequest, _ := url.Parse(redirectURI)
+		state, nonce := authRequest.Query().Get("state"), authRequest.Query().Get("nonce")
+
+		launched := false
+		launch := NewWithCallback(cfg, func(w http.ResponseWriter, r *http.Request, launchID string,
+			launchClaims claims.LaunchClaims) {
+			launched = true
+		})
+		launch.StateSigner = stateSigner
+		request := httptest.NewRequest(http.MethodPost, "/launch", strings.NewReader(url.Values{
+			"id_token": {string(sign(newLaunchTokenForTesting(nonce, deepLinkingURI.String())))},
+			"state":    {state},
+		}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(&stateCookie)
+		recorder := httptest.NewRecorder()
+		launch.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK || !launched {
+			t.Errorf("signed state %v: got status %d: %s", stateSigner != nil, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestTimingHook(t *testing.T) {
+	l := New(datastore.Config{Registrations: nonpersistent.New()}, nil)
+	var timing Timing
+	l.TimingHook = func(r *http.Request, t Timing) {
+		timing = t
+	}
+
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/launch", nil))
+	var stages []Stage
+	for _, s := range timing.Stages {
+		stages = append(stages, s.Stage)
+	}
+	expected := []Stage{StageRequest, StagePlatformError, StageToken}
+	if !reflect.DeepEqual(stages, expected) || timing.FailedStage != StageToken || timing.Total <= 0 {
+		t.Errorf("got stages %v of timing %+v, wanted %v", stages, timing, expected)
+	}
+	if l.timer != nil {
+		t.Error("timer of the launch recorded in the Launch")
+	}
+}
+
+func TestValidateStartProctoring(t *testing.T) {
+	token := jwt.New()
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]interface{}{"id": "quiz"})
+	token.Set("https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number", float64(1))
+
+	_, err := validateEndAssessment(token)
+	if err != nil {
+		t.Errorf("validate end assessment error: %v", err)
+	}
+	_, err = validateStartProctoring(token)
+	if err == nil {
+		t.Error("missing session data not reported")
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti-ap/claim/session_data", "opaque")
+	token.Set("https://purl.imsglobal.org/spec/lti-ap/claim/start_assessment_url", "https://platform.tld/start")
+	_, err = validateStartProctoring(token)
+	if err != nil {
+		t.Errorf("validate start proctoring error: %v", err)
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti-ap/claim/attempt_number", float64(1.5))
+	_, err = validateStartProctoring(token)
+	if err == nil {
+		t.Error("improper attempt number not reported")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/macewan-cs/lti/autosubmit"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/login"
+)
+
+// A platformStoragePage is the data of platformStorageTemplate.
+type platformStoragePage struct {
+	Action    string
+	Fields    []autosubmit.Field
+	Parameter string
+	Target    string
+	Origin    string
+	Key       string
+	Nonce     string
+}
+
+// platformStorageTemplate reads the state back from the platform's storage frame with an lti.get_data message and
+// posts the launch again, with the state found, or an empty value, in the Parameter field. It waits at most two
+// seconds for the storage frame.
+var platformStorageTemplate = template.Must(template.New("platformStorage").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Launching</title></head>
+<body>
+<form id="lti-platform-storage" method="POST" action="{{.Action}}">
+{{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{end}}<input type="hidden" name="{{.Parameter}}" value="">
+</form>
+<script nonce="{{.Nonce}}">
+(function () {
+	var form = document.getElementById("lti-platform-storage");
+	var target = {{.Target}}, origin = {{.Origin}}, id = "lti-get-" + Math.random().toString(36).slice(2);
+	var submitted = false;
+	function submit(value) {
+		if (submitted) {
+			return;
+		}
+		submitted = true;
+		form.elements[{{.Parameter}}].value = value || "";
+		form.submit();
+	}
+	var frame = target === "_parent" ? window.parent : window.parent.frames[target];
+	if (!frame || frame === window) {
+		submit("");
+		return;
+	}
+	window.addEventListener("message", function (event) {
+		var data = event.data;
+		if (event.origin !== origin || !data || data.subject !== "lti.get_data.response" || data.message_id !== id) {
+			return;
+		}
+		submit(data.value);
+	});
+	frame.postMessage({subject: "lti.get_data", message_id: id, key: {{.Key}}}, origin);
+	setTimeout(function () { submit(""); }, 2000);
+})();
+</script>
+</body>
+</html>
+`))
+
+// needsPlatformStorage reports whether the state of the launch must be read back from the platform's storage frame:
+// PlatformStorage is set, the platform named its storage frame, the state cookie is missing and the state has not
+// been read back yet.
+func (l *Launch) needsPlatformStorage(r *http.Request) bool {
+	if !l.PlatformStorage || l.StateSigner != nil || l.ServerSideState {
+		return false
+	}
+	if r.FormValue(login.StorageTargetParameter) == "" {
+		return false
+	}
+	if _, readBack := r.Form[login.StorageStateParameter]; readBack {
+		return false
+	}
+
+	return !hasStateCookie(r)
+}
+
+// hasStateCookie reports whether the request carries the state cookie or its legacy copy.
+func hasStateCookie(r *http.Request) bool {
+	if _, err := r.Cookie(login.StateCookieName); err == nil {
+		return true
+	}
+	_, err := r.Cookie(login.LegacyStateCookieName)
+
+	return err == nil
+}
+
+// writePlatformStoragePage writes the page that reads the state back from the platform's storage frame and posts the
+// launch's parameters again, with a Content Security Policy permitting only the page's script and the form's
+// submission to the tool.
+func writePlatformStoragePage(w http.ResponseWriter, r *http.Request, registration datastore.Registration) error {
+	origin, err := login.StorageOrigin(registration.AuthLoginURI)
+	if err != nil {
+		return fmt.Errorf("could not determine platform storage origin: %w", err)
+	}
+	nonce, err := autosubmit.NewNonce()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(r.Form))
+	for name := range r.Form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []autosubmit.Field
+	for _, name := range names {
+		for _, value := range r.Form[name] {
+			fields = append(fields, autosubmit.Field{Name: name, Value: value})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'none'; script-src 'nonce-%s'; form-action 'self'; base-uri 'none'", nonce))
+
+	return platformStorageTemplate.Execute(w, platformStoragePage{
+		Action:    r.URL.Path,
+		Fields:    fields,
+		Parameter: login.StorageStateParameter,
+		Target:    r.FormValue(login.StorageTargetParameter),
+		Origin:    origin,
+		Key:       login.StateStorageKey(r.FormValue("state")),
+		Nonce:     nonce,
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// TenantContextKey is the context key used to attach the resolved tenant to the request context.
+const TenantContextKey = ContextKeyType("Tenant")
+
+// A TenantResolver determines an application tenant from the claims of a launch.
+type TenantResolver func(claims map[string]interface{}) (string, error)
+
+// TenantFromIssuerAndDeployment returns a TenantResolver that identifies the tenant by the issuer and deployment ID of
+// the launch, i.e., one tenant per platform-tool integration. The tenant is the path-escaped issuer and deployment ID
+// joined by a slash, e.g., "https:%2F%2Fplatform.tld/1", so that no other issuer and deployment ID, which may both
+// contain slashes, can identify the same tenant.
+func TenantFromIssuerAndDeployment() TenantResolver {
+	return func(launchClaims map[string]interface{}) (string, error) {
+		issuer, ok := launchClaims["iss"].(string)
+		if !ok || issuer == "" {
+			return "", errors.New("issuer not found in launch data")
+		}
+		deploymentID, ok := launchClaims[claims.DeploymentID].(string)
+		if !ok || deploymentID == "" {
+			return "", errors.New("deployment ID not found in launch data")
+		}
+
+		return url.PathEscape(issuer) + "/" + url.PathEscape(deploymentID), nil
+	}
+}
+
+// TenantFromCustomParameter returns a TenantResolver that identifies the tenant by the named custom parameter, which is
+// configured on the platform as part of the tool's placement.
+func TenantFromCustomParameter(name string) TenantResolver {
+	return func(launchClaims map[string]interface{}) (string, error) {
+		custom, ok := launchClaims[claims.Custom].(map[string]interface{})
+		if !ok {
+			return "", errors.New("custom parameters not found in launch data")
+		}
+		tenant, ok := custom[name].(string)
+		if !ok || tenant == "" {
+			return "", fmt.Errorf("custom parameter %s not found in launch data", name)
+		}
+
+		return tenant, nil
+	}
+}
+
+// ResolveTenant returns middleware to run after a successful launch (i.e., on a request whose context carries a launch
+// ID). It resolves the tenant from the stored launch data and attaches it to the request context before calling next.
+// If the Config's launch data store is nil, it falls back on the in-memory nonpersistent.DefaultStore.
+func ResolveTenant(cfg datastore.Config, resolve TenantResolver, next http.Handler) http.Handler {
+	if cfg.LaunchData == nil {
+		cfg.LaunchData = nonpersistent.DefaultStore
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		launchID, ok := r.Context().Value(ContextKey).(string)
+		if !ok || launchID == "" {
+			http.Error(w, "launch ID not found in request", http.StatusUnauthorized)
+			return
+		}
+
+		launchData, err := cfg.LaunchData.FindLaunchData(launchID)
+		if err != nil {
+			if errors.Is(err, datastore.ErrLaunchDataNotFound) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			http.Error(w, fmt.Sprintf("resolve tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		var launchClaims map[string]interface{}
+		err = json.Unmarshal(launchData, &launchClaims)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("resolve tenant: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		tenant, err := resolve(launchClaims)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("resolve tenant: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), TenantContextKey, tenant)))
+	})
+}
+
+// TenantFromContext returns the tenant attached to the context by ResolveTenant, or an empty string.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(TenantContextKey).(string)
+
+	return tenant
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package launch
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// A Timing breaks down the time spent on a launch, so that operators can tell whether slow launches are caused by the
+// platform's keyset, the datastore or the verification itself. The durations are measured with the system's
+// monotonic clock, not the Config's Clock.
+type Timing struct {
+	Start time.Time
+	Total time.Duration
+
+	// Stages lists the duration of each stage performed, in order. A failed launch ends with the stage that failed,
+	// which is also given by FailedStage; FailedStage is empty for a successful launch.
+	Stages      []StageTiming
+	FailedStage Stage
+
+	// Keyset is the time spent retrieving the platform's keyset, as part of StageSignature. It is near zero when the
+	// keyset is cached.
+	Keyset time.Duration
+
+	// Datastore is the time spent in the calls to the Config's stores, across the stages, and DatastoreCalls is the
+	// number of calls.
+	Datastore      time.Duration
+	DatastoreCalls int
+}
+
+// A StageTiming is the duration of a stage of a launch.
+type StageTiming struct {
+	Stage    Stage
+	Duration time.Duration
+}
+
+// Stage returns the duration of the stage, or zero if it was not performed.
+func (t Timing) Stage(stage Stage) time.Duration {
+	var duration time.Duration
+	for _, s := range t.Stages {
+		if s.Stage == stage {
+			duration += s.Duration
+		}
+	}
+
+	return duration
+}
+
+// A TimingHook receives the Timing of each launch, successful or not, e.g., to record it as metrics or to log slow
+// launches. It is called before the launch is passed to the next handler or callback, whose time is not included.
+type TimingHook func(r *http.Request, timing Timing)
+
+// A launchTimer records the Timing of one launch.
+type launchTimer struct {
+	timing     Timing
+	stage      Stage
+	stageStart time.Time
+
+	// mu guards the datastore durations, since the hooks of a wrapped store may be called concurrently.
+	mu sync.Mutex
+}
+
+// newLaunchTimer starts the timing of a launch.
+func newLaunchTimer() *launchTimer {
+	return &launchTimer{timing: Timing{Start: time.Now()}}
+}
+
+// withTimer returns a copy of the Launch that records the Timing of a launch, with its stores wrapped to measure the
+// datastore calls.
+func (l *Launch) withTimer() *Launch {
+	timed := *l
+	timed.timer = newLaunchTimer()
+	timed.cfg = datastore.Wrap(l.cfg, datastore.Hooks{After: timed.timer.recordCall})
+
+	return &timed
+}
+
+// begin ends the current stage, if any, and starts the stage. It does nothing when the launch is not timed.
+func (t *launchTimer) begin(stage Stage) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.endStage(now)
+	t.stage, t.stageStart = stage, now
+}
+
+// endStage records the duration of the current stage.
+func (t *launchTimer) endStage(now time.Time) {
+	if t.stage == "" {
+		return
+	}
+	t.timing.Stages = append(t.timing.Stages, StageTiming{Stage: t.stage, Duration: now.Sub(t.stageStart)})
+	t.stage = ""
+}
+
+// recordCall adds the duration of a datastore call.
+func (t *launchTimer) recordCall(call *datastore.Call) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.timing.Datastore += call.Duration
+	t.timing.DatastoreCalls++
+}
+
+// timeKeyset records the time spent retrieving the keyset since start. It does nothing when the launch is not timed.
+func (t *launchTimer) timeKeyset(start time.Time) {
+	if t == nil {
+		return
+	}
+	t.timing.Keyset += time.Since(start)
+}
+
+// finishTiming ends the timing of the launch, which failed in the stage unless it is empty, and passes it to the hook.
+func (l *Launch) finishTiming(r *http.Request, failedStage Stage) {
+	if l.timer == nil || l.TimingHook == nil {
+		return
+	}
+
+	now := time.Now()
+	l.timer.endStage(now)
+	l.timer.timing.Total = now.Sub(l.timer.timing.Start)
+	l.timer.timing.FailedStage = failedStage
+
+	l.timer.mu.Lock()
+	timing := l.timer.timing
+	l.timer.mu.Unlock()
+	l.TimingHook(r, timing)
+}
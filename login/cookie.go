@@ -0,0 +1,43 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"net/http"
+	"strings"
+)
+
+// partitionedAttribute is the Set-Cookie attribute of a partitioned cookie.
+//
+// Ref: https://developer.mozilla.org/en-US/docs/Web/Privacy/Privacy_sandbox/Partitioned_cookies
+const partitionedAttribute = "Partitioned"
+
+// SetPartitionedCookie is like http.SetCookie, but the cookie carries the Partitioned attribute (CHIPS), so that
+// browsers blocking third-party cookies still keep it in the platform's iframe, partitioned by the platform's site.
+// Browsers only accept partitioned cookies that are Secure; the cookie is set without the attribute otherwise.
+func SetPartitionedCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	value := cookie.String()
+	if value == "" {
+		return
+	}
+	if cookie.Secure {
+		value += "; " + partitionedAttribute
+	}
+
+	w.Header().Add("Set-Cookie", value)
+}
+
+// IsPartitioned reports whether the cookie, as parsed from a Set-Cookie header, e.g., by http.Response.Cookies,
+// carries the Partitioned attribute. The attribute is looked up in the cookie's Raw header.
+func IsPartitioned(cookie *http.Cookie) bool {
+	for _, attribute := range strings.Split(cookie.Raw, ";")[1:] {
+		if strings.EqualFold(strings.TrimSpace(attribute), partitionedAttribute) {
+			return true
+		}
+	}
+
+	return false
+}
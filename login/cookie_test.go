@@ -0,0 +1,50 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestPartitionedCookies(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+
+	for _, partitioned := range []bool{false, true} {
+		login.PartitionedCookies = partitioned
+		r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+		w := httptest.NewRecorder()
+		login.ServeHTTP(w, r)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) != 2 {
+			t.Fatalf("got %d cookies, wanted the state cookie and its legacy copy", len(cookies))
+		}
+		for _, cookie := range cookies {
+			if IsPartitioned(cookie) != partitioned || cookie.Value == "" || !cookie.Secure {
+				t.Errorf("got cookie %s, wanted partitioned %t", cookie.Raw, partitioned)
+			}
+		}
+
+		// The launch reads the state cookie from the request as before.
+		launchRequest := httptest.NewRequest(http.MethodPost, "https://tool.tld/launcher", nil)
+		launchRequest.Header.Set("Cookie", cookies[0].Name+"="+cookies[0].Value)
+		if cookie, err := launchRequest.Cookie(StateCookieName); err != nil || cookie.Value != cookies[0].Value {
+			t.Errorf("got state cookie %v, %v", cookie, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	SetPartitionedCookie(w, &http.Cookie{Name: "insecure", Value: "a"})
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || IsPartitioned(cookies[0]) {
+		t.Errorf("got cookies %v for insecure cookie", cookies)
+	}
+}
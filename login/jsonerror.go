@@ -0,0 +1,69 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// CorrelationIDHeader is the request header whose value, e.g., set by a load balancer, is used as the correlation ID
+// of a JSON error response. Requests without it are given a random correlation ID.
+const CorrelationIDHeader = "X-Request-ID"
+
+// A JSONError is the body of the error responses of a Login or Launch whose JSONErrors is set. Code identifies the
+// failure in a form suited to programs, e.g., "stale_launch". Message describes it; the messages of server errors are
+// generic, so that internal details are not shown to the user. CorrelationID identifies the failure in the tool's
+// logs, e.g., in a launch's SupportBundle.
+type JSONError struct {
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// CorrelationID returns the correlation ID of the request: its CorrelationIDHeader, if set, or else a random UUID.
+func CorrelationID(r *http.Request) string {
+	if correlationID := r.Header.Get(CorrelationIDHeader); correlationID != "" {
+		return correlationID
+	}
+
+	return uuid.New().String()
+}
+
+// WriteJSONError responds with the status code and a JSONError describing err.
+func WriteJSONError(w http.ResponseWriter, statusCode int, code, correlationID string, err error) {
+	message := err.Error()
+	if statusCode >= http.StatusInternalServerError {
+		message = http.StatusText(statusCode)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(JSONError{
+		Code:          code,
+		Message:       message,
+		CorrelationID: correlationID,
+	})
+}
+
+// errorCode returns the JSONError code of a failed login request.
+func errorCode(statusCode int, err error) string {
+	switch {
+	case errors.Is(err, ErrTargetLinkURINotRegistered):
+		return "target_link_uri_not_registered"
+	case errors.Is(err, datastore.ErrRegistrationNotFound):
+		return "registration_not_found"
+	case statusCode >= http.StatusInternalServerError:
+		return "internal_error"
+	}
+
+	return "invalid_login_request"
+}
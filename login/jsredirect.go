@@ -0,0 +1,128 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/macewan-cs/lti/autosubmit"
+)
+
+// A JSRedirectPage is the data of the template rendering the page returned by JSRedirect. A template must navigate to
+// the URL, the top-level window if Top is set, and set the Nonce on each of its scripts. If Storage is not nil, the
+// page must first store its values in the platform's storage frame.
+type JSRedirectPage struct {
+	URL     string
+	Top     bool
+	Nonce   string
+	Storage *PlatformStorage
+}
+
+// DefaultJSRedirectTemplate is a minimal page that navigates to the platform as soon as it loads, or as soon as the
+// platform's storage frame has stored the values of the page's Storage. It waits at most two seconds for the storage
+// frame, so that a platform that does not respond does not block the login. Without JavaScript, or when the browser
+// refuses to navigate the top-level window, the user follows the link.
+var DefaultJSRedirectTemplate = template.Must(template.New("jsRedirect").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Redirecting to the platform</title></head>
+<body>
+<p><a id="lti-redirect" href="{{.URL}}"{{if .Top}} target="_top"{{end}}>Continue</a></p>
+<script nonce="{{.Nonce}}">
+(function () {
+	var navigated = false;
+	function navigate() {
+		if (navigated) {
+			return;
+		}
+		navigated = true;
+		try {
+			({{if .Top}}window.top{{else}}window{{end}}).location.replace({{.URL}});
+		} catch (e) {}
+	}
+{{- with .Storage}}
+	var target = {{.Target}}, origin = {{.Origin}}, values = {{.Values}};
+	var frame = target === "_parent" ? window.parent : window.parent.frames[target];
+	if (!frame || frame === window) {
+		navigate();
+		return;
+	}
+	var pending = {};
+	window.addEventListener("message", function (event) {
+		var data = event.data;
+		if (event.origin !== origin || !data || data.subject !== "lti.put_data.response" || !pending[data.message_id]) {
+			return;
+		}
+		delete pending[data.message_id];
+		if (Object.keys(pending).length === 0) {
+			navigate();
+		}
+	});
+	Object.keys(values).forEach(function (key, i) {
+		var id = "lti-put-" + i + "-" + Math.random().toString(36).slice(2);
+		pending[id] = true;
+		frame.postMessage({subject: "lti.put_data", message_id: id, key: key, value: values[key]}, origin);
+	});
+	setTimeout(navigate, 2000);
+{{- else}}
+	navigate();
+{{- end}}
+})();
+</script>
+</body>
+</html>
+`))
+
+// JSRedirect handles a login request like ServeHTTP, but instead of redirecting it returns an HTML page whose script
+// navigates to the platform's auth endpoint, e.g., for tools that serve the page themselves within the platform's
+// iframe, or that break out of it (see BreakOutOfFrame). The state cookies, unless the state is kept on the server or
+// signed, and a Content Security Policy permitting only the page's script are set on w; the caller then writes the
+// page. With PlatformStorage, the page also stores the state and nonce in the platform's storage frame.
+func (l *Login) JSRedirect(w http.ResponseWriter, r *http.Request) (string, error) {
+	redirectURI, stateCookie, err := l.RedirectURI(r)
+	if err != nil {
+		return "", err
+	}
+
+	return l.redirectPage(w, r, redirectURI, stateCookie)
+}
+
+// redirectPage renders the page of JSRedirect for the auth redirect URI, and sets the state cookies and the page's
+// headers.
+func (l *Login) redirectPage(w http.ResponseWriter, r *http.Request, redirectURI string,
+	stateCookie http.Cookie) (string, error) {
+	nonce, err := autosubmit.NewNonce()
+	if err != nil {
+		return "", err
+	}
+	data := JSRedirectPage{URL: redirectURI, Top: l.BreakOutOfFrame, Nonce: nonce}
+	if l.usePlatformStorage(r) {
+		data.Storage, err = platformStorage(r.FormValue(StorageTargetParameter), redirectURI)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	page := l.JSRedirectTemplate
+	if page == nil {
+		page = DefaultJSRedirectTemplate
+	}
+	var b bytes.Buffer
+	err = page.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("could not render redirect page: %w", err)
+	}
+
+	l.setStateCookies(w, stateCookie)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; base-uri 'none'",
+		nonce))
+
+	return b.String(), nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestJSRedirect(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+
+	r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+	w := httptest.NewRecorder()
+	page, err := login.JSRedirect(w, r)
+	if err != nil {
+		t.Fatalf("js redirect error: %v", err)
+	}
+	if !strings.Contains(page, `(window).location.replace("https:`) || !strings.Contains(page, "state=state-") {
+		t.Errorf("got page %s", page)
+	}
+	policy := w.Header().Get("Content-Security-Policy")
+	if !strings.Contains(policy, "script-src 'nonce-") || !strings.Contains(page, `<script nonce="`) {
+		t.Errorf("got policy %s for page %s", policy, page)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 2 || cookies[0].Name != StateCookieName {
+		t.Errorf("got cookies %v", cookies)
+	}
+
+	login.BreakOutOfFrame = true
+	page, err = login.JSRedirect(httptest.NewRecorder(), r)
+	if err != nil || !strings.Contains(page, "window.top).location") || !strings.Contains(page, `target="_top"`) {
+		t.Errorf("got page %s, error %v breaking out of the frame", page, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://tool.tld/login", nil)
+	if _, err := login.JSRedirect(httptest.NewRecorder(), r); err == nil {
+		t.Error("invalid login request not reported")
+	}
+}
@@ -7,11 +7,20 @@
 package login
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/macewan-cs/lti/autosubmit"
+	"github.com/macewan-cs/lti/claims"
 	"github.com/macewan-cs/lti/datastore"
 	"github.com/macewan-cs/lti/datastore/nonpersistent"
 )
@@ -21,6 +30,14 @@ const (
 	LegacyStateCookieName = StateCookieName + "-legacy"
 )
 
+// DefaultStateCookieMaxAge is the time for which a state cookie is kept when a Login's StateCookieMaxAge is zero. Like
+// nonpersistent.DefaultNonceTTL, it allows ample time for the platform to complete the launch.
+const DefaultStateCookieMaxAge = 10 * time.Minute
+
+// statePrefix begins each state value. It is followed by the state's expiry, in Unix time, an underscore and a random
+// UUID.
+const statePrefix = "state-"
+
 // New creates a new login object. If the passed Config has zero-value store interfaces, fall back on the in-memory
 // nonpersistent.DefaultStore.
 func New(cfg datastore.Config) *Login {
@@ -34,29 +51,176 @@ func New(cfg datastore.Config) *Login {
 	if login.cfg.Nonces == nil {
 		login.cfg.Nonces = nonpersistent.DefaultStore
 	}
+	if login.cfg.States == nil {
+		login.cfg.States = nonpersistent.DefaultStore
+	}
 
 	return &login
 }
 
+// NewStrict is like New, but it returns an error wrapping datastore.ErrStoreNotConfigured instead of falling back on
+// the nonpersistent default store when the Config's registrations or nonces store is nil.
+func NewStrict(cfg datastore.Config) (*Login, error) {
+	err := cfg.RequireStores(datastore.RegistrationsStore, datastore.NoncesStore)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cfg), nil
+}
+
 // A Login implements an http.Handler that can be easily associated with a tool URI such as /services/lti/login/.
 type Login struct {
 	cfg datastore.Config
+
+	// ClaimGroups lists the groups of identity claims that the tool needs. When set, the auth request includes an
+	// OpenID Connect `claims' parameter asking for only those claims, which platforms supporting claims
+	// minimization use to withhold the rest. When nil, the platform decides which claims to send.
+	ClaimGroups []ClaimGroup
+
+	// TargetLinkURIPolicy selects how the target_link_uri of a login request is matched against the registration's
+	// TargetLinkURI and the AdditionalTargetLinkURIs. A login request for any other URI is rejected with
+	// ErrTargetLinkURINotRegistered, so that the tool cannot be made to start a launch for a foreign URI.
+	TargetLinkURIPolicy TargetLinkURIPolicy
+
+	// AdditionalTargetLinkURIs lists tool URIs, other than the registration's TargetLinkURI, that login requests may
+	// target, e.g., the deep linking endpoint.
+	AdditionalTargetLinkURIs []*url.URL
+
+	// StateCookieMaxAge is the time for which the state cookie is kept, and after which the launch is rejected as
+	// stale. When it is zero, DefaultStateCookieMaxAge is used.
+	StateCookieMaxAge time.Duration
+
+	// ErrorHandler, if set, responds to failed login requests in place of http.Error, e.g., to render a branded error
+	// page without showing the error's internal details to the user.
+	ErrorHandler ErrorHandler
+
+	// JSONErrors, if set, makes failed login requests respond with a JSONError instead of plain text, e.g., for tools
+	// whose pages in the platform's iframe are single-page applications. The ErrorHandler takes precedence.
+	JSONErrors bool
+
+	// FormPost, if set, sends the auth request to the platform with a self-submitting form (POST) instead of a
+	// redirect, as OpenID Connect permits. The page is served from the tool's origin along with the state cookies, so
+	// that tools bootstrapping cookies in the platform's iframe can customize it through AutoSubmit, e.g., with a
+	// template that requests storage access before submitting the form.
+	FormPost bool
+
+	// AutoSubmit renders the page of the FormPost auth request. When it is nil, autosubmit.DefaultRenderer is used.
+	AutoSubmit *autosubmit.Renderer
+
+	// PartitionedCookies, if set, sets the state cookies with the Partitioned attribute (see SetPartitionedCookie),
+	// so that launches in the platform's iframe keep working in browsers that block third-party cookies. The launch
+	// reads the state cookie the same way whether or not it is partitioned.
+	PartitionedCookies bool
+
+	// ServerSideState, if set, stores the state of each login in the Config's States store, along with its nonce, and
+	// sets no state cookie, for browsers that block cookies in the platform's iframe entirely, e.g., Safari and
+	// embedded webviews. The Launch must set ServerSideState as well.
+	ServerSideState bool
+
+	// StateSigner, if set, issues signed states carrying the nonce and target link URI of each login, and neither
+	// stores the nonce nor sets a state cookie, so that any instance of the tool holding the same key can verify the
+	// launch. The Launch must be given a StateSigner with the same key. It takes precedence over ServerSideState.
+	StateSigner *StateSigner
+
+	// BreakOutOfFrame, if set, makes the page returned by JSRedirect navigate the top-level window to the platform
+	// rather than the frame it is displayed in, so that the launch is not framed and its cookies are first-party.
+	// Browsers only permit it when the platform's iframe allows top navigation, or after a user interaction.
+	BreakOutOfFrame bool
+
+	// JSRedirectTemplate renders the page returned by JSRedirect. When it is nil, DefaultJSRedirectTemplate is used.
+	JSRedirectTemplate *template.Template
+
+	// PlatformStorage, if set, stores the state and nonce of each login in the platform's storage frame through the
+	// LTI platform storage postMessage API, when the platform names the frame in the login request (see
+	// StorageTargetParameter). The login then responds with the page of JSRedirect, which stores them before it
+	// navigates to the platform, so that the launch does not depend on third-party cookies. The state is also stored
+	// in the Config's States store, along with its nonce, so that the launch can verify the state read back from the
+	// frame. The state cookies are still set for browsers that accept them. The Launch must set PlatformStorage as
+	// well.
+	PlatformStorage bool
+}
+
+// An ErrorHandler responds to a failed request with the status code, e.g., http.StatusBadRequest, and the error that
+// caused the failure. The error may describe internal details, such as datastore failures, that are meant for logs
+// rather than for the user.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, statusCode int, err error)
+
+// A TargetLinkURIPolicy selects how the target_link_uri of a login request is matched against the registered URIs.
+type TargetLinkURIPolicy int
+
+// Target link URI policies. The zero value is TargetLinkURIOrigin.
+const (
+	// TargetLinkURIOrigin accepts a URI with the scheme and host of a registered URI.
+	TargetLinkURIOrigin TargetLinkURIPolicy = iota
+	// TargetLinkURIPrefix accepts a URI with the scheme and host of a registered URI whose path is, or is below, the
+	// registered path.
+	TargetLinkURIPrefix
+	// TargetLinkURIExact accepts only a registered URI, ignoring any query or fragment.
+	TargetLinkURIExact
+)
+
+// ErrTargetLinkURINotRegistered is returned when the target_link_uri of a login request does not match a registered
+// URI according to the Login's TargetLinkURIPolicy.
+var ErrTargetLinkURINotRegistered = errors.New("target link uri not registered")
+
+// A ClaimGroup names a set of related identity claims that a tool may require from the platform.
+type ClaimGroup string
+
+// Claim groups supported by ClaimGroups.
+const (
+	ClaimGroupIdentity ClaimGroup = "identity"
+	ClaimGroupEmail    ClaimGroup = "email"
+	ClaimGroupRoles    ClaimGroup = "roles"
+)
+
+// claimGroups maps each claim group to its id_token claims.
+var claimGroups = map[ClaimGroup][]string{
+	ClaimGroupIdentity: {"name", "given_name", "family_name"},
+	ClaimGroupEmail:    {"email"},
+	ClaimGroupRoles:    {claims.Roles},
+}
+
+// Claims returns the names of the id_token claims in the claim group.
+func (g ClaimGroup) Claims() []string {
+	return claimGroups[g]
 }
 
 // RedirectURI extracts the form data from the initial login request and returns a auth redirect URI and state cookie.
 // The login must cache the "nonce" locally and include it in the response.
 func (l *Login) RedirectURI(r *http.Request) (string, http.Cookie, error) {
+	if err := UseRequestValues(r); err != nil {
+		return "", http.Cookie{}, err
+	}
+
 	registration, err := l.validate(r)
 	if err != nil {
 		return "", http.Cookie{}, err
 	}
+	// The nonce is bound to the validated target link URI of the request, which the platform returns in the id_token;
+	// it may differ from the registration's TargetLinkURI, e.g., for a deep linking endpoint.
+	targetLinkURI := r.FormValue("target_link_uri")
 
-	// Generate state and state cookie.
-	state := "state-" + uuid.New().String()
+	// Generate state and state cookie. The state carries its expiry, so that the launch can tell a stale launch from a
+	// missing cookie.
+	maxAge := l.StateCookieMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultStateCookieMaxAge
+	}
+	expiry := datastore.Now(l.cfg.Clock).Add(maxAge)
+	nonce := uuid.New().String()
+	state := statePrefix + strconv.FormatInt(expiry.Unix(), 10) + "_" + uuid.New().String()
+	if l.StateSigner != nil {
+		state, err = l.StateSigner.Sign(nonce, targetLinkURI, expiry)
+		if err != nil {
+			return "", http.Cookie{}, err
+		}
+	}
 	stateCookie := http.Cookie{
-		Name:  StateCookieName,
-		Value: state,
-		Path:  registration.TargetLinkURI.EscapedPath(),
+		Name:   StateCookieName,
+		Value:  state,
+		Path:   registration.TargetLinkURI.EscapedPath(),
+		MaxAge: int(maxAge.Seconds()),
 		// Recent versions of Chrome have changed the default handling of Cookies. To support these versions of
 		// Chrome, the following options are necessary.
 		//
@@ -65,11 +229,19 @@ func (l *Login) RedirectURI(r *http.Request) (string, http.Cookie, error) {
 		Secure:   true,
 	}
 
-	// Generate and store nonce.
-	nonce := uuid.New().String()
-	err = l.cfg.Nonces.StoreNonce(nonce, registration.TargetLinkURI.String())
-	if err != nil {
-		return "", http.Cookie{}, err
+	// Store the nonce, and the state if it is kept on the server or in the platform's storage frame, unless the signed
+	// state carries the nonce.
+	if l.StateSigner == nil {
+		err = l.cfg.Nonces.StoreNonce(nonce, targetLinkURI)
+		if err != nil {
+			return "", http.Cookie{}, err
+		}
+		if l.ServerSideState || l.usePlatformStorage(r) {
+			err = l.cfg.States.StoreState(state, nonce, expiry)
+			if err != nil {
+				return "", http.Cookie{}, err
+			}
+		}
 	}
 
 	// Build auth response to initial login request.
@@ -84,37 +256,90 @@ func (l *Login) RedirectURI(r *http.Request) (string, http.Cookie, error) {
 	values.Set("nonce", nonce)
 	values.Set("login_hint", r.FormValue("login_hint"))
 
+	// Ask for only the claims the tool needs, if it has declared them.
+	if len(l.ClaimGroups) > 0 {
+		claimsParameter, err := claimsRequestParameter(l.ClaimGroups)
+		if err != nil {
+			return "", http.Cookie{}, err
+		}
+		values.Set("claims", claimsParameter)
+	}
+
 	// Pass back the message hint if received.
 	if r.FormValue("lti_message_hint") != "" {
 		values.Set("lti_message_hint", r.FormValue("lti_message_hint"))
 	}
 
-	redirectURI := registration.AuthLoginURI
+	// The registration's URL may be shared, e.g., by a datastore.RegistrationCache, so the query is set on a copy.
+	redirectURI := *registration.AuthLoginURI
 	redirectURI.RawQuery = values.Encode()
 	return redirectURI.String(), stateCookie, nil
 }
 
-// JSRedirect will return JS code to perform the redirect.
-func (l *Login) JSRedirect(w http.ResponseWriter, r *http.Request) (string, error) {
-	redirect, stateCookie, err := l.RedirectURI(r)
+// StateExpiry returns the expiry carried by a state value issued by a Login. It returns false for state values that do
+// not carry one, e.g., those issued by earlier versions.
+func StateExpiry(state string) (time.Time, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(state, statePrefix), "_", 2)
+	if !strings.HasPrefix(state, statePrefix) || len(parts) != 2 {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return "", err
+		return time.Time{}, false
 	}
 
-	return "JS redirect code using: " + redirect + stateCookie.Name, nil
+	return time.Unix(seconds, 0), true
 }
 
 // ServeHTTP makes Login an http.Handler so that it can easily be associated with tool URI, e.g., /services/lti/login/.
 // The handler must set the "state" in a cookie (in addition to including it in the response) and the two will be
-// compared in the launch.
+// compared in the launch, unless the state is kept on the server or signed (see ServerSideState and StateSigner).
 func (l *Login) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	redirectURI, stateCookie, err := l.RedirectURI(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		statusCode := http.StatusBadRequest
+		if errors.Is(err, ErrMethodNotAllowed) {
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			statusCode = http.StatusMethodNotAllowed
+		}
+		l.fail(w, r, statusCode, err)
+		return
+	}
+
+	if l.usePlatformStorage(r) {
+		page, err := l.redirectPage(w, r, redirectURI, stateCookie)
+		if err != nil {
+			l.fail(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		io.WriteString(w, page)
 		return
 	}
 
-	http.SetCookie(w, &stateCookie)
+	l.setStateCookies(w, stateCookie)
+
+	if l.FormPost {
+		if err := l.writeAuthForm(w, redirectURI); err != nil {
+			l.fail(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, redirectURI, http.StatusFound)
+}
+
+// setStateCookies sets the state cookie, and its legacy copy when needed, unless the state is kept on the server or
+// signed.
+func (l *Login) setStateCookies(w http.ResponseWriter, stateCookie http.Cookie) {
+	if l.ServerSideState || l.StateSigner != nil {
+		return
+	}
+
+	setCookie := http.SetCookie
+	if l.PartitionedCookies {
+		setCookie = SetPartitionedCookie
+	}
+	setCookie(w, &stateCookie)
 
 	if stateCookie.SameSite == http.SameSiteNoneMode {
 		// Not all browsers support the SameSite=None setting. Create and attach a copy of the cookie without the
@@ -125,13 +350,69 @@ func (l *Login) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		legacyStateCookie.Name = LegacyStateCookieName
 		legacyStateCookie.SameSite = http.SameSiteDefaultMode
 
-		http.SetCookie(w, &legacyStateCookie)
+		setCookie(w, &legacyStateCookie)
 	}
+}
 
-	http.Redirect(w, r, redirectURI, http.StatusFound)
+// writeAuthForm writes a self-submitting form that POSTs the auth request of the redirect URI to the platform, with a
+// Content Security Policy permitting only the form's script and its submission to the platform.
+func (l *Login) writeAuthForm(w http.ResponseWriter, redirectURI string) error {
+	renderer := l.AutoSubmit
+	if renderer == nil {
+		renderer = autosubmit.DefaultRenderer
+	}
+
+	action, fields, err := autosubmit.URLFields(redirectURI)
+	if err != nil {
+		return fmt.Errorf("could not write auth form: %w", err)
+	}
+
+	return renderer.WriteWithPolicy(w, action, fields)
 }
 
-// validate checks for the presence of the issuer and login_hint, and existence of a registration for that issuer.
+// fail responds to a failed login request with the ErrorHandler, if set, or else with a JSONError or http.Error.
+func (l *Login) fail(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	if l.ErrorHandler != nil {
+		l.ErrorHandler(w, r, statusCode, err)
+		return
+	}
+	if l.JSONErrors {
+		WriteJSONError(w, statusCode, errorCode(statusCode, err), CorrelationID(r), err)
+		return
+	}
+
+	http.Error(w, err.Error(), statusCode)
+}
+
+// claimsRequestParameter builds the OpenID Connect `claims' request parameter for the claim groups.
+//
+// Ref: https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter
+func claimsRequestParameter(groups []ClaimGroup) (string, error) {
+	type claimRequest struct {
+		Essential bool `json:"essential"`
+	}
+
+	idTokenClaims := map[string]claimRequest{}
+	for _, group := range groups {
+		groupClaims := group.Claims()
+		if groupClaims == nil {
+			return "", fmt.Errorf("unknown claim group %s", group)
+		}
+		for _, claim := range groupClaims {
+			idTokenClaims[claim] = claimRequest{Essential: true}
+		}
+	}
+
+	claimsParameter, err := json.Marshal(map[string]interface{}{"id_token": idTokenClaims})
+	if err != nil {
+		return "", fmt.Errorf("could not encode claims request parameter: %w", err)
+	}
+
+	return string(claimsParameter), nil
+}
+
+// validate checks for the presence of the issuer and login_hint, existence of a registration for that issuer, and
+// that the target link uri is registered.
 func (l *Login) validate(r *http.Request) (datastore.Registration, error) {
 	// Validate issuer.
 	if r.FormValue("iss") == "" {
@@ -154,5 +435,55 @@ func (l *Login) validate(r *http.Request) (datastore.Registration, error) {
 		return datastore.Registration{}, err
 	}
 
+	if l.cfg.StrictHTTPS {
+		if err := datastore.ValidateRegistrationSecurity(registration); err != nil {
+			return datastore.Registration{}, err
+		}
+	}
+
+	if err := l.validateTargetLinkURI(r.FormValue("target_link_uri"), registration); err != nil {
+		return datastore.Registration{}, err
+	}
+
 	return registration, nil
 }
+
+// validateTargetLinkURI checks that the target link uri of the login request matches the registration's
+// TargetLinkURI or one of the AdditionalTargetLinkURIs.
+func (l *Login) validateTargetLinkURI(rawTargetLinkURI string, registration datastore.Registration) error {
+	targetLinkURI, err := url.Parse(rawTargetLinkURI)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTargetLinkURINotRegistered, err)
+	}
+
+	registered := append([]*url.URL{registration.TargetLinkURI}, l.AdditionalTargetLinkURIs...)
+	for _, registeredURI := range registered {
+		if registeredURI != nil && l.TargetLinkURIPolicy.matches(targetLinkURI, registeredURI) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrTargetLinkURINotRegistered, rawTargetLinkURI)
+}
+
+// matches reports whether the target link uri is accepted for the registered URI under the policy.
+func (p TargetLinkURIPolicy) matches(targetLinkURI, registeredURI *url.URL) bool {
+	if !strings.EqualFold(targetLinkURI.Scheme, registeredURI.Scheme) ||
+		!strings.EqualFold(targetLinkURI.Host, registeredURI.Host) {
+		return false
+	}
+
+	targetPath, registeredPath := targetLinkURI.EscapedPath(), registeredURI.EscapedPath()
+	switch p {
+	case TargetLinkURIOrigin:
+		return true
+	case TargetLinkURIPrefix:
+		// Match on path segments, so that /launcher is not accepted for /launch.
+		registeredPath = strings.TrimSuffix(registeredPath, "/")
+		return targetPath == registeredPath || strings.HasPrefix(targetPath, registeredPath+"/")
+	case TargetLinkURIExact:
+		return targetPath == registeredPath
+	default:
+		return false
+	}
+}
@@ -7,12 +7,20 @@ package login
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/macewan-cs/lti/autosubmit"
 	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
 )
 
 // Set up a test Registration.
@@ -50,6 +58,19 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewStrict(t *testing.T) {
+	_, err := NewStrict(datastore.Config{Registrations: nonpersistent.New()})
+	if !errors.Is(err, datastore.ErrStoreNotConfigured) {
+		t.Errorf("got %v, wanted ErrStoreNotConfigured", err)
+	}
+
+	store := nonpersistent.New()
+	login, err := NewStrict(datastore.Config{Registrations: store, Nonces: store})
+	if err != nil || login == nil {
+		t.Errorf("got login %v, error %v", login, err)
+	}
+}
+
 // Test the validate checks with appropriately malformed requests.
 func TestValidate(t *testing.T) {
 	login := New(datastore.Config{})
@@ -109,4 +130,234 @@ func TestRedirectURI(t *testing.T) {
 	if cookie.Name != "stateCookie" || cookie.Value != redirectURI.Query().Get("state") {
 		t.Fatalf("redirect uri cookie error")
 	}
+	if cookie.MaxAge != int(DefaultStateCookieMaxAge.Seconds()) {
+		t.Errorf("got state cookie max age %d", cookie.MaxAge)
+	}
+	expiry, ok := StateExpiry(cookie.Value)
+	if !ok || time.Until(expiry) > DefaultStateCookieMaxAge || time.Until(expiry) < DefaultStateCookieMaxAge/2 {
+		t.Errorf("got state expiry %v, %v", expiry, ok)
+	}
+	if _, ok := StateExpiry("state-12345678-1234-1234-1234-123456789012"); ok {
+		t.Error("expiry found in state without one")
+	}
+}
+
+// Test that concurrent logins do not share the registration's auth login URI, whether the store returns the same URL to
+// every caller or a RegistrationCache copies it; run with -race to detect them writing to the same URL.
+func TestRedirectURIConcurrent(t *testing.T) {
+	for _, registrations := range []datastore.RegistrationStorer{
+		nonpersistent.New(),
+		datastore.NewRegistrationCache(nonpersistent.New(), 0),
+	} {
+		registrations.StoreRegistration(getRegistration())
+		login := New(datastore.Config{Registrations: registrations, Nonces: nonpersistent.New()})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := httptest.NewRequest(http.MethodPost, "https://tool.tld/login", bytes.NewReader(getPostBody()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				if _, _, err := login.RedirectURI(r); err != nil {
+					t.Errorf("redirect uri error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		reg, _ := registrations.FindRegistrationByIssuerAndClientID(getRegistration().Issuer, getRegistration().ClientID)
+		if reg.AuthLoginURI.RawQuery != "" {
+			t.Errorf("%T: got auth login URI %s, wanted it unchanged", registrations, reg.AuthLoginURI)
+		}
+	}
+}
+
+// Test login initiation by GET and the rejection of other methods.
+func TestRequestMethods(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+
+	r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("got status %d for GET login, wanted %d", w.Code, http.StatusFound)
+	}
+
+	// The parameters of a POST request are not taken from its query.
+	r = httptest.NewRequest(http.MethodPost, "https://tool.tld/login?"+string(getPostBody()), nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, _, err := login.RedirectURI(r); err == nil {
+		t.Error("POST login accepted with parameters in its query")
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "https://tool.tld/login", bytes.NewReader(getPostBody()))
+	w = httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Errorf("got status %d, Allow %q for PUT login", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestFormPost(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	login.FormPost = true
+
+	r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, `name="response_mode" value="form_post"`) ||
+		!strings.Contains(body, `method="POST"`) {
+		t.Errorf("got status %d with page %s", w.Code, body)
+	}
+	if w.Header().Get("Content-Security-Policy") == "" || len(w.Result().Cookies()) != 2 {
+		t.Errorf("got headers %v", w.Header())
+	}
+
+	login.AutoSubmit = &autosubmit.Renderer{Template: template.Must(template.New("custom").Parse(
+		`{{.Action}}{{range .Fields}} {{.Name}}{{end}}`))}
+	w = httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), " client_id ") || strings.Contains(w.Body.String(), "<form") {
+		t.Errorf("got page %s from custom template", w.Body.String())
+	}
+}
+
+func TestServerSideState(t *testing.T) {
+	store := nonpersistent.New()
+	login := New(datastore.Config{Registrations: store, Nonces: store, States: store})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	login.ServerSideState = true
+
+	r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	if cookies := w.Result().Cookies(); w.Code != http.StatusFound || len(cookies) != 0 {
+		t.Fatalf("got status %d with cookies %v", w.Code, cookies)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("cannot parse redirect: %v", err)
+	}
+	query := location.Query()
+	if err := store.TestAndClearState(query.Get("state"), query.Get("nonce")); err != nil {
+		t.Errorf("got %v for stored state", err)
+	}
+}
+
+// Test that failed login requests are passed to the ErrorHandler.
+func TestErrorHandler(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	var handledStatus int
+	login.ErrorHandler = func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+		handledStatus = statusCode
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "https://tool.tld/login", bytes.NewReader(getPostBody()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+
+	if handledStatus != http.StatusBadRequest || w.Code != http.StatusTeapot {
+		t.Errorf("got handled status %d, response status %d", handledStatus, w.Code)
+	}
+}
+
+// Test the JSON error responses of failed login requests.
+func TestJSONErrors(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New()})
+	login.JSONErrors = true
+
+	r := httptest.NewRequest(http.MethodPost, "https://tool.tld/login", bytes.NewReader(getPostBody()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+
+	var body JSONError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || body.Code != "registration_not_found" || body.CorrelationID == "" {
+		t.Errorf("got status %d, body %+v", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	WriteJSONError(w, http.StatusInternalServerError, "internal_error", "1", errors.New("database password"))
+	if strings.Contains(w.Body.String(), "password") {
+		t.Errorf("server error details in body %q", w.Body.String())
+	}
+}
+
+// Test the claims parameter added for declared claim groups.
+func TestRedirectURIClaimGroups(t *testing.T) {
+	login := New(datastore.Config{})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	login.ClaimGroups = []ClaimGroup{ClaimGroupEmail}
+
+	r := httptest.NewRequest(http.MethodPost, "https://tool.tld/login", bytes.NewReader(getPostBody()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	redirect, _, err := login.RedirectURI(r)
+	if err != nil {
+		t.Fatalf("redirect uri error: %v", err)
+	}
+	redirectURI, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatalf("redirect uri parse error: %v", err)
+	}
+	expected := `{"id_token":{"email":{"essential":true}}}`
+	if actual := redirectURI.Query().Get("claims"); actual != expected {
+		t.Fatalf("got claims %s, wanted %s", actual, expected)
+	}
+
+	login.ClaimGroups = []ClaimGroup{"unknown"}
+	_, _, err = login.RedirectURI(r)
+	if err == nil {
+		t.Fatal("error not reported for unknown claim group")
+	}
+}
+
+// Test the matching of the target link uri against the registered URIs.
+func TestValidateTargetLinkURI(t *testing.T) {
+	login := New(datastore.Config{Registrations: nonpersistent.New()})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	deepLinkingURI, _ := url.Parse("https://tool.tld/deeplinking")
+	login.AdditionalTargetLinkURIs = []*url.URL{deepLinkingURI}
+
+	tests := []struct {
+		policy        TargetLinkURIPolicy
+		targetLinkURI string
+		accepted      bool
+	}{
+		{TargetLinkURIOrigin, "https://tool.tld/other", true},
+		{TargetLinkURIOrigin, "https://attacker.tld/launcher", false},
+		{TargetLinkURIOrigin, "http://tool.tld/launcher", false},
+		{TargetLinkURIPrefix, "https://tool.tld/launcher/activity/1", true},
+		{TargetLinkURIPrefix, "https://tool.tld/launcher2", false},
+		{TargetLinkURIExact, "https://tool.tld/launcher?activity=1", true},
+		{TargetLinkURIExact, "https://tool.tld/deeplinking", true},
+		{TargetLinkURIExact, "https://tool.tld/launcher/activity/1", false},
+	}
+
+	for _, test := range tests {
+		login.TargetLinkURIPolicy = test.policy
+		body := "iss=https://platform.tld/instance&login_hint=1&client_id=abcdef123456&target_link_uri=" +
+			url.QueryEscape(test.targetLinkURI)
+		r := httptest.NewRequest(http.MethodPost, "https://tool.tld/login", bytes.NewReader([]byte(body)))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		_, err := login.validate(r)
+		if test.accepted && err != nil {
+			t.Errorf("policy %d, %s: got error %v", test.policy, test.targetLinkURI, err)
+		}
+		if !test.accepted && !errors.Is(err, ErrTargetLinkURINotRegistered) {
+			t.Errorf("policy %d, %s: got %v, wanted ErrTargetLinkURINotRegistered", test.policy, test.targetLinkURI,
+				err)
+		}
+	}
 }
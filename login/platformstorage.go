@@ -0,0 +1,86 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// The parameters of the platform storage flow. Platforms supporting platform storage send StorageTargetParameter with
+// the login request and the launch; StorageStateParameter is added by the tool's launch page when it re-posts the
+// launch with the state read back from the platform's storage.
+//
+// Ref: https://www.imsglobal.org/spec/lti-cs-oidc/v0p1
+const (
+	StorageTargetParameter = "lti_storage_target"
+	StorageStateParameter  = "lti_storage_state"
+)
+
+// A PlatformStorage describes the values that a page stores in the platform's storage frame, with the lti.put_data
+// messages of the platform storage API, before it navigates to the platform.
+type PlatformStorage struct {
+	// Target is the frame named by the StorageTargetParameter, e.g., "_parent".
+	Target string
+
+	// Origin is the platform's origin, the only one to which the messages are sent and from which responses are
+	// accepted.
+	Origin string
+
+	// Values maps the keys to store, e.g., StateStorageKey(state), to their values.
+	Values map[string]string
+}
+
+// StateStorageKey returns the platform storage key under which a login stores its state.
+func StateStorageKey(state string) string {
+	return "state_" + state
+}
+
+// NonceStorageKey returns the platform storage key under which a login stores its nonce.
+func NonceStorageKey(nonce string) string {
+	return "nonce_" + nonce
+}
+
+// StorageOrigin returns the origin of the platform's storage frame, that of the platform's OIDC auth endpoint.
+func StorageOrigin(authLoginURI *url.URL) (string, error) {
+	if authLoginURI == nil || authLoginURI.Scheme == "" || authLoginURI.Host == "" {
+		return "", errors.New("auth login URI is not an absolute URL")
+	}
+
+	return authLoginURI.Scheme + "://" + authLoginURI.Host, nil
+}
+
+// usePlatformStorage reports whether the login stores its state in the platform's storage frame: PlatformStorage is
+// set and the platform named its storage frame in the login request.
+func (l *Login) usePlatformStorage(r *http.Request) bool {
+	return l.PlatformStorage && r.FormValue(StorageTargetParameter) != ""
+}
+
+// platformStorage returns the PlatformStorage of the state and nonce of the auth redirect URI.
+func platformStorage(target, redirectURI string) (*PlatformStorage, error) {
+	authRequest, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse redirect URI: %w", err)
+	}
+	origin, err := StorageOrigin(authRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	values := authRequest.Query()
+	state, nonce := values.Get("state"), values.Get("nonce")
+
+	return &PlatformStorage{
+		Target: target,
+		Origin: origin,
+		Values: map[string]string{
+			StateStorageKey(state): state,
+			NonceStorageKey(nonce): nonce,
+		},
+	}, nil
+}
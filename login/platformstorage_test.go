@@ -0,0 +1,61 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestPlatformStorage(t *testing.T) {
+	states := nonpersistent.New()
+	login := New(datastore.Config{Registrations: nonpersistent.New(), Nonces: nonpersistent.New(), States: states})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	loginURL := "https://tool.tld/login?" + string(getPostBody()) + "&" + StorageTargetParameter + "=_parent"
+
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loginURL, nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("got status %d without PlatformStorage", w.Code)
+	}
+
+	login.PlatformStorage = true
+	w = httptest.NewRecorder()
+	login.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loginURL, nil))
+	page := w.Body.String()
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 2 {
+		t.Fatalf("got status %d with cookies %v", w.Code, w.Result().Cookies())
+	}
+	for _, expected := range []string{`"lti.put_data"`, `origin = "https://platform.tld"`, `target = "_parent"`,
+		`"state_state-`, `"nonce_`, `location.replace(`} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("page missing %s: %s", expected, page)
+		}
+	}
+
+	// The state is stored with its nonce, so that the launch can verify the state read back from platform storage.
+	state := regexp.MustCompile(`"state_(state-[^"]+)"`).FindStringSubmatch(page)
+	nonce := regexp.MustCompile(`"nonce_([^"]+)"`).FindStringSubmatch(page)
+	if state == nil || nonce == nil {
+		t.Fatalf("state and nonce not found in page: %s", page)
+	}
+	if err := states.TestAndClearState(state[1], nonce[1]); err != nil {
+		t.Errorf("got %v for the state stored in platform storage", err)
+	}
+
+	// Without a storage frame named by the platform, the login redirects as usual.
+	w = httptest.NewRecorder()
+	login.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("got status %d without storage target", w.Code)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrMethodNotAllowed is returned for login and launch requests made with a method other than GET or POST.
+var ErrMethodNotAllowed = errors.New("request method not allowed")
+
+// RequestValues returns the parameters of a login or launch request. Platforms send them either as the query of a GET
+// request, e.g., when initiating the login by a redirect or returning the auth response with response_mode=query, or
+// as the form-encoded body of a POST request. The values of a GET request are read from its query only, and those of
+// a POST request from its body only, so that the parameters of one request are never mixed from both. Other methods
+// are rejected with an error wrapping ErrMethodNotAllowed.
+func RequestValues(r *http.Request) (url.Values, error) {
+	switch r.Method {
+	case http.MethodGet:
+		return r.URL.Query(), nil
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("could not parse request form: %w", err)
+		}
+		return r.PostForm, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrMethodNotAllowed, r.Method)
+}
+
+// UseRequestValues replaces the request's form with its RequestValues, so that r.FormValue reads the parameters of the
+// request consistently for either method.
+func UseRequestValues(r *http.Request) error {
+	values, err := RequestValues(r)
+	if err != nil {
+		return err
+	}
+	r.Form = values
+
+	return nil
+}
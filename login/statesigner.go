@@ -0,0 +1,120 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinimumStateKeyLength is the length, in bytes, of the shortest key accepted by NewStateSigner.
+const MinimumStateKeyLength = 32
+
+var (
+	// ErrStateKeyTooShort is returned by NewStateSigner for a key shorter than MinimumStateKeyLength.
+	ErrStateKeyTooShort = errors.New("state signing key too short")
+
+	// ErrInvalidSignedState is returned when a state is not one signed with the StateSigner's key, e.g., because it
+	// was tampered with.
+	ErrInvalidSignedState = errors.New("invalid signed state")
+
+	// ErrSignedStateExpired is returned when a signed state is valid but its expiry has passed.
+	ErrSignedStateExpired = errors.New("signed state has expired")
+)
+
+// A StateSigner issues state values that carry the login's nonce and target link URI along with an HMAC-SHA256
+// signature, and verifies them at the launch. The launch then needs neither the state cookie nor a datastore lookup,
+// e.g., for tools running on several instances without shared storage, as long as every instance has the same key.
+//
+// A signed state can be verified until it expires, so it does not prevent a launch from being replayed during its
+// lifetime; the id_token's replay check (see datastore.ReplayStorer) does.
+type StateSigner struct {
+	key []byte
+}
+
+// A SignedState holds the values carried by a signed state.
+type SignedState struct {
+	Nonce         string    `json:"nonce"`
+	TargetLinkURI string    `json:"target_link_uri"`
+	Expiry        time.Time `json:"-"`
+}
+
+// NewStateSigner returns a StateSigner signing with the key, which must be a secret of at least MinimumStateKeyLength
+// random bytes.
+func NewStateSigner(key []byte) (*StateSigner, error) {
+	if len(key) < MinimumStateKeyLength {
+		return nil, fmt.Errorf("%w: got %d bytes, wanted at least %d", ErrStateKeyTooShort, len(key),
+			MinimumStateKeyLength)
+	}
+
+	return &StateSigner{key: append([]byte(nil), key...)}, nil
+}
+
+// Sign returns a state value carrying the nonce and target link URI until the expiry. Like the other state values
+// issued by a Login, it carries its expiry in the clear (see StateExpiry); the signature covers the expiry as well.
+func (s *StateSigner) Sign(nonce, targetLinkURI string, expiry time.Time) (string, error) {
+	if nonce == "" {
+		return "", errors.New("received empty nonce argument")
+	}
+
+	payload, err := json.Marshal(SignedState{Nonce: nonce, TargetLinkURI: targetLinkURI})
+	if err != nil {
+		return "", fmt.Errorf("could not encode state: %w", err)
+	}
+	signed := statePrefix + strconv.FormatInt(expiry.Unix(), 10) + "_" + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.mac(signed)), nil
+}
+
+// Verify checks the signature and expiry of a state issued by Sign, as of now, and returns the values it carries. It
+// returns an error wrapping ErrInvalidSignedState or ErrSignedStateExpired.
+func (s *StateSigner) Verify(state string, now time.Time) (SignedState, error) {
+	separator := strings.LastIndexByte(state, '.')
+	if separator < 0 {
+		return SignedState{}, fmt.Errorf("%w: signature not found", ErrInvalidSignedState)
+	}
+	signed := state[:separator]
+	signature, err := base64.RawURLEncoding.DecodeString(state[separator+1:])
+	if err != nil || !hmac.Equal(signature, s.mac(signed)) {
+		return SignedState{}, fmt.Errorf("%w: signature mismatch", ErrInvalidSignedState)
+	}
+
+	// The signature is valid, so the state was issued by Sign and its parts are well-formed.
+	expiry, ok := StateExpiry(signed)
+	if !ok {
+		return SignedState{}, fmt.Errorf("%w: expiry not found", ErrInvalidSignedState)
+	}
+	if !now.Before(expiry) {
+		return SignedState{}, fmt.Errorf("%w at %v", ErrSignedStateExpired, expiry.UTC().Format(time.RFC3339))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(signed[strings.IndexByte(signed, '_')+1:])
+	if err != nil {
+		return SignedState{}, fmt.Errorf("%w: %v", ErrInvalidSignedState, err)
+	}
+	var values SignedState
+	if err := json.Unmarshal(payload, &values); err != nil {
+		return SignedState{}, fmt.Errorf("%w: %v", ErrInvalidSignedState, err)
+	}
+	values.Expiry = expiry
+
+	return values, nil
+}
+
+// mac returns the HMAC-SHA256 of the signed part of a state.
+func (s *StateSigner) mac(signed string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(signed))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package login
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+func TestStateSigner(t *testing.T) {
+	if _, err := NewStateSigner([]byte("short")); !errors.Is(err, ErrStateKeyTooShort) {
+		t.Errorf("got %v for short key, wanted ErrStateKeyTooShort", err)
+	}
+	signer, err := NewStateSigner(bytes.Repeat([]byte("k"), MinimumStateKeyLength))
+	if err != nil {
+		t.Fatalf("new state signer error: %v", err)
+	}
+
+	now := time.Date(2021, time.September, 1, 9, 0, 0, 0, time.UTC)
+	state, err := signer.Sign("nonce", "https://tool.tld/launch", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("sign error: %v", err)
+	}
+	if expiry, ok := StateExpiry(state); !ok || !expiry.Equal(now.Add(time.Minute)) {
+		t.Errorf("got expiry %v, %t from signed state", expiry, ok)
+	}
+
+	values, err := signer.Verify(state, now)
+	if err != nil || values.Nonce != "nonce" || values.TargetLinkURI != "https://tool.tld/launch" {
+		t.Errorf("got %+v, %v", values, err)
+	}
+	if _, err := signer.Verify(state, now.Add(time.Minute)); !errors.Is(err, ErrSignedStateExpired) {
+		t.Errorf("got %v for expired state, wanted ErrSignedStateExpired", err)
+	}
+
+	// Moving the expiry invalidates the signature.
+	tampered := strings.Replace(state, "state-1", "state-2", 1)
+	if _, err := signer.Verify(tampered, now); !errors.Is(err, ErrInvalidSignedState) {
+		t.Errorf("got %v for tampered state, wanted ErrInvalidSignedState", err)
+	}
+	other, _ := NewStateSigner(bytes.Repeat([]byte("o"), MinimumStateKeyLength))
+	if _, err := other.Verify(state, now); !errors.Is(err, ErrInvalidSignedState) {
+		t.Errorf("got %v for state signed with another key, wanted ErrInvalidSignedState", err)
+	}
+}
+
+func TestLoginStateSigner(t *testing.T) {
+	store := nonpersistent.New()
+	login := New(datastore.Config{Registrations: store, Nonces: store})
+	login.cfg.Registrations.StoreRegistration(getRegistration())
+	login.StateSigner, _ = NewStateSigner(bytes.Repeat([]byte("k"), MinimumStateKeyLength))
+
+	r := httptest.NewRequest(http.MethodGet, "https://tool.tld/login?"+string(getPostBody()), nil)
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, r)
+	if cookies := w.Result().Cookies(); w.Code != http.StatusFound || len(cookies) != 0 {
+		t.Fatalf("got status %d with cookies %v", w.Code, cookies)
+	}
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	query := location.Query()
+	values, err := login.StateSigner.Verify(query.Get("state"), time.Now())
+	if err != nil || values.Nonce != query.Get("nonce") {
+		t.Errorf("got %+v, %v for nonce %s", values, err, query.Get("nonce"))
+	}
+	if err := store.TestAndClearNonce(query.Get("nonce"), values.TargetLinkURI); err != datastore.ErrNonceNotFound {
+		t.Errorf("got %v, wanted the nonce not to be stored", err)
+	}
+}
@@ -14,29 +14,78 @@ import (
 	"database/sql"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
+	"sort"
+	"time"
 
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
 	"github.com/macewan-cs/lti/connector"
 	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
 	dssql "github.com/macewan-cs/lti/datastore/sql"
+	"github.com/macewan-cs/lti/keyset"
 	"github.com/macewan-cs/lti/launch"
 	"github.com/macewan-cs/lti/login"
+	"github.com/macewan-cs/lti/registration"
+	"github.com/macewan-cs/lti/session"
 )
 
 // JSONWebKeySet provides configuration for a keyset handler implemented on this type. The ServeHTTP method is
 // implemented for this type to allow it to serve as an http.Handler.
+//
+// When a single process hosts several tools, AdditionalKeys holds the keys of the other tools. Every key is published
+// under its own key ID.
 type JSONWebKeySet struct {
+	Identifier     string
+	PrivateKey     string
+	AdditionalKeys []ToolKey
+}
+
+// A ToolKey is a tool's PEM encoded RSA private key and the key ID under which its public key is published.
+//
+// Use is the intended use of the key, either KeyUseSignature or KeyUseEncryption, and Algorithm is the JWA algorithm
+// identifier advertised for it. When empty, they default to KeyUseSignature and RS256 (or RSA-OAEP-256 for encryption
+// keys), respectively.
+type ToolKey struct {
 	Identifier string
 	PrivateKey string
+	Use        string
+	Algorithm  string
+}
+
+// The intended uses of a published key.
+const (
+	KeyUseSignature  = "sig"
+	KeyUseEncryption = "enc"
+)
+
+// supportedKeyAlgorithms lists, by intended use, the RSA algorithms that can be advertised for a key.
+var supportedKeyAlgorithms = map[string][]string{
+	KeyUseSignature: {
+		jwa.RS256.String(), jwa.RS384.String(), jwa.RS512.String(),
+		jwa.PS256.String(), jwa.PS384.String(), jwa.PS512.String(),
+	},
+	KeyUseEncryption: {jwa.RSA_OAEP_256.String(), jwa.RSA_OAEP.String(), jwa.RSA1_5.String()},
 }
 
 // KeySet is encoded to provide the public key to be fetched in order to verify the authenticity of JSON Web Tokens
-// sent from this library.
+// sent from this library. A JSONWebKeySet publishing a single key, e.g., one without AdditionalKeys, encodes it as a
+// KeySet; one publishing several keys encodes them all under the same "keys" member.
 type KeySet struct {
 	Keys [1]jwk.Key `json:"keys"`
 }
 
+// toolKeySet is encoded to publish the public keys of every tool key of a JSONWebKeySet.
+type toolKeySet struct {
+	Keys []jwk.Key `json:"keys"`
+}
+
 // NewSQLDatastoreConfig returns a new SQL datastore configuration containing the library's default table and field
 // names. These table and field names can be modified before calling NewSQLDatastore.
 func NewSQLDatastoreConfig() dssql.Config {
@@ -68,11 +117,45 @@ func NewLogin(cfg datastore.Config) *login.Login {
 // After a successful launch, further LTI requests must include the launch ID in their requests. To support a variety of
 // tool implementation, the launch ID is attached to the *http.Request context immediately prior to calling
 // `next'. Convenience functions, like `LaunchIDFromRequest' and `LaunchIDFromContext', also available in this package,
-// simplify the retrieval of this launch ID.
+// simplify the retrieval of this launch ID. The verified id_token is attached as well, and `LaunchTokenFromRequest'
+// retrieves it.
 func NewLaunch(cfg datastore.Config, next http.HandlerFunc) *launch.Launch {
 	return launch.New(cfg, next)
 }
 
+// NewLaunchWithCallback is like NewLaunch, but on a successful launch it calls `callback' with the launch ID and the
+// claims of the launch, so that the launch ID need not be retrieved from the request context.
+func NewLaunchWithCallback(cfg datastore.Config, callback launch.Callback) *launch.Launch {
+	return launch.NewWithCallback(cfg, callback)
+}
+
+// NewSessionManager returns a *session.Manager issuing sessions signed with `key', so that requests made after a
+// launch, e.g., from the tool's pages, can be authenticated. Wrap the launch's `next' handler with its
+// IssueAfterLaunch method, and the handlers serving those requests with its Require method.
+func NewSessionManager(key []byte) *session.Manager {
+	return session.New(key)
+}
+
+// RequireLaunch returns middleware authenticating the requests made after a launch by their session (see
+// NewSessionManager), found in the session cookie or an Authorization header. It attaches the session's launch ID to
+// the request context, so that LaunchIDFromRequest returns it, and it responds with 401 Unauthorized to requests
+// without a valid session or whose launch data is no longer stored.
+func RequireLaunch(cfg datastore.Config, sessions *session.Manager, next http.Handler) http.Handler {
+	return sessions.RequireLaunch(cfg, next)
+}
+
+// NewStrictLogin is like NewLogin, but it returns an error instead of falling back on nonpersistent storage when a
+// store required by the login is missing from the Config.
+func NewStrictLogin(cfg datastore.Config) (*login.Login, error) {
+	return login.NewStrict(cfg)
+}
+
+// NewStrictLaunch is like NewLaunch, but it returns an error instead of falling back on nonpersistent storage when a
+// store required by the launch is missing from the Config.
+func NewStrictLaunch(cfg datastore.Config, next http.HandlerFunc) (*launch.Launch, error) {
+	return launch.NewStrict(cfg, next)
+}
+
 // GetLaunchContextKey returns the context key used for attaching the launch ID to the request context.
 func GetLaunchContextKey() launch.ContextKeyType {
 	return launch.ContextKey
@@ -95,6 +178,100 @@ func LaunchIDFromRequest(r *http.Request) string {
 	return LaunchIDFromContext(r.Context())
 }
 
+// LaunchTokenFromRequest takes an *http.Request (after a successful launch), and it returns the verified id_token of
+// the launch, or nil. Unlike LaunchClaims, it does not look up the launch data in a store, but it is only available to
+// the handler called by the launch.
+func LaunchTokenFromRequest(r *http.Request) jwt.Token {
+	return launch.TokenFromContext(r.Context())
+}
+
+// PurgeUserData removes the data stored for the user identified by `subject' (the `sub' claim of a launch) from every
+// store in the configuration that implements datastore.Purger. It returns the total number of records removed.
+func PurgeUserData(cfg datastore.Config, subject string) (int, error) {
+	return purge(cfg, func(p datastore.Purger) (int, error) {
+		return p.PurgeUserData(subject)
+	})
+}
+
+// PurgeContextData removes the data stored for the LTI context (i.e., course) identified by `contextID' from every
+// store in the configuration that implements datastore.Purger. It returns the total number of records removed.
+func PurgeContextData(cfg datastore.Config, contextID string) (int, error) {
+	return purge(cfg, func(p datastore.Purger) (int, error) {
+		return p.PurgeContextData(contextID)
+	})
+}
+
+// purge applies a purge operation once to each distinct store in the configuration. As elsewhere in the library, a nil
+// launch data store refers to the nonpersistent default store.
+func purge(cfg datastore.Config, operation func(datastore.Purger) (int, error)) (int, error) {
+	var launchData interface{} = cfg.LaunchData
+	if cfg.LaunchData == nil {
+		launchData = nonpersistent.DefaultStore
+	}
+	stores := []interface{}{launchData, cfg.Registrations, cfg.Nonces, cfg.AccessTokens}
+
+	var (
+		total  int
+		purged []datastore.Purger
+	)
+	for _, store := range stores {
+		purger, ok := store.(datastore.Purger)
+		if !ok || containsPurger(purged, purger) {
+			continue
+		}
+		purged = append(purged, purger)
+
+		count, err := operation(purger)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// containsPurger returns whether a purger exists in a []datastore.Purger. Purgers are compared by reference, since
+// comparing interfaces holding non-comparable values, e.g., map-backed stores, panics. Purgers that are not references
+// are never considered to be the same store; purging such a store twice only finds nothing the second time.
+func containsPurger(purgers []datastore.Purger, p datastore.Purger) bool {
+	pValue := reflect.ValueOf(p)
+	switch pValue.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+	default:
+		return false
+	}
+
+	for _, v := range purgers {
+		vValue := reflect.ValueOf(v)
+		if vValue.Type() == pValue.Type() && vValue.Pointer() == pValue.Pointer() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolveTenant returns middleware that resolves an application tenant from the launch data of the request's launch
+// ID and attaches it to the request context. It is placed in front of handlers that run after a successful launch.
+// See launch.TenantFromIssuerAndDeployment and launch.TenantFromCustomParameter for common resolvers.
+func ResolveTenant(cfg datastore.Config, resolve launch.TenantResolver, next http.Handler) http.Handler {
+	return launch.ResolveTenant(cfg, resolve, next)
+}
+
+// RequireRoles returns a middleware constructor for routes restricted to users holding any of the LTI roles, e.g.,
+// RequireRoles(cfg, roles.Instructor)(next). The middleware checks the roles in the launch data of the request's
+// launch ID, and it responds with 403 Forbidden to users lacking them. See launch.RequireRoles.
+func RequireRoles(cfg datastore.Config, requiredRoles ...string) func(next http.Handler) http.Handler {
+	return launch.RequireRoles(cfg, requiredRoles...)
+}
+
+// TenantFromRequest takes an *http.Request (after tenant resolution), and it returns the tenant attached to that
+// request.
+func TenantFromRequest(r *http.Request) string {
+	return launch.TenantFromContext(r.Context())
+}
+
 // NewConnector returns a *connector.Connector (on success) that can be used for accessing LTI services. These services
 // include Names and Role Provisioning Services (NRPS) and Assignment and Grade Services (AGS). The returned connector
 // needs to be successfully `upgraded' (which returns a new type) before it can be used for these services.
@@ -102,9 +279,115 @@ func NewConnector(cfg datastore.Config, launchID, keyID string) (*connector.Conn
 	return connector.New(cfg, launchID, keyID)
 }
 
+// NewStrictConnector is like NewConnector, but it returns an error instead of falling back on nonpersistent storage
+// when a store required by the connector is missing from the Config.
+func NewStrictConnector(cfg datastore.Config, launchID, keyID string) (*connector.Connector, error) {
+	return connector.NewStrict(cfg, launchID, keyID)
+}
+
+// NewConnectorFromLaunchData is like NewConnector, but it takes the verified launch data and the registration of the
+// launch from the caller instead of the Config's stores, e.g., when launch data travels with each request.
+func NewConnectorFromLaunchData(cfg datastore.Config, launchData json.RawMessage, registration datastore.Registration,
+	keyID string) (*connector.Connector, error) {
+	return connector.NewFromLaunchData(cfg, launchData, registration, keyID)
+}
+
+// NewConnectorFactory returns a *connector.Factory, which creates Connectors sharing a configuration, e.g., the scope
+// profiles requested by the tool's features.
+func NewConnectorFactory(cfg datastore.Config, keyID string) *connector.Factory {
+	return connector.NewFactory(cfg, keyID)
+}
+
+// LaunchClaims returns the claims of the stored launch in typed form.
+func LaunchClaims(cfg datastore.Config, launchID string) (claims.LaunchClaims, error) {
+	if cfg.LaunchData == nil {
+		cfg.LaunchData = nonpersistent.DefaultStore
+	}
+
+	launchData, err := cfg.LaunchData.FindLaunchData(launchID)
+	if err != nil {
+		return claims.LaunchClaims{}, err
+	}
+
+	return claims.ParseLaunchClaims(launchData)
+}
+
+// VerifyPlatformJWT verifies a JWT signed by the platform identified by `issuer' for the tool's `clientID', e.g., the
+// payload of a notification sent by the platform to a tool webhook, and returns the parsed token. The signature is
+// verified with the keyset of the registration of the issuer and client ID, which is cached by keyset.DefaultCache.
+// The token's iss, exp, iat and nbf claims are validated, allowing launch.DefaultClockSkew, and its aud and azp claims
+// must identify the client ID as for a launch (see claims.Audience.ClientID), or the error wraps
+// launch.ErrClientIDMismatch. Any message-specific claims are left to the caller.
+func VerifyPlatformJWT(cfg datastore.Config, rawToken []byte, issuer, clientID string) (jwt.Token, error) {
+	if clientID == "" {
+		return nil, errors.New("received empty client ID argument")
+	}
+	if cfg.Registrations == nil {
+		cfg.Registrations = nonpersistent.DefaultStore
+	}
+
+	registration, err := cfg.Registrations.FindRegistrationByIssuerAndClientID(issuer, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StrictHTTPS {
+		if err := datastore.ValidateRegistrationSecurity(registration); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := keyset.Verify(rawToken, registration.KeysetURI.String())
+	if err != nil {
+		return nil, err
+	}
+
+	clock := jwt.ClockFunc(func() time.Time {
+		return datastore.Now(cfg.Clock)
+	})
+	err = jwt.Validate(token, jwt.WithIssuer(issuer), jwt.WithClock(clock),
+		jwt.WithAcceptableSkew(launch.DefaultClockSkew))
+	if err != nil {
+		return nil, fmt.Errorf("platform JWT validation failed: %w", err)
+	}
+
+	tokenClientID, err := claims.TokenClientID(token)
+	if err != nil {
+		return nil, fmt.Errorf("platform JWT validation failed: %w", err)
+	}
+	if tokenClientID != registration.ClientID {
+		return nil, fmt.Errorf("platform JWT validation failed: %w: token issued to %s", launch.ErrClientIDMismatch,
+			tokenClientID)
+	}
+
+	return token, nil
+}
+
+// InvalidateKeyset removes the keyset found at the URI from keyset.DefaultCache, which caches the keysets used to
+// verify launches and platform JWTs, e.g., after a platform has revoked a compromised key. The keyset is fetched again
+// when it is next used.
+func InvalidateKeyset(keysetURI string) {
+	keyset.DefaultCache.Invalidate(keysetURI)
+}
+
+// NewDynamicRegistration returns a *registration.Handler implementing the tool's dynamic registration URL, e.g.,
+// /services/lti/register/. When a platform administrator registers the tool by URL, the handler registers the tool
+// with the platform and stores the resulting registration and deployment. Each registration request must be allowed
+// by authorize; see registration.Authorizer.
+func NewDynamicRegistration(cfg datastore.Config, tool registration.ToolConfiguration,
+	authorize registration.Authorizer) *registration.Handler {
+	return registration.NewHandler(cfg, tool, authorize)
+}
+
+// DiscoverRegistration returns a registration populated from the platform's OpenID configuration, found at the
+// issuer's well-known location. The client ID and target link URI must be set before the registration is stored.
+func DiscoverRegistration(issuer string) (datastore.Registration, error) {
+	return registration.DiscoverRegistration(issuer)
+}
+
 // NewKeySet returns a *JSONWebKeySet that provides the key used to verify the sender authenticity of JSON Web Tokens
 // exchanged as part of accessing LTI services between Platforms and Tools. This object is an http.handler so it can be
-// easily associated with a keyset URI, e.g., /services/lti/keyset.
+// easily associated with a keyset URI, e.g., /services/lti/keyset. To publish the keys of several tools, see
+// NewToolKeySet.
 func NewKeySet(identifier, privateKey string) *JSONWebKeySet {
 	jsonWebKeySet := JSONWebKeySet{
 		Identifier: identifier,
@@ -114,35 +397,113 @@ func NewKeySet(identifier, privateKey string) *JSONWebKeySet {
 	return &jsonWebKeySet
 }
 
+// NewToolKeySet returns a *JSONWebKeySet that publishes the keys carried by the supplied registrations, allowing one
+// keyset URI to serve several tools hosted by the same process. Registrations without a key are skipped, and a key ID
+// shared by several registrations is published once.
+func NewToolKeySet(registrations ...datastore.Registration) *JSONWebKeySet {
+	jsonWebKeySet := JSONWebKeySet{}
+	seen := map[string]bool{}
+	for _, registration := range registrations {
+		if registration.KeyID == "" || registration.PrivateKey == "" || seen[registration.KeyID] {
+			continue
+		}
+		seen[registration.KeyID] = true
+
+		jsonWebKeySet.AdditionalKeys = append(jsonWebKeySet.AdditionalKeys, ToolKey{
+			Identifier: registration.KeyID,
+			PrivateKey: registration.PrivateKey,
+		})
+	}
+
+	return &jsonWebKeySet
+}
+
 // ServeHTTP makes the JSONWebKeySet type a handler to provide a JSON Web Key Set response for key fetch requests.
 func (j *JSONWebKeySet) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	block, _ := pem.Decode([]byte(j.PrivateKey))
-	if block == nil {
-		http.Error(w, "failed to parse key", http.StatusInternalServerError)
+	var toolKeys []ToolKey
+	if j.Identifier != "" || j.PrivateKey != "" {
+		toolKeys = append(toolKeys, ToolKey{Identifier: j.Identifier, PrivateKey: j.PrivateKey})
+	}
+	toolKeys = append(toolKeys, j.AdditionalKeys...)
+
+	// Keep the order stable: signing keys come before encryption keys, and otherwise keys are published in the
+	// order they were supplied.
+	sort.SliceStable(toolKeys, func(a, b int) bool {
+		return toolKeys[a].Use != KeyUseEncryption && toolKeys[b].Use == KeyUseEncryption
+	})
+
+	jwks := toolKeySet{
+		Keys: make([]jwk.Key, 0, len(toolKeys)),
+	}
+	for _, toolKey := range toolKeys {
+		key, err := publicKey(toolKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jwks.Keys = append(jwks.Keys, key)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if len(jwks.Keys) == 1 {
+		enc.Encode(KeySet{Keys: [1]jwk.Key{jwks.Keys[0]}})
 		return
+	}
+	enc.Encode(jwks)
+}
 
+// publicKey derives the public JSON Web Key for a tool key.
+func publicKey(toolKey ToolKey) (jwk.Key, error) {
+	use := toolKey.Use
+	if use == "" {
+		use = KeyUseSignature
+	}
+	algorithms, ok := supportedKeyAlgorithms[use]
+	if !ok {
+		return nil, fmt.Errorf("unsupported use %s for key %s", use, toolKey.Identifier)
+	}
+	algorithm := toolKey.Algorithm
+	if algorithm == "" {
+		algorithm = algorithms[0]
+	}
+	if !contains(algorithm, algorithms) {
+		return nil, fmt.Errorf("unsupported algorithm %s for %s key %s", algorithm, use, toolKey.Identifier)
+	}
+
+	block, _ := pem.Decode([]byte(toolKey.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse key %s", toolKey.Identifier)
 	}
 	privkey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	key, err := jwk.New(&privkey.PublicKey)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	key.Set(jwk.KeyIDKey, j.Identifier)
-	key.Set(jwk.AlgorithmKey, "RS256")
-	key.Set(jwk.KeyUsageKey, "sig")
+	key.Set(jwk.KeyIDKey, toolKey.Identifier)
+	key.Set(jwk.AlgorithmKey, algorithm)
+	key.Set(jwk.KeyUsageKey, use)
 
-	var keyArr [1]jwk.Key = [1]jwk.Key{key}
-	jwks := KeySet{
-		Keys: keyArr,
+	return key, nil
+}
+
+// contains returns whether a string exists in a []string.
+func contains(n string, s []string) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.Encode(jwks)
+	return false
+}
+
+// Diagnose exercises the registration's platform endpoints (keyset, auth login and auth token) and reports their
+// reachability, TLS details and latency. It is useful when onboarding a new platform.
+func Diagnose(reg datastore.Registration) registration.Diagnosis {
+	return registration.Diagnose(reg)
 }
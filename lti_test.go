@@ -0,0 +1,302 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package lti
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/launch"
+)
+
+func newPEMPrivateKeyForTesting(t *testing.T) string {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	}))
+}
+
+func TestJSONWebKeySetServeHTTP(t *testing.T) {
+	privateKey := newPEMPrivateKeyForTesting(t)
+	keySet := NewKeySet("primary", privateKey)
+	keySet.AdditionalKeys = []ToolKey{
+		{Identifier: "encryption", PrivateKey: privateKey, Use: KeyUseEncryption},
+		{Identifier: "secondary", PrivateKey: privateKey, Algorithm: "PS256"},
+	}
+
+	recorder := httptest.NewRecorder()
+	keySet.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/keyset", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var actual struct {
+		Keys []struct {
+			KeyID     string `json:"kid"`
+			Use       string `json:"use"`
+			Algorithm string `json:"alg"`
+		} `json:"keys"`
+	}
+	err := json.NewDecoder(recorder.Body).Decode(&actual)
+	if err != nil {
+		t.Fatalf("cannot decode keyset: %v", err)
+	}
+
+	expected := [][3]string{
+		{"primary", "sig", "RS256"},
+		{"secondary", "sig", "PS256"},
+		{"encryption", "enc", "RSA-OAEP-256"},
+	}
+	if len(actual.Keys) != len(expected) {
+		t.Fatalf("got %d keys, wanted %d", len(actual.Keys), len(expected))
+	}
+	for i, key := range actual.Keys {
+		if [3]string{key.KeyID, key.Use, key.Algorithm} != expected[i] {
+			t.Errorf("got key %v, wanted %v", key, expected[i])
+		}
+	}
+
+	keySet.AdditionalKeys = []ToolKey{{Identifier: "bad", PrivateKey: privateKey, Use: KeyUseEncryption, Algorithm: "RS256"}}
+	recorder = httptest.NewRecorder()
+	keySet.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/keyset", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("mismatched algorithm not reported: got status %d", recorder.Code)
+	}
+}
+
+func TestNewToolKeySet(t *testing.T) {
+	first, second := newPEMPrivateKeyForTesting(t), newPEMPrivateKeyForTesting(t)
+	keySet := NewToolKeySet(
+		datastore.Registration{ClientID: "a", KeyID: "tool-a", PrivateKey: first},
+		datastore.Registration{ClientID: "b"},
+		datastore.Registration{ClientID: "c", KeyID: "tool-b", PrivateKey: second},
+		datastore.Registration{ClientID: "d", KeyID: "tool-a", PrivateKey: first},
+	)
+	if keySet.Identifier != "" || keySet.PrivateKey != "" {
+		t.Errorf("got primary key %q, wanted none", keySet.Identifier)
+	}
+
+	recorder := httptest.NewRecorder()
+	keySet.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/keyset", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+	published, err := jwk.Parse(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("cannot parse keyset: %v", err)
+	}
+
+	expected := []string{"tool-a", "tool-b"}
+	if published.Len() != len(expected) {
+		t.Fatalf("got %d keys, wanted %d", published.Len(), len(expected))
+	}
+	for i, keyID := range expected {
+		key, _ := published.Get(i)
+		if key.KeyID() != keyID {
+			t.Errorf("got key ID %q at %d, wanted %q", key.KeyID(), i, keyID)
+		}
+	}
+}
+
+func TestKeySetEncoding(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	key, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot create key: %v", err)
+	}
+	key.Set(jwk.KeyIDKey, "primary")
+
+	encoded, err := json.Marshal(KeySet{Keys: [1]jwk.Key{key}})
+	if err != nil {
+		t.Fatalf("cannot encode keyset: %v", err)
+	}
+	published, err := jwk.Parse(encoded)
+	if err != nil {
+		t.Fatalf("cannot parse keyset: %v", err)
+	}
+	if _, ok := published.LookupKeyID("primary"); !ok || published.Len() != 1 {
+		t.Errorf("got keyset %s, wanted the primary key only", encoded)
+	}
+
+	// A JSONWebKeySet publishing a single key encodes it as a KeySet.
+	recorder := httptest.NewRecorder()
+	NewKeySet("primary", newPEMPrivateKeyForTesting(t)).ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/keyset", nil))
+	published, err = jwk.Parse(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("cannot parse keyset: %v", err)
+	}
+	if _, ok := published.LookupKeyID("primary"); !ok || published.Len() != 1 {
+		t.Errorf("got keyset %s, wanted the primary key only", recorder.Body)
+	}
+}
+
+func TestVerifyPlatformJWT(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	publicKey, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot create key: %v", err)
+	}
+	publicKey.Set(jwk.KeyIDKey, "platform")
+	publicKey.Set(jwk.AlgorithmKey, jwa.RS256)
+	published := jwk.NewSet()
+	published.Add(publicKey)
+
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(published)
+	}))
+	defer platform.Close()
+
+	keysetURI, _ := url.Parse(platform.URL)
+	store := nonpersistent.New()
+	err = store.StoreRegistration(datastore.Registration{
+		Issuer:        "https://platform.tld",
+		ClientID:      "tool",
+		AuthTokenURI:  keysetURI,
+		AuthLoginURI:  keysetURI,
+		KeysetURI:     keysetURI,
+		TargetLinkURI: keysetURI,
+	})
+	if err != nil {
+		t.Fatalf("cannot store registration: %v", err)
+	}
+	cfg := datastore.Config{Registrations: store}
+
+	sign := func(issuer string, expiry time.Time, extra map[string]interface{}) []byte {
+		token := jwt.New()
+		token.Set(jwt.IssuerKey, issuer)
+		token.Set(jwt.ExpirationKey, expiry)
+		token.Set(jwt.AudienceKey, "tool")
+		for name, value := range extra {
+			token.Set(name, value)
+		}
+		signingKey, _ := jwk.New(privateKey)
+		signingKey.Set(jwk.KeyIDKey, "platform")
+		signed, err := jwt.Sign(token, jwa.RS256, signingKey)
+		if err != nil {
+			t.Fatalf("cannot sign token: %v", err)
+		}
+		return signed
+	}
+	valid := time.Now().Add(time.Minute)
+	// errRejected stands for any error, e.g., from the validation of the token's times.
+	errRejected := errors.New("rejected")
+
+	tests := []struct {
+		name     string
+		rawToken []byte
+		issuer   string
+		clientID string
+		wantErr  error
+	}{
+		{"valid", sign("https://platform.tld", valid, nil), "https://platform.tld", "tool", nil},
+		{"azp", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: []string{"tool", "other"},
+			"azp": "tool"}), "https://platform.tld", "tool", nil},
+		{"expired", sign("https://platform.tld", time.Now().Add(-time.Hour), nil), "https://platform.tld", "tool",
+			errRejected},
+		{"other issuer", sign("https://other.tld", valid, nil), "https://platform.tld", "tool", errRejected},
+		{"unregistered issuer", sign("https://other.tld", valid, nil), "https://other.tld", "tool",
+			datastore.ErrRegistrationNotFound},
+		{"unregistered client ID", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: "other"}),
+			"https://platform.tld", "other", datastore.ErrRegistrationNotFound},
+		{"other audience", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: "other"}),
+			"https://platform.tld", "tool", launch.ErrClientIDMismatch},
+		{"other azp", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: []string{"tool",
+			"other"}, "azp": "other"}), "https://platform.tld", "tool", launch.ErrClientIDMismatch},
+		{"ambiguous audience", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: []string{
+			"tool", "other"}}), "https://platform.tld", "tool", claims.ErrInvalidAudience},
+		{"missing audience", sign("https://platform.tld", valid, map[string]interface{}{jwt.AudienceKey: []string{}}),
+			"https://platform.tld", "tool", claims.ErrInvalidAudience},
+	}
+
+	for _, test := range tests {
+		_, err := VerifyPlatformJWT(cfg, test.rawToken, test.issuer, test.clientID)
+		if test.wantErr == nil && err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if test.wantErr != nil && (err == nil || test.wantErr != errRejected && !errors.Is(err, test.wantErr)) {
+			t.Errorf("%s: got error %v, wanted %v", test.name, err, test.wantErr)
+		}
+	}
+
+	_, err = VerifyPlatformJWT(cfg, sign("https://platform.tld", valid, nil), "https://platform.tld", "")
+	if err == nil {
+		t.Error("token accepted without client ID")
+	}
+}
+
+// purgingStore is a value-type, non-comparable nonce and access token store that counts its purges.
+type purgingStore struct {
+	nonces []string
+	purges *int
+}
+
+func (p purgingStore) StoreNonce(nonce, targetLinkURI string) error        { return nil }
+func (p purgingStore) TestAndClearNonce(nonce, targetLinkURI string) error { return nil }
+func (p purgingStore) StoreAccessToken(token datastore.AccessToken) error  { return nil }
+func (p purgingStore) PurgeContextData(contextID string) (int, error)      { return 0, nil }
+
+func (p purgingStore) FindAccessToken(tokenURI, clientID string, scopes []string) (datastore.AccessToken, error) {
+	return datastore.AccessToken{}, datastore.ErrAccessTokenNotFound
+}
+
+func (p purgingStore) PurgeUserData(subject string) (int, error) {
+	*p.purges++
+	return 0, nil
+}
+
+func TestPurgeUserData(t *testing.T) {
+	store := nonpersistent.New()
+	store.StoreLaunchData("1", json.RawMessage(`{"sub":"a"}`))
+	store.StoreLaunchData("2", json.RawMessage(`{"sub":"b"}`))
+	var purges int
+	cfg := datastore.Config{
+		Registrations: store,
+		Nonces:        purgingStore{purges: &purges},
+		LaunchData:    store,
+		AccessTokens:  purgingStore{purges: &purges},
+	}
+
+	purged, err := PurgeUserData(cfg, "a")
+	if err != nil {
+		t.Fatalf("purge error: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("got %d purged, wanted 1", purged)
+	}
+	if purges != 2 {
+		t.Errorf("got %d purges of the value stores, wanted 2", purges)
+	}
+	if _, err := store.FindLaunchData("2"); err != nil {
+		t.Errorf("launch data of another user purged: %v", err)
+	}
+}
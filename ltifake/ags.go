@@ -0,0 +1,206 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltifake
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/macewan-cs/lti/connector"
+)
+
+// FakeAGS is an in-memory connector.AGSService. Scores posted with PutScore are recorded in Scores; Results and
+// LineItems are served as configured. When PageSize is non-zero, results are served in pages of that size.
+type FakeAGS struct {
+	Faults
+
+	LaunchUserID string
+	LineItem     connector.LineItem
+	LineItems    []connector.LineItem
+	Results      []connector.Result
+	Scores       []connector.Score
+	PageSize     int
+
+	mu         sync.Mutex
+	nextResult int
+	lastID     int
+}
+
+var _ connector.AGSService = (*FakeAGS)(nil)
+
+// PutScore records the score. As with the connector, the launching user's ID is used when useLaunchUserID is set.
+func (f *FakeAGS) PutScore(s connector.Score, useLaunchUserID bool) error {
+	if err := f.fault("PutScore"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if useLaunchUserID {
+		s.UserID = f.LaunchUserID
+	}
+	if s.UserID == "" {
+		return errors.New("received empty user ID")
+	}
+	f.Scores = append(f.Scores, s)
+
+	return nil
+}
+
+// GetResults returns all of the results.
+func (f *FakeAGS) GetResults() ([]connector.Result, error) {
+	if err := f.fault("GetResults"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.userResults(""), nil
+}
+
+// GetUserResults returns the results of a user.
+func (f *FakeAGS) GetUserResults(userID string) ([]connector.Result, error) {
+	if err := f.fault("GetUserResults"); err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		return nil, errors.New("received empty userID")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.userResults(userID), nil
+}
+
+// GetPagedResults returns the next page of results, optionally for a single user. The limit, when non-zero,
+// overrides PageSize.
+func (f *FakeAGS) GetPagedResults(limit int, userID string) ([]connector.Result, bool, error) {
+	if err := f.fault("GetPagedResults"); err != nil {
+		return nil, false, err
+	}
+	if limit < 0 {
+		return nil, false, errors.New("invalid paging limit")
+	}
+	if limit == 0 {
+		limit = f.PageSize
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results := f.userResults(userID)
+	start, end, next := page(len(results), f.nextResult, limit)
+	f.nextResult = next
+
+	return results[start:end], next != 0, nil
+}
+
+// userResults returns the results of a user, or all results for an empty user ID.
+func (f *FakeAGS) userResults(userID string) []connector.Result {
+	results := []connector.Result{}
+	for _, result := range f.Results {
+		if userID == "" || result.UserID == userID {
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+// GetLineItem returns the launched lineitem.
+func (f *FakeAGS) GetLineItem() (connector.LineItem, error) {
+	if err := f.fault("GetLineItem"); err != nil {
+		return connector.LineItem{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.LineItem, nil
+}
+
+// GetLineItems returns all of the lineitems.
+func (f *FakeAGS) GetLineItems() ([]connector.LineItem, error) {
+	if err := f.fault("GetLineItems"); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]connector.LineItem{}, f.LineItems...), nil
+}
+
+// UpdateLineItem replaces the lineitem with the same ID, or the one identified by notLaunchedLineItemEndpoint.
+func (f *FakeAGS) UpdateLineItem(lineItem connector.LineItem, notLaunchedLineItemEndpoint string) (connector.LineItem, error) {
+	if err := f.fault("UpdateLineItem"); err != nil {
+		return connector.LineItem{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := notLaunchedLineItemEndpoint
+	if id == "" {
+		id = lineItem.ID
+	}
+	lineItem.ID = id
+	if f.LineItem.ID == id {
+		f.LineItem = lineItem
+	}
+	for i := range f.LineItems {
+		if f.LineItems[i].ID == id {
+			f.LineItems[i] = lineItem
+			return lineItem, nil
+		}
+	}
+	if f.LineItem.ID == id {
+		return lineItem, nil
+	}
+
+	return connector.LineItem{}, fmt.Errorf("lineitem %s not found", id)
+}
+
+// CreateLineItem adds a lineitem, assigning it an ID if it has none.
+func (f *FakeAGS) CreateLineItem(lineItem connector.LineItem) (connector.LineItem, error) {
+	if err := f.fault("CreateLineItem"); err != nil {
+		return connector.LineItem{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if lineItem.ID == "" {
+		f.lastID++
+		lineItem.ID = fmt.Sprintf("https://platform.invalid/lineitems/%d", f.lastID)
+	}
+	f.LineItems = append(f.LineItems, lineItem)
+
+	return lineItem, nil
+}
+
+// DeleteLineItem removes the lineitem with the ID lineItemToDeleteEndpoint.
+func (f *FakeAGS) DeleteLineItem(lineItemToDeleteEndpoint string) error {
+	if err := f.fault("DeleteLineItem"); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.LineItems {
+		if f.LineItems[i].ID == lineItemToDeleteEndpoint {
+			f.LineItems = append(f.LineItems[:i], f.LineItems[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("lineitem %s not found", lineItemToDeleteEndpoint)
+}
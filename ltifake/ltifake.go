@@ -0,0 +1,77 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package ltifake provides test doubles for applications built with the LTI packages. FakeAGS and FakeNRPS implement
+// connector.AGSService and connector.NRPSService without a platform, and Store implements the datastore interfaces in
+// memory. Each fake can be made to fail or respond slowly, which allows application unit tests to simulate platform
+// behavior without HTTP servers.
+package ltifake
+
+import (
+	"sync"
+	"time"
+)
+
+// Faults holds the failures and delay injected into a fake. The zero value injects nothing.
+type Faults struct {
+	// Delay is slept at the start of every call, simulating a slow platform or database.
+	Delay time.Duration
+
+	lock   sync.Mutex
+	errors map[string]error
+}
+
+// FailWith makes every call of the named method (e.g., "PutScore") return err. A nil err clears the failure.
+func (f *Faults) FailWith(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.errors == nil {
+		f.errors = map[string]error{}
+	}
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// ClearFailures removes all injected failures.
+func (f *Faults) ClearFailures() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.errors = nil
+}
+
+// fault sleeps for the injected delay and returns the failure injected for the method, if any.
+func (f *Faults) fault(method string) error {
+	f.lock.Lock()
+	delay, err := f.Delay, f.errors[method]
+	f.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return err
+}
+
+// page returns the items of the page starting at cursor and the cursor of the next page, or 0 after the last page.
+func page(length, cursor, size int) (int, int, int) {
+	if cursor >= length {
+		cursor = 0
+	}
+	if size <= 0 {
+		return cursor, length, 0
+	}
+
+	end := cursor + size
+	if end >= length {
+		return cursor, length, 0
+	}
+
+	return cursor, end, end
+}
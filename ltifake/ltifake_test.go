@@ -0,0 +1,91 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltifake
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/macewan-cs/lti/connector"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+func TestFakeNRPSPaging(t *testing.T) {
+	nrps := &FakeNRPS{PageSize: 2}
+	for _, id := range []string{"1", "2", "3"} {
+		nrps.Membership.Members = append(nrps.Membership.Members, connector.Member{UserID: id, Roles: []string{"Learner"}})
+	}
+
+	var pages [][]connector.Member
+	for hasMore := true; hasMore; {
+		var (
+			membership connector.Membership
+			err        error
+		)
+		membership, hasMore, err = nrps.GetPagedMembership(0)
+		if err != nil {
+			t.Fatalf("get paged membership error: %v", err)
+		}
+		pages = append(pages, membership.Members)
+	}
+	if len(pages) != 2 || len(pages[0]) != 2 || len(pages[1]) != 1 {
+		t.Errorf("got pages %v", pages)
+	}
+
+	learners, err := nrps.GetLearners()
+	if err != nil || len(learners) != 3 {
+		t.Errorf("got learners %v, error %v", learners, err)
+	}
+
+	failure := errors.New("platform unavailable")
+	nrps.FailWith("GetMembership", failure)
+	_, err = nrps.GetMembership()
+	if err != failure {
+		t.Errorf("got %v, wanted injected failure", err)
+	}
+	nrps.FailWith("GetMembership", nil)
+	_, err = nrps.GetMembership()
+	if err != nil {
+		t.Errorf("cleared failure reported: %v", err)
+	}
+}
+
+func TestFakeAGS(t *testing.T) {
+	ags := &FakeAGS{LaunchUserID: "a"}
+
+	err := ags.PutScore(connector.Score{ScoreGiven: 1}, true)
+	if err != nil {
+		t.Fatalf("put score error: %v", err)
+	}
+	if len(ags.Scores) != 1 || ags.Scores[0].UserID != "a" {
+		t.Errorf("got scores %v", ags.Scores)
+	}
+
+	lineItem, err := ags.CreateLineItem(connector.LineItem{Label: "Quiz"})
+	if err != nil || lineItem.ID == "" {
+		t.Fatalf("got lineitem %v, error %v", lineItem, err)
+	}
+	err = ags.DeleteLineItem(lineItem.ID)
+	if err != nil {
+		t.Errorf("delete lineitem error: %v", err)
+	}
+}
+
+func TestStoreFailures(t *testing.T) {
+	store := NewStore()
+	store.FailWith("FindLaunchData", errors.New("database down"))
+
+	_, err := store.Config().LaunchData.FindLaunchData("launch")
+	if err == nil || err.Error() != "database down" {
+		t.Errorf("got %v, wanted injected failure", err)
+	}
+
+	store.ClearFailures()
+	_, err = store.FindLaunchData("launch")
+	if err != datastore.ErrLaunchDataNotFound {
+		t.Errorf("got %v, wanted ErrLaunchDataNotFound", err)
+	}
+}
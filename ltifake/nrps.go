@@ -0,0 +1,123 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltifake
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/macewan-cs/lti/connector"
+)
+
+// FakeNRPS is an in-memory connector.NRPSService serving the configured Membership. When PageSize is non-zero, the
+// members are served in pages of that size. LaunchingMember is returned by GetLaunchingMember and
+// GetLaunchingMemberPartial, which also returns Missing.
+type FakeNRPS struct {
+	Faults
+
+	Membership      connector.Membership
+	LaunchingMember connector.Member
+	Missing         connector.MissingClaims
+	PageSize        int
+
+	mu         sync.Mutex
+	nextMember int
+}
+
+var _ connector.NRPSService = (*FakeNRPS)(nil)
+
+// GetMembership returns the whole membership.
+func (f *FakeNRPS) GetMembership() (connector.Membership, error) {
+	if err := f.fault("GetMembership"); err != nil {
+		return connector.Membership{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	membership := f.Membership
+	membership.Members = append([]connector.Member{}, f.Membership.Members...)
+
+	return membership, nil
+}
+
+// GetPagedMembership returns the next page of the membership. The limit, when non-zero, overrides PageSize.
+func (f *FakeNRPS) GetPagedMembership(limit int) (connector.Membership, bool, error) {
+	if err := f.fault("GetPagedMembership"); err != nil {
+		return connector.Membership{}, false, err
+	}
+	if limit < 0 {
+		return connector.Membership{}, false, errors.New("invalid paging limit")
+	}
+	if limit == 0 {
+		limit = f.PageSize
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	start, end, next := page(len(f.Membership.Members), f.nextMember, limit)
+	f.nextMember = next
+
+	membership := f.Membership
+	membership.Members = append([]connector.Member{}, f.Membership.Members[start:end]...)
+
+	return membership, next != 0, nil
+}
+
+// GetMembersByRole returns the members that hold the role, optionally limited to active members.
+func (f *FakeNRPS) GetMembersByRole(role string, activeOnly bool) ([]connector.Member, error) {
+	if err := f.fault("GetMembersByRole"); err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, errors.New("received empty role")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return connector.FilterMembers(f.Membership.Members, role, activeOnly), nil
+}
+
+// GetInstructors returns the instructors.
+func (f *FakeNRPS) GetInstructors() ([]connector.Member, error) {
+	return f.GetMembersByRole("Instructor", false)
+}
+
+// GetLearners returns the learners.
+func (f *FakeNRPS) GetLearners() ([]connector.Member, error) {
+	return f.GetMembersByRole("Learner", false)
+}
+
+// GetActiveLearners returns the learners whose membership is active.
+func (f *FakeNRPS) GetActiveLearners() ([]connector.Member, error) {
+	return f.GetMembersByRole("Learner", true)
+}
+
+// GetLaunchingMember returns LaunchingMember, whether or not any claim is Missing.
+func (f *FakeNRPS) GetLaunchingMember() (connector.Member, error) {
+	if err := f.fault("GetLaunchingMember"); err != nil {
+		return connector.Member{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.LaunchingMember, nil
+}
+
+// GetLaunchingMemberPartial returns LaunchingMember and Missing.
+func (f *FakeNRPS) GetLaunchingMemberPartial() (connector.Member, connector.MissingClaims, error) {
+	if err := f.fault("GetLaunchingMemberPartial"); err != nil {
+		return connector.Member{}, 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.LaunchingMember, f.Missing, nil
+}
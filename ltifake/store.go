@@ -0,0 +1,175 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltifake
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// Store is an in-memory store implementing all of the datastore interfaces, backed by a private nonpersistent store.
+// Failures and delays injected with its Faults apply to the named datastore method, e.g., "FindLaunchData".
+type Store struct {
+	Faults
+
+	store *nonpersistent.Store
+}
+
+var (
+	_ datastore.RegistrationStorer = (*Store)(nil)
+	_ datastore.NonceStorer        = (*Store)(nil)
+	_ datastore.LaunchDataStorer   = (*Store)(nil)
+	_ datastore.AccessTokenStorer  = (*Store)(nil)
+	_ datastore.ReplayStorer       = (*Store)(nil)
+	_ datastore.StateStorer        = (*Store)(nil)
+	_ datastore.RegistrationLister = (*Store)(nil)
+)
+
+// NewStore returns a new, empty *Store.
+func NewStore() *Store {
+	return &Store{store: nonpersistent.New()}
+}
+
+// Config returns a datastore configuration that uses the store for everything.
+func (s *Store) Config() datastore.Config {
+	return datastore.Config{
+		Registrations: s,
+		Nonces:        s,
+		LaunchData:    s,
+		AccessTokens:  s,
+		Replays:       s,
+		States:        s,
+	}
+}
+
+// StoreRegistration stores a registration.
+func (s *Store) StoreRegistration(reg datastore.Registration) error {
+	if err := s.fault("StoreRegistration"); err != nil {
+		return err
+	}
+
+	return s.store.StoreRegistration(reg)
+}
+
+// FindRegistrationByIssuerAndClientID finds a registration.
+func (s *Store) FindRegistrationByIssuerAndClientID(issuer, clientID string) (datastore.Registration, error) {
+	if err := s.fault("FindRegistrationByIssuerAndClientID"); err != nil {
+		return datastore.Registration{}, err
+	}
+
+	return s.store.FindRegistrationByIssuerAndClientID(issuer, clientID)
+}
+
+// ListRegistrations lists the stored registrations.
+func (s *Store) ListRegistrations() ([]datastore.Registration, error) {
+	if err := s.fault("ListRegistrations"); err != nil {
+		return nil, err
+	}
+
+	return s.store.ListRegistrations()
+}
+
+// StoreDeployment stores a deployment.
+func (s *Store) StoreDeployment(issuer string, deployment datastore.Deployment) error {
+	if err := s.fault("StoreDeployment"); err != nil {
+		return err
+	}
+
+	return s.store.StoreDeployment(issuer, deployment)
+}
+
+// FindDeployment finds a deployment.
+func (s *Store) FindDeployment(issuer, deploymentID string) (datastore.Deployment, error) {
+	if err := s.fault("FindDeployment"); err != nil {
+		return datastore.Deployment{}, err
+	}
+
+	return s.store.FindDeployment(issuer, deploymentID)
+}
+
+// StoreNonce stores a nonce.
+func (s *Store) StoreNonce(nonce, targetLinkURI string) error {
+	if err := s.fault("StoreNonce"); err != nil {
+		return err
+	}
+
+	return s.store.StoreNonce(nonce, targetLinkURI)
+}
+
+// TestAndClearNonce tests for and clears a nonce.
+func (s *Store) TestAndClearNonce(nonce, targetLinkURI string) error {
+	if err := s.fault("TestAndClearNonce"); err != nil {
+		return err
+	}
+
+	return s.store.TestAndClearNonce(nonce, targetLinkURI)
+}
+
+// StoreLaunchData stores launch data.
+func (s *Store) StoreLaunchData(launchID string, launchData json.RawMessage) error {
+	if err := s.fault("StoreLaunchData"); err != nil {
+		return err
+	}
+
+	return s.store.StoreLaunchData(launchID, launchData)
+}
+
+// FindLaunchData finds launch data.
+func (s *Store) FindLaunchData(launchID string) (json.RawMessage, error) {
+	if err := s.fault("FindLaunchData"); err != nil {
+		return nil, err
+	}
+
+	return s.store.FindLaunchData(launchID)
+}
+
+// StoreAccessToken stores an access token.
+func (s *Store) StoreAccessToken(token datastore.AccessToken) error {
+	if err := s.fault("StoreAccessToken"); err != nil {
+		return err
+	}
+
+	return s.store.StoreAccessToken(token)
+}
+
+// FindAccessToken finds an access token.
+func (s *Store) FindAccessToken(tokenURI, clientID string, scopes []string) (datastore.AccessToken, error) {
+	if err := s.fault("FindAccessToken"); err != nil {
+		return datastore.AccessToken{}, err
+	}
+
+	return s.store.FindAccessToken(tokenURI, clientID, scopes)
+}
+
+// StoreTokenID records a used token ID.
+func (s *Store) StoreTokenID(tokenID string, expiry time.Time) error {
+	if err := s.fault("StoreTokenID"); err != nil {
+		return err
+	}
+
+	return s.store.StoreTokenID(tokenID, expiry)
+}
+
+// StoreState stores the state of a login.
+func (s *Store) StoreState(state, nonce string, expiry time.Time) error {
+	if err := s.fault("StoreState"); err != nil {
+		return err
+	}
+
+	return s.store.StoreState(state, nonce, expiry)
+}
+
+// TestAndClearState tests for and clears the state of a login.
+func (s *Store) TestAndClearState(state, nonce string) error {
+	if err := s.fault("TestAndClearState"); err != nil {
+		return err
+	}
+
+	return s.store.TestAndClearState(state, nonce)
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltimoodletest
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// A Tool describes the LTI 1.3 tool to register with Moodle. LoginURI, LaunchURI and KeysetURI are the tool's login
+// initiation URL, launch (redirection) URL and public keyset URL. AGS and NRPS enable the services for the tool's
+// launches.
+type Tool struct {
+	Name      string
+	LoginURI  string
+	LaunchURI string
+	KeysetURI string
+	AGS       bool
+	NRPS      bool
+}
+
+// A RegisteredTool is a tool registered with Moodle: the registration and deployment to store in the tool's
+// datastore, and the ID of the Moodle tool type, which is needed to add activities using the tool.
+type RegisteredTool struct {
+	Registration datastore.Registration
+	Deployment   datastore.Deployment
+	TypeID       int
+}
+
+// registerToolScript adds a site-wide LTI 1.3 tool type with a keyset URL, shown in the activity chooser.
+const registerToolScript = `
+require_once($CFG->dirroot . '/mod/lti/locallib.php');
+
+$type = new stdClass();
+$type->name = $input['name'];
+$type->baseurl = $input['launchURI'];
+$type->state = LTI_TOOL_STATE_CONFIGURED;
+$type->coursevisible = LTI_COURSEVISIBLE_ACTIVITYCHOOSER;
+$type->ltiversion = LTI_VERSION_1P3;
+$type->clientid = random_string(15);
+
+$config = new stdClass();
+$config->lti_typename = $input['name'];
+$config->lti_toolurl = $input['launchURI'];
+$config->lti_ltiversion = LTI_VERSION_1P3;
+$config->lti_keytype = LTI_JWK_KEYSET;
+$config->lti_publickeyset = $input['keysetURI'];
+$config->lti_initiatelogin = $input['loginURI'];
+$config->lti_redirectionuris = $input['launchURI'];
+$config->lti_coursevisible = LTI_COURSEVISIBLE_ACTIVITYCHOOSER;
+$config->lti_launchcontainer = LTI_LAUNCH_CONTAINER_EMBED;
+$config->lti_sendname = LTI_SETTING_ALWAYS;
+$config->lti_sendemailaddr = LTI_SETTING_ALWAYS;
+$config->lti_acceptgrades = LTI_SETTING_ALWAYS;
+$config->ltiservice_gradesynchronization = $input['ags'] ? 2 : 0;
+$config->ltiservice_memberships = $input['nrps'] ? 1 : 0;
+
+$typeid = lti_add_type($type, $config);
+echo json_encode(['typeID' => (int)$typeid, 'clientID' => $type->clientid]);
+`
+
+// RegisterTool registers the tool with Moodle. Moodle uses the tool type's ID as the deployment ID.
+func (m *Moodle) RegisterTool(tool Tool) (RegisteredTool, error) {
+	var registered struct {
+		TypeID   int
+		ClientID string
+	}
+	err := m.PHP(registerToolScript, map[string]interface{}{
+		"name":      tool.Name,
+		"loginURI":  tool.LoginURI,
+		"launchURI": tool.LaunchURI,
+		"keysetURI": tool.KeysetURI,
+		"ags":       tool.AGS,
+		"nrps":      tool.NRPS,
+	}, &registered)
+	if err != nil {
+		return RegisteredTool{}, fmt.Errorf("could not register tool: %w", err)
+	}
+
+	reg := datastore.Registration{
+		Issuer:   m.URL,
+		ClientID: registered.ClientID,
+	}
+	for _, u := range []struct {
+		field **url.URL
+		uri   string
+	}{
+		{&reg.AuthLoginURI, m.URL + "/mod/lti/auth.php"},
+		{&reg.AuthTokenURI, m.URL + "/mod/lti/token.php"},
+		{&reg.KeysetURI, m.URL + "/mod/lti/certs.php"},
+		{&reg.TargetLinkURI, tool.LaunchURI},
+	} {
+		*u.field, err = url.Parse(u.uri)
+		if err != nil {
+			return RegisteredTool{}, fmt.Errorf("registration URI parse error: %w", err)
+		}
+	}
+
+	return RegisteredTool{
+		Registration: reg,
+		Deployment:   datastore.Deployment{DeploymentID: strconv.Itoa(registered.TypeID)},
+		TypeID:       registered.TypeID,
+	}, nil
+}
+
+// createCourseScript creates a course in the default category.
+const createCourseScript = `
+require_once($CFG->dirroot . '/course/lib.php');
+
+$course = create_course((object)[
+    'fullname' => $input['shortName'],
+    'shortname' => $input['shortName'],
+    'category' => core_course_category::get_default()->id,
+]);
+echo json_encode(['id' => (int)$course->id]);
+`
+
+// CreateCourse creates a course and returns its ID. The short name must be unique within the Moodle.
+func (m *Moodle) CreateCourse(shortName string) (int, error) {
+	var course struct{ ID int }
+	err := m.PHP(createCourseScript, map[string]string{"shortName": shortName}, &course)
+	if err != nil {
+		return 0, fmt.Errorf("could not create course: %w", err)
+	}
+
+	return course.ID, nil
+}
+
+// createUserScript creates a manually authenticated user.
+const createUserScript = `
+require_once($CFG->dirroot . '/user/lib.php');
+
+$id = user_create_user((object)[
+    'username' => $input['username'],
+    'password' => $input['password'],
+    'firstname' => $input['username'],
+    'lastname' => 'Test',
+    'email' => $input['username'] . '@example.com',
+    'auth' => 'manual',
+    'confirmed' => 1,
+    'mnethostid' => $CFG->mnet_localhost_id,
+]);
+echo json_encode(['id' => (int)$id]);
+`
+
+// CreateUser creates a user who can log in with the password and returns the user's ID.
+func (m *Moodle) CreateUser(username, password string) (int, error) {
+	var user struct{ ID int }
+	err := m.PHP(createUserScript, map[string]string{"username": username, "password": password}, &user)
+	if err != nil {
+		return 0, fmt.Errorf("could not create user: %w", err)
+	}
+
+	return user.ID, nil
+}
+
+// The short names of Moodle's standard course roles.
+const (
+	RoleStudent        = "student"
+	RoleTeacher        = "teacher"
+	RoleEditingTeacher = "editingteacher"
+)
+
+// enrolScript enrols a user in a course with the manual enrolment method.
+const enrolScript = `
+$role = $DB->get_record('role', ['shortname' => $input['role']], '*', MUST_EXIST);
+if (!enrol_try_internal_enrol($input['courseID'], $input['userID'], $role->id)) {
+    fwrite(STDERR, 'manual enrolment is not available');
+    exit(1);
+}
+echo json_encode(['enrolled' => true]);
+`
+
+// Enrol enrols the user in the course with the role, e.g., RoleStudent.
+func (m *Moodle) Enrol(courseID, userID int, role string) error {
+	var enrolled struct{ Enrolled bool }
+	err := m.PHP(enrolScript, map[string]interface{}{"courseID": courseID, "userID": userID, "role": role},
+		&enrolled)
+	if err != nil {
+		return fmt.Errorf("could not enrol user: %w", err)
+	}
+
+	return nil
+}
+
+// addActivityScript adds an external tool activity using a registered tool type, graded out of 100.
+const addActivityScript = `
+require_once($CFG->dirroot . '/course/modlib.php');
+require_once($CFG->dirroot . '/mod/lti/locallib.php');
+
+$moduleinfo = create_module((object)[
+    'modulename' => 'lti',
+    'course' => $input['courseID'],
+    'section' => 0,
+    'visible' => 1,
+    'name' => $input['name'],
+    'typeid' => $input['typeID'],
+    'toolurl' => '',
+    'instructorchoiceacceptgrades' => LTI_SETTING_ALWAYS,
+    'instructorchoicesendname' => LTI_SETTING_ALWAYS,
+    'instructorchoicesendemailaddr' => LTI_SETTING_ALWAYS,
+    'launchcontainer' => LTI_LAUNCH_CONTAINER_DEFAULT,
+    'grade' => 100,
+]);
+echo json_encode(['cmid' => (int)$moduleinfo->coursemodule]);
+`
+
+// AddActivity adds an activity launching the registered tool to the course and returns its course module ID, which
+// identifies the activity to Launch.
+func (m *Moodle) AddActivity(courseID int, tool RegisteredTool, name string) (int, error) {
+	var activity struct{ CMID int }
+	err := m.PHP(addActivityScript, map[string]interface{}{
+		"courseID": courseID,
+		"typeID":   tool.TypeID,
+		"name":     name,
+	}, &activity)
+	if err != nil {
+		return 0, fmt.Errorf("could not add activity: %w", err)
+	}
+
+	return activity.CMID, nil
+}
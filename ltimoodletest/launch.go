@@ -0,0 +1,180 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltimoodletest
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	formPattern      = regexp.MustCompile(`(?is)<form\b([^>]*)>(.*?)</form>`)
+	inputPattern     = regexp.MustCompile(`(?is)<input\b([^>]*)>`)
+	attributePattern = regexp.MustCompile(`(?s)([a-zA-Z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// A form is an HTML form found in a page.
+type form struct {
+	action string
+	values url.Values
+}
+
+// Launch logs in to Moodle as the user and launches the activity identified by its course module ID, following the
+// login initiation and authentication forms through to the tool's launch. It returns the response of the tool's
+// launch handler, or of the handler it redirects to, whose body the caller must close.
+func (m *Moodle) Launch(cmid int, username, password string) (*http.Response, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := *m.HTTPClient
+	client.Jar = jar
+
+	if err := m.logIn(&client, username, password); err != nil {
+		return nil, err
+	}
+
+	// The launch page holds the form initiating the login with the tool.
+	launchPage, err := getPage(&client, m.URL+"/mod/lti/launch.php?id="+strconv.Itoa(cmid))
+	if err != nil {
+		return nil, fmt.Errorf("could not load launch page: %w", err)
+	}
+	initiation, err := findForm(launchPage)
+	if err != nil {
+		return nil, fmt.Errorf("launch page: %w", err)
+	}
+
+	// The tool redirects the login to Moodle's authentication endpoint, which answers with the form posting the
+	// id_token to the tool.
+	authPage, err := postPage(&client, initiation)
+	if err != nil {
+		return nil, fmt.Errorf("could not initiate login: %w", err)
+	}
+	authentication, err := findForm(authPage)
+	if err != nil {
+		return nil, fmt.Errorf("authentication response: %w", err)
+	}
+	if authentication.values.Get("id_token") == "" {
+		return nil, fmt.Errorf("authentication response has no id_token: %s", authentication.values.Get("error"))
+	}
+
+	response, err := client.PostForm(authentication.action, authentication.values)
+	if err != nil {
+		return nil, fmt.Errorf("could not launch: %w", err)
+	}
+
+	return response, nil
+}
+
+// logIn logs in to Moodle with the login form.
+func (m *Moodle) logIn(client *http.Client, username, password string) error {
+	loginURL := m.URL + "/login/index.php"
+	loginPage, err := getPage(client, loginURL)
+	if err != nil {
+		return fmt.Errorf("could not load login page: %w", err)
+	}
+	loginForm, err := findForm(loginPage)
+	if err != nil {
+		return fmt.Errorf("login page: %w", err)
+	}
+
+	values := url.Values{
+		"username":   {username},
+		"password":   {password},
+		"logintoken": {loginForm.values.Get("logintoken")},
+	}
+	response, err := client.PostForm(loginURL, values)
+	if err != nil {
+		return fmt.Errorf("could not log in: %w", err)
+	}
+	defer response.Body.Close()
+
+	// A failed login lands back on the login page.
+	if strings.HasPrefix(response.Request.URL.Path, "/login/") {
+		return fmt.Errorf("could not log in as %s", username)
+	}
+
+	return nil
+}
+
+// getPage returns the body of the page at the URL.
+func getPage(client *http.Client, pageURL string) (string, error) {
+	response, err := client.Get(pageURL)
+	if err != nil {
+		return "", err
+	}
+
+	return readPage(response)
+}
+
+// postPage submits the form and returns the body of the resulting page.
+func postPage(client *http.Client, f form) (string, error) {
+	response, err := client.PostForm(f.action, f.values)
+	if err != nil {
+		return "", err
+	}
+
+	return readPage(response)
+}
+
+// readPage reads and closes the body of a successful response.
+func readPage(response *http.Response) (string, error) {
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d: %s", response.Request.URL, response.StatusCode, body)
+	}
+
+	return string(body), nil
+}
+
+// findForm returns the first form of the page that has an action, with the values of its named inputs.
+func findForm(page string) (form, error) {
+	for _, match := range formPattern.FindAllStringSubmatch(page, -1) {
+		action := attributes(match[1])["action"]
+		if action == "" {
+			continue
+		}
+
+		values := url.Values{}
+		for _, input := range inputPattern.FindAllStringSubmatch(match[2], -1) {
+			inputAttributes := attributes(input[1])
+			if name := inputAttributes["name"]; name != "" {
+				values.Add(name, inputAttributes["value"])
+			}
+		}
+
+		return form{action: action, values: values}, nil
+	}
+
+	return form{}, errors.New("form not found")
+}
+
+// attributes returns the unescaped values of the attributes of an HTML tag, by lowercase name.
+func attributes(tag string) map[string]string {
+	found := map[string]string{}
+	for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+		value := match[2]
+		if value == "" {
+			value = match[3]
+		}
+		found[strings.ToLower(match[1])] = html.UnescapeString(value)
+	}
+
+	return found
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltimoodletest
+
+import (
+	"testing"
+)
+
+func TestFindForm(t *testing.T) {
+	page := `<html><body>
+<form action="/search" method="get"><input type="text" name="q"></form>
+<form action="https://tool.tld/login?a=1&amp;b=2" name="ltiLaunchForm" id="ltiLaunchForm" method="post"
+      encType="application/x-www-form-urlencoded">
+<input type="hidden" name="iss" value="https://moodle.tld" />
+<input type='hidden' name='lti_message_hint' value='{&quot;cmid&quot;:2}'/>
+<input type="submit" value="Continue">
+</form>
+</body></html>`
+
+	found, err := findForm(page)
+	if err != nil {
+		t.Fatalf("find form error: %v", err)
+	}
+	if found.action != "/search" {
+		t.Errorf("got action %s, wanted the first form", found.action)
+	}
+
+	found, err = findForm(page[len(`<html><body>
+<form action="/search" method="get"><input type="text" name="q"></form>`):])
+	if err != nil {
+		t.Fatalf("find form error: %v", err)
+	}
+	if found.action != "https://tool.tld/login?a=1&b=2" {
+		t.Errorf("got action %s", found.action)
+	}
+	if found.values.Get("iss") != "https://moodle.tld" || found.values.Get("lti_message_hint") != `{"cmid":2}` {
+		t.Errorf("got values %v", found.values)
+	}
+	if len(found.values) != 2 {
+		t.Errorf("got %d values, wanted the named inputs only", len(found.values))
+	}
+
+	if _, err := findForm("<html></html>"); err == nil {
+		t.Error("error not reported for page without form")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package ltimoodletest drives a Moodle instance running in a Docker container, so that applications built with the
+// LTI packages can run end-to-end tests of login, launch, AGS and NRPS against a real platform, e.g., in CI.
+//
+// The harness is configured through the environment, and tests using New are skipped when it is not configured:
+//
+//	LTI_MOODLE_URL        the Moodle site's wwwroot, e.g., http://localhost:8000, which is also the issuer
+//	LTI_MOODLE_CONTAINER  the name of the container running Moodle
+//	LTI_MOODLE_ROOT       the Moodle code directory in the container (default /var/www/html)
+//
+// Administration, such as registering the tool and creating courses, users and activities, is performed by PHP
+// scripts run with Moodle's CLI in the container, so no administrator password is needed. Launches are performed
+// over HTTP as a logged in user, following the auto-submitted forms as a browser would.
+//
+// The tool under test must be reachable from the container for service requests, e.g., on the host's address, and
+// from the test process for the launch. Since the login's state cookie is Secure, the tool must be served over HTTPS,
+// e.g., with httptest.NewTLSServer, and the Moodle's HTTPClient must trust its certificate.
+package ltimoodletest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// The environment variables read by ConfigFromEnv.
+const (
+	URLVariable       = "LTI_MOODLE_URL"
+	ContainerVariable = "LTI_MOODLE_CONTAINER"
+	RootVariable      = "LTI_MOODLE_ROOT"
+)
+
+// DefaultRoot is the Moodle code directory used when a Config's Root is empty. It is the directory used by the
+// moodlehq/moodle-php-apache image.
+const DefaultRoot = "/var/www/html"
+
+// Config locates the Moodle instance. Docker is the docker command to run; when empty, "docker" is used.
+type Config struct {
+	URL       string
+	Container string
+	Root      string
+	Docker    string
+}
+
+// ConfigFromEnv returns the Config given by the environment. It reports false if the URL or container is not set.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		URL:       strings.TrimSuffix(os.Getenv(URLVariable), "/"),
+		Container: os.Getenv(ContainerVariable),
+		Root:      os.Getenv(RootVariable),
+	}
+
+	return cfg, cfg.URL != "" && cfg.Container != ""
+}
+
+// A Moodle is a Moodle instance under the control of the harness. HTTPClient is used for launches, each with a cookie
+// jar of its own, so that consecutive launches by different users do not share a session.
+type Moodle struct {
+	Config
+	HTTPClient *http.Client
+}
+
+// NewMoodle returns a *Moodle for the configured instance.
+func NewMoodle(cfg Config) *Moodle {
+	if cfg.Root == "" {
+		cfg.Root = DefaultRoot
+	}
+	if cfg.Docker == "" {
+		cfg.Docker = "docker"
+	}
+
+	return &Moodle{
+		Config:     cfg,
+		HTTPClient: &http.Client{},
+	}
+}
+
+// New returns a *Moodle for the instance configured in the environment, or skips the test if there is none.
+func New(t testing.TB) *Moodle {
+	t.Helper()
+
+	cfg, ok := ConfigFromEnv()
+	if !ok {
+		t.Skipf("%s and %s are not set", URLVariable, ContainerVariable)
+	}
+
+	return NewMoodle(cfg)
+}
+
+// PHP runs the PHP statements as a Moodle CLI script in the container, after Moodle's config.php is loaded and the
+// administrator is set as the current user. The input is available to the statements, decoded from JSON, in the
+// $input array. The statements must echo their result as JSON, which is decoded into output unless it is nil.
+func (m *Moodle) PHP(statements string, input, output interface{}) error {
+	encodedInput, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("could not encode script input: %w", err)
+	}
+
+	script := `<?php
+define('CLI_SCRIPT', true);
+require('` + m.Root + `/config.php');
+\core\session\manager::set_user(get_admin());
+$input = json_decode(base64_decode('` + base64.StdEncoding.EncodeToString(encodedInput) + `'), true);
+` + statements
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(m.Docker, "exec", "-i", m.Container, "php")
+	command.Stdin = strings.NewReader(script)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("moodle script failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if output == nil {
+		return nil
+	}
+	if stdout.Len() == 0 {
+		return errors.New("moodle script returned no output")
+	}
+	if err := json.Unmarshal(stdout.Bytes(), output); err != nil {
+		return fmt.Errorf("could not decode script output %q: %w", stdout.String(), err)
+	}
+
+	return nil
+}
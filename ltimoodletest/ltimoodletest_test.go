@@ -0,0 +1,110 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package ltimoodletest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/launch"
+	"github.com/macewan-cs/lti/login"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	for _, variable := range []string{URLVariable, ContainerVariable, RootVariable} {
+		value, ok := os.LookupEnv(variable)
+		defer func(variable string) {
+			if ok {
+				os.Setenv(variable, value)
+			} else {
+				os.Unsetenv(variable)
+			}
+		}(variable)
+	}
+
+	os.Setenv(URLVariable, "http://localhost:8000/")
+	os.Unsetenv(ContainerVariable)
+	os.Unsetenv(RootVariable)
+	if _, ok := ConfigFromEnv(); ok {
+		t.Error("configuration reported without a container")
+	}
+
+	os.Setenv(ContainerVariable, "moodle")
+	cfg, ok := ConfigFromEnv()
+	if !ok || cfg.URL != "http://localhost:8000" {
+		t.Errorf("got %+v, %t", cfg, ok)
+	}
+	if moodle := NewMoodle(cfg); moodle.Root != DefaultRoot || moodle.Docker != "docker" {
+		t.Errorf("got defaults %+v", moodle.Config)
+	}
+}
+
+// TestLaunch registers a tool served by the test with the Moodle configured in the environment, and launches it as a
+// student.
+func TestLaunch(t *testing.T) {
+	moodle := New(t)
+
+	store := nonpersistent.New()
+	cfg := datastore.Config{Registrations: store, Nonces: store, LaunchData: store, Replays: store}
+	mux := http.NewServeMux()
+	mux.Handle("/login", login.New(cfg))
+	mux.Handle("/launch", launch.New(cfg, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "launched")
+	}))
+	tool := httptest.NewTLSServer(mux)
+	defer tool.Close()
+	moodle.HTTPClient = tool.Client()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	registered, err := moodle.RegisterTool(Tool{
+		Name:      "Test tool " + suffix,
+		LoginURI:  tool.URL + "/login",
+		LaunchURI: tool.URL + "/launch",
+		KeysetURI: tool.URL + "/keyset",
+	})
+	if err != nil {
+		t.Fatalf("register tool error: %v", err)
+	}
+	if err := store.StoreRegistration(registered.Registration); err != nil {
+		t.Fatalf("store registration error: %v", err)
+	}
+	if err := store.StoreDeployment(registered.Registration.Issuer, registered.Deployment); err != nil {
+		t.Fatalf("store deployment error: %v", err)
+	}
+
+	courseID, err := moodle.CreateCourse("lti" + suffix)
+	if err != nil {
+		t.Fatalf("create course error: %v", err)
+	}
+	userID, err := moodle.CreateUser("student"+suffix, "Password-1")
+	if err != nil {
+		t.Fatalf("create user error: %v", err)
+	}
+	if err := moodle.Enrol(courseID, userID, RoleStudent); err != nil {
+		t.Fatalf("enrol error: %v", err)
+	}
+	cmid, err := moodle.AddActivity(courseID, registered, "Activity")
+	if err != nil {
+		t.Fatalf("add activity error: %v", err)
+	}
+
+	response, err := moodle.Launch(cmid, "student"+suffix, "Password-1")
+	if err != nil {
+		t.Fatalf("launch error: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "launched" {
+		t.Errorf("got status %d: %s", response.StatusCode, body)
+	}
+}
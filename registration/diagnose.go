@@ -0,0 +1,196 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package registration
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+// A Check is the result of exercising one of the platform's endpoints. Reachable reports whether the endpoint
+// responded at all; Err is set when it could not be reached or when its response shows that it is not usable by the
+// tool. The TLS fields are set only for HTTPS endpoints.
+type Check struct {
+	URI        string
+	Reachable  bool
+	StatusCode int
+	Latency    time.Duration
+	TLSVersion string
+	CertExpiry time.Time
+	Err        error
+}
+
+// OK reports whether the check succeeded.
+func (c Check) OK() bool {
+	return c.Reachable && c.Err == nil
+}
+
+// A Diagnosis holds the checks of a registration's platform endpoints.
+type Diagnosis struct {
+	Keyset    Check
+	AuthLogin Check
+	AuthToken Check
+}
+
+// OK reports whether every check succeeded.
+func (d Diagnosis) OK() bool {
+	return d.Keyset.OK() && d.AuthLogin.OK() && d.AuthToken.OK()
+}
+
+// tlsVersions names the TLS versions reported in checks.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// Diagnose exercises the registration's platform endpoints, which is useful when onboarding a new platform:
+//
+// The keyset URI must serve a parseable key set.
+//
+// The auth login URI is requested with HEAD; any response short of a server error is accepted, since the platform
+// expects login parameters that the check does not send.
+//
+// The auth token URI is sent a client credentials grant with an invalid client assertion; it must reject the grant
+// with an OAuth 2.0 error response.
+func Diagnose(reg datastore.Registration) Diagnosis {
+	return DiagnoseWithClient(&http.Client{Timeout: timeout}, reg)
+}
+
+// DiagnoseWithClient is like Diagnose, but it uses the supplied HTTP client, e.g., one with a custom transport.
+func DiagnoseWithClient(client *http.Client, reg datastore.Registration) Diagnosis {
+	return Diagnosis{
+		Keyset:    checkKeyset(client, reg.KeysetURI),
+		AuthLogin: checkAuthLogin(client, reg.AuthLoginURI),
+		AuthToken: checkAuthToken(client, reg.AuthTokenURI),
+	}
+}
+
+// checkKeyset gets the keyset and checks that it can be parsed.
+func checkKeyset(client *http.Client, uri *url.URL) Check {
+	check, body := probe(client, uri, func(target string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, target, nil)
+	})
+	if check.Err != nil {
+		return check
+	}
+
+	if check.StatusCode != http.StatusOK {
+		check.Err = fmt.Errorf("keyset got response status %s", http.StatusText(check.StatusCode))
+		return check
+	}
+	if _, err := jwk.Parse(body); err != nil {
+		check.Err = fmt.Errorf("could not parse keyset: %w", err)
+	}
+
+	return check
+}
+
+// checkAuthLogin checks that the auth login endpoint responds to a HEAD request.
+func checkAuthLogin(client *http.Client, uri *url.URL) Check {
+	check, _ := probe(client, uri, func(target string) (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, target, nil)
+	})
+	if check.Err != nil {
+		return check
+	}
+
+	if check.StatusCode >= 500 {
+		check.Err = fmt.Errorf("auth login got response status %s", http.StatusText(check.StatusCode))
+	}
+
+	return check
+}
+
+// checkAuthToken checks that the token endpoint rejects an invalid client assertion with an OAuth 2.0 error.
+func checkAuthToken(client *http.Client, uri *url.URL) Check {
+	check, body := probe(client, uri, func(target string) (*http.Request, error) {
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {"invalid"},
+		}
+		request, err := http.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return request, nil
+	})
+	if check.Err != nil {
+		return check
+	}
+
+	if check.StatusCode != http.StatusBadRequest && check.StatusCode != http.StatusUnauthorized {
+		check.Err = fmt.Errorf("token endpoint got response status %s to an invalid grant",
+			http.StatusText(check.StatusCode))
+		return check
+	}
+	var oauthError struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &oauthError); err != nil || oauthError.Error == "" {
+		check.Err = errors.New("token endpoint did not return an OAuth error response")
+	}
+
+	return check
+}
+
+// maximumProbeBody limits the response body read by a probe.
+const maximumProbeBody = 1 << 20
+
+// probe sends the request created for the URI, timing it and recording its TLS details, and returns the check with
+// the response body.
+func probe(client *http.Client, uri *url.URL, newRequest func(target string) (*http.Request, error)) (Check, []byte) {
+	if uri == nil {
+		return Check{Err: errors.New("endpoint not set")}, nil
+	}
+	check := Check{URI: uri.String()}
+
+	request, err := newRequest(check.URI)
+	if err != nil {
+		check.Err = fmt.Errorf("could not create request: %w", err)
+		return check, nil
+	}
+
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		check.Latency = time.Since(start)
+		check.Err = err
+		return check, nil
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maximumProbeBody))
+	check.Latency = time.Since(start)
+	check.Reachable = true
+	check.StatusCode = response.StatusCode
+	if err != nil {
+		check.Err = fmt.Errorf("could not read response: %w", err)
+		return check, nil
+	}
+
+	if response.TLS != nil {
+		check.TLSVersion = tlsVersions[response.TLS.Version]
+		if len(response.TLS.PeerCertificates) > 0 {
+			check.CertExpiry = response.TLS.PeerCertificates[0].NotAfter
+		}
+	}
+
+	return check, body
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package registration
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/macewan-cs/lti/datastore"
+)
+
+func TestDiagnose(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	key, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot create key: %v", err)
+	}
+	keyset := jwk.NewSet()
+	keyset.Add(key)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keyset", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keyset)
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_assertion") == "invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"token"}`))
+	})
+	platform := httptest.NewTLSServer(mux)
+	defer platform.Close()
+
+	uri := func(path string) *url.URL {
+		u, _ := url.Parse(platform.URL + path)
+		return u
+	}
+	registration := datastore.Registration{
+		KeysetURI:    uri("/keyset"),
+		AuthLoginURI: uri("/auth"),
+		AuthTokenURI: uri("/token"),
+	}
+
+	diagnosis := DiagnoseWithClient(platform.Client(), registration)
+	if !diagnosis.OK() {
+		t.Fatalf("got diagnosis %+v", diagnosis)
+	}
+	if diagnosis.Keyset.TLSVersion == "" || diagnosis.Keyset.CertExpiry.IsZero() {
+		t.Errorf("got keyset check %+v, wanted TLS details", diagnosis.Keyset)
+	}
+
+	registration.KeysetURI = uri("/auth")
+	registration.AuthTokenURI = uri("/keyset")
+	registration.AuthLoginURI = nil
+	diagnosis = DiagnoseWithClient(platform.Client(), registration)
+	if diagnosis.Keyset.OK() || !diagnosis.Keyset.Reachable || diagnosis.AuthToken.OK() || diagnosis.AuthLogin.OK() {
+		t.Errorf("got diagnosis %+v, wanted failures", diagnosis)
+	}
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package registration implements the tool's side of LTI Dynamic Registration. Instead of entering the platform's
+// details by hand, a platform administrator gives the platform the tool's registration URL. The platform then opens
+// that URL with the location of its OpenID configuration, and the tool registers itself and stores the resulting
+// registration and deployment.
+//
+// Ref: https://www.imsglobal.org/spec/lti-dr/v1p0
+package registration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// Timeout value for http clients.
+var timeout time.Duration = time.Second * 15
+
+// ErrRegistrationExists is returned when a registration would replace the existing registration of the platform's
+// issuer. See RegisterReplacing.
+var ErrRegistrationExists = errors.New("platform issuer is already registered")
+
+// PlatformConfiguration is the platform's OpenID configuration, including the LTI platform configuration claim.
+type PlatformConfiguration struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	RegistrationEndpoint  string   `json:"registration_endpoint"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ClaimsSupported       []string `json:"claims_supported"`
+
+	LTIPlatformConfiguration LTIPlatformConfiguration `json:"https://purl.imsglobal.org/spec/lti-platform-configuration"`
+}
+
+// LTIPlatformConfiguration describes the LTI platform product and the messages it supports.
+type LTIPlatformConfiguration struct {
+	ProductFamilyCode string             `json:"product_family_code"`
+	Version           string             `json:"version"`
+	MessagesSupported []SupportedMessage `json:"messages_supported"`
+	Variables         []string           `json:"variables"`
+}
+
+// A SupportedMessage is an LTI message type supported by the platform, with its supported placements.
+type SupportedMessage struct {
+	Type       string   `json:"type"`
+	Placements []string `json:"placements"`
+}
+
+// ToolConfiguration describes the tool to the platform during registration.
+type ToolConfiguration struct {
+	ClientName       string
+	InitiateLoginURI string
+	RedirectURIs     []string
+	JWKSURI          string
+	LogoURI          string
+	Scopes           []string
+	Domain           string
+	TargetLinkURI    string
+	Description      string
+	CustomParameters map[string]string
+	Claims           []string
+	Messages         []ToolMessage
+}
+
+// A ToolMessage is an LTI message type offered by the tool, e.g., a deep linking request for content selection.
+type ToolMessage struct {
+	Type             string            `json:"type"`
+	TargetLinkURI    string            `json:"target_link_uri,omitempty"`
+	Label            string            `json:"label,omitempty"`
+	Placements       []string          `json:"placements,omitempty"`
+	CustomParameters map[string]string `json:"custom_parameters,omitempty"`
+}
+
+// toolRegistration is the body of the tool's registration request and of the platform's response.
+type toolRegistration struct {
+	ApplicationType         string   `json:"application_type"`
+	ResponseTypes           []string `json:"response_types"`
+	GrantTypes              []string `json:"grant_types"`
+	InitiateLoginURI        string   `json:"initiate_login_uri"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name"`
+	JWKSURI                 string   `json:"jwks_uri"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	Scope                   string   `json:"scope,omitempty"`
+	ClientID                string   `json:"client_id,omitempty"`
+
+	LTIToolConfiguration ltiToolConfiguration `json:"https://purl.imsglobal.org/spec/lti-tool-configuration"`
+}
+
+// ltiToolConfiguration is the LTI tool configuration claim of a registration.
+type ltiToolConfiguration struct {
+	Domain           string            `json:"domain"`
+	TargetLinkURI    string            `json:"target_link_uri"`
+	Description      string            `json:"description,omitempty"`
+	CustomParameters map[string]string `json:"custom_parameters,omitempty"`
+	Claims           []string          `json:"claims"`
+	Messages         []ToolMessage     `json:"messages,omitempty"`
+	DeploymentID     string            `json:"deployment_id,omitempty"`
+}
+
+// FetchConfiguration gets and parses the platform's OpenID configuration. As required by the specification, the issuer
+// of the configuration must match the beginning of the configuration URL: both must have the same scheme and host, and
+// the configuration URL's path must begin with the issuer's path, if any, up to a "/".
+func FetchConfiguration(configurationURL string) (PlatformConfiguration, error) {
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(configurationURL)
+	if err != nil {
+		return PlatformConfiguration{}, fmt.Errorf("fetch configuration error: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return PlatformConfiguration{}, fmt.Errorf("fetch configuration got response status %s",
+			http.StatusText(response.StatusCode))
+	}
+
+	var configuration PlatformConfiguration
+	err = json.NewDecoder(response.Body).Decode(&configuration)
+	if err != nil {
+		return PlatformConfiguration{}, fmt.Errorf("could not decode platform configuration: %w", err)
+	}
+
+	if !issuerMatches(configurationURL, configuration.Issuer) {
+		return PlatformConfiguration{}, errors.New("platform configuration issuer does not match configuration URL")
+	}
+
+	return configuration, nil
+}
+
+// issuerMatches reports whether the configuration URL belongs to the issuer. A plain prefix check would let a
+// configuration served from, e.g., https://platform.tld.example.com claim the issuer https://platform.tld.
+func issuerMatches(configurationURL, issuer string) bool {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil || issuerURL.Scheme == "" || issuerURL.Host == "" || issuerURL.User != nil ||
+		issuerURL.RawQuery != "" || issuerURL.Fragment != "" {
+		return false
+	}
+	configuration, err := url.Parse(configurationURL)
+	if err != nil || configuration.User != nil {
+		return false
+	}
+	if !strings.EqualFold(configuration.Scheme, issuerURL.Scheme) ||
+		!strings.EqualFold(configuration.Host, issuerURL.Host) {
+		return false
+	}
+
+	issuerPath := strings.TrimSuffix(issuerURL.EscapedPath(), "/")
+	path := configuration.EscapedPath()
+
+	return issuerPath == "" || path == issuerPath || strings.HasPrefix(path, issuerPath+"/")
+}
+
+// Discover gets the OpenID configuration of the platform identified by the issuer from its well-known location.
+func Discover(issuer string) (PlatformConfiguration, error) {
+	if issuer == "" {
+		return PlatformConfiguration{}, errors.New("received empty issuer")
+	}
+
+	return FetchConfiguration(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+}
+
+// DiscoverRegistration returns a registration populated from the OpenID configuration of the platform identified by
+// the issuer: its issuer, auth login (authorization endpoint), auth token and keyset URIs. The client ID and target
+// link URI, which are assigned when the tool is added to the platform, must be set before the registration is stored.
+func DiscoverRegistration(issuer string) (datastore.Registration, error) {
+	configuration, err := Discover(issuer)
+	if err != nil {
+		return datastore.Registration{}, err
+	}
+
+	return configuration.Registration()
+}
+
+// Registration returns a registration populated with the platform's issuer and endpoints. The client ID and target
+// link URI are left empty.
+func (p PlatformConfiguration) Registration() (datastore.Registration, error) {
+	registration := datastore.Registration{
+		Issuer: p.Issuer,
+	}
+
+	uris := []struct {
+		name string
+		raw  string
+		uri  **url.URL
+	}{
+		{"authorization endpoint", p.AuthorizationEndpoint, &registration.AuthLoginURI},
+		{"token endpoint", p.TokenEndpoint, &registration.AuthTokenURI},
+		{"keyset URI", p.JWKSURI, &registration.KeysetURI},
+	}
+	for _, u := range uris {
+		if u.raw == "" {
+			return datastore.Registration{}, fmt.Errorf("platform configuration has no %s", u.name)
+		}
+
+		var err error
+		*u.uri, err = url.Parse(u.raw)
+		if err != nil {
+			return datastore.Registration{}, fmt.Errorf("could not parse %s: %w", u.name, err)
+		}
+	}
+
+	return registration, nil
+}
+
+// Register registers the tool with the platform whose OpenID configuration is found at configurationURL, using the
+// registration token supplied by the platform (if any). The resulting registration and deployment are stored in the
+// configured datastore. If the platform's issuer is already registered, Register returns ErrRegistrationExists
+// without contacting the registration endpoint.
+func Register(cfg datastore.Config, configurationURL, registrationToken string,
+	tool ToolConfiguration) (datastore.Registration, error) {
+	return register(cfg, configurationURL, registrationToken, tool, false)
+}
+
+// RegisterReplacing registers the tool like Register, but replaces the existing registration of the platform's
+// issuer, e.g., when an administrator deliberately registers the tool with the platform again.
+func RegisterReplacing(cfg datastore.Config, configurationURL, registrationToken string,
+	tool ToolConfiguration) (datastore.Registration, error) {
+	return register(cfg, configurationURL, registrationToken, tool, true)
+}
+
+// register registers the tool, refusing to replace an existing registration of the issuer unless replace is set.
+func register(cfg datastore.Config, configurationURL, registrationToken string, tool ToolConfiguration,
+	replace bool) (datastore.Registration, error) {
+	if cfg.Registrations == nil {
+		cfg.Registrations = nonpersistent.DefaultStore
+	}
+
+	configuration, err := FetchConfiguration(configurationURL)
+	if err != nil {
+		return datastore.Registration{}, err
+	}
+	if configuration.RegistrationEndpoint == "" {
+		return datastore.Registration{}, errors.New("platform configuration has no registration endpoint")
+	}
+	if !replace {
+		_, err = cfg.Registrations.FindRegistrationByIssuerAndClientID(configuration.Issuer, "")
+		if err == nil {
+			return datastore.Registration{}, fmt.Errorf("%w: %s", ErrRegistrationExists, configuration.Issuer)
+		}
+		if !errors.Is(err, datastore.ErrRegistrationNotFound) {
+			return datastore.Registration{}, fmt.Errorf("could not look up existing registration: %w", err)
+		}
+	}
+
+	response, err := sendRegistration(configuration.RegistrationEndpoint, registrationToken, tool)
+	if err != nil {
+		return datastore.Registration{}, err
+	}
+	if response.ClientID == "" {
+		return datastore.Registration{}, errors.New("registration response has no client ID")
+	}
+
+	registration, err := configuration.Registration()
+	if err != nil {
+		return datastore.Registration{}, err
+	}
+	registration.ClientID = response.ClientID
+	registration.TargetLinkURI, err = url.Parse(tool.TargetLinkURI)
+	if err != nil {
+		return datastore.Registration{}, fmt.Errorf("could not parse target link URI: %w", err)
+	}
+
+	err = cfg.Registrations.StoreRegistration(registration)
+	if err != nil {
+		return datastore.Registration{}, fmt.Errorf("could not store registration: %w", err)
+	}
+
+	deploymentID := response.LTIToolConfiguration.DeploymentID
+	if deploymentID != "" {
+		err = cfg.Registrations.StoreDeployment(registration.Issuer, datastore.Deployment{DeploymentID: deploymentID})
+		if err != nil {
+			return datastore.Registration{}, fmt.Errorf("could not store deployment: %w", err)
+		}
+	}
+
+	return registration, nil
+}
+
+// sendRegistration POSTs the tool's registration request to the platform's registration endpoint.
+func sendRegistration(endpoint, registrationToken string, tool ToolConfiguration) (toolRegistration, error) {
+	claims := tool.Claims
+	if claims == nil {
+		claims = []string{"iss", "sub"}
+	}
+	request := toolRegistration{
+		ApplicationType:         "web",
+		ResponseTypes:           []string{"id_token"},
+		GrantTypes:              []string{"implicit", "client_credentials"},
+		InitiateLoginURI:        tool.InitiateLoginURI,
+		RedirectURIs:            tool.RedirectURIs,
+		ClientName:              tool.ClientName,
+		JWKSURI:                 tool.JWKSURI,
+		LogoURI:                 tool.LogoURI,
+		TokenEndpointAuthMethod: "private_key_jwt",
+		Scope:                   strings.Join(tool.Scopes, " "),
+		LTIToolConfiguration: ltiToolConfiguration{
+			Domain:           tool.Domain,
+			TargetLinkURI:    tool.TargetLinkURI,
+			Description:      tool.Description,
+			CustomParameters: tool.CustomParameters,
+			Claims:           claims,
+			Messages:         tool.Messages,
+		},
+	}
+	if len(request.RedirectURIs) == 0 {
+		request.RedirectURIs = []string{tool.TargetLinkURI}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return toolRegistration{}, fmt.Errorf("could not encode registration request: %w", err)
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return toolRegistration{}, fmt.Errorf("could not create http request for registration: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "application/json")
+	if registrationToken != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+registrationToken)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return toolRegistration{}, fmt.Errorf("send registration error: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return toolRegistration{}, fmt.Errorf("registration got response status %s: %s",
+			http.StatusText(response.StatusCode), message)
+	}
+
+	var registered toolRegistration
+	err = json.NewDecoder(response.Body).Decode(&registered)
+	if err != nil {
+		return toolRegistration{}, fmt.Errorf("could not decode registration response: %w", err)
+	}
+
+	return registered, nil
+}
+
+// An Authorizer decides whether a registration request may proceed, returning an error to refuse it. The
+// registration URL is opened by a platform administrator, so an Authorizer typically checks that the request comes
+// from an operator of the tool, e.g., by a one-time secret included in the registration URL given to the platform, or
+// by the operator's session.
+type Authorizer func(r *http.Request) error
+
+// A Handler implements the tool's registration URL. It registers the tool with the platform that opened it and then
+// asks the platform to close the registration window.
+//
+// Registering stores the platform's keyset URI and client ID, which determine the launches the tool accepts, so every
+// request must be allowed by the Handler's Authorizer. A Handler without an Authorizer refuses every request. The
+// Handler does not replace the existing registration of a platform's issuer unless ReplaceExisting is set.
+type Handler struct {
+	ReplaceExisting bool
+
+	cfg       datastore.Config
+	tool      ToolConfiguration
+	authorize Authorizer
+}
+
+// NewHandler creates a *Handler, which implements the http.Handler interface for dynamic registration. Each
+// registration request must be allowed by authorize.
+func NewHandler(cfg datastore.Config, tool ToolConfiguration, authorize Authorizer) *Handler {
+	return &Handler{
+		cfg:       cfg,
+		tool:      tool,
+		authorize: authorize,
+	}
+}
+
+// closePage tells the platform, which opened the registration URL in an iframe or window, that registration is done.
+var closePage = template.Must(template.New("close").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Registration complete</title></head>
+<body>
+<p>The tool has been registered.</p>
+<script>(window.opener || window.parent).postMessage({subject: "org.imsglobal.lti.close"}, "*");</script>
+</body>
+</html>
+`))
+
+// ServeHTTP performs the registration using the openid_configuration and registration_token parameters sent by the
+// platform.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorize == nil {
+		http.Error(w, "registration is not enabled", http.StatusForbidden)
+		return
+	}
+	if err := h.authorize(r); err != nil {
+		http.Error(w, "registration not authorized", http.StatusForbidden)
+		return
+	}
+
+	configurationURL := r.FormValue("openid_configuration")
+	if configurationURL == "" {
+		http.Error(w, "openid configuration not found in registration request", http.StatusBadRequest)
+		return
+	}
+
+	_, err := register(h.cfg, configurationURL, r.FormValue("registration_token"), h.tool, h.ReplaceExisting)
+	if errors.Is(err, ErrRegistrationExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	closePage.Execute(w, nil)
+}
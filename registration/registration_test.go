@@ -0,0 +1,230 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package registration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+)
+
+// newPlatformForTesting starts a platform publishing its OpenID configuration and accepting registrations that carry
+// the registration token "token".
+func newPlatformForTesting(t *testing.T) *httptest.Server {
+	var platform *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{
+			"issuer": "%[1]s",
+			"authorization_endpoint": "%[1]s/auth",
+			"token_endpoint": "%[1]s/token",
+			"jwks_uri": "%[1]s/keyset",
+			"registration_endpoint": "%[1]s/register",
+			"https://purl.imsglobal.org/spec/lti-platform-configuration": {
+				"product_family_code": "moodle",
+				"messages_supported": [{"type": "LtiResourceLinkRequest"}]
+			}
+		}`, platform.URL)
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var request map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || request["token_endpoint_auth_method"] != "private_key_jwt" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		request["client_id"] = "abcdef123456"
+		request["https://purl.imsglobal.org/spec/lti-tool-configuration"].(map[string]interface{})["deployment_id"] = "1"
+		json.NewEncoder(w).Encode(request)
+	})
+	platform = httptest.NewServer(mux)
+
+	return platform
+}
+
+func TestHandler(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	store := nonpersistent.New()
+	tool := ToolConfiguration{
+		ClientName:       "Tool",
+		InitiateLoginURI: "https://tool.tld/login",
+		JWKSURI:          "https://tool.tld/keyset",
+		Domain:           "tool.tld",
+		TargetLinkURI:    "https://tool.tld/launch",
+	}
+	authorize := func(r *http.Request) error {
+		if r.FormValue("secret") != "s3cret" {
+			return errors.New("wrong secret")
+		}
+		return nil
+	}
+	handler := NewHandler(datastore.Config{Registrations: store}, tool, authorize)
+
+	configurationURL := platform.URL + "/.well-known/openid-configuration"
+	registrationURL := "/register?secret=s3cret&openid_configuration=" + configurationURL + "&registration_token=token"
+
+	unauthorized := []struct {
+		name    string
+		handler *Handler
+		target  string
+	}{
+		{"no authorizer", NewHandler(datastore.Config{Registrations: store}, tool, nil), registrationURL},
+		{"wrong secret", handler, strings.Replace(registrationURL, "s3cret", "guess", 1)},
+	}
+	for _, test := range unauthorized {
+		recorder := httptest.NewRecorder()
+		test.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("%s: got status %d, wanted %d", test.name, recorder.Code, http.StatusForbidden)
+		}
+		_, err := store.FindRegistrationByIssuerAndClientID(platform.URL, "")
+		if err == nil {
+			t.Fatalf("%s: unauthorized registration stored", test.name)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, registrationURL, nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "org.imsglobal.lti.close") {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	registration, err := store.FindRegistrationByIssuerAndClientID(platform.URL, "abcdef123456")
+	if err != nil {
+		t.Fatalf("registration not stored: %v", err)
+	}
+	if registration.AuthTokenURI.String() != platform.URL+"/token" ||
+		registration.TargetLinkURI.String() != "https://tool.tld/launch" {
+		t.Errorf("got registration %v", registration)
+	}
+	_, err = store.FindDeployment(platform.URL, "1")
+	if err != nil {
+		t.Errorf("deployment not stored: %v", err)
+	}
+
+	// The issuer is registered, so registering again is refused unless the handler replaces existing registrations.
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, registrationURL, nil))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("got status %d for existing registration, wanted %d", recorder.Code, http.StatusConflict)
+	}
+	_, err = Register(datastore.Config{Registrations: store}, configurationURL, "token", tool)
+	if !errors.Is(err, ErrRegistrationExists) {
+		t.Errorf("got %v, wanted ErrRegistrationExists", err)
+	}
+
+	handler.ReplaceExisting = true
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, registrationURL, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("got status %d replacing registration: %s", recorder.Code, recorder.Body)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/register?secret=s3cret&openid_configuration="+configurationURL, nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code == http.StatusOK {
+		t.Error("rejected registration not reported")
+	}
+}
+
+func TestIssuerMatches(t *testing.T) {
+	tests := []struct {
+		configurationURL string
+		issuer           string
+		expected         bool
+	}{
+		{"https://platform.tld/.well-known/openid-configuration", "https://platform.tld", true},
+		{"https://platform.tld/.well-known/openid-configuration", "https://platform.tld/", true},
+		{"https://PLATFORM.tld/.well-known/openid-configuration", "https://platform.tld", true},
+		{"https://platform.tld/tenant/.well-known/openid-configuration", "https://platform.tld/tenant", true},
+		{"https://platform.tld/tenant", "https://platform.tld/tenant", true},
+		{"https://platform.tld.evil.tld/.well-known/openid-configuration", "https://platform.tld", false},
+		{"https://platform.tld:8443/.well-known/openid-configuration", "https://platform.tld", false},
+		{"http://platform.tld/.well-known/openid-configuration", "https://platform.tld", false},
+		{"https://platform.tld@evil.tld/.well-known/openid-configuration", "https://platform.tld", false},
+		{"https://platform.tld/tenant-evil/.well-known/openid-configuration", "https://platform.tld/tenant", false},
+		{"https://platform.tld/other/.well-known/openid-configuration", "https://platform.tld/tenant", false},
+		{"https://platform.tld/.well-known/openid-configuration", "https://platform.tld?x=1", false},
+		{"https://platform.tld/.well-known/openid-configuration", "platform.tld", false},
+		{"https://platform.tld/.well-known/openid-configuration", "", false},
+	}
+	for _, test := range tests {
+		actual := issuerMatches(test.configurationURL, test.issuer)
+		if actual != test.expected {
+			t.Errorf("got %v for %s with issuer %q, wanted %v", actual, test.configurationURL, test.issuer,
+				test.expected)
+		}
+	}
+}
+
+func TestFetchConfigurationIssuerMismatch(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	_, err := FetchConfiguration(platform.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatalf("fetch configuration error: %v", err)
+	}
+
+	// The same configuration, served from a different host, must be rejected.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, err := http.Get(platform.URL + r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer response.Body.Close()
+		w.WriteHeader(response.StatusCode)
+		var body json.RawMessage
+		json.NewDecoder(response.Body).Decode(&body)
+		w.Write(body)
+	}))
+	defer proxy.Close()
+
+	_, err = FetchConfiguration(proxy.URL + "/.well-known/openid-configuration")
+	if err == nil {
+		t.Error("issuer mismatch not reported")
+	}
+}
+
+func TestDiscoverRegistration(t *testing.T) {
+	platform := newPlatformForTesting(t)
+	defer platform.Close()
+
+	configuration, err := Discover(platform.URL + "/")
+	if err != nil {
+		t.Fatalf("discover error: %v", err)
+	}
+	if configuration.LTIPlatformConfiguration.ProductFamilyCode != "moodle" {
+		t.Errorf("got platform configuration %v", configuration.LTIPlatformConfiguration)
+	}
+
+	registration, err := DiscoverRegistration(platform.URL)
+	if err != nil {
+		t.Fatalf("discover registration error: %v", err)
+	}
+	if registration.Issuer != platform.URL || registration.AuthLoginURI.String() != platform.URL+"/auth" ||
+		registration.AuthTokenURI.String() != platform.URL+"/token" ||
+		registration.KeysetURI.String() != platform.URL+"/keyset" {
+		t.Errorf("got registration %v", registration)
+	}
+}
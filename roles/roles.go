@@ -0,0 +1,161 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package roles provides the LIS role URIs used in LTI launches and in Names and Roles memberships, and helpers to
+// test a user's roles. Platforms may send context (membership) roles in their short form, e.g., "Instructor"; the
+// helpers treat the short and full forms as equal.
+//
+// Ref: https://www.imsglobal.org/spec/lti/v1p3/#role-vocabularies
+package roles
+
+import (
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/claims"
+)
+
+// Prefixes of the role vocabularies.
+const (
+	SystemPrefix      = "http://purl.imsglobal.org/vocab/lis/v2/system/person#"
+	InstitutionPrefix = "http://purl.imsglobal.org/vocab/lis/v2/institution/person#"
+	MembershipPrefix  = "http://purl.imsglobal.org/vocab/lis/v2/membership#"
+)
+
+// System roles.
+const (
+	SystemAdministrator = SystemPrefix + "Administrator"
+	SystemNone          = SystemPrefix + "None"
+	SystemAccountAdmin  = SystemPrefix + "AccountAdmin"
+	SystemCreator       = SystemPrefix + "Creator"
+	SystemSysAdmin      = SystemPrefix + "SysAdmin"
+	SystemSysSupport    = SystemPrefix + "SysSupport"
+	SystemUser          = SystemPrefix + "User"
+)
+
+// Institution roles.
+const (
+	InstitutionAdministrator      = InstitutionPrefix + "Administrator"
+	InstitutionFaculty            = InstitutionPrefix + "Faculty"
+	InstitutionGuest              = InstitutionPrefix + "Guest"
+	InstitutionNone               = InstitutionPrefix + "None"
+	InstitutionOther              = InstitutionPrefix + "Other"
+	InstitutionStaff              = InstitutionPrefix + "Staff"
+	InstitutionStudent            = InstitutionPrefix + "Student"
+	InstitutionAlumni             = InstitutionPrefix + "Alumni"
+	InstitutionInstructor         = InstitutionPrefix + "Instructor"
+	InstitutionLearner            = InstitutionPrefix + "Learner"
+	InstitutionMember             = InstitutionPrefix + "Member"
+	InstitutionMentor             = InstitutionPrefix + "Mentor"
+	InstitutionObserver           = InstitutionPrefix + "Observer"
+	InstitutionProspectiveStudent = InstitutionPrefix + "ProspectiveStudent"
+)
+
+// Context (membership) roles.
+const (
+	Administrator    = MembershipPrefix + "Administrator"
+	ContentDeveloper = MembershipPrefix + "ContentDeveloper"
+	Instructor       = MembershipPrefix + "Instructor"
+	Learner          = MembershipPrefix + "Learner"
+	Mentor           = MembershipPrefix + "Mentor"
+	Manager          = MembershipPrefix + "Manager"
+	Member           = MembershipPrefix + "Member"
+	Officer          = MembershipPrefix + "Officer"
+)
+
+// Context sub-roles commonly used by platforms.
+const (
+	TeachingAssistant = "http://purl.imsglobal.org/vocab/lis/v2/membership/Instructor#TeachingAssistant"
+)
+
+// Normalize expands the short form of a context role to its full URI. Full URIs are returned unchanged.
+func Normalize(role string) string {
+	if role == "" || strings.Contains(role, ":") {
+		return role
+	}
+
+	return MembershipPrefix + role
+}
+
+// HasRole reports whether the roles include the role, comparing short and full forms of context roles.
+func HasRole(roles []string, role string) bool {
+	role = Normalize(role)
+	for _, r := range roles {
+		if Normalize(r) == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasAnyRole reports whether the roles include any of the candidates.
+func HasAnyRole(roles []string, candidates ...string) bool {
+	for _, candidate := range candidates {
+		if HasRole(roles, candidate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsInstructor reports whether the roles include the context Instructor role or one of its sub-roles, e.g., a
+// teaching assistant.
+func IsInstructor(roles []string) bool {
+	return HasRole(roles, Instructor) || hasSubRole(roles, "Instructor")
+}
+
+// IsLearner reports whether the roles include the context Learner role or one of its sub-roles.
+func IsLearner(roles []string) bool {
+	return HasRole(roles, Learner) || hasSubRole(roles, "Learner")
+}
+
+// IsAdmin reports whether the roles include an administrator role of the system, the institution or the context.
+func IsAdmin(roles []string) bool {
+	return HasAnyRole(roles, SystemAdministrator, SystemSysAdmin, InstitutionAdministrator, Administrator) ||
+		hasSubRole(roles, "Administrator")
+}
+
+// hasSubRole reports whether the roles include a sub-role of the context role, e.g., Instructor#TeachingAssistant.
+func hasSubRole(roles []string, contextRole string) bool {
+	prefix := strings.TrimSuffix(MembershipPrefix, "#") + "/" + contextRole + "#"
+	for _, r := range roles {
+		if strings.HasPrefix(r, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromToken returns the roles claim of the launch token. It returns nil if the claim is absent or improperly
+// formatted.
+func FromToken(token jwt.Token) []string {
+	rawRoles, ok := token.Get(claims.Roles)
+	if !ok {
+		return nil
+	}
+
+	switch roles := rawRoles.(type) {
+	case []string:
+		return roles
+	case []interface{}:
+		var converted []string
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				converted = append(converted, s)
+			}
+		}
+		return converted
+	}
+
+	return nil
+}
+
+// FromClaims returns the roles of the launch claims.
+func FromClaims(launchClaims claims.LaunchClaims) []string {
+	return launchClaims.Roles
+}
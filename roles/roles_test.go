@@ -0,0 +1,52 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package roles
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestRoleHelpers(t *testing.T) {
+	tests := []struct {
+		roles      []string
+		instructor bool
+		learner    bool
+		admin      bool
+	}{
+		{[]string{"Instructor"}, true, false, false},
+		{[]string{Learner, InstitutionStudent}, false, true, false},
+		{[]string{TeachingAssistant}, true, false, false},
+		{[]string{SystemAdministrator, "Learner"}, false, true, true},
+		{[]string{InstitutionInstructor}, false, false, false},
+		{nil, false, false, false},
+	}
+	for _, test := range tests {
+		if IsInstructor(test.roles) != test.instructor || IsLearner(test.roles) != test.learner ||
+			IsAdmin(test.roles) != test.admin {
+			t.Errorf("roles %v: got instructor %t, learner %t, admin %t", test.roles, IsInstructor(test.roles),
+				IsLearner(test.roles), IsAdmin(test.roles))
+		}
+	}
+
+	if !HasRole([]string{Mentor}, "Mentor") || HasRole([]string{InstitutionMentor}, "Mentor") {
+		t.Error("short and full forms not normalized")
+	}
+}
+
+func TestFromToken(t *testing.T) {
+	token := jwt.New()
+	if FromToken(token) != nil {
+		t.Error("got roles without roles claim")
+	}
+
+	token.Set("https://purl.imsglobal.org/spec/lti/claim/roles", []interface{}{Instructor, 1})
+	roles := FromToken(token)
+	if len(roles) != 1 || !IsInstructor(roles) {
+		t.Errorf("got roles %v", roles)
+	}
+}
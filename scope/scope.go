@@ -0,0 +1,34 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package scope provides the OAuth 2.0 scopes of the LTI Advantage services.
+package scope
+
+// Assignment and Grade Services scopes.
+// Ref: https://www.imsglobal.org/spec/lti-ags/v2p0#assignment-and-grade-service-claim
+const (
+	LineItem         = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	LineItemReadOnly = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"
+	ResultReadOnly   = "https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly"
+	Score            = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+)
+
+// Names and Role Provisioning Services scopes.
+// Ref: https://www.imsglobal.org/spec/lti-nrps/v2p0
+const (
+	ContextMembershipReadOnly = "https://purl.imsglobal.org/spec/lti-nrps/scope/contextmembership.readonly"
+)
+
+// Course Groups Service scopes.
+// Ref: https://www.imsglobal.org/lti-course-groups-service-spec
+const (
+	ContextGroupReadOnly = "https://purl.imsglobal.org/spec/lti-gs/scope/contextgroup.readonly"
+)
+
+// Proctoring Assessment Control Service scopes.
+// Ref: https://www.imsglobal.org/spec/proctoring/v1p0#assessment-control-service
+const (
+	AssessmentControl = "https://purl.imsglobal.org/spec/lti-ap/scope/control.all"
+)
@@ -0,0 +1,222 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+// Package session issues sessions bound to a launch ID after a successful launch, so that the tool's later requests,
+// e.g., AJAX calls from the tool's pages, are authenticated without a new launch.
+//
+// A session is a JWT signed with the Manager's key (HS256) whose subject is the launch ID. It is set as an HttpOnly
+// cookie, and it is also returned by Issue, so that pages can send it in an "Authorization: Bearer" header when the
+// browser withholds third-party cookies from the tool's iframe.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/launch"
+)
+
+// DefaultLifetime is the time for which a session is valid when a Manager's Lifetime is zero.
+const DefaultLifetime = time.Hour
+
+// DefaultCookieName is the name of the session cookie when a Manager's CookieName is empty.
+const DefaultCookieName = "lti-session"
+
+// MinimumKeyLength is the length, in bytes, of the shortest signing key accepted.
+const MinimumKeyLength = 32
+
+// audience identifies the JWTs issued as sessions, so that other JWTs signed with the same key are not accepted.
+const audience = "lti-session"
+
+var (
+	// ErrNoSession is returned when a request carries no session.
+	ErrNoSession = errors.New("session not found in request")
+
+	// ErrInvalidSession is returned when a session's signature cannot be verified or the session has expired.
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+// A Manager issues and verifies sessions. Key is the secret signing key, which must be at least MinimumKeyLength
+// bytes long and shared by every process serving the tool. Lifetime is the time for which a session is valid; when
+// zero, DefaultLifetime is used. CookieName and CookiePath set the session cookie's name and path; when empty,
+// DefaultCookieName and "/" are used. Clock is the time source used for the session's times; when nil, the system
+// clock is used.
+type Manager struct {
+	Key        []byte
+	Lifetime   time.Duration
+	CookieName string
+	CookiePath string
+	Clock      datastore.Clock
+}
+
+// New returns a *Manager signing its sessions with the key.
+func New(key []byte) *Manager {
+	return &Manager{
+		Key:        key,
+		Lifetime:   DefaultLifetime,
+		CookieName: DefaultCookieName,
+		CookiePath: "/",
+	}
+}
+
+// Token returns a signed session for the launch ID.
+func (m *Manager) Token(launchID string) (string, error) {
+	if launchID == "" {
+		return "", errors.New("received empty launchID argument")
+	}
+	if len(m.Key) < MinimumKeyLength {
+		return "", fmt.Errorf("session key must be at least %d bytes long", MinimumKeyLength)
+	}
+
+	now := datastore.Now(m.Clock)
+	token := jwt.New()
+	token.Set(jwt.SubjectKey, launchID)
+	token.Set(jwt.AudienceKey, audience)
+	token.Set(jwt.IssuedAtKey, now)
+	token.Set(jwt.ExpirationKey, now.Add(m.lifetime()))
+
+	signed, err := jwt.Sign(token, jwa.HS256, m.Key)
+	if err != nil {
+		return "", fmt.Errorf("could not sign session: %w", err)
+	}
+
+	return string(signed), nil
+}
+
+// Issue sets a session cookie for the launch ID and returns the session, e.g., to embed it in the tool's page for use
+// in an Authorization header.
+func (m *Manager) Issue(w http.ResponseWriter, launchID string) (string, error) {
+	session, err := m.Token(launchID)
+	if err != nil {
+		return "", err
+	}
+
+	cookiePath := m.CookiePath
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    session,
+		Path:     cookiePath,
+		MaxAge:   int(m.lifetime().Seconds()),
+		HttpOnly: true,
+		// The tool is typically displayed in an iframe of the platform, where only SameSite=None cookies are sent.
+		SameSite: http.SameSiteNoneMode,
+		Secure:   true,
+	})
+
+	return session, nil
+}
+
+// LaunchID verifies the session of the request, found in a bearer Authorization header or else in the session
+// cookie, and returns its launch ID. It returns ErrNoSession if the request has no session, and an error wrapping
+// ErrInvalidSession if the session is not valid.
+func (m *Manager) LaunchID(r *http.Request) (string, error) {
+	var session string
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		session = strings.TrimPrefix(authorization, "Bearer ")
+	} else if cookie, err := r.Cookie(m.cookieName()); err == nil {
+		session = cookie.Value
+	}
+	if session == "" {
+		return "", ErrNoSession
+	}
+
+	token, err := jwt.Parse([]byte(session), jwt.WithVerify(jwa.HS256, m.Key))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	err = jwt.Validate(token, jwt.WithAudience(audience), jwt.WithClock(jwt.ClockFunc(func() time.Time {
+		return datastore.Now(m.Clock)
+	})))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+	if token.Subject() == "" {
+		return "", fmt.Errorf("%w: no launch ID", ErrInvalidSession)
+	}
+
+	return token.Subject(), nil
+}
+
+// IssueAfterLaunch returns middleware for the handler run by a successful launch. It issues a session for the launch
+// ID found in the request context before calling next, e.g., launch.New(cfg, manager.IssueAfterLaunch(next)).
+func (m *Manager) IssueAfterLaunch(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		launchID, _ := r.Context().Value(launch.ContextKey).(string)
+		if _, err := m.Issue(w, launchID); err != nil {
+			http.Error(w, fmt.Sprintf("issue session: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Require returns middleware that rejects requests without a valid session with 401 Unauthorized. For requests with
+// a valid session, it attaches the session's launch ID to the request context, as a launch does, before calling next.
+func (m *Manager) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		launchID, err := m.LaunchID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), launch.ContextKey, launchID)))
+	})
+}
+
+// RequireLaunch is like Require, but it also checks that the launch data of the session's launch ID is still stored,
+// e.g., that it has not expired or been purged, so that next can rely on it. It rejects sessions whose launch data is
+// not found with 401 Unauthorized, like sessions that are not valid. If the Config's launch data store is nil, it
+// falls back on the in-memory nonpersistent.DefaultStore.
+func (m *Manager) RequireLaunch(cfg datastore.Config, next http.Handler) http.Handler {
+	if cfg.LaunchData == nil {
+		cfg.LaunchData = nonpersistent.DefaultStore
+	}
+
+	return m.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		launchID, _ := r.Context().Value(launch.ContextKey).(string)
+		if _, err := cfg.LaunchData.FindLaunchData(launchID); err != nil {
+			if errors.Is(err, datastore.ErrLaunchDataNotFound) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			http.Error(w, fmt.Sprintf("require launch: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// lifetime returns the Lifetime, or DefaultLifetime if it is zero.
+func (m *Manager) lifetime() time.Duration {
+	if m.Lifetime == 0 {
+		return DefaultLifetime
+	}
+
+	return m.Lifetime
+}
+
+// cookieName returns the CookieName, or DefaultCookieName if it is empty.
+func (m *Manager) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultCookieName
+	}
+
+	return m.CookieName
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021 MacEwan University. All rights reserved.
+//
+// This source code is licensed under the MIT-style license found in
+// the LICENSE file in the root directory of this source tree.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/macewan-cs/lti/datastore"
+	"github.com/macewan-cs/lti/datastore/nonpersistent"
+	"github.com/macewan-cs/lti/launch"
+)
+
+func TestSession(t *testing.T) {
+	now := time.Now()
+	manager := New([]byte("0123456789abcdef0123456789abcdef"))
+	manager.Clock = datastore.ClockFunc(func() time.Time { return now })
+
+	var launched string
+	protected := manager.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		launched, _ = r.Context().Value(launch.ContextKey).(string)
+	}))
+
+	// A successful launch issues the session cookie.
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/launch", nil)
+	request = request.WithContext(context.WithValue(request.Context(), launch.ContextKey, "lti1p3-launch-1"))
+	manager.IssueAfterLaunch(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))(recorder, request)
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].Name != DefaultCookieName {
+		t.Fatalf("got cookies %v", cookies)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/api", nil)
+	request.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || launched != "lti1p3-launch-1" {
+		t.Errorf("got status %d, launch ID %q", recorder.Code, launched)
+	}
+
+	// The session is accepted as a bearer token as well.
+	request = httptest.NewRequest(http.MethodGet, "/api", nil)
+	request.Header.Set("Authorization", "Bearer "+cookies[0].Value)
+	if launchID, err := manager.LaunchID(request); err != nil || launchID != "lti1p3-launch-1" {
+		t.Errorf("got launch ID %q, error %v for bearer session", launchID, err)
+	}
+
+	if _, err := manager.LaunchID(httptest.NewRequest(http.MethodGet, "/api", nil)); err != ErrNoSession {
+		t.Errorf("got %v without session, wanted ErrNoSession", err)
+	}
+
+	other := New([]byte("another key of at least 32 bytes!"))
+	if _, err := other.LaunchID(request); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("got %v for session signed with another key, wanted ErrInvalidSession", err)
+	}
+
+	now = now.Add(DefaultLifetime + time.Minute)
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for expired session", recorder.Code)
+	}
+
+	if _, err := New([]byte("short")).Token("lti1p3-launch-1"); err == nil {
+		t.Error("error not reported for short key")
+	}
+}
+
+func TestRequireLaunch(t *testing.T) {
+	store := nonpersistent.New()
+	store.StoreLaunchData("lti1p3-launch-1", json.RawMessage(`{}`))
+	manager := New([]byte("0123456789abcdef0123456789abcdef"))
+
+	var called bool
+	handler := manager.RequireLaunch(datastore.Config{LaunchData: store}, http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		called = true
+	}))
+
+	for launchID, wanted := range map[string]int{
+		"lti1p3-launch-1": http.StatusOK,
+		"lti1p3-launch-2": http.StatusUnauthorized,
+	} {
+		called = false
+		session, _ := manager.Token(launchID)
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Header.Set("Authorization", "Bearer "+session)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != wanted || called != (wanted == http.StatusOK) {
+			t.Errorf("launch %s: got status %d, called %t", launchID, recorder.Code, called)
+		}
+	}
+}